@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -14,6 +15,8 @@ import (
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder/interceptor"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
@@ -27,6 +30,10 @@ type Config struct {
 	kataAgentSocketPath string
 	kataAgentNamespace  string
 	HostInterface       string
+	auditLog            string
+	attester            string
+	agentPolicyPath     string
+	renewedCertPath     string
 }
 
 func load(path string, obj interface{}) error {
@@ -63,6 +70,10 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		flags.StringVar(&tlsConfig.KeyFile, "cert-key", "", "cert key")
 		flags.BoolVar(&tlsConfig.SkipVerify, "tls-skip-verify", false, "Skip TLS certificate verification - use it only for testing")
 		flags.BoolVar(&disableTLS, "disable-tls", false, "Disable TLS encryption - use it only for testing")
+		flags.StringVar(&cfg.attester, "attester", "", "Path to a command that generates TEE evidence of this pod VM. It is used when cloud-api-adaptor requires attestation")
+		flags.StringVar(&cfg.agentPolicyPath, "agent-policy-file", daemon.DefaultAgentPolicyPath, "Path to an agent API policy baked into the pod VM image. It is enforced in addition to the policy in the daemon config file, if it exists")
+		flags.StringVar(&cfg.renewedCertPath, "renewed-cert-file", daemon.DefaultRenewedCertPath, "Path to a file where a server certificate renewed by cloud-api-adaptor is stored and loaded after restart (empty disables it)")
+		flags.StringVar(&cfg.auditLog, "audit-log", "", "Destination of the audit log of agent API calls: a file path, \"stdout\" or \"stderr\" (empty disables audit logging)")
 	})
 
 	if !disableTLS {
@@ -83,11 +94,31 @@ func (cfg *Config) Setup() (cmd.Starter, error) {
 		}
 	}
 
+	if cfg.agentPolicyPath != "" {
+		policy, err := os.ReadFile(cfg.agentPolicyPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read agent policy file %s: %w", cfg.agentPolicyPath, err)
+		}
+		cfg.daemonConfig.ImageAgentPolicy = string(policy)
+	}
+
+	cfg.daemonConfig.RenewedCertPath = cfg.renewedCertPath
+
+	auditSink, err := agentproto.OpenAuditSink(cfg.auditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	var attester attestation.Attester
+	if cfg.attester != "" {
+		attester = attestation.NewCommandAttester(cfg.attester)
+	}
+
 	interceptor := interceptor.NewInterceptor(cfg.kataAgentSocketPath, cfg.kataAgentNamespace)
 
 	podNode := podnetwork.NewPodNode(cfg.kataAgentNamespace, cfg.HostInterface, cfg.daemonConfig.PodNetwork)
 
-	daemon := daemon.NewDaemon(&cfg.daemonConfig, cfg.listenAddr, cfg.tlsConfig, interceptor, podNode)
+	daemon := daemon.NewDaemon(&cfg.daemonConfig, cfg.listenAddr, cfg.tlsConfig, interceptor, podNode, auditSink, attester)
 
 	return cmd.NewStarter(daemon), nil
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/confidential-containers/cloud-api-adaptor/cmd"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor"
@@ -16,7 +17,10 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler/vxlan"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/probe"
@@ -85,6 +89,16 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 	var (
 		disableTLS bool
 		tlsConfig  tlsutil.TLSConfig
+		auditLog   string
+
+		attestationVerifier string
+		attestationPolicy   string
+
+		userDataSigningKey    string
+		userDataEncryptionKey string
+		userDataKeyID         string
+
+		tunableAgentSettings string
 	)
 
 	cmd.Parse(programName, os.Args[1:], func(flags *flag.FlagSet) {
@@ -97,15 +111,25 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 
 		flags.StringVar(&cfg.serverConfig.SocketPath, "socket", adaptor.DefaultSocketPath, "Unix domain socket path of remote hypervisor service")
 		flags.StringVar(&cfg.serverConfig.PodsDir, "pods-dir", adaptor.DefaultPodsDir, "base directory for pod directories")
-		flags.StringVar(&cfg.serverConfig.CriSocketPath, "cri-runtime-endpoint", "", "cri runtime uds endpoint")
-		flags.StringVar(&cfg.serverConfig.PauseImage, "pause-image", "", "pause image to be used for the pods")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.CriSocketPath, "cri-runtime-endpoint", "", "cri runtime uds endpoint")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.PauseImage, "pause-image", "", "pause image to be used for the pods")
 		flags.StringVar(&cfg.serverConfig.ForwarderPort, "forwarder-port", daemon.DefaultListenPort, "port number of agent protocol forwarder")
 		flags.StringVar(&tlsConfig.CAFile, "ca-cert-file", "", "CA cert file")
 		flags.StringVar(&tlsConfig.CertFile, "cert-file", "", "cert file")
 		flags.StringVar(&tlsConfig.KeyFile, "cert-key", "", "cert key")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.CACertDir, "ca-cert-dir", "", "Directory to persist the CA certificate and key (tls.crt and tls.key) used to issue pod VM certificates, e.g. a mounted Secret")
+		flags.DurationVar(&cfg.serverConfig.ProxyConfig.ServerCertLifetime, "server-cert-lifetime", tlsutil.DefaultServerCertLifetime, "Lifetime of pod VM server certificates. Certificates are renewed before they expire, and a pod VM that is stopped until its certificate expires becomes unreachable")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.ServerIdentity.Mode, "server-identity", "", "How to verify pod VM server certificates: \"spiffe\" (URI SAN spiffe://<trust domain>/sandbox/<sandbox ID>), \"ip\" (IP SAN of the pod VM), or \"name\" (DNS SAN). Defaults to \"spiffe\" with automatically issued certificates, and to \"ip\" with -ca-cert-file")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.ServerIdentity.Name, "server-name", "", "DNS SAN of pod VM server certificates signed by the CA specified by -ca-cert-file, which is shared by all pod VMs (\"name\" server identity only)")
+		flags.StringVar(&cfg.serverConfig.ProxyConfig.ServerIdentity.TrustDomain, "spiffe-trust-domain", proxy.DefaultSPIFFETrustDomain, "SPIFFE trust domain of pod VM server certificates (\"spiffe\" server identity only)")
+		flags.StringVar(&attestationVerifier, "attestation-verifier", "", "Path to a command that verifies TEE evidence of pod VMs. When specified, pod VMs must be attested before use")
+		flags.StringVar(&attestationPolicy, "attestation-policy", "", "Path to a JSON reference value policy of pod VM TEE evidence")
+		flags.StringVar(&userDataSigningKey, "user-data-signing-key", "", "Path to a PEM private key to sign pod VM user data. Pod VM images must include the public key")
+		flags.StringVar(&userDataEncryptionKey, "user-data-encryption-key", "", "Path to a 32-byte AES key to encrypt pod VM user data. Pod VMs retrieve the key from a KBS by the ID specified by -user-data-encryption-key-id")
+		flags.StringVar(&userDataKeyID, "user-data-encryption-key-id", "", "KBS resource ID of the user data encryption key, e.g. kbs:///default/peerpod/user-data-key")
 		flags.BoolVar(&tlsConfig.SkipVerify, "tls-skip-verify", false, "Skip TLS certificate verification - use it only for testing")
 		flags.BoolVar(&disableTLS, "disable-tls", false, "Disable TLS encryption - use it only for testing")
-		flags.DurationVar(&cfg.serverConfig.ProxyTimeout, "proxy-timeout", proxy.DefaultProxyTimeout, "Maximum timeout in minutes for establishing agent proxy connection")
+		flags.DurationVar(&cfg.serverConfig.ProxyConfig.ProxyTimeout, "proxy-timeout", proxy.DefaultProxyTimeout, "Maximum timeout in minutes for establishing agent proxy connection")
 
 		flags.StringVar(&cfg.networkConfig.TunnelType, "tunnel-type", podnetwork.DefaultTunnelType, "Tunnel provider")
 		flags.StringVar(&cfg.networkConfig.HostInterface, "host-interface", "", "Host Interface")
@@ -113,20 +137,80 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		flags.IntVar(&cfg.networkConfig.VXLANMinID, "vxlan-min-id", vxlan.DefaultVXLANMinID, "Minimum VXLAN ID (VXLAN tunnel mode only")
 		flags.StringVar(&cfg.serverConfig.AAKBCParams, "aa-kbc-params", "", "attestation-agent KBC parameters")
 		flags.BoolVar(&cfg.serverConfig.EnableCloudConfigVerify, "cloud-config-verify", false, "Enable cloud config verify - should use it for production")
+		flags.DurationVar(&cfg.serverConfig.TunnelCheckInterval, "tunnel-check-interval", adaptor.DefaultTunnelCheckInterval, "Interval of pod network tunnel health checks (0 disables health checks)")
+		flags.StringVar(&tunableAgentSettings, "tunable-agent-settings", strings.Join(daemon.DefaultTunableAgentSettings, ","), "Kata agent settings that pods can specify in the "+daemon.AgentConfigAnnotation+" annotation, comma separated (empty disallows the annotation)")
+		flags.StringVar(&auditLog, "audit-log", "", "Destination of the audit log of agent API calls: a file path, \"stdout\" or \"stderr\" (empty disables audit logging)")
 
 		cloud.ParseCmd(flags)
 	})
 
 	cmd.ShowVersion(programName)
 
+	cfg.serverConfig.TunableAgentSettings = []string{}
+	if tunableAgentSettings != "" {
+		cfg.serverConfig.TunableAgentSettings = strings.Split(tunableAgentSettings, ",")
+	}
+
 	fmt.Printf("%s: starting Cloud API Adaptor daemon for %q\n", programName, cloudName)
 
 	if !disableTLS {
-		cfg.serverConfig.TLSConfig = &tlsConfig
+		cfg.serverConfig.ProxyConfig.TLSConfig = &tlsConfig
+
+		if err := cfg.serverConfig.ProxyConfig.ServerIdentity.Validate(!tlsConfig.HasCA()); err != nil {
+			return nil, err
+		}
+	}
+
+	if attestationVerifier != "" {
+		if disableTLS {
+			return nil, fmt.Errorf("attestation of pod VMs requires TLS")
+		}
+
+		cfg.serverConfig.ProxyConfig.Attestation = &attestation.Config{
+			Verifier: attestation.NewCommandVerifier(attestationVerifier),
+		}
+
+		if attestationPolicy != "" {
+			policy, err := attestation.LoadReferencePolicy(attestationPolicy)
+			if err != nil {
+				return nil, err
+			}
+			cfg.serverConfig.ProxyConfig.Attestation.Policy = policy
+		}
+	} else if attestationPolicy != "" {
+		return nil, fmt.Errorf("attestation policy is specified without attestation verifier")
+	}
+
+	if userDataSigningKey != "" {
+		signer, err := userdata.LoadSigningKey(userDataSigningKey)
+		if err != nil {
+			return nil, err
+		}
+
+		var encryptionKey []byte
+		if userDataEncryptionKey != "" {
+			encryptionKey, err = userdata.LoadEncryptionKey(userDataEncryptionKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cfg.serverConfig.UserDataSealer, err = userdata.NewSealer(signer, encryptionKey, userDataKeyID)
+		if err != nil {
+			return nil, err
+		}
+	} else if userDataEncryptionKey != "" || userDataKeyID != "" {
+		return nil, fmt.Errorf("user data encryption requires a user data signing key")
 	}
 
 	cloud.LoadEnv()
 
+	auditSink, err := agentproto.OpenAuditSink(auditLog)
+	if err != nil {
+		return nil, err
+	}
+	cfg.serverConfig.ProxyConfig.AuditSink = auditSink
+
 	workerNode := podnetwork.NewWorkerNode(cfg.TunnelType, cfg.HostInterface, cfg.VXLANPort, cfg.VXLANMinID)
 
 	provider, err := cloud.NewProvider()
@@ -134,7 +218,10 @@ func (cfg *daemonConfig) Setup() (cmd.Starter, error) {
 		return nil, err
 	}
 
-	server := adaptor.NewServer(provider, &cfg.serverConfig, workerNode)
+	server, err := adaptor.NewServer(provider, &cfg.serverConfig, workerNode)
+	if err != nil {
+		return nil, err
+	}
 
 	return cmd.NewStarter(server), nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// configDrive is an ISO 9660 filesystem attached to a pod VM that contains the user data
+type configDrive struct {
+	device       string   // block device or ISO image file
+	userDataPath []string // path to the user data file in the filesystem
+}
+
+// OpenStack config drive, and cloud-init NoCloud ISO that is used by the libvirt provider
+var configDriveDevices = []configDrive{
+	{device: "/dev/disk/by-label/config-2", userDataPath: []string{"openstack", "latest", "user_data"}},
+	{device: "/dev/disk/by-label/CONFIG-2", userDataPath: []string{"openstack", "latest", "user_data"}},
+	{device: "/dev/disk/by-label/cidata", userDataPath: []string{"user-data"}},
+	{device: "/dev/disk/by-label/CIDATA", userDataPath: []string{"user-data"}},
+}
+
+type configDriveDataSource struct {
+	devices []configDrive
+}
+
+func (s *configDriveDataSource) Name() string {
+	return dataSourceConfigDrive
+}
+
+func (s *configDriveDataSource) IsAvailable(ctx context.Context) bool {
+	return s.findDevice() != nil
+}
+
+func (s *configDriveDataSource) GetUserData(ctx context.Context) (string, error) {
+
+	drive := s.findDevice()
+	if drive == nil {
+		return "", fmt.Errorf("no config drive is found")
+	}
+
+	return readConfigDrive(drive)
+}
+
+func (s *configDriveDataSource) findDevice() *configDrive {
+	for i := range s.devices {
+		if _, err := os.Stat(s.devices[i].device); err == nil {
+			return &s.devices[i]
+		}
+	}
+	return nil
+}
+
+// Read the user data file from an ISO 9660 filesystem without mounting it
+func readConfigDrive(drive *configDrive) (string, error) {
+
+	file, err := os.Open(drive.device)
+	if err != nil {
+		return "", fmt.Errorf("failed to open config drive: %s", err)
+	}
+	defer file.Close()
+
+	image, err := iso9660.OpenImage(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config drive %s: %s", drive.device, err)
+	}
+
+	entry, err := image.RootDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to read config drive %s: %s", drive.device, err)
+	}
+
+	for _, name := range drive.userDataPath {
+
+		children, err := entry.GetChildren()
+		if err != nil {
+			return "", fmt.Errorf("failed to read config drive %s: %s", drive.device, err)
+		}
+
+		entry = nil
+		for _, child := range children {
+			if isoNameEqual(child.Name(), name) {
+				entry = child
+				break
+			}
+		}
+
+		if entry == nil {
+			return "", fmt.Errorf("%s is not found in config drive %s", strings.Join(drive.userDataPath, "/"), drive.device)
+		}
+	}
+
+	if entry.IsDir() {
+		return "", fmt.Errorf("%s in config drive %s is a directory", strings.Join(drive.userDataPath, "/"), drive.device)
+	}
+
+	userData, err := io.ReadAll(entry.Reader())
+	if err != nil {
+		return "", fmt.Errorf("failed to read user data from config drive %s: %s", drive.device, err)
+	}
+
+	return string(userData), nil
+}
+
+// ISO 9660 file identifiers are upper case, and do not allow '-', so
+// a file name can be mangled when the filesystem has no Rock Ridge or Joliet extension
+func isoNameEqual(isoName, name string) bool {
+	normalize := func(s string) string {
+		return strings.ReplaceAll(strings.ToLower(s), "-", "_")
+	}
+	return normalize(isoName) == normalize(name)
+}
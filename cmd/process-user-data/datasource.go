@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/aws"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/azure"
+	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+	"gopkg.in/yaml.v2"
+)
+
+// Timeout for checking whether a data source is available
+const dataSourceProbeTimeout = 10 * time.Second
+
+// dataSource provides the user data of a pod VM
+type dataSource interface {
+	// Name returns the name of the data source, which is used by the --data-source flag
+	Name() string
+
+	// IsAvailable returns true if the pod VM can retrieve the user data from the data source
+	IsAvailable(ctx context.Context) bool
+
+	// GetUserData retrieves the user data
+	GetUserData(ctx context.Context) (string, error)
+}
+
+// Get the data sources in the order of detection
+func getDataSources(userDataFilePaths []string) []dataSource {
+	return []dataSource{
+		&azureDataSource{url: azure.AzureUserDataImdsUrl},
+		&awsDataSource{url: aws.AWSUserDataImdsUrl},
+		&ibmcloudDataSource{endpoint: ibmcloudMetadataEndpoint},
+		&vsphereDataSource{rpctool: vmwareRpctoolPath},
+		&configDriveDataSource{devices: configDriveDevices},
+		&fileDataSource{paths: userDataFilePaths},
+	}
+}
+
+// Find a data source. If name is empty, the first available data source is returned.
+func findDataSource(ctx context.Context, sources []dataSource, name string) (dataSource, error) {
+
+	for _, source := range sources {
+
+		if name != "" {
+			if source.Name() == name {
+				return source, nil
+			}
+			continue
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, dataSourceProbeTimeout)
+		available := source.IsAvailable(probeCtx)
+		cancel()
+
+		if available {
+			return source, nil
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("unknown data source: %s", name)
+	}
+
+	return nil, fmt.Errorf("no data source is available")
+}
+
+// Get the daemon config from userData. The userData is either the daemon config itself,
+// or a cloud-config that writes the daemon config to daemon.DefaultSealedConfigPath or daemon.DefaultConfigPath.
+func extractDaemonConfig(userData string) (string, error) {
+
+	if !strings.HasPrefix(userData, "#cloud-config") {
+		return userData, nil
+	}
+
+	var cloudConfig cloudinit.CloudConfig
+	if err := yaml.Unmarshal([]byte(userData), &cloudConfig); err != nil {
+		return "", fmt.Errorf("failed to parse cloud-config: %s", err)
+	}
+
+	for _, file := range cloudConfig.WriteFiles {
+
+		if file.Path != daemon.DefaultSealedConfigPath && file.Path != daemon.DefaultConfigPath {
+			continue
+		}
+
+		switch file.Encoding {
+		case "":
+			return file.Content, nil
+		case "b64", "base64":
+			content, err := base64.StdEncoding.DecodeString(file.Content)
+			if err != nil {
+				return "", fmt.Errorf("failed to decode %s in cloud-config: %s", file.Path, err)
+			}
+			return string(content), nil
+		default:
+			return "", fmt.Errorf("unsupported encoding of %s in cloud-config: %s", file.Path, file.Encoding)
+		}
+	}
+
+	return "", fmt.Errorf("cloud-config does not contain %s or %s", daemon.DefaultSealedConfigPath, daemon.DefaultConfigPath)
+}
+
+// Azure instance metadata service
+type azureDataSource struct {
+	url string
+}
+
+func (s *azureDataSource) Name() string {
+	return providerAzure
+}
+
+func (s *azureDataSource) IsAvailable(ctx context.Context) bool {
+	return azure.IsAzure(ctx)
+}
+
+func (s *azureDataSource) GetUserData(ctx context.Context) (string, error) {
+	return azure.GetUserData(ctx, s.url)
+}
+
+// AWS instance metadata service
+type awsDataSource struct {
+	url string
+}
+
+func (s *awsDataSource) Name() string {
+	return providerAws
+}
+
+func (s *awsDataSource) IsAvailable(ctx context.Context) bool {
+	return aws.IsAWS(ctx)
+}
+
+func (s *awsDataSource) GetUserData(ctx context.Context) (string, error) {
+	return aws.GetUserData(ctx, s.url)
+}
+
+// A local file written by cloud-init or a provider. The first existing file of paths is used,
+// e.g. a sealed daemon config that is kept intact across reboots, and then a plain daemon config.
+type fileDataSource struct {
+	paths []string
+}
+
+func (s *fileDataSource) Name() string {
+	return dataSourceFile
+}
+
+func (s *fileDataSource) IsAvailable(ctx context.Context) bool {
+	return s.path() != ""
+}
+
+// path returns the first existing file of paths, or an empty string if none exists
+func (s *fileDataSource) path() string {
+	for _, path := range s.paths {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func (s *fileDataSource) GetUserData(ctx context.Context) (string, error) {
+
+	path := s.path()
+	if path == "" {
+		return "", fmt.Errorf("user data file is not found in %s", strings.Join(s.paths, ", "))
+	}
+
+	userData, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read user data file: %s", err)
+	}
+
+	return string(userData), nil
+}
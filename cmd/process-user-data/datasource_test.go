@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+	"github.com/kdomanski/iso9660"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDaemonConfig = `{"pod-network":{"podip":"10.244.0.19/24"},"pod-namespace":"default","pod-name":"nginx"}`
+
+// Test server to simulate the IBM Cloud VPC metadata service
+func startIBMCloudTestServer(userData string) *httptest.Server {
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/instance_identity/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Metadata-Flavor") != "ibm" {
+			http.Error(w, "Bad request.", http.StatusBadRequest)
+			return
+		}
+		_, _ = io.WriteString(w, `{"access_token": "test-token", "expires_in": 300}`)
+	})
+
+	mux.HandleFunc("/metadata/v1/instance/initialization", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"user_data": userData, "keys": []string{}})
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestIBMCloudDataSource(t *testing.T) {
+
+	srv := startIBMCloudTestServer(testDaemonConfig)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	source := &ibmcloudDataSource{endpoint: srv.URL}
+	assert.True(t, source.IsAvailable(ctx))
+
+	userData, err := source.GetUserData(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig, userData)
+
+	// Azure IMDS does not provide an instance identity token
+	azureSrv := startTestServer()
+	defer azureSrv.Close()
+
+	source = &ibmcloudDataSource{endpoint: azureSrv.URL}
+	assert.False(t, source.IsAvailable(ctx))
+}
+
+// Create a fake vmware-rpctool that serves guestinfo variables
+func createFakeRpctool(t *testing.T, guestInfo map[string]string) string {
+
+	dir := t.TempDir()
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\ncase \"$1\" in\n")
+	for key, value := range guestInfo {
+		script.WriteString("\"info-get " + key + "\") echo '" + value + "' ;;\n")
+	}
+	script.WriteString("*) echo 'No value found' >&2; exit 1 ;;\nesac\n")
+
+	path := filepath.Join(dir, "vmware-rpctool")
+	require.NoError(t, os.WriteFile(path, []byte(script.String()), 0755))
+
+	return path
+}
+
+func TestVSphereDataSource(t *testing.T) {
+
+	ctx := context.Background()
+
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	_, err := writer.Write([]byte(testDaemonConfig))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	for encoding, value := range map[string]string{
+		"":       testDaemonConfig,
+		"base64": base64.StdEncoding.EncodeToString([]byte(testDaemonConfig)),
+		"gz+b64": base64.StdEncoding.EncodeToString(gzipped.Bytes()),
+	} {
+		guestInfo := map[string]string{"guestinfo.userdata": value}
+		if encoding != "" {
+			guestInfo["guestinfo.userdata.encoding"] = encoding
+		}
+
+		source := &vsphereDataSource{rpctool: createFakeRpctool(t, guestInfo)}
+		assert.True(t, source.IsAvailable(ctx))
+
+		userData, err := source.GetUserData(ctx)
+		require.NoError(t, err, "encoding %q", encoding)
+		assert.Equal(t, testDaemonConfig, userData, "encoding %q", encoding)
+	}
+
+	source := &vsphereDataSource{rpctool: createFakeRpctool(t, nil)}
+	assert.False(t, source.IsAvailable(ctx))
+
+	source = &vsphereDataSource{rpctool: filepath.Join(t.TempDir(), "nonexistent")}
+	assert.False(t, source.IsAvailable(ctx))
+}
+
+// Create an ISO image that contains files at the given paths
+func createISOImage(t *testing.T, label string, files map[string]string) string {
+
+	writer, err := iso9660.NewWriter()
+	require.NoError(t, err)
+	defer writer.Cleanup() //nolint:errcheck
+
+	for path, content := range files {
+		require.NoError(t, writer.AddFile(strings.NewReader(content), path))
+	}
+
+	path := filepath.Join(t.TempDir(), label+".iso")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	require.NoError(t, writer.WriteTo(file, label))
+
+	return path
+}
+
+func TestConfigDriveDataSource(t *testing.T) {
+
+	ctx := context.Background()
+
+	cidata := createISOImage(t, "cidata", map[string]string{"user-data": testDaemonConfig, "meta-data": "local-hostname: podvm"})
+	configDrive2 := createISOImage(t, "config-2", map[string]string{"openstack/latest/user_data": testDaemonConfig})
+
+	for _, device := range []configDrive{
+		{device: cidata, userDataPath: []string{"user-data"}},
+		{device: configDrive2, userDataPath: []string{"openstack", "latest", "user_data"}},
+	} {
+		source := &configDriveDataSource{devices: []configDrive{{device: filepath.Join(t.TempDir(), "nonexistent")}, device}}
+		assert.True(t, source.IsAvailable(ctx))
+
+		userData, err := source.GetUserData(ctx)
+		require.NoError(t, err, "device %s", device.device)
+		assert.Equal(t, testDaemonConfig, userData, "device %s", device.device)
+	}
+
+	source := &configDriveDataSource{devices: []configDrive{{device: cidata, userDataPath: []string{"openstack", "latest", "user_data"}}}}
+	_, err := source.GetUserData(ctx)
+	assert.Error(t, err)
+
+	source = &configDriveDataSource{devices: []configDrive{{device: filepath.Join(t.TempDir(), "nonexistent")}}}
+	assert.False(t, source.IsAvailable(ctx))
+}
+
+func TestFileDataSource(t *testing.T) {
+
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "user-data")
+
+	sealedPath := filepath.Join(t.TempDir(), "user-data.sealed")
+
+	source := &fileDataSource{paths: []string{sealedPath, path}}
+	assert.False(t, source.IsAvailable(ctx))
+
+	_, err := source.GetUserData(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(testDaemonConfig), 0644))
+	assert.True(t, source.IsAvailable(ctx))
+
+	userData, err := source.GetUserData(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig, userData)
+
+	// The first existing file is preferred
+	require.NoError(t, os.WriteFile(sealedPath, []byte("sealed"), 0644))
+
+	userData, err = source.GetUserData(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed", userData)
+}
+
+func TestFindDataSource(t *testing.T) {
+
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "user-data")
+	require.NoError(t, os.WriteFile(path, []byte(testDaemonConfig), 0644))
+
+	sources := []dataSource{
+		&configDriveDataSource{devices: []configDrive{{device: filepath.Join(t.TempDir(), "nonexistent")}}},
+		&fileDataSource{paths: []string{path}},
+	}
+
+	source, err := findDataSource(ctx, sources, "")
+	require.NoError(t, err)
+	assert.Equal(t, dataSourceFile, source.Name())
+
+	source, err = findDataSource(ctx, sources, dataSourceConfigDrive)
+	require.NoError(t, err)
+	assert.Equal(t, dataSourceConfigDrive, source.Name())
+
+	_, err = findDataSource(ctx, sources, "unknown")
+	assert.Error(t, err)
+
+	_, err = findDataSource(ctx, sources[:1], "")
+	assert.Error(t, err)
+}
+
+func TestExtractDaemonConfig(t *testing.T) {
+
+	// Plain daemon config
+	config, err := extractDaemonConfig(testDaemonConfig)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig, config)
+
+	// cloud-config generated by cloud-api-adaptor
+	cloudConfig := &cloudinit.CloudConfig{
+		WriteFiles: []cloudinit.WriteFile{
+			{Path: "/etc/other.conf", Content: "other"},
+			{Path: daemon.DefaultConfigPath, Content: testDaemonConfig},
+		},
+	}
+	userData, err := cloudConfig.Generate()
+	require.NoError(t, err)
+
+	config, err = extractDaemonConfig(userData)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig+"\n", config)
+
+	// Base64 encoded content
+	cloudConfig.WriteFiles[1].Encoding = "b64"
+	cloudConfig.WriteFiles[1].Content = base64.StdEncoding.EncodeToString([]byte(testDaemonConfig))
+	userData, err = cloudConfig.Generate()
+	require.NoError(t, err)
+
+	config, err = extractDaemonConfig(userData)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig, config)
+
+	// Sealed daemon config
+	cloudConfig.WriteFiles[1] = cloudinit.WriteFile{Path: daemon.DefaultSealedConfigPath, Content: testDaemonConfig}
+	userData, err = cloudConfig.Generate()
+	require.NoError(t, err)
+
+	config, err = extractDaemonConfig(userData)
+	require.NoError(t, err)
+	assert.Equal(t, testDaemonConfig+"\n", config)
+
+	// No daemon config
+	_, err = extractDaemonConfig("#cloud-config\nwrite_files:\n  - path: /etc/other.conf\n    content: other\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	ibmcloudMetadataEndpoint = "http://169.254.169.254"
+	ibmcloudMetadataVersion  = "2022-03-01"
+)
+
+// IBM Cloud VPC metadata service
+// https://cloud.ibm.com/docs/vpc?topic=vpc-imd-about
+type ibmcloudDataSource struct {
+	endpoint string
+}
+
+func (s *ibmcloudDataSource) Name() string {
+	return providerIBMCloud
+}
+
+func (s *ibmcloudDataSource) IsAvailable(ctx context.Context) bool {
+	_, err := s.getToken(ctx)
+	return err == nil
+}
+
+func (s *ibmcloudDataSource) GetUserData(ctx context.Context) (string, error) {
+
+	token, err := s.getToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Example request for IBM Cloud VPC.
+	// curl -H "Authorization: Bearer $token" "http://169.254.169.254/metadata/v1/instance/initialization?version=2022-03-01"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+"/metadata/v1/instance/initialization?version="+ibmcloudMetadataVersion, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	var initialization struct {
+		UserData string `json:"user_data"`
+	}
+	if err := doJSONRequest(req, &initialization); err != nil {
+		return "", fmt.Errorf("failed to retrieve userData: %s", err)
+	}
+
+	return initialization.UserData, nil
+}
+
+// Get an instance identity access token that is required by the metadata service
+func (s *ibmcloudDataSource) getToken(ctx context.Context) (string, error) {
+
+	body := bytes.NewBufferString(`{"expires_in": 300}`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint+"/instance_identity/v1/token?version="+ibmcloudMetadataVersion, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %s", err)
+	}
+	req.Header.Add("Metadata-Flavor", "ibm")
+	req.Header.Add("Content-Type", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSONRequest(req, &token); err != nil {
+		return "", fmt.Errorf("failed to retrieve an instance identity token: %s", err)
+	}
+
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("instance identity token is empty")
+	}
+
+	return token.AccessToken, nil
+}
+
+// Send a request and decode the JSON response body into v
+func doJSONRequest(req *http.Request, v interface{}) error {
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %s", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode response body: %s", err)
+	}
+
+	return nil
+}
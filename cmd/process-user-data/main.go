@@ -48,10 +48,18 @@ func init() {
 
 	// Add a flag to specify the timeout for fetching user data
 	provisionFilesCmd.Flags().IntVarP(&cfg.userDataFetchTimeout, "user-data-fetch-timeout", "t", 180, "Timeout (in secs) for fetching user data")
+	// Add flags to select a data source of user data
+	provisionFilesCmd.Flags().StringVarP(&cfg.dataSource, "data-source", "s", "", "Data source of user data (azure, aws, ibmcloud, vsphere, config-drive, file). Detected automatically if not specified")
+	provisionFilesCmd.Flags().StringSliceVarP(&cfg.userDataFilePaths, "user-data-file", "f", []string{defaultUserDataFilePath}, "Paths of user data files used by the file data source. The first existing file is used")
+	// Add flags to verify and decrypt user data sealed by cloud-api-adaptor
+	provisionFilesCmd.Flags().StringVar(&cfg.userDataVerificationKeyPath, "user-data-verification-key", defaultUserDataVerificationKeyPath, "Path to a PEM public key to verify user data. If the file exists, unsigned user data is rejected")
+	provisionFilesCmd.Flags().StringVar(&cfg.userDataKeyCommand, "user-data-key-command", "", "Path to a command that retrieves a user data decryption key from a KBS. The command is invoked with the key ID, and writes the key to its standard output")
 	rootCmd.AddCommand(provisionFilesCmd)
 
 	// Add a flag to specify the agentConfigPath to updateAgentConfigCmd subcommand
 	updateAgentConfigCmd.Flags().StringVarP(&cfg.agentConfigPath, "agent-config-file", "a", defaultAgentConfigPath, "Path to a agent config file")
+	// Add a flag to specify the agent settings that the daemon config can update, which are baked in a pod VM image
+	updateAgentConfigCmd.Flags().StringSliceVar(&cfg.tunableAgentSettings, "tunable-agent-settings", daemon.DefaultTunableAgentSettings, "Kata agent settings that the agent-config of the daemon config can update, comma separated")
 
 	rootCmd.AddCommand(updateAgentConfigCmd)
 
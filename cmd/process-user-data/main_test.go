@@ -2,17 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/azure"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
+	toml "github.com/pelletier/go-toml/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -295,7 +303,7 @@ func TestGetConfigFromUserData(t *testing.T) {
 	config := getConfigFromUserData(testUserData)
 	// Error if config struct is empty struct, not nil
 
-	if config == (daemon.Config{}) {
+	if reflect.DeepEqual(config, daemon.Config{}) {
 		t.Fatalf("getConfigFromUserData failed")
 	}
 
@@ -413,141 +421,225 @@ func TestGetConfigFromLocalFile(t *testing.T) {
 		AAKBCParams: "test",
 	}
 
-	if config != expectedConfig {
+	if !reflect.DeepEqual(config, expectedConfig) {
 		t.Fatalf("Expected %+v, but got %+v", expectedConfig, config)
 	}
 }
 
-// Test the writeAgentConfig function
-func TestWriteAgentConfig(t *testing.T) {
-	// Create a temporary directory for the test
-	tmpDir, err := os.MkdirTemp("", "test")
-	if err != nil {
-		t.Fatalf("failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
+// Test the mergeAgentConfig function
+func TestMergeAgentConfig(t *testing.T) {
 
-	// Create a temporary file for the test
-	tmpFile, err := os.CreateTemp(tmpDir, "agent-config.json")
+	data, err := os.ReadFile("test-data/sample-agent-config.toml")
 	if err != nil {
-		t.Fatalf("failed to create temp file: %v", err)
+		t.Fatalf("failed to read agent config file: %v", err)
 	}
 
-	defer os.Remove(tmpFile.Name())
-
-	// Create an instance of AgentConfig
-	agentConfig := AgentConfig{
-		// Set the fields of AgentConfig
-		EnableSignatureVerification: true,
-		ServerAddr:                  "unix:///run/kata-containers/agent.sock",
-		AaKbcParams:                 "cc_kbc::http://192.168.1.2:8080",
-		ImageRegistryAuthFile:       "/etc/attestation-agent/auth.json",
-		Endpoints:                   Endpoints{Allowed: []string{"AddARPNeighborsRequest", "AddSwapRequest"}},
+	values := map[string]interface{}{
+		"aa_kbc_params": "cc_kbc::http://192.168.100.2:8080",
+		"log_level":     "debug",
+		"endpoints": map[string]interface{}{
+			"allowed": []interface{}{"CreateContainerRequest", "CreateSandboxRequest"},
+		},
+		"guest_components": map[string]interface{}{
+			"rest_api": "resource",
+		},
+		// JSON numbers are decoded as float64
+		"dev_mode_timeout": float64(30),
 	}
 
-	// Call the writeAgentConfig function
-	err = writeAgentConfig(agentConfig, tmpFile.Name())
+	merged, err := mergeAgentConfig(data, values)
 	assert.NoError(t, err)
 
-	// Parse the agent config file data
-	tmpAgentConfig, err := parseAgentConfig(tmpFile.Name())
-	if err != nil {
-		t.Fatalf("failed to parse agent config file: %v", err)
-	}
+	// Comments and settings that are not merged are preserved
+	for _, line := range []string{
+		"# This disables signature verification which now defaults to true.",
+		"enable_signature_verification=false",
+		`server_addr="unix:///run/kata-containers/agent.sock"`,
+		"# temp workaround for kata-containers/kata-containers#5590",
+		"[endpoints]",
+	} {
+		assert.Contains(t, string(merged), line)
+	}
+
+	// Existing keys are updated in place
+	assert.Contains(t, string(merged), `aa_kbc_params = "cc_kbc::http://192.168.100.2:8080"`+"\n")
+	assert.Contains(t, string(merged), "[endpoints]\n"+`allowed = ["CreateContainerRequest", "CreateSandboxRequest"]`)
+
+	var agentConfig map[string]interface{}
+	assert.NoError(t, toml.Unmarshal(merged, &agentConfig))
+
+	assert.Equal(t, "debug", agentConfig["log_level"])
+	assert.Equal(t, int64(30), agentConfig["dev_mode_timeout"])
+	assert.Equal(t, "file:///etc/attestation-agent/auth.json", agentConfig["image_registry_auth_file"])
+	assert.Equal(t, map[string]interface{}{"rest_api": "resource"}, agentConfig["guest_components"])
+	assert.Equal(t, map[string]interface{}{"allowed": []interface{}{"CreateContainerRequest", "CreateSandboxRequest"}}, agentConfig["endpoints"])
+}
 
-	// Use deepequal to match agentConfig and tmpAgentConfig
-	assert.Equal(t, agentConfig, *tmpAgentConfig)
+// Test the mergeAgentConfig function with keys added to an existing table
+func TestMergeAgentConfigNewKeys(t *testing.T) {
 
+	data := "# agent config\n\n[endpoints] # allowed endpoints\nallowed = [\"A\"] # A only\n\n[[array]]\nname = \"x\"\n"
+
+	merged, err := mergeAgentConfig([]byte(data), map[string]interface{}{
+		"server_addr": "vsock://-1:1024",
+		"endpoints":   map[string]interface{}{"denied": []interface{}{"B"}},
+		"a.b":         true,
+	})
+	assert.NoError(t, err)
+
+	expected := "# agent config\n\n" + `"a.b" = true` + "\nserver_addr = \"vsock://-1:1024\"\n\n[endpoints] # allowed endpoints\nallowed = [\"A\"] # A only\ndenied = [\"B\"]\n\n[[array]]\nname = \"x\"\n"
+	assert.Equal(t, expected, string(merged))
+
+	// An empty document gets new keys
+	merged, err = mergeAgentConfig(nil, map[string]interface{}{"log_level": "info"})
+	assert.NoError(t, err)
+	assert.Equal(t, "log_level = \"info\"\n", string(merged))
 }
 
-// Test the parseAgentConfig function
-func TestParseAgentConfig(t *testing.T) {
+// Test the mergeAgentConfig function with values that result in an invalid agent config
+func TestMergeAgentConfigInvalid(t *testing.T) {
 
-	// Parse the agent config file data
-	agentConfig, err := parseAgentConfig("test-data/sample-agent-config.toml")
+	data, err := os.ReadFile("test-data/sample-agent-config.toml")
 	if err != nil {
-		t.Fatalf("failed to parse agent config file: %v", err)
+		t.Fatalf("failed to read agent config file: %v", err)
+	}
+
+	for name, values := range map[string]map[string]interface{}{
+		"scalar to table":   {"server_addr": map[string]interface{}{"port": float64(1024)}},
+		"table to scalar":   {"endpoints": "all"},
+		"wrong type":        {"enable_signature_verification": "false"},
+		"null value":        {"log_level": nil},
+		"unsupported value": {"log_level": struct{}{}},
+	} {
+		if _, err := mergeAgentConfig(data, values); err == nil {
+			t.Fatalf("Expect error for %s, got nil", name)
+		}
 	}
 
-	// Verify that the config fields match the test data
-	if agentConfig.EnableSignatureVerification != false {
-		t.Fatalf("agentConfig.EnableSignatureVerification does not match test data: expected %v, got %v", false, agentConfig.EnableSignatureVerification)
+	if _, err := mergeAgentConfig([]byte("key = "), map[string]interface{}{"log_level": "debug"}); err == nil {
+		t.Fatal("Expect error for an invalid agent config file, got nil")
 	}
+}
 
-	if agentConfig.ServerAddr != "unix:///run/kata-containers/agent.sock" {
-		t.Fatalf("agentConfig.ServerAddr does not match test data: expected %v, got %v", "unix:///run/kata-containers/agent.sock", agentConfig.ServerAddr)
+// Test the verifyUserData function with signed and unsigned userData
+func TestVerifyUserData(t *testing.T) {
+
+	tmpDir := t.TempDir()
+
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate a key: %v", err)
 	}
 
-	if agentConfig.AaKbcParams != "" {
-		t.Fatalf("agentConfig.AaKbcParams does not match test data: expected %v, got %v", "", agentConfig.AaKbcParams)
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatalf("failed to marshal a public key: %v", err)
+	}
+	keyPath := filepath.Join(tmpDir, "user-data-signing.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		t.Fatalf("failed to write a public key: %v", err)
 	}
 
-	if agentConfig.ImageRegistryAuthFile != "file:///etc/attestation-agent/auth.json" {
-		t.Fatalf("agentConfig.ImageRegistryAuthFile does not match test data: expected %v, got %v", "/etc/attestation-agent/auth.json", agentConfig.ImageRegistryAuthFile)
+	sealer, err := userdata.NewSealer(signer, nil, "")
+	if err != nil {
+		t.Fatalf("failed to create a sealer: %v", err)
 	}
 
-	if agentConfig.Endpoints.Allowed[0] != "AddARPNeighborsRequest" {
-		t.Fatalf("agentConfig.Endpoints does not match test data: expected %v, got %v", "AddARPNeighborsRequest", agentConfig.Endpoints.Allowed[0])
+	testUserData := `{"pod-network":{"podip":"10.244.0.19/24"}}`
+	sealed, err := sealer.Seal([]byte(testUserData))
+	if err != nil {
+		t.Fatalf("failed to seal userData: %v", err)
 	}
 
-	if agentConfig.Endpoints.Allowed[1] != "AddSwapRequest" {
-		t.Fatalf("agentConfig.Endpoints does not match test data: expected %v, got %v", "AddSwapRequest", agentConfig.Endpoints.Allowed[1])
+	// Signed userData is verified with the public key
+	envelope, err := verifyUserData(string(sealed), keyPath)
+	assert.NoError(t, err)
+	if assert.NotNil(t, envelope) {
+		payload, err := envelope.Open(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, testUserData, string(payload))
 	}
 
+	// Unsigned userData is rejected when the public key exists
+	_, err = verifyUserData(testUserData, keyPath)
+	assert.Error(t, err)
+
+	// Tampered userData is rejected
+	tampered := strings.Replace(string(sealed), `"payload":"`, `"payload":"A`, 1)
+	_, err = verifyUserData(tampered, keyPath)
+	assert.Error(t, err)
+
+	// Unsigned userData is accepted when the public key does not exist
+	envelope, err = verifyUserData(testUserData, filepath.Join(tmpDir, "nonexistent.pub"))
+	assert.NoError(t, err)
+	assert.Nil(t, envelope)
+
+	// Signed userData cannot be verified without the public key
+	_, err = verifyUserData(string(sealed), filepath.Join(tmpDir, "nonexistent.pub"))
+	assert.Error(t, err)
 }
 
-// Test the writeAgentConfig function with non existent toml entry in agent config file
-func TestWriteAgentConfigNonExistentTomlEntry(t *testing.T) {
-	// Create a temporary directory for the test
-	tmpDir, err := os.MkdirTemp("", "test")
+// Test that a sealed daemon config is verified again when the pod VM restarts
+func TestProvisionFilesSealed(t *testing.T) {
+
+	tmpDir := t.TempDir()
+
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		t.Fatalf("failed to create temp dir: %v", err)
+		t.Fatalf("failed to generate a key: %v", err)
 	}
-	defer os.RemoveAll(tmpDir)
 
-	// Create a temporary file for the test
-	tmpFile, err := os.CreateTemp(tmpDir, "agent-config.json")
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
 	if err != nil {
-		t.Fatalf("failed to create temp file: %v", err)
+		t.Fatalf("failed to marshal a public key: %v", err)
 	}
-
-	defer os.Remove(tmpFile.Name())
-
-	// Create an instance of AgentConfig
-	agentConfig := AgentConfig{
-		// Set the fields of AgentConfig
-		EnableSignatureVerification: true,
-		ServerAddr:                  "unix:///run/kata-containers/agent.sock",
-		AaKbcParams:                 "cc_kbc::http://192.168.1.2:8080",
+	keyPath := filepath.Join(tmpDir, "user-data-signing.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		t.Fatalf("failed to write a public key: %v", err)
 	}
 
-	// Call the writeAgentConfig function
-	err = writeAgentConfig(agentConfig, tmpFile.Name())
-	assert.NoError(t, err)
+	sealer, err := userdata.NewSealer(signer, nil, "")
+	if err != nil {
+		t.Fatalf("failed to create a sealer: %v", err)
+	}
 
-	// Parse the agent config file data
-	newAgentConfig, err := parseAgentConfig(tmpFile.Name())
+	testUserData := `{"pod-network":{"podip":"10.244.0.19/24"}}`
+	sealed, err := sealer.Seal([]byte(testUserData))
 	if err != nil {
-		t.Fatalf("failed to parse agent config file: %v", err)
+		t.Fatalf("failed to seal userData: %v", err)
 	}
 
-	// Add the missing field to the agentConfig
-	newAgentConfig.ImageRegistryAuthFile = "file:///etc/attestation-agent/auth.json"
-	newAgentConfig.Endpoints.Allowed = []string{""}
+	sealedPath := filepath.Join(tmpDir, "daemon.json.sealed")
+	daemonConfigPath := filepath.Join(tmpDir, "daemon.json")
+	if err := os.WriteFile(sealedPath, sealed, 0644); err != nil {
+		t.Fatalf("failed to write sealed userData: %v", err)
+	}
 
-	// Update existing field
-	newAgentConfig.AaKbcParams = "cc_kbc::offline_kbc"
+	saved := cfg
+	defer func() { cfg = saved }()
 
-	// Call the writeAgentConfig function
-	err = writeAgentConfig(*newAgentConfig, tmpFile.Name())
-	assert.NoError(t, err)
+	cfg.daemonConfigPath = daemonConfigPath
+	cfg.dataSource = dataSourceFile
+	cfg.userDataFilePaths = []string{sealedPath, daemonConfigPath}
+	cfg.userDataFetchTimeout = 5
+	cfg.userDataVerificationKeyPath = keyPath
 
-	// Parse the agent config file data
-	tmpAgentConfig, err := parseAgentConfig(tmpFile.Name())
-	if err != nil {
-		t.Fatalf("failed to parse agent config file: %v", err)
-	}
+	// The second run simulates a restart of the pod VM
+	for i := 0; i < 2; i++ {
+		if err := provisionFiles(nil, nil); err != nil {
+			t.Fatalf("Expect no error at run %d, got %v", i, err)
+		}
+
+		data, err := os.ReadFile(daemonConfigPath)
+		if err != nil {
+			t.Fatalf("failed to read the daemon config: %v", err)
+		}
+		assert.Equal(t, testUserData, string(data))
 
-	// Check if tmpAgentConfig has the new fields
-	assert.Equal(t, newAgentConfig, tmpAgentConfig)
+		data, err = os.ReadFile(sealedPath)
+		if err != nil {
+			t.Fatalf("failed to read the sealed daemon config: %v", err)
+		}
+		assert.Equal(t, string(sealed), string(data))
+	}
 }
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+var bareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKeyValue is a key-value expression found in a TOML document
+type tomlKeyValue struct {
+	path       []string // full key path including the enclosing table
+	valueStart int      // offset of the first byte of the value
+	valueEnd   int      // offset next to the last byte of the value
+	lineEnd    int      // offset next to the value or its trailing comment
+}
+
+// tomlTable is a standard table, including the root table, declared in a TOML document
+type tomlTable struct {
+	path      []string
+	insertPos int // offset where a new key-value of this table is inserted
+	hasKeys   bool
+	newLines  []string // key-values to be added to this table
+}
+
+// tomlDocument describes the locations of key-values and tables in a TOML document
+type tomlDocument struct {
+	data      []byte
+	keyValues []*tomlKeyValue
+	tables    []*tomlTable
+}
+
+// tomlEdit replaces data[start:end] with text
+type tomlEdit struct {
+	start, end int
+	text       string
+}
+
+// mergeAgentConfig merges values into a kata agent config TOML document.
+// Existing keys are updated in place and missing keys are added to their enclosing table,
+// so comments, formatting and settings that do not appear in values are preserved.
+// A nested map in values is merged into the corresponding table instead of replacing it.
+func mergeAgentConfig(data []byte, values map[string]interface{}) ([]byte, error) {
+
+	doc, err := parseTOMLDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agent config: %w", err)
+	}
+
+	var edits []tomlEdit
+	if err := doc.merge(nil, values, &edits); err != nil {
+		return nil, err
+	}
+
+	for _, table := range doc.tables {
+		if len(table.newLines) > 0 {
+			edits = append(edits, doc.insertEdit(table))
+		}
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var merged bytes.Buffer
+	pos := 0
+	for _, edit := range edits {
+		merged.Write(data[pos:edit.start])
+		merged.WriteString(edit.text)
+		pos = edit.end
+	}
+	merged.Write(data[pos:])
+
+	if err := validateAgentConfig(merged.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return merged.Bytes(), nil
+}
+
+// validateAgentConfig checks that data is a valid TOML document and that the settings
+// known to process-user-data have the expected types
+func validateAgentConfig(data []byte) error {
+
+	var generic map[string]interface{}
+	if err := toml.Unmarshal(data, &generic); err != nil {
+		return fmt.Errorf("invalid agent config: %w", err)
+	}
+
+	var agentConfig AgentConfig
+	if err := toml.Unmarshal(data, &agentConfig); err != nil {
+		return fmt.Errorf("invalid agent config: %w", err)
+	}
+
+	return nil
+}
+
+func (doc *tomlDocument) merge(prefix []string, values map[string]interface{}, edits *[]tomlEdit) error {
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := append(append([]string{}, prefix...), key)
+
+		if table, ok := values[key].(map[string]interface{}); ok {
+			if err := doc.merge(path, table, edits); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := encodeTOMLValue(values[key])
+		if err != nil {
+			return fmt.Errorf("failed to encode agent config %s: %w", strings.Join(path, "."), err)
+		}
+
+		if kv := doc.findKeyValue(path); kv != nil {
+			*edits = append(*edits, tomlEdit{start: kv.valueStart, end: kv.valueEnd, text: value})
+			continue
+		}
+
+		table := doc.findTable(path)
+		table.newLines = append(table.newLines, encodeTOMLKey(path[len(table.path):])+" = "+value)
+	}
+
+	return nil
+}
+
+// insertEdit returns an edit that adds the new key-values to a table
+func (doc *tomlDocument) insertEdit(table *tomlTable) tomlEdit {
+
+	lines := strings.Join(table.newLines, "\n")
+
+	switch {
+	case table.hasKeys || len(table.path) > 0:
+		lines = "\n" + lines
+	case table.insertPos < len(doc.data):
+		// The root table has no key. Keep a blank line before the first table header.
+		lines += "\n\n"
+	case len(doc.data) > 0 && doc.data[len(doc.data)-1] != '\n':
+		lines = "\n" + lines + "\n"
+	default:
+		lines += "\n"
+	}
+
+	return tomlEdit{start: table.insertPos, end: table.insertPos, text: lines}
+}
+
+func (doc *tomlDocument) findKeyValue(path []string) *tomlKeyValue {
+	for _, kv := range doc.keyValues {
+		if equalPath(kv.path, path) {
+			return kv
+		}
+	}
+	return nil
+}
+
+// findTable returns the most specific table that can hold a new key of path
+func (doc *tomlDocument) findTable(path []string) *tomlTable {
+	found := doc.tables[0]
+	for _, table := range doc.tables[1:] {
+		if len(table.path) < len(path) && len(table.path) > len(found.path) && equalPath(table.path, path[:len(table.path)]) {
+			found = table
+		}
+	}
+	return found
+}
+
+// tomlExpression holds the information of a top level expression that is needed after the parser moves on
+type tomlExpression struct {
+	kind    unstable.Kind
+	keys    []string
+	start   int
+	keyEnd  int
+	comment int // offset of a trailing comment, or -1
+}
+
+func parseTOMLDocument(data []byte) (*tomlDocument, error) {
+
+	p := unstable.Parser{KeepComments: true}
+	p.Reset(data)
+
+	var exprs []tomlExpression
+
+	for p.NextExpression() {
+		node := p.Expression()
+
+		expr := tomlExpression{kind: node.Kind, comment: -1}
+
+		if node.Kind == unstable.Comment {
+			expr.start = int(node.Raw.Offset)
+			exprs = append(exprs, expr)
+			continue
+		}
+
+		it := node.Key()
+		for it.Next() {
+			key := it.Node()
+			if len(expr.keys) == 0 {
+				expr.start = int(key.Raw.Offset)
+			}
+			expr.keys = append(expr.keys, string(key.Data))
+			expr.keyEnd = int(key.Raw.Offset + key.Raw.Length)
+		}
+
+		if node.Kind == unstable.Table || node.Kind == unstable.ArrayTable {
+			// Move the start to the opening bracket of the table header
+			for expr.start > 0 && data[expr.start] != '[' {
+				expr.start--
+			}
+			if node.Kind == unstable.ArrayTable && expr.start > 0 {
+				expr.start--
+			}
+		}
+
+		if next := node.Next(); next != nil && next.Kind == unstable.Comment {
+			expr.comment = int(next.Raw.Offset)
+		}
+
+		exprs = append(exprs, expr)
+	}
+	if err := p.Error(); err != nil {
+		return nil, err
+	}
+
+	root := &tomlTable{insertPos: len(data)}
+	doc := &tomlDocument{data: data, tables: []*tomlTable{root}}
+
+	current := root
+	inArrayTable := false
+
+	for i, expr := range exprs {
+
+		exprEnd := len(data)
+		if i+1 < len(exprs) {
+			exprEnd = exprs[i+1].start
+		}
+
+		switch expr.kind {
+		case unstable.Table:
+			if !root.hasKeys && root.insertPos == len(data) {
+				root.insertPos = expr.start
+			}
+			end := trimTOMLSpace(data, expr.keyEnd, exprEnd)
+			current = &tomlTable{path: expr.keys, insertPos: end}
+			doc.tables = append(doc.tables, current)
+			inArrayTable = false
+
+		case unstable.ArrayTable:
+			if !root.hasKeys && root.insertPos == len(data) {
+				root.insertPos = expr.start
+			}
+			// Keys in an array of tables are not addressable by a key path
+			current = nil
+			inArrayTable = true
+
+		case unstable.KeyValue:
+			if inArrayTable {
+				continue
+			}
+
+			valueStart := expr.keyEnd
+			for valueStart < len(data) && (data[valueStart] == ' ' || data[valueStart] == '\t' || data[valueStart] == '=') {
+				valueStart++
+			}
+
+			valueEnd := exprEnd
+			if expr.comment >= 0 {
+				valueEnd = expr.comment
+			}
+			valueEnd = trimTOMLSpace(data, valueStart, valueEnd)
+
+			path := append(append([]string{}, current.path...), expr.keys...)
+			doc.keyValues = append(doc.keyValues, &tomlKeyValue{
+				path:       path,
+				valueStart: valueStart,
+				valueEnd:   valueEnd,
+				lineEnd:    trimTOMLSpace(data, valueStart, exprEnd),
+			})
+
+			current.insertPos = doc.keyValues[len(doc.keyValues)-1].lineEnd
+			current.hasKeys = true
+		}
+	}
+
+	return doc, nil
+}
+
+// trimTOMLSpace returns the offset next to the last non-whitespace byte of data[start:end]
+func trimTOMLSpace(data []byte, start, end int) int {
+	for end > start && strings.ContainsRune(" \t\r\n", rune(data[end-1])) {
+		end--
+	}
+	return end
+}
+
+// encodeTOMLValue encodes v, which is typically decoded from JSON, as a TOML inline value
+func encodeTOMLValue(v interface{}) (string, error) {
+
+	switch v := v.(type) {
+	case string:
+		return encodeTOMLString(v)
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		// TOML distinguishes integers from floats, while JSON numbers are always decoded as float64
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("unsupported number %v", v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return v.String(), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", fmt.Errorf("invalid number %s: %w", v, err)
+		}
+		return encodeTOMLValue(f)
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, e := range v {
+			elem, err := encodeTOMLValue(e)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = elem
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		elems := make([]string, len(keys))
+		for i, key := range keys {
+			elem, err := encodeTOMLValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			elems[i] = encodeTOMLKey([]string{key}) + " = " + elem
+		}
+		return "{" + strings.Join(elems, ", ") + "}", nil
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// encodeTOMLString encodes s as a TOML basic string. A JSON string is a valid TOML basic string.
+func encodeTOMLString(s string) (string, error) {
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(s); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func encodeTOMLKey(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		if bareKeyRegexp.MatchString(key) {
+			keys[i] = key
+		} else {
+			// encodeTOMLString never fails for a string
+			keys[i], _ = encodeTOMLString(key)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -9,30 +9,11 @@ import (
 	"time"
 
 	"github.com/avast/retry-go/v4"
-	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/aws"
-	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/azure"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
 	"github.com/spf13/cobra"
 )
 
-// Get the provider and the URL to retrieve the userData from the instance metadata service
-func getProviderAndUserDataURL(ctx context.Context) (provider string, userDataUrl string) {
-
-	if azure.IsAzure(ctx) {
-		provider = providerAzure
-		// If the VM is running on Azure, retrieve the userData from the Azure IMDS endpoint
-		userDataUrl = azure.AzureUserDataImdsUrl
-	}
-
-	if aws.IsAWS(ctx) {
-		provider = providerAws
-		// If the VM is running on AWS, retrieve the userData from the AWS IMDS endpoint
-		userDataUrl = aws.AWSUserDataImdsUrl
-	}
-
-	return provider, userDataUrl
-}
-
 // Add method to parse the userData and copy it to a file
 func parseAndCopyUserData(userData string, dstFilePath string) error {
 
@@ -84,6 +65,38 @@ func getConfigFromUserData(userData string) daemon.Config {
 	return daemonConfig
 }
 
+// Verify the signature of userData sealed by cloud-api-adaptor with the public key baked in the pod VM image.
+// When the public key exists, unsigned userData is rejected. It returns nil if userData is not sealed.
+func verifyUserData(userData string, verificationKeyPath string) (*userdata.Envelope, error) {
+
+	sealed := userdata.IsSealed([]byte(userData))
+
+	if verificationKeyPath != "" {
+		if _, err := os.Stat(verificationKeyPath); err == nil {
+
+			if !sealed {
+				return nil, fmt.Errorf("userData is not signed")
+			}
+
+			publicKey, err := userdata.LoadVerificationKey(verificationKeyPath)
+			if err != nil {
+				return nil, err
+			}
+
+			return userdata.Verify([]byte(userData), publicKey)
+
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to access verification key: %s", err)
+		}
+	}
+
+	if sealed {
+		return nil, fmt.Errorf("userData is signed, but verification key %q is not found", verificationKeyPath)
+	}
+
+	return nil, nil
+}
+
 func provisionFiles(cmd *cobra.Command, args []string) error {
 
 	var (
@@ -100,29 +113,53 @@ func provisionFiles(cmd *cobra.Command, args []string) error {
 	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(cfg.userDataFetchTimeout)*time.Second)
 	defer cancel()
 
-	// Get the provider and userData URL
-	provider, userDataUrl := getProviderAndUserDataURL(ctx)
-
-	fmt.Printf("provider: %s, userDataUrl: %s\n", provider, userDataUrl)
+	var source dataSource
 
 	err := retry.Do(
 		func() error {
 
-			var err error
-			// Get the userData depending on the provider
-			switch provider {
-			case providerAzure:
-				cfg.userData, err = azure.GetUserData(ctx, userDataUrl)
-			case providerAws:
-				cfg.userData, err = aws.GetUserData(ctx, userDataUrl)
-			default:
-				return fmt.Errorf("unsupported provider")
+			// Find the data source of userData. It is retried, since a metadata service
+			// may not be reachable until the network is configured
+			if source == nil {
+				var err error
+				source, err = findDataSource(ctx, getDataSources(cfg.userDataFilePaths), cfg.dataSource)
+				if err != nil {
+					return fmt.Errorf("failed to find a data source of userData: %s", err)
+				}
+				fmt.Printf("data source: %s\n", source.Name())
 			}
 
+			userData, err := source.GetUserData(ctx)
 			if err != nil {
 				return fmt.Errorf("failed to get userData: %s", err)
 			}
 
+			// Data sources other than Azure and AWS deliver the daemon config in a cloud-config
+			daemonConfig, err := extractDaemonConfig(userData)
+			if err != nil {
+				return fmt.Errorf("failed to get daemon config from userData: %s", err)
+			}
+
+			// A signature mismatch is not retried, so that the forwarder never starts with tampered userData
+			envelope, err := verifyUserData(daemonConfig, cfg.userDataVerificationKeyPath)
+			if err != nil {
+				return retry.Unrecoverable(fmt.Errorf("failed to verify userData: %s", err))
+			}
+
+			cfg.userData = daemonConfig
+			if envelope != nil {
+				var keys userdata.KeyProvider
+				if cfg.userDataKeyCommand != "" {
+					keys = userdata.NewCommandKeyProvider(cfg.userDataKeyCommand)
+				}
+
+				payload, err := envelope.Open(ctx, keys)
+				if err != nil {
+					return fmt.Errorf("failed to open userData: %s", err)
+				}
+				cfg.userData = string(payload)
+			}
+
 			if cfg.userData != "" && strings.Contains(cfg.userData, "podip") {
 				return nil // Valid user data, stop retrying
 			}
@@ -138,7 +175,7 @@ func provisionFiles(cmd *cobra.Command, args []string) error {
 	)
 
 	if err != nil {
-		return fmt.Errorf("failed to get valid user data")
+		return fmt.Errorf("failed to get valid user data: %s", err)
 	}
 
 	fmt.Printf("Valid user data: %s\n", cfg.userData)
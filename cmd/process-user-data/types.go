@@ -1,13 +1,22 @@
 package main
 
 const (
-	programName   = "process-user-data"
-	providerAzure = "azure"
-	providerAws   = "aws"
+	programName      = "process-user-data"
+	providerAzure    = "azure"
+	providerAws      = "aws"
+	providerIBMCloud = "ibmcloud"
+	providerVSphere  = "vsphere"
+
+	dataSourceConfigDrive = "config-drive"
+	dataSourceFile        = "file"
 
 	defaultAgentConfigPath  = "/etc/agent-config.toml"
 	defaultAuthJsonFilePath = "/etc/auth.json"
 	offlineKbcAuthFile      = "/etc/aa-offline_fs_kbc-resources.json"
+	defaultUserDataFilePath = "/peerpod/user-data"
+
+	// Public key to verify the signature of user data, which is baked in a pod VM image
+	defaultUserDataVerificationKeyPath = "/etc/peerpod/user-data-signing.pub"
 )
 
 type Config struct {
@@ -15,6 +24,13 @@ type Config struct {
 	agentConfigPath      string
 	userData             string
 	userDataFetchTimeout int
+	userDataFilePaths    []string
+	dataSource           string
+
+	userDataVerificationKeyPath string
+	userDataKeyCommand          string
+
+	tunableAgentSettings []string
 }
 
 type Endpoints struct {
@@ -4,10 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"reflect"
 
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
-	toml "github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
 )
 
@@ -53,29 +52,20 @@ func updateAAKBCParams(aaKBCParams string, agentConfigFile string) error {
 		return fmt.Errorf("agentConfigFile is empty")
 	}
 
-	// Replace the aa_kbc_params line in agentConfigFile with the aaKBCParams value
 	// Read the agentConfigFile
 	agentConfig, err := os.ReadFile(agentConfigFile)
 	if err != nil {
 		return fmt.Errorf("failed to read agent config file: %s", err)
 	}
 
-	// Split the agentConfigFile into lines
-	lines := strings.Split(string(agentConfig), "\n")
-
-	// Loop through the lines and replace the line that starts with aa_kbc_params
-	for i, line := range lines {
-		if strings.Contains(line, "aa_kbc_params") {
-			lines[i] = fmt.Sprintf("aa_kbc_params = \"%s\"", aaKBCParams)
-			fmt.Printf("Updated line: %s\n", lines[i])
-		}
+	// Replace the value of aa_kbc_params, keeping the rest of the file as it is
+	newAgentConfig, err := mergeAgentConfig(agentConfig, map[string]interface{}{"aa_kbc_params": aaKBCParams})
+	if err != nil {
+		return fmt.Errorf("failed to update aa_kbc_params: %s", err)
 	}
 
-	// Join the lines back into a string
-	newAgentConfig := strings.Join(lines, "\n")
-
 	// Write the newAgentConfig to the agentConfigFile
-	err = os.WriteFile(agentConfigFile, []byte(newAgentConfig), 0644)
+	err = os.WriteFile(agentConfigFile, newAgentConfig, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write agent config file: %s", err)
 	}
@@ -91,19 +81,30 @@ func updateAgentConfig(cmd *cobra.Command, args []string) error {
 	// It's assumed that the local file is already provisioned either via the provision-files command
 	// or via some other means
 	config := getConfigFromLocalFile(cfg.daemonConfigPath)
-	if config == (daemon.Config{}) {
+	if reflect.DeepEqual(config, daemon.Config{}) {
 		return fmt.Errorf("failed to get daemon config from local file")
 	}
 
-	// Parse the agent config file
-	agentConfig, err := parseAgentConfig(cfg.agentConfigPath)
+	// Read the agent config file
+	agentConfig, err := os.ReadFile(cfg.agentConfigPath)
 	if err != nil {
-		return fmt.Errorf("failed to parse agent config file: %s", err)
+		return fmt.Errorf("failed to read agent config file: %s", err)
+	}
+
+	// The settings are checked again in the pod VM, since the daemon config may not be created by cloud-api-adaptor
+	if err := daemon.ValidateAgentConfig(config.AgentConfig, cfg.tunableAgentSettings); err != nil {
+		return fmt.Errorf("failed to update agent config file: %s", err)
+	}
+
+	// Tunable agent settings are merged first, so that the dedicated daemon config fields take precedence
+	values := make(map[string]interface{}, len(config.AgentConfig)+2)
+	for key, value := range config.AgentConfig {
+		values[key] = value
 	}
 
 	if config.AAKBCParams != "" {
-		fmt.Printf("Updating aa_kbc_params in agent config file")
-		agentConfig.AaKbcParams = config.AAKBCParams
+		fmt.Printf("Updating aa_kbc_params in agent config file\n")
+		values["aa_kbc_params"] = config.AAKBCParams
 	}
 
 	if config.AuthJson != "" {
@@ -121,54 +122,27 @@ func updateAgentConfig(cmd *cobra.Command, args []string) error {
 		}
 
 		// Update the file path in the agent config
-		agentConfig.ImageRegistryAuthFile = "file://" + defaultAuthJsonFilePath
+		values["image_registry_auth_file"] = "file://" + defaultAuthJsonFilePath
 
 	}
 
-	// Write the updated agent config file
-	err = writeAgentConfig(*agentConfig, cfg.agentConfigPath)
-	if err != nil {
-		return fmt.Errorf("failed to write agent config file: %s", err)
-	}
-
-	return nil
-}
-
-// Kata agent config is a TOML file, parse it and return the AgentConfig struct
-func parseAgentConfig(agentConfigFile string) (agentConfig *AgentConfig, err error) {
-
-	agentConfig = &AgentConfig{}
-
-	data, err := os.ReadFile(agentConfigFile)
-	if err != nil {
-		fmt.Println("Error reading file:", err)
-		return nil, err
-	}
-
-	// Parse the agent config file data
-	err = toml.Unmarshal(data, agentConfig)
-	if err != nil {
-		fmt.Println("Error parsing agent config file:", err)
-		return nil, err
+	if len(values) == 0 {
+		fmt.Printf("No agent config update. Nothing to do\n")
+		return nil
 	}
 
-	return agentConfig, nil
-}
-
-// Write the agent config file
-func writeAgentConfig(agentConfig AgentConfig, agentConfigFile string) error {
-
-	data, err := toml.Marshal(agentConfig)
+	// Merge the values into the agent config file. Unknown settings and comments are preserved.
+	newAgentConfig, err := mergeAgentConfig(agentConfig, values)
 	if err != nil {
-		return fmt.Errorf("error marshalling agent config: %s", err)
+		return fmt.Errorf("failed to update agent config file: %s", err)
 	}
 
-	// Write the newAgentConfig to the agentConfigFile
-	err = os.WriteFile(agentConfigFile, data, 0644)
+	err = os.WriteFile(cfg.agentConfigPath, newAgentConfig, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write agent config file: %s", err)
 	}
 
-	fmt.Printf("Updated agent config file: %s\n", agentConfigFile)
+	fmt.Printf("Updated agent config file: %s\n", cfg.agentConfigPath)
+
 	return nil
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// vmware-rpctool is provided by open-vm-tools
+const vmwareRpctoolPath = "/usr/bin/vmware-rpctool"
+
+// vSphere guestinfo variables, which are set in the VM extra config by cloud-api-adaptor
+type vsphereDataSource struct {
+	rpctool string
+}
+
+func (s *vsphereDataSource) Name() string {
+	return providerVSphere
+}
+
+func (s *vsphereDataSource) IsAvailable(ctx context.Context) bool {
+	userData, err := s.getGuestInfo(ctx, "guestinfo.userdata")
+	return err == nil && userData != ""
+}
+
+func (s *vsphereDataSource) GetUserData(ctx context.Context) (string, error) {
+
+	userData, err := s.getGuestInfo(ctx, "guestinfo.userdata")
+	if err != nil {
+		return "", err
+	}
+
+	// guestinfo.userdata.encoding is optional
+	encoding, err := s.getGuestInfo(ctx, "guestinfo.userdata.encoding")
+	if err != nil {
+		encoding = ""
+	}
+
+	return decodeGuestInfo(userData, encoding)
+}
+
+func (s *vsphereDataSource) getGuestInfo(ctx context.Context, key string) (string, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.rpctool, "info-get "+key)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get %s: %s: %s", key, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Decode a guestinfo value in the encoding supported by cloud-init
+// https://cloudinit.readthedocs.io/en/latest/reference/datasources/vmware.html
+func decodeGuestInfo(value, encoding string) (string, error) {
+
+	switch encoding {
+	case "":
+		return value, nil
+	case "b64", "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode guestinfo: %s", err)
+		}
+		return string(data), nil
+	case "gz+b64", "gzip+base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode guestinfo: %s", err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress guestinfo: %s", err)
+		}
+		defer reader.Close()
+
+		decompressed, err := io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress guestinfo: %s", err)
+		}
+		return string(decompressed), nil
+	default:
+		return "", fmt.Errorf("unsupported guestinfo encoding: %s", encoding)
+	}
+}
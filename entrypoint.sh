@@ -14,9 +14,20 @@ optionals+=""
 [[ "${VXLAN_PORT}" ]] && optionals+="-vxlan-port ${VXLAN_PORT} "
 [[ "${CACERT_FILE}" ]] && optionals+="-ca-cert-file ${CACERT_FILE} "
 [[ "${CERT_FILE}" ]] && [[ "${CERT_KEY}" ]] && optionals+="-cert-file ${CERT_FILE} -cert-key ${CERT_KEY} "
+[[ "${CA_CERT_DIR}" ]] && optionals+="-ca-cert-dir ${CA_CERT_DIR} "
+[[ "${SERVER_CERT_LIFETIME}" ]] && optionals+="-server-cert-lifetime ${SERVER_CERT_LIFETIME} "
+[[ "${SERVER_IDENTITY}" ]] && optionals+="-server-identity ${SERVER_IDENTITY} "
+[[ "${SERVER_NAME}" ]] && optionals+="-server-name ${SERVER_NAME} "
+[[ "${SPIFFE_TRUST_DOMAIN}" ]] && optionals+="-spiffe-trust-domain ${SPIFFE_TRUST_DOMAIN} "
+[[ "${ATTESTATION_VERIFIER}" ]] && optionals+="-attestation-verifier ${ATTESTATION_VERIFIER} "
+[[ "${ATTESTATION_POLICY}" ]] && optionals+="-attestation-policy ${ATTESTATION_POLICY} "
+[[ "${USER_DATA_SIGNING_KEY}" ]] && optionals+="-user-data-signing-key ${USER_DATA_SIGNING_KEY} "
+[[ "${USER_DATA_ENCRYPTION_KEY}" ]] && optionals+="-user-data-encryption-key ${USER_DATA_ENCRYPTION_KEY} "
+[[ "${USER_DATA_ENCRYPTION_KEY_ID}" ]] && optionals+="-user-data-encryption-key-id ${USER_DATA_ENCRYPTION_KEY_ID} "
 [[ "${TLS_SKIP_VERIFY}" ]] && optionals+="-tls-skip-verify "
 [[ "${PROXY_TIMEOUT}" ]] && optionals+="-proxy-timeout ${PROXY_TIMEOUT} "
 [[ "${AA_KBC_PARAMS}" ]] && optionals+="-aa-kbc-params ${AA_KBC_PARAMS} "
+[[ "${TUNABLE_AGENT_SETTINGS+set}" ]] && optionals+="-tunable-agent-settings=${TUNABLE_AGENT_SETTINGS} "
 [[ "${CLOUD_CONFIG_VERIFY}" == "true" ]] && optionals+="-cloud-config-verify "
 
 test_vars() {
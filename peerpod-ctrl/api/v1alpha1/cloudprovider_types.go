@@ -0,0 +1,102 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CloudProviderName is the name of the CloudProvider object that peerpod-ctrl maintains
+const CloudProviderName = "default"
+
+// Condition types of a CloudProvider
+const (
+	// ProviderReady indicates whether peerpod-ctrl loaded the cloud provider from peer-pods-cm and peer-pods-secret
+	ProviderReady = "ProviderReady"
+)
+
+// CloudProviderStatus defines the observed state of the cloud provider used by peerpod-ctrl
+type CloudProviderStatus struct {
+	// Provider is the name of the cloud provider, e.g. aws
+	// +optional
+	Provider string `json:"provider,omitempty"`
+
+	// ConfigMapResourceVersion is the resource version of peer-pods-cm that the provider was loaded from
+	// +optional
+	ConfigMapResourceVersion string `json:"configMapResourceVersion,omitempty"`
+
+	// SecretResourceVersion is the resource version of peer-pods-secret that the provider was loaded from
+	// +optional
+	SecretResourceVersion string `json:"secretResourceVersion,omitempty"`
+
+	// LastLoadedTimestamp is the time when the provider was last (re)loaded
+	// +optional
+	LastLoadedTimestamp *metav1.Time `json:"lastLoadedTimestamp,omitempty"`
+
+	// Quota is the remaining quota of the cloud account, if the cloud provider supports it
+	// +optional
+	Quota *CloudQuota `json:"quota,omitempty"`
+
+	// Conditions represent the latest available observations of the cloud provider, e.g. ProviderReady
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// CloudQuota is the remaining quota of the cloud account for new instances
+type CloudQuota struct {
+	// Instances is the number of instances that can still be created, or -1 if it is not known
+	Instances int64 `json:"instances"`
+
+	// VCPUs is the number of vCPUs that can still be allocated, or -1 if it is not known
+	VCPUs int64 `json:"vcpus"`
+
+	// LastUpdateTime is the time when the quota was checked
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.status.provider`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="ProviderReady")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="ProviderReady")].reason`
+//+kubebuilder:printcolumn:name="Instance Quota",type=integer,JSONPath=`.status.quota.instances`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// CloudProvider reports the state of the cloud provider configuration of peerpod-ctrl.
+// peerpod-ctrl maintains a single object named "default".
+type CloudProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status CloudProviderStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// CloudProviderList contains a list of CloudProvider
+type CloudProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CloudProvider `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CloudProvider{}, &CloudProviderList{})
+}
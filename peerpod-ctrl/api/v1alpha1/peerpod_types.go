@@ -29,13 +29,94 @@ type PeerPodSpec struct {
 	InstanceID    string `json:"instanceID,omitempty"`
 }
 
+// PeerPodPhase is a lifecycle phase of a peer pod VM
+// +kubebuilder:validation:Enum=Creating;Running;Deleting;Failed
+type PeerPodPhase string
+
+const (
+	// PeerPodCreating means that the instance is created, and the pod VM is booting
+	PeerPodCreating PeerPodPhase = "Creating"
+	// PeerPodRunning means that the kata agent in the pod VM is connected
+	PeerPodRunning PeerPodPhase = "Running"
+	// PeerPodDeleting means that the instance is being deleted
+	PeerPodDeleting PeerPodPhase = "Deleting"
+	// PeerPodFailed means that the pod VM failed to start, or the instance failed to be deleted
+	PeerPodFailed PeerPodPhase = "Failed"
+)
+
+// Condition types of a PeerPod
+const (
+	// TunnelReady indicates whether the pod network tunnel between the worker node and the pod VM is healthy
+	TunnelReady = "TunnelReady"
+	// AgentConnected indicates whether cloud-api-adaptor is connected to the kata agent in the pod VM
+	AgentConnected = "AgentConnected"
+	// InstanceDeleted indicates whether peerpod-ctrl deleted the instance of a PeerPod being deleted
+	InstanceDeleted = "InstanceDeleted"
+	// InstanceRunning indicates whether the instance still exists and is running, as checked periodically by peerpod-ctrl
+	InstanceRunning = "InstanceRunning"
+)
+
+// ForceReleaseAnnotation is an annotation of a PeerPod. When it is set to "true", peerpod-ctrl removes
+// the finalizer of the PeerPod being deleted without deleting the instance, which must be cleaned up manually.
+const ForceReleaseAnnotation = "peerpod.confidentialcontainers.org/force-release"
+
 // PeerPodStatus defines the observed state of PeerPod
 type PeerPodStatus struct {
-	Cleaned bool `json:"cleand,omitempty"`
+	// Phase is the lifecycle phase of the peer pod VM
+	// +optional
+	Phase PeerPodPhase `json:"phase,omitempty"`
+
+	// InstanceName is the name of the instance in the cloud provider
+	// +optional
+	InstanceName string `json:"instanceName,omitempty"`
+
+	// InstanceType is the instance type or profile of the instance
+	// +optional
+	InstanceType string `json:"instanceType,omitempty"`
+
+	// IPs are the IP addresses of the instance. The first one is used for the pod network tunnel.
+	// +optional
+	IPs []string `json:"ips,omitempty"`
+
+	// Zone is the availability zone of the instance
+	// +optional
+	Zone string `json:"zone,omitempty"`
+
+	// Image is the pod VM image of the instance
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// InstanceCreationTimestamp is the time when the instance was created
+	// +optional
+	InstanceCreationTimestamp *metav1.Time `json:"instanceCreationTimestamp,omitempty"`
+
+	// RunningTimestamp is the time when the kata agent in the pod VM got connected
+	// +optional
+	RunningTimestamp *metav1.Time `json:"runningTimestamp,omitempty"`
+
+	// DeletionAttempts is the number of failed attempts of peerpod-ctrl to delete the instance
+	// +optional
+	DeletionAttempts int32 `json:"deletionAttempts,omitempty"`
+
+	// Conditions represent the latest available observations of the PeerPod, e.g. TunnelReady
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// +genclient
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Instance",type=string,JSONPath=`.status.instanceName`
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.status.instanceType`
+//+kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.ips[0]`
+//+kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.cloudProvider`
+//+kubebuilder:printcolumn:name="Instance ID",type=string,JSONPath=`.spec.instanceID`,priority=1
+//+kubebuilder:printcolumn:name="Zone",type=string,JSONPath=`.status.zone`,priority=1
+//+kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.status.image`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // PeerPod is the Schema for the peerpods API
 type PeerPod struct {
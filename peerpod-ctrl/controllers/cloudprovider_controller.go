@@ -0,0 +1,269 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/cloudmgr"
+)
+
+const (
+	ppConfigMap = "peer-pods-cm"
+	ppSecret    = "peer-pods-secret"
+
+	// Interval between attempts to load an invalid configuration
+	configRetryInterval = time.Minute
+)
+
+// CloudProviderReconciler loads the cloud provider from peer-pods-cm and peer-pods-secret, reloads it when they
+// change, and reports the result in the status of the CloudProvider object
+type CloudProviderReconciler struct {
+	client.Client
+
+	// Cache reads peer-pods-cm and peer-pods-secret in Namespace
+	Cache     cache.Cache
+	Namespace string
+
+	// Provider is updated with the loaded cloud provider
+	Provider *ReloadableProvider
+
+	// QuotaRefreshInterval is the interval of checking the remaining quota of the cloud account, which is reported
+	// in the status. Zero disables the check. The check requires a cloud provider that implements cloud.QuotaChecker.
+	QuotaRefreshInterval time.Duration
+
+	// newProvider creates a cloud provider, and defaults to the cloud providers of cloudmgr
+	newProvider func(cloudName string, config map[string]string) (cloud.Provider, error)
+
+	// configHash identifies the configuration of the loaded cloud provider
+	configHash string
+}
+
+//+kubebuilder:rbac:groups="",namespace=system,resources=configmaps;secrets,verbs=get;list;watch
+
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=cloudproviders,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=cloudproviders/status,verbs=get;update;patch
+
+func (r *CloudProviderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var cm corev1.ConfigMap
+	if err := r.Cache.Get(ctx, types.NamespacedName{Name: ppConfigMap, Namespace: r.Namespace}, &cm); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	var secret corev1.Secret
+	if err := r.Cache.Get(ctx, types.NamespacedName{Name: ppSecret, Namespace: r.Namespace}, &secret); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	config := make(map[string]string, len(cm.Data)+len(secret.Data))
+	for k, v := range cm.Data {
+		config[k] = v
+	}
+	for k, v := range secret.Data {
+		config[k] = string(v)
+	}
+
+	hash := configHash(config)
+	if hash == r.configHash && r.Provider.Get() != nil {
+		return r.refreshQuota(ctx)
+	}
+
+	cloudName := config["CLOUD_PROVIDER"]
+	if cloudName == "" {
+		cloudName = os.Getenv("CLOUD_PROVIDER")
+	}
+
+	provider, err := r.loadProvider(cloudName, config, cm.ResourceVersion == "" && secret.ResourceVersion == "")
+	if err != nil {
+		logger.Error(err, "failed to load cloud provider", "CloudProvider", cloudName)
+
+		message := err.Error()
+		if r.Provider.Get() != nil {
+			message += ", keeping the previous configuration"
+		}
+		if err := r.updateStatus(ctx, func(status *confidentialcontainersorgv1alpha1.CloudProviderStatus) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:    confidentialcontainersorgv1alpha1.ProviderReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ConfigError",
+				Message: message,
+			})
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: configRetryInterval}, nil
+	}
+
+	if err := r.Provider.Set(provider); err != nil {
+		logger.Info("failed to tear down previous cloud provider", "error", err)
+	}
+	r.configHash = hash
+
+	logger.Info("loaded cloud provider", "CloudProvider", cloudName, "ConfigMap", cm.ResourceVersion, "Secret", secret.ResourceVersion)
+
+	now := metav1.Now()
+	if err := r.updateStatus(ctx, func(status *confidentialcontainersorgv1alpha1.CloudProviderStatus) {
+		status.Provider = cloudName
+		status.ConfigMapResourceVersion = cm.ResourceVersion
+		status.SecretResourceVersion = secret.ResourceVersion
+		status.LastLoadedTimestamp = &now
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    confidentialcontainersorgv1alpha1.ProviderReady,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ProviderLoaded",
+			Message: fmt.Sprintf("Loaded cloud provider %s", cloudName),
+		})
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return r.refreshQuota(ctx)
+}
+
+// refreshQuota reports the remaining quota of the cloud account in the status, and schedules the next check
+func (r *CloudProviderReconciler) refreshQuota(ctx context.Context) (ctrl.Result, error) {
+	provider, release := r.Provider.acquire()
+	defer release()
+
+	checker, ok := provider.(cloud.QuotaChecker)
+	if !ok || r.QuotaRefreshInterval <= 0 {
+		return ctrl.Result{}, nil
+	}
+	result := ctrl.Result{RequeueAfter: r.QuotaRefreshInterval}
+
+	quota, err := checker.GetQuota(ctx)
+	if err != nil {
+		log.FromContext(ctx).Info("failed to check cloud quota", "error", err)
+		return result, nil
+	}
+
+	if err := r.updateStatus(ctx, func(status *confidentialcontainersorgv1alpha1.CloudProviderStatus) {
+		status.Quota = &confidentialcontainersorgv1alpha1.CloudQuota{
+			Instances:      quota.Instances,
+			VCPUs:          quota.VCPUs,
+			LastUpdateTime: metav1.Now(),
+		}
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return result, nil
+}
+
+func (r *CloudProviderReconciler) loadProvider(cloudName string, config map[string]string, noConfig bool) (cloud.Provider, error) {
+	if cloudName == "" {
+		if noConfig {
+			return nil, fmt.Errorf("neither %s nor %s found in namespace %s", ppConfigMap, ppSecret, r.Namespace)
+		}
+		return nil, fmt.Errorf("CLOUD_PROVIDER is not set in %s", ppConfigMap)
+	}
+
+	if r.newProvider != nil {
+		return r.newProvider(cloudName, config)
+	}
+
+	c := cloudmgr.Get(cloudName)
+	if c == nil {
+		return nil, fmt.Errorf("cloud provider %s is not supported", cloudName)
+	}
+	return c.NewProviderWithConfig(config)
+}
+
+// updateStatus applies update to the status of the CloudProvider object, which is created if it does not exist
+func (r *CloudProviderReconciler) updateStatus(ctx context.Context, update func(status *confidentialcontainersorgv1alpha1.CloudProviderStatus)) error {
+	var cp confidentialcontainersorgv1alpha1.CloudProvider
+	if err := r.Get(ctx, types.NamespacedName{Name: confidentialcontainersorgv1alpha1.CloudProviderName}, &cp); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		cp.Name = confidentialcontainersorgv1alpha1.CloudProviderName
+		if err := r.Create(ctx, &cp); err != nil {
+			return fmt.Errorf("failed to create CloudProvider: %w", err)
+		}
+	}
+
+	update(&cp.Status)
+
+	if err := r.Status().Update(ctx, &cp); err != nil {
+		return fmt.Errorf("failed to update CloudProvider status: %w", err)
+	}
+	return nil
+}
+
+// configHash returns a digest of config, which does not depend on the order of its keys
+func configHash(config map[string]string) string {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%q=%q\n", k, config[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CloudProviderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// All events are mapped to the CloudProvider object
+	request := handler.EnqueueRequestsFromMapFunc(func(client.Object) []ctrl.Request {
+		return []ctrl.Request{{NamespacedName: types.NamespacedName{Name: confidentialcontainersorgv1alpha1.CloudProviderName}}}
+	})
+	named := func(name string) builder.Predicates {
+		return builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetName() == name
+		}))
+	}
+
+	// Load the provider at startup, even if there is no configuration yet
+	initial := make(chan event.GenericEvent, 1)
+	initial <- event.GenericEvent{Object: &confidentialcontainersorgv1alpha1.CloudProvider{}}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cloudprovider").
+		Watches(source.NewKindWithCache(&corev1.ConfigMap{}, r.Cache), request, named(ppConfigMap)).
+		Watches(source.NewKindWithCache(&corev1.Secret{}, r.Cache), request, named(ppSecret)).
+		Watches(&source.Channel{Source: initial}, request).
+		Complete(r)
+}
@@ -0,0 +1,199 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
+)
+
+// readerCache reads objects from a client instead of informers
+type readerCache struct {
+	cache.Cache
+	reader client.Reader
+}
+
+func (c *readerCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.reader.Get(ctx, key, obj, opts...)
+}
+
+func TestReconcileCloudProvider(t *testing.T) {
+	scheme := newTestScheme(t)
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ppConfigMap, Namespace: "confidential-containers-system"},
+		Data:       map[string]string{"CLOUD_PROVIDER": "aws", "AWS_REGION": "us-east-1"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ppSecret, Namespace: "confidential-containers-system"},
+		Data:       map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("AKIA0123")},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm, secret).Build()
+
+	var loaded []map[string]string
+	var providers []*mockProvider
+	r := &CloudProviderReconciler{
+		Client:    c,
+		Cache:     &readerCache{reader: c},
+		Namespace: "confidential-containers-system",
+		Provider:  &ReloadableProvider{},
+		newProvider: func(cloudName string, config map[string]string) (cloud.Provider, error) {
+			if cloudName != "aws" {
+				return nil, fmt.Errorf("cloud provider %s is not supported", cloudName)
+			}
+			loaded = append(loaded, config)
+			providers = append(providers, &mockProvider{})
+			return providers[len(providers)-1], nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: confidentialcontainersorgv1alpha1.CloudProviderName}}
+
+	expectCondition := func(status metav1.ConditionStatus, reason string) *confidentialcontainersorgv1alpha1.CloudProvider {
+		t.Helper()
+		var cp confidentialcontainersorgv1alpha1.CloudProvider
+		if err := c.Get(context.Background(), req.NamespacedName, &cp); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		cond := meta.FindStatusCondition(cp.Status.Conditions, confidentialcontainersorgv1alpha1.ProviderReady)
+		if cond == nil || cond.Status != status || cond.Reason != reason {
+			t.Fatalf("Expect %s condition, got %v", reason, cond)
+		}
+		return &cp
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+	if e, a := 1, len(loaded); e != a {
+		t.Fatalf("Expect provider to be loaded %d time, got %d", e, a)
+	}
+	for k, e := range map[string]string{"CLOUD_PROVIDER": "aws", "AWS_REGION": "us-east-1", "AWS_ACCESS_KEY_ID": "AKIA0123"} {
+		if a := loaded[0][k]; e != a {
+			t.Fatalf("Expect %s=%q, got %q", k, e, a)
+		}
+	}
+	if r.Provider.Get() != providers[0] {
+		t.Fatalf("Expect provider to be set")
+	}
+	cp := expectCondition(metav1.ConditionTrue, "ProviderLoaded")
+	if e, a := "aws", cp.Status.Provider; e != a {
+		t.Fatalf("Expect provider %s, got %s", e, a)
+	}
+	if e, a := cm.ResourceVersion, cp.Status.ConfigMapResourceVersion; e != a {
+		t.Fatalf("Expect ConfigMap resource version %s, got %s", e, a)
+	}
+
+	// An invalid configuration keeps the previous provider
+	cm.Data["CLOUD_PROVIDER"] = "unknown"
+	if err := c.Update(context.Background(), cm); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := time.Minute, result.RequeueAfter; e != a {
+		t.Fatalf("Expect requeue after %v, got %v", e, a)
+	}
+	if r.Provider.Get() != providers[0] || providers[0].tornDown {
+		t.Fatalf("Expect previous provider to be kept")
+	}
+	expectCondition(metav1.ConditionFalse, "ConfigError")
+
+	// A fixed configuration replaces the provider
+	cm.Data["CLOUD_PROVIDER"] = "aws"
+	cm.Data["AWS_REGION"] = "eu-west-1"
+	if err := c.Update(context.Background(), cm); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "eu-west-1", loaded[1]["AWS_REGION"]; e != a {
+		t.Fatalf("Expect AWS_REGION=%q, got %q", e, a)
+	}
+	if r.Provider.Get() != providers[1] || !providers[0].tornDown {
+		t.Fatalf("Expect provider to be replaced")
+	}
+	expectCondition(metav1.ConditionTrue, "ProviderLoaded")
+}
+
+func TestReconcileCloudProviderQuota(t *testing.T) {
+	scheme := newTestScheme(t)
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ppConfigMap, Namespace: "confidential-containers-system"},
+		Data:       map[string]string{"CLOUD_PROVIDER": "aws"},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm).Build()
+
+	provider := &mockProvider{quota: &cloud.Quota{Instances: 19, VCPUs: -1}}
+	r := &CloudProviderReconciler{
+		Client:               c,
+		Cache:                &readerCache{reader: c},
+		Namespace:            "confidential-containers-system",
+		Provider:             &ReloadableProvider{},
+		QuotaRefreshInterval: 5 * time.Minute,
+		newProvider: func(cloudName string, config map[string]string) (cloud.Provider, error) {
+			return provider, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: confidentialcontainersorgv1alpha1.CloudProviderName}}
+
+	for _, instances := range []int64{19, 7} {
+		provider.quota.Instances = instances
+
+		result, err := r.Reconcile(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if e, a := 5*time.Minute, result.RequeueAfter; e != a {
+			t.Fatalf("Expect requeue after %v, got %v", e, a)
+		}
+
+		var cp confidentialcontainersorgv1alpha1.CloudProvider
+		if err := c.Get(context.Background(), req.NamespacedName, &cp); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if cp.Status.Quota == nil || cp.Status.Quota.Instances != instances || cp.Status.Quota.VCPUs != -1 {
+			t.Fatalf("Expect quota of %d instances, got %v", instances, cp.Status.Quota)
+		}
+	}
+}
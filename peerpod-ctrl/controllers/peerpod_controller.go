@@ -18,20 +18,26 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
-	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud/cloudmgr"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -40,15 +46,31 @@ type PeerPodReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Provider cloud.Provider
+	Recorder record.EventRecorder
+
+	// MaxDeletionAttempts is the maximum number of attempts to delete an instance. When it is reached,
+	// the PeerPod keeps the finalizer until it is force-released. Zero means no limit.
+	MaxDeletionAttempts int32
+
+	// DriftCheckInterval is the interval of checking whether the instance of a PeerPod still exists and is running.
+	// Zero disables the check. The check requires a Provider that implements cloud.InstanceChecker.
+	DriftCheckInterval time.Duration
+
+	// EvictPodsOnDrift enables eviction of the owning Pod of a PeerPod whose instance was terminated or stopped
+	// out-of-band, so that the workload gets rescheduled
+	EvictPodsOnDrift bool
 }
 
 const (
 	ppFinalizer = "peer.pod/finalizer"
-	ppConfigMap = "peer-pods-cm"
-	ppSecret    = "peer-pods-secret"
+
+	// Initial and maximum intervals between attempts to delete an instance
+	deletionBackoffBase = 10 * time.Second
+	deletionBackoffMax  = 10 * time.Minute
 )
 
-//+kubebuilder:rbac:groups="",resourceNames=peer-pods-cm;peer-pods-secret,resources=configmaps;secrets,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
 
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpods/status,verbs=get;update;patch
@@ -58,22 +80,6 @@ func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	logger := log.FromContext(ctx)
 	pp := confidentialcontainersorgv1alpha1.PeerPod{}
 
-	// cloud provider was not set, try to fetch cloud provider and its configs dynamically from ConfigMap or Secret
-	// make sure the matching RBAC rules are set
-	if r.Provider == nil {
-		logger.Info("trying to fetch cloud provider configs for peerpod-ctrl")
-		if err := r.cloudConfigsGetter(); err != nil {
-			// don't requeue, if cloud configs are missing it will requeue later
-			logger.Info("cannot fetch cloud configs at the moment", "error", err)
-		}
-
-		var pErr error
-		r.Provider, pErr = SetProvider()
-		if pErr != nil {
-			return ctrl.Result{}, pErr
-		}
-	}
-
 	if err := r.Get(ctx, req.NamespacedName, &pp); err != nil {
 		if apierrors.IsNotFound(err) {
 			return ctrl.Result{}, nil
@@ -101,77 +107,232 @@ func (r *PeerPodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			}
 		}
 
+		return r.checkDrift(ctx, &pp)
+	}
+
+	if !controllerutil.ContainsFinalizer(&pp, ppFinalizer) {
 		return ctrl.Result{}, nil
 	}
 
-	if controllerutil.ContainsFinalizer(&pp, ppFinalizer) {
-		logger.Info("deleting instance", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
-		if err := r.Provider.DeleteInstance(ctx, pp.Spec.InstanceID); err != nil {
+	if pp.Annotations[confidentialcontainersorgv1alpha1.ForceReleaseAnnotation] == "true" {
+		logger.Info("force-releasing PeerPod without deleting instance", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+		if err := r.removeFinalizer(ctx, &pp); err != nil {
 			return ctrl.Result{}, err
 		}
+		r.recordEvent(&pp, corev1.EventTypeWarning, "ForceReleased", "Released PeerPod without deleting instance %s, which needs to be deleted manually", pp.Spec.InstanceID)
+		return ctrl.Result{}, nil
+	}
 
-		controllerutil.RemoveFinalizer(&pp, ppFinalizer)
-		if err := r.Update(ctx, &pp); err != nil {
-			if !apierrors.IsNotFound(err) { // object exist but fail to update, try again
-				return ctrl.Result{}, err
-			}
+	if r.MaxDeletionAttempts > 0 && pp.Status.DeletionAttempts >= r.MaxDeletionAttempts {
+		// Wait for the PeerPod to be force-released
+		logger.Info("gave up deleting instance", "InstanceID", pp.Spec.InstanceID, "attempts", pp.Status.DeletionAttempts)
+		return ctrl.Result{}, nil
+	}
+
+	logger.Info("deleting instance", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+	if err := r.Provider.DeleteInstance(ctx, pp.Spec.InstanceID); err != nil {
+		if errors.Is(err, ErrProviderNotLoaded) {
+			// Not an attempt, retry when the cloud provider is configured
+			return ctrl.Result{}, err
 		}
+		if !errors.Is(err, cloud.ErrInstanceNotFound) {
+			return r.deletionFailed(ctx, &pp, err)
+		}
+		logger.Info("instance not found, assuming it is already deleted", "InstanceID", pp.Spec.InstanceID, "error", err)
+	}
 
-		logger.Info("instance deleted", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
+	if err := r.removeFinalizer(ctx, &pp); err != nil {
+		return ctrl.Result{}, err
 	}
+	r.recordEvent(&pp, corev1.EventTypeNormal, "InstanceDeleted", "Deleted instance %s", pp.Spec.InstanceID)
+
+	logger.Info("instance deleted", "InstanceID", pp.Spec.InstanceID, "CloudProvider", pp.Spec.CloudProvider)
 
 	return ctrl.Result{}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *PeerPodReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&confidentialcontainersorgv1alpha1.PeerPod{}).
-		Complete(r)
-}
+// checkDrift verifies that the instance of pp still exists and is running, and schedules the next check.
+// An instance terminated or stopped out-of-band, e.g. by spot reclaim or manual deletion, is reported in the
+// InstanceRunning condition and an event, and the owning Pod is evicted if EvictPodsOnDrift is set.
+func (r *PeerPodReconciler) checkDrift(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
 
-func (r *PeerPodReconciler) cloudConfigsGetter() error {
-	peerpodscm := corev1.ConfigMap{}
-	peerpodssecret := corev1.Secret{}
-	ns := os.Getenv("PEERPODS_NAMESPACE")
-	if ns == "" {
-		return fmt.Errorf("PEERPODS_NAMESPACE is not set")
+	checker, ok := r.Provider.(cloud.InstanceChecker)
+	if !ok || r.DriftCheckInterval <= 0 || pp.Spec.InstanceID == "" {
+		return ctrl.Result{}, nil
 	}
+	result := ctrl.Result{RequeueAfter: r.DriftCheckInterval}
+
+	if pp.Status.Phase == confidentialcontainersorgv1alpha1.PeerPodDeleting {
+		// cloud-api-adaptor is deleting the instance
+		return result, nil
+	}
+
+	condition := metav1.Condition{Type: confidentialcontainersorgv1alpha1.InstanceRunning}
+
+	state, err := checker.GetInstanceState(ctx, pp.Spec.InstanceID)
+	switch {
+	case errors.Is(err, cloud.ErrInstanceNotFound):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InstanceNotFound"
+		condition.Message = fmt.Sprintf("Instance %s does not exist", pp.Spec.InstanceID)
+	case err != nil:
+		logger.Info("failed to check instance", "InstanceID", pp.Spec.InstanceID, "error", err)
+		return result, nil
+	case state == cloud.InstanceStateRunning:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "InstanceRunning"
+		condition.Message = fmt.Sprintf("Instance %s is running", pp.Spec.InstanceID)
+	case state == cloud.InstanceStateStopped || state == cloud.InstanceStateFailed:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Instance" + string(state)
+		condition.Message = fmt.Sprintf("Instance %s is %s", pp.Spec.InstanceID, strings.ToLower(string(state)))
+	default:
+		// The instance is starting, or its state is not known
+		return result, nil
+	}
+
+	if current := meta.FindStatusCondition(pp.Status.Conditions, condition.Type); current == nil || current.Status != condition.Status || current.Reason != condition.Reason {
+
+		patch := client.MergeFrom(pp.DeepCopy())
+		meta.SetStatusCondition(&pp.Status.Conditions, condition)
+
+		if condition.Status == metav1.ConditionFalse {
+			logger.Info("instance is not running anymore", "InstanceID", pp.Spec.InstanceID, "reason", condition.Reason)
+			pp.Status.Phase = confidentialcontainersorgv1alpha1.PeerPodFailed
+			r.recordEvent(pp, corev1.EventTypeWarning, condition.Reason, "%s", condition.Message)
+			if pod := ownerPod(pp); pod != nil && r.Recorder != nil {
+				r.Recorder.Eventf(pod, corev1.EventTypeWarning, "PodVM"+strings.TrimPrefix(condition.Reason, "Instance"), "Pod VM %s", condition.Message)
+			}
+		}
 
-	var cmErr error
-	if cmErr = r.Client.Get(context.TODO(), types.NamespacedName{Name: ppConfigMap, Namespace: ns}, &peerpodscm); cmErr == nil {
-		// set all configs as env vars to make sure all the required vars for auth are set
-		for k, v := range peerpodscm.Data {
-			os.Setenv(k, v)
+		if err := r.Status().Patch(ctx, pp, patch); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to update PeerPod status: %w", err)
 		}
 	}
 
-	var secretErr error
-	if secretErr = r.Client.Get(context.TODO(), types.NamespacedName{Name: ppSecret, Namespace: ns}, &peerpodssecret); secretErr == nil {
-		for k, v := range peerpodssecret.Data {
-			os.Setenv(k, string(v))
+	if condition.Status == metav1.ConditionFalse && r.EvictPodsOnDrift {
+		if err := r.evictOwnerPod(ctx, pp); err != nil {
+			logger.Info("failed to evict Pod, will retry", "error", err)
 		}
 	}
 
-	if peerpodscm.Data == nil && peerpodssecret.Data == nil {
-		return fmt.Errorf("ConfigMap Error: %v, Secret Error: %v", cmErr, secretErr)
+	return result, nil
+}
+
+// evictOwnerPod evicts the Pod that owns pp, so that its controller reschedules the workload
+func (r *PeerPodReconciler) evictOwnerPod(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) error {
+	pod := ownerPod(pp)
+	if pod == nil {
+		return nil
+	}
+
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &pod.UID},
+		},
+	}
+
+	if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) { // the Pod is gone or replaced
+			return nil
+		}
+		return err
 	}
 
+	log.FromContext(ctx).Info("evicted Pod of terminated instance", "Pod", pod.Name, "InstanceID", pp.Spec.InstanceID)
+	r.recordEvent(pp, corev1.EventTypeNormal, "PodEvicted", "Evicted Pod %s, because instance %s is not running", pod.Name, pp.Spec.InstanceID)
+
 	return nil
 }
 
-func SetProvider() (cloud.Provider, error) {
-	cloudName := os.Getenv("CLOUD_PROVIDER")
-	if cloud := cloudmgr.Get(cloudName); cloud != nil {
-		cloud.LoadEnv() // we assume LoadEnv knows to load all necessary configs
-		provider, err := cloud.NewProvider()
-		if err != nil {
-			return nil, err
+// ownerPod returns a reference to the Pod that owns pp, or nil
+func ownerPod(pp *confidentialcontainersorgv1alpha1.PeerPod) *corev1.Pod {
+	for _, ref := range pp.OwnerReferences {
+		if ref.APIVersion == "v1" && ref.Kind == "Pod" {
+			return &corev1.Pod{
+				TypeMeta:   metav1.TypeMeta{APIVersion: ref.APIVersion, Kind: ref.Kind},
+				ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: pp.Namespace, UID: ref.UID},
+			}
 		}
-		return provider, nil
+	}
+	return nil
+}
+
+// deletionFailed records a failed attempt to delete the instance of pp, and schedules the next attempt
+// with exponential backoff until MaxDeletionAttempts is reached
+func (r *PeerPodReconciler) deletionFailed(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod, deleteErr error) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	pp.Status.DeletionAttempts++
+	attempts := pp.Status.DeletionAttempts
+
+	condition := metav1.Condition{
+		Type:    confidentialcontainersorgv1alpha1.InstanceDeleted,
+		Status:  metav1.ConditionFalse,
+		Reason:  "DeletionFailed",
+		Message: fmt.Sprintf("Attempt %d to delete instance %s failed: %v", attempts, pp.Spec.InstanceID, deleteErr),
+	}
+	result := ctrl.Result{RequeueAfter: deletionBackoff(attempts)}
+
+	if r.MaxDeletionAttempts > 0 && attempts >= r.MaxDeletionAttempts {
+		pp.Status.Phase = confidentialcontainersorgv1alpha1.PeerPodFailed
+		condition.Reason = "DeletionAttemptsExceeded"
+		condition.Message = fmt.Sprintf("Gave up deleting instance %s after %d attempts: %v. Delete the instance manually, and set annotation %s=true to release the PeerPod",
+			pp.Spec.InstanceID, attempts, deleteErr, confidentialcontainersorgv1alpha1.ForceReleaseAnnotation)
+		result = ctrl.Result{}
+		logger.Error(deleteErr, "gave up deleting instance", "InstanceID", pp.Spec.InstanceID, "attempts", attempts)
+	} else {
+		logger.Error(deleteErr, "failed to delete instance", "InstanceID", pp.Spec.InstanceID, "attempts", attempts, "retryAfter", result.RequeueAfter)
 	}
 
-	return nil, fmt.Errorf("cloudmgr: %s cloud provider not supported", cloudName)
+	meta.SetStatusCondition(&pp.Status.Conditions, condition)
+	r.recordEvent(pp, corev1.EventTypeWarning, condition.Reason, "%s", condition.Message)
+
+	if err := r.Status().Update(ctx, pp); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update PeerPod status: %w", err)
+	}
+
+	return result, nil
+}
+
+func (r *PeerPodReconciler) removeFinalizer(ctx context.Context, pp *confidentialcontainersorgv1alpha1.PeerPod) error {
+	controllerutil.RemoveFinalizer(pp, ppFinalizer)
+	if err := r.Update(ctx, pp); err != nil {
+		if !apierrors.IsNotFound(err) { // object exist but fail to update, try again
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PeerPodReconciler) recordEvent(pp *confidentialcontainersorgv1alpha1.PeerPod, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(pp, eventType, reason, messageFmt, args...)
+	}
+}
+
+// deletionBackoff returns the interval before the next attempt to delete an instance
+func deletionBackoff(attempts int32) time.Duration {
+	backoff := deletionBackoffBase
+	for i := int32(1); i < attempts && backoff < deletionBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > deletionBackoffMax {
+		backoff = deletionBackoffMax
+	}
+	return backoff
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *PeerPodReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// Ignore status updates, which would otherwise bypass the backoff of instance deletion.
+		// The deletion timestamp increments the generation.
+		For(&confidentialcontainersorgv1alpha1.PeerPod{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Complete(r)
 }
 
 func isOldPeerPod(pp, cur confidentialcontainersorgv1alpha1.PeerPod) bool {
@@ -0,0 +1,319 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+)
+
+type mockProvider struct {
+	deleteErr error
+	deleted   []string
+
+	state    cloud.InstanceState
+	stateErr error
+
+	quota *cloud.Quota
+
+	tornDown bool
+}
+
+func (p *mockProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec cloud.InstanceTypeSpec) (*cloud.Instance, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (p *mockProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+	p.deleted = append(p.deleted, instanceID)
+	return p.deleteErr
+}
+
+func (p *mockProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+	return p.state, p.stateErr
+}
+
+func (p *mockProvider) GetQuota(ctx context.Context) (*cloud.Quota, error) {
+	return p.quota, nil
+}
+
+func (p *mockProvider) Teardown() error {
+	p.tornDown = true
+	return nil
+}
+
+func (p *mockProvider) ConfigVerifier() error {
+	return nil
+}
+
+// evictionClient records evictions, which the fake client does not support
+type evictionClient struct {
+	client.Client
+	evicted []string
+}
+
+func (c *evictionClient) SubResource(subResource string) client.SubResourceClient {
+	if subResource != "eviction" {
+		return c.Client.SubResource(subResource)
+	}
+	return &evictionRecorder{SubResourceClient: c.Client.SubResource(subResource), c: c}
+}
+
+type evictionRecorder struct {
+	client.SubResourceClient
+	c *evictionClient
+}
+
+func (r *evictionRecorder) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	r.c.evicted = append(r.c.evicted, obj.GetName())
+	return nil
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return scheme
+}
+
+// newDeletingPeerPod returns a reconciler and a request for a PeerPod being deleted
+func newDeletingPeerPod(t *testing.T, provider cloud.Provider, annotations map[string]string) (*PeerPodReconciler, ctrl.Request) {
+	scheme := newTestScheme(t)
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx-i-0123",
+			Namespace:   "default",
+			Finalizers:  []string{ppFinalizer},
+			Annotations: annotations,
+		},
+		Spec: confidentialcontainersorgv1alpha1.PeerPodSpec{CloudProvider: "aws", InstanceID: "i-0123"},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pp).Build()
+	if err := c.Delete(context.Background(), pp); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	r := &PeerPodReconciler{
+		Client:              c,
+		Scheme:              scheme,
+		Provider:            provider,
+		Recorder:            record.NewFakeRecorder(10),
+		MaxDeletionAttempts: 3,
+	}
+
+	return r, ctrl.Request{NamespacedName: types.NamespacedName{Name: pp.Name, Namespace: pp.Namespace}}
+}
+
+func getPeerPod(t *testing.T, c client.Client, req ctrl.Request) *confidentialcontainersorgv1alpha1.PeerPod {
+	var pp confidentialcontainersorgv1alpha1.PeerPod
+	if err := c.Get(context.Background(), req.NamespacedName, &pp); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return &pp
+}
+
+func TestReconcileDeleteInstance(t *testing.T) {
+	for _, deleteErr := range []error{nil, fmt.Errorf("terminating instance: %w", cloud.ErrInstanceNotFound)} {
+
+		provider := &mockProvider{deleteErr: deleteErr}
+		r, req := newDeletingPeerPod(t, provider, nil)
+
+		result, err := r.Reconcile(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if result.RequeueAfter != 0 {
+			t.Fatalf("Expect no requeue, got %v", result.RequeueAfter)
+		}
+		if e, a := 1, len(provider.deleted); e != a {
+			t.Fatalf("Expect %d deletion, got %d", e, a)
+		}
+
+		var pp confidentialcontainersorgv1alpha1.PeerPod
+		if err := r.Get(context.Background(), req.NamespacedName, &pp); err == nil {
+			t.Fatalf("Expect PeerPod to be released, got finalizers %v", pp.Finalizers)
+		}
+	}
+}
+
+func TestReconcileDeleteInstanceFailure(t *testing.T) {
+	provider := &mockProvider{deleteErr: errors.New("UnauthorizedOperation")}
+	r, req := newDeletingPeerPod(t, provider, nil)
+
+	for i, backoff := range []int64{10, 20} {
+		result, err := r.Reconcile(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if e, a := backoff, int64(result.RequeueAfter.Seconds()); e != a {
+			t.Fatalf("Expect requeue after %ds, got %ds", e, a)
+		}
+
+		pp := getPeerPod(t, r.Client, req)
+		if e, a := int32(i+1), pp.Status.DeletionAttempts; e != a {
+			t.Fatalf("Expect %d deletion attempts, got %d", e, a)
+		}
+		cond := meta.FindStatusCondition(pp.Status.Conditions, confidentialcontainersorgv1alpha1.InstanceDeleted)
+		if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "DeletionFailed" {
+			t.Fatalf("Expect DeletionFailed condition, got %v", cond)
+		}
+	}
+
+	// The last attempt
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if result.RequeueAfter != 0 {
+		t.Fatalf("Expect no requeue, got %v", result.RequeueAfter)
+	}
+
+	pp := getPeerPod(t, r.Client, req)
+	if e, a := confidentialcontainersorgv1alpha1.PeerPodFailed, pp.Status.Phase; e != a {
+		t.Fatalf("Expect phase %s, got %s", e, a)
+	}
+	cond := meta.FindStatusCondition(pp.Status.Conditions, confidentialcontainersorgv1alpha1.InstanceDeleted)
+	if cond == nil || cond.Reason != "DeletionAttemptsExceeded" {
+		t.Fatalf("Expect DeletionAttemptsExceeded condition, got %v", cond)
+	}
+
+	// No more attempts
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := 3, len(provider.deleted); e != a {
+		t.Fatalf("Expect %d deletions, got %d", e, a)
+	}
+
+	// Force-release the PeerPod
+	pp.Annotations = map[string]string{confidentialcontainersorgv1alpha1.ForceReleaseAnnotation: "true"}
+	if err := r.Update(context.Background(), pp); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := r.Get(context.Background(), req.NamespacedName, pp); err == nil {
+		t.Fatalf("Expect PeerPod to be released, got finalizers %v", pp.Finalizers)
+	}
+	if e, a := 3, len(provider.deleted); e != a {
+		t.Fatalf("Expect %d deletions, got %d", e, a)
+	}
+}
+
+func TestReconcileProviderNotLoaded(t *testing.T) {
+	r, req := newDeletingPeerPod(t, &ReloadableProvider{}, nil)
+
+	if _, err := r.Reconcile(context.Background(), req); !errors.Is(err, ErrProviderNotLoaded) {
+		t.Fatalf("Expect error %v, got %v", ErrProviderNotLoaded, err)
+	}
+
+	pp := getPeerPod(t, r.Client, req)
+	if e, a := int32(0), pp.Status.DeletionAttempts; e != a {
+		t.Fatalf("Expect %d deletion attempts, got %d", e, a)
+	}
+}
+
+func TestReconcileDrift(t *testing.T) {
+	scheme := newTestScheme(t)
+
+	pp := &confidentialcontainersorgv1alpha1.PeerPod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "nginx-i-0123",
+			Namespace:  "default",
+			Finalizers: []string{ppFinalizer},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Pod", Name: "nginx", UID: "0a1b2c3d"},
+			},
+		},
+		Spec:   confidentialcontainersorgv1alpha1.PeerPodSpec{CloudProvider: "aws", InstanceID: "i-0123"},
+		Status: confidentialcontainersorgv1alpha1.PeerPodStatus{Phase: confidentialcontainersorgv1alpha1.PeerPodRunning},
+	}
+
+	provider := &mockProvider{state: cloud.InstanceStateRunning}
+	c := &evictionClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(pp).Build()}
+	r := &PeerPodReconciler{
+		Client:             c,
+		Scheme:             scheme,
+		Provider:           provider,
+		Recorder:           record.NewFakeRecorder(10),
+		DriftCheckInterval: time.Minute,
+		EvictPodsOnDrift:   true,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: pp.Name, Namespace: pp.Namespace}}
+
+	result, err := r.Reconcile(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := time.Minute, result.RequeueAfter; e != a {
+		t.Fatalf("Expect requeue after %v, got %v", e, a)
+	}
+
+	pp = getPeerPod(t, r.Client, req)
+	if !meta.IsStatusConditionTrue(pp.Status.Conditions, confidentialcontainersorgv1alpha1.InstanceRunning) {
+		t.Fatalf("Expect InstanceRunning condition to be true, got %v", pp.Status.Conditions)
+	}
+	if len(c.evicted) != 0 {
+		t.Fatalf("Expect no eviction, got %v", c.evicted)
+	}
+
+	// The instance is terminated out-of-band
+	provider.stateErr = fmt.Errorf("describing instance: %w", cloud.ErrInstanceNotFound)
+
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	pp = getPeerPod(t, r.Client, req)
+	cond := meta.FindStatusCondition(pp.Status.Conditions, confidentialcontainersorgv1alpha1.InstanceRunning)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "InstanceNotFound" {
+		t.Fatalf("Expect InstanceNotFound condition, got %v", cond)
+	}
+	if e, a := confidentialcontainersorgv1alpha1.PeerPodFailed, pp.Status.Phase; e != a {
+		t.Fatalf("Expect phase %s, got %s", e, a)
+	}
+	if e, a := []string{"nginx"}, c.evicted; len(a) != 1 || a[0] != e[0] {
+		t.Fatalf("Expect eviction of %v, got %v", e, a)
+	}
+}
+
+func TestDeletionBackoff(t *testing.T) {
+	for attempts, e := range map[int32]int64{1: 10, 2: 20, 3: 40, 6: 320, 7: 600, 100: 600} {
+		if a := int64(deletionBackoff(attempts).Seconds()); e != a {
+			t.Fatalf("Expect %ds after %d attempts, got %ds", e, attempts, a)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+)
+
+// ErrProviderNotLoaded is returned by a ReloadableProvider that has no cloud provider loaded yet
+var ErrProviderNotLoaded = errors.New("cloud provider is not loaded")
+
+// ReloadableProvider is a cloud.Provider that delegates to a cloud provider that can be replaced at runtime,
+// e.g. when its configuration changes
+type ReloadableProvider struct {
+	mu      sync.RWMutex
+	current *loadedProvider
+}
+
+// loadedProvider counts the calls in flight on a cloud provider, so that it is torn down only after they return
+type loadedProvider struct {
+	provider cloud.Provider
+	calls    sync.WaitGroup
+}
+
+var _ cloud.Provider = (*ReloadableProvider)(nil)
+var _ cloud.InstanceChecker = (*ReloadableProvider)(nil)
+
+// Get returns the current cloud provider, or nil
+func (p *ReloadableProvider) Get() cloud.Provider {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.current == nil {
+		return nil
+	}
+	return p.current.provider
+}
+
+// acquire returns the current cloud provider, or nil, and a function to release it after use.
+// A released provider is not torn down while it is in use.
+func (p *ReloadableProvider) acquire() (cloud.Provider, func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	current := p.current
+	if current == nil {
+		return nil, func() {}
+	}
+	current.calls.Add(1)
+	return current.provider, current.calls.Done
+}
+
+// Set replaces the current cloud provider with provider, and tears down the previous one
+// after the calls in flight on it return
+func (p *ReloadableProvider) Set(provider cloud.Provider) error {
+	var current *loadedProvider
+	if provider != nil {
+		current = &loadedProvider{provider: provider}
+	}
+
+	p.mu.Lock()
+	old := p.current
+	p.current = current
+	p.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	old.calls.Wait()
+	return old.provider.Teardown()
+}
+
+func (p *ReloadableProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec cloud.InstanceTypeSpec) (*cloud.Instance, error) {
+	provider, release := p.acquire()
+	defer release()
+	if provider == nil {
+		return nil, ErrProviderNotLoaded
+	}
+	return provider.CreateInstance(ctx, podName, sandboxID, cloudConfig, spec)
+}
+
+func (p *ReloadableProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+	provider, release := p.acquire()
+	defer release()
+	if provider == nil {
+		return ErrProviderNotLoaded
+	}
+	return provider.DeleteInstance(ctx, instanceID)
+}
+
+// GetInstanceState returns cloud.InstanceStateUnknown if the current cloud provider does not implement cloud.InstanceChecker
+func (p *ReloadableProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+	provider, release := p.acquire()
+	defer release()
+	if provider == nil {
+		return cloud.InstanceStateUnknown, ErrProviderNotLoaded
+	}
+	checker, ok := provider.(cloud.InstanceChecker)
+	if !ok {
+		return cloud.InstanceStateUnknown, nil
+	}
+	return checker.GetInstanceState(ctx, instanceID)
+}
+
+func (p *ReloadableProvider) Teardown() error {
+	return p.Set(nil)
+}
+
+func (p *ReloadableProvider) ConfigVerifier() error {
+	provider, release := p.acquire()
+	defer release()
+	if provider == nil {
+		return ErrProviderNotLoaded
+	}
+	return provider.ConfigVerifier()
+}
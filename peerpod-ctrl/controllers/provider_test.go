@@ -0,0 +1,98 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingProvider blocks DeleteInstance until unblocked
+type blockingProvider struct {
+	mockProvider
+	deleting chan struct{}
+	unblock  chan struct{}
+}
+
+func (p *blockingProvider) DeleteInstance(ctx context.Context, instanceID string) error {
+	close(p.deleting)
+	<-p.unblock
+	return p.mockProvider.DeleteInstance(ctx, instanceID)
+}
+
+func TestReloadableProviderSet(t *testing.T) {
+	old := &blockingProvider{deleting: make(chan struct{}), unblock: make(chan struct{})}
+	p := &ReloadableProvider{}
+	if err := p.Set(old); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	deleted := make(chan error)
+	go func() {
+		deleted <- p.DeleteInstance(context.Background(), "i-0123")
+	}()
+	<-old.deleting
+
+	replaced := make(chan error)
+	current := &mockProvider{}
+	go func() {
+		replaced <- p.Set(current)
+	}()
+
+	// New calls use the new provider while the previous one is still in use
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Get() != current {
+		if time.Now().After(deadline) {
+			t.Fatal("Expect provider to be replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.DeleteInstance(context.Background(), "i-4567"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := []string{"i-4567"}, current.deleted; len(a) != 1 || a[0] != e[0] {
+		t.Fatalf("Expect deleted instances %v, got %v", e, a)
+	}
+
+	select {
+	case <-replaced:
+		t.Fatal("Expect previous provider to be torn down after the call in flight returns")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(old.unblock)
+	if err := <-deleted; err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := <-replaced; err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !old.tornDown {
+		t.Fatal("Expect previous provider to be torn down")
+	}
+
+	if err := p.Teardown(); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !current.tornDown || p.Get() != nil {
+		t.Fatal("Expect provider to be torn down")
+	}
+	if err := p.DeleteInstance(context.Background(), "i-4567"); err != ErrProviderNotLoaded {
+		t.Fatalf("Expect %v, got %v", ErrProviderNotLoaded, err)
+	}
+}
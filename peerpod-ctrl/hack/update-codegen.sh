@@ -0,0 +1,59 @@
+#!/bin/bash
+#
+# Generate the typed clientset, listers and informers of the PeerPod API
+
+set -o errexit -o pipefail -o nounset -o errtrace
+
+package=github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl
+group=peerpod
+version=v1alpha1
+codegen_version=v0.26.3
+
+basedir=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." &>/dev/null && pwd -P)
+gobin=${GOBIN:-$(go env GOPATH)/bin}
+
+for gen in client-gen lister-gen informer-gen; do
+	go install "k8s.io/code-generator/cmd/$gen@$codegen_version"
+done
+
+# The generators expect the API package at <input-base>/<group>/<version>, and
+# treat a group named "api" as the legacy core group, so a copy of the API package
+# is placed at a temporary path, which is replaced with the real one afterwards.
+tmpdir=$(mktemp -d "$basedir/.codegen.XXXXX")
+outdir=$(mktemp -d /tmp/codegen.XXXXX)
+
+cleanup() {
+	trap - SIGINT SIGTERM ERR EXIT
+	rm -fr "$tmpdir" "$outdir"
+}
+trap cleanup SIGINT SIGTERM ERR EXIT
+
+mkdir -p "$tmpdir/$group/$version"
+cp "$basedir/api/$version"/*.go "$tmpdir/$group/$version/"
+
+input_base="$package/$(basename "$tmpdir")"
+output="$package/pkg/generated/$group"
+
+cd "$basedir"
+
+"$gobin/client-gen" --clientset-name versioned \
+	--input-base "$input_base" --input "$group/$version" \
+	--output-package "$output/clientset" \
+	--output-base "$outdir" --go-header-file /dev/null
+
+"$gobin/lister-gen" --input-dirs "$input_base/$group/$version" \
+	--output-package "$output/listers" \
+	--output-base "$outdir" --go-header-file /dev/null
+
+"$gobin/informer-gen" --input-dirs "$input_base/$group/$version" \
+	--versioned-clientset-package "$output/clientset/versioned" \
+	--listers-package "$output/listers" \
+	--output-package "$output/informers" \
+	--output-base "$outdir" --go-header-file /dev/null
+
+grep -rl "$input_base/$group/$version" "$outdir" | xargs sed -i "s#$input_base/$group/$version#$package/api/$version#g"
+
+rm -fr "$basedir/pkg/generated/$group"
+mkdir -p "$basedir/pkg/generated"
+cp -r "$outdir/$output" "$basedir/pkg/generated/"
+gofmt -w "$basedir/pkg/generated/$group"
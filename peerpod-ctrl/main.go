@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,6 +30,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -54,11 +56,25 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxDeletionAttempts int
+	var driftCheckInterval time.Duration
+	var evictPodsOnDrift bool
+	var quotaRefreshInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxDeletionAttempts, "max-deletion-attempts", 10,
+		"The maximum number of attempts to delete an instance before giving up. "+
+			"A PeerPod that reached it is released only with the "+confidentialcontainersorgv1alpha1.ForceReleaseAnnotation+" annotation. "+
+			"Zero means no limit.")
+	flag.DurationVar(&driftCheckInterval, "drift-check-interval", 5*time.Minute,
+		"The interval of checking whether the instance of each PeerPod still exists and is running. Zero disables the check.")
+	flag.BoolVar(&evictPodsOnDrift, "evict-pods-on-drift", false,
+		"Evict the Pod of a PeerPod whose instance was terminated or stopped out-of-band, so that the workload gets rescheduled.")
+	flag.DurationVar(&quotaRefreshInterval, "quota-refresh-interval", 5*time.Minute,
+		"The interval of checking the remaining instance quota of the cloud account, which is reported in the CloudProvider status. Zero disables the check.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -96,15 +112,50 @@ func main() {
 		os.Exit(1)
 	}
 
-	provider, err := controllers.SetProvider()
+	// peer-pods-cm and peer-pods-secret are read from a cache of the namespace of peerpod-ctrl,
+	// which requires RBAC rules only in that namespace
+	namespace := os.Getenv("PEERPODS_NAMESPACE")
+	if namespace == "" {
+		setupLog.Error(nil, "PEERPODS_NAMESPACE is not set")
+		os.Exit(1)
+	}
+	configCache, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: namespace,
+	})
 	if err != nil {
-		setupLog.Info("unable to set provider at init, will retry at reconcile", "error", err)
+		setupLog.Error(err, "unable to create cache", "namespace", namespace)
+		os.Exit(1)
+	}
+	if err := mgr.Add(configCache); err != nil {
+		setupLog.Error(err, "unable to add cache", "namespace", namespace)
+		os.Exit(1)
+	}
+
+	provider := &controllers.ReloadableProvider{}
+
+	if err = (&controllers.CloudProviderReconciler{
+		Client:    mgr.GetClient(),
+		Cache:     configCache,
+		Namespace: namespace,
+		Provider:  provider,
+
+		QuotaRefreshInterval: quotaRefreshInterval,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CloudProvider")
+		os.Exit(1)
 	}
 
 	if err = (&controllers.PeerPodReconciler{
 		Client:   mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
 		Provider: provider,
+		Recorder: mgr.GetEventRecorderFor("peerpod-ctrl"),
+
+		MaxDeletionAttempts: int32(maxDeletionAttempts),
+		DriftCheckInterval:  driftCheckInterval,
+		EvictPodsOnDrift:    evictPodsOnDrift,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PeerPod")
 		os.Exit(1)
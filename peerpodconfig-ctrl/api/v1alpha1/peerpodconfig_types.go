@@ -31,6 +31,14 @@ type PeerPodConfigSpec struct {
 	// Limit is the max number of peer pods per node. This is exposed as extended resource on the node
 	Limit string `json:"limit,omitempty"`
 
+	// CapacitySource selects how the number of peer pods per node is computed. Limit, the default, advertises
+	// Limit on every node. NodeLabel advertises the value of the peerpods.confidentialcontainers.org/capacity label
+	// of each node, or Limit if the label is not set. Quota divides the remaining instance quota of the cloud account,
+	// as reported by peerpod-ctrl, across the nodes, and Limit, if set, caps the number of each node.
+	// +kubebuilder:default:=Limit
+	// +optional
+	CapacitySource CapacitySource `json:"capacitySource,omitempty"`
+
 	// CloudSecretName is the name of the secret that holds the credentials for the cloud provider
 	// +kubebuilder:default:=peer-pods-secret
 	CloudSecretName string `json:"cloudSecretName"`
@@ -44,6 +52,22 @@ type PeerPodConfigSpec struct {
 	ConfigMapName string `json:"configMapName"`
 }
 
+// CapacitySource is a source of the number of peer pods per node
+// +kubebuilder:validation:Enum=Limit;NodeLabel;Quota
+type CapacitySource string
+
+const (
+	// CapacityFromLimit advertises Limit on every node
+	CapacityFromLimit CapacitySource = "Limit"
+	// CapacityFromNodeLabel advertises the value of the CapacityLabel of each node
+	CapacityFromNodeLabel CapacitySource = "NodeLabel"
+	// CapacityFromQuota divides the remaining quota of the cloud account across the nodes
+	CapacityFromQuota CapacitySource = "Quota"
+)
+
+// CapacityLabel is the node label with the number of peer pods of the node, when CapacitySource is NodeLabel
+const CapacityLabel = "peerpods.confidentialcontainers.org/capacity"
+
 // PeerPodConfigStatus defines the observed state of PeerPodConfig
 type PeerPodConfigStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -51,10 +75,35 @@ type PeerPodConfigStatus struct {
 
 	// SetupCompleted is set to true when all components have been deployed/created
 	SetupCompleted bool `json:"setupCompleted,omitempty"`
+
+	// ObservedGeneration is the generation of the PeerPodConfig that the components were reconciled with
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// AdvertisedNodes are the names of the nodes that the extended resource is advertised on. The extended
+	// resource is only removed from these nodes, when they are not selected any more or the PeerPodConfig is deleted.
+	// +optional
+	AdvertisedNodes []string `json:"advertisedNodes,omitempty"`
+
+	// Conditions represent the state of each component, e.g. CloudAPIAdaptorReady
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// Condition types of a PeerPodConfig
+const (
+	// CloudAPIAdaptorReady indicates whether the cloud-api-adaptor DaemonSet is up to date and its pods are ready
+	CloudAPIAdaptorReady = "CloudAPIAdaptorReady"
+	// ExtendedResourcesAdvertised indicates whether the peer pods extended resource is advertised on the selected nodes
+	ExtendedResourcesAdvertised = "ExtendedResourcesAdvertised"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Setup Completed",type=boolean,JSONPath=`.status.setupCompleted`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // PeerPodConfig is the Schema for the peerpodconfigs API
 type PeerPodConfig struct {
@@ -0,0 +1,172 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	ccv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpodconfig-ctrl/api/v1alpha1"
+)
+
+const (
+	// Interval of recomputing the capacity of nodes from the cloud quota
+	quotaResyncInterval = time.Minute
+	// Name of the CloudProvider object of peerpod-ctrl, which reports the cloud quota
+	cloudProviderName = "default"
+)
+
+// cloudProviderGVK is the kind of the CloudProvider object of peerpod-ctrl. It is read as unstructured,
+// so that peerpodconfig-ctrl does not depend on peerpod-ctrl.
+var cloudProviderGVK = schema.GroupVersionKind{Group: "confidentialcontainers.org", Version: "v1alpha1", Kind: "CloudProvider"}
+
+// nodeCapacities returns the number of peer pods to advertise on each of nodes, by node name
+func (r *PeerPodConfigReconciler) nodeCapacities(ctx context.Context, nodes []corev1.Node) (map[string]string, error) {
+	// Parse limit from PeerPodConfig.Spec.Limit.
+	// If not set or in case of error, use defaultPeerPodsLimitPerNode
+	limitPerNode := defaultPeerPodsLimitPerNode
+	if r.peerPodConfig.Spec.Limit != "" {
+		limitPerNode = r.peerPodConfig.Spec.Limit
+	}
+
+	capacities := make(map[string]string, len(nodes))
+
+	switch r.peerPodConfig.Spec.CapacitySource {
+	case ccv1alpha1.CapacityFromNodeLabel:
+		for _, node := range nodes {
+			capacities[node.Name] = limitPerNode
+			if value, ok := node.Labels[ccv1alpha1.CapacityLabel]; ok {
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+					capacities[node.Name] = value
+				} else {
+					r.Log.Info("Ignoring invalid capacity label of node", "node name", node.Name, "label", value)
+				}
+			}
+		}
+
+	case ccv1alpha1.CapacityFromQuota:
+		remaining, err := r.remainingQuota(ctx)
+		if err != nil {
+			return nil, err
+		}
+		used, err := r.peerPodsInUse(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var max int64 = -1
+		if r.peerPodConfig.Spec.Limit != "" {
+			if max, err = strconv.ParseInt(r.peerPodConfig.Spec.Limit, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid limit %q: %w", r.peerPodConfig.Spec.Limit, err)
+			}
+		}
+
+		for name, n := range divideCapacity(remaining+used, nodes) {
+			if max >= 0 && n > max {
+				n = max
+			}
+			capacities[name] = strconv.FormatInt(n, 10)
+		}
+
+	default:
+		for _, node := range nodes {
+			capacities[node.Name] = limitPerNode
+		}
+	}
+
+	return capacities, nil
+}
+
+// divideCapacity divides total as evenly as possible across nodes
+func divideCapacity(total int64, nodes []corev1.Node) map[string]int64 {
+	capacities := make(map[string]int64, len(nodes))
+	if len(nodes) == 0 {
+		return capacities
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+
+	base, extra := total/int64(len(names)), total%int64(len(names))
+	for i, name := range names {
+		capacities[name] = base
+		if int64(i) < extra {
+			capacities[name]++
+		}
+	}
+	return capacities
+}
+
+// remainingQuota returns the number of instances that can still be created in the cloud account, as reported
+// in the status of the CloudProvider object of peerpod-ctrl
+func (r *PeerPodConfigReconciler) remainingQuota(ctx context.Context) (int64, error) {
+	cp := &unstructured.Unstructured{}
+	cp.SetGroupVersionKind(cloudProviderGVK)
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: cloudProviderName}, cp); err != nil {
+		return 0, fmt.Errorf("failed to get cloud quota from CloudProvider %s: %w", cloudProviderName, err)
+	}
+
+	remaining := int64(-1)
+	// Every instance has at least one vCPU
+	for _, field := range []string{"instances", "vcpus"} {
+		n, found, err := unstructured.NestedInt64(cp.Object, "status", "quota", field)
+		if err != nil || !found || n < 0 {
+			continue
+		}
+		if remaining < 0 || n < remaining {
+			remaining = n
+		}
+	}
+	if remaining < 0 {
+		return 0, fmt.Errorf("cloud quota is not reported in CloudProvider %s", cloudProviderName)
+	}
+	return remaining, nil
+}
+
+// peerPodsInUse returns the number of peer pods that are scheduled, and do not count in the remaining quota anymore
+func (r *PeerPodConfigReconciler) peerPodsInUse(ctx context.Context) (int64, error) {
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods); err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			if q, ok := c.Resources.Requests[peerPodsExtendedResource]; ok {
+				used += q.Value()
+			} else if q, ok := c.Resources.Limits[peerPodsExtendedResource]; ok {
+				used += q.Value()
+			}
+		}
+	}
+	return used, nil
+}
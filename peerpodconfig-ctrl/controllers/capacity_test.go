@@ -0,0 +1,236 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ccv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpodconfig-ctrl/api/v1alpha1"
+)
+
+func TestDivideCapacity(t *testing.T) {
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+	}
+
+	for _, tc := range []struct {
+		total    int64
+		expected map[string]int64
+	}{
+		{total: 0, expected: map[string]int64{"worker-0": 0, "worker-1": 0, "worker-2": 0}},
+		{total: 9, expected: map[string]int64{"worker-0": 3, "worker-1": 3, "worker-2": 3}},
+		{total: 11, expected: map[string]int64{"worker-0": 4, "worker-1": 4, "worker-2": 3}},
+	} {
+		if a := divideCapacity(tc.total, nodes); !reflect.DeepEqual(tc.expected, a) {
+			t.Errorf("Expect %v for total %d, got %v", tc.expected, tc.total, a)
+		}
+	}
+
+	if a := divideCapacity(10, nil); len(a) != 0 {
+		t.Errorf("Expect no capacity without nodes, got %v", a)
+	}
+}
+
+// newTestCloudProvider returns the CloudProvider object of peerpod-ctrl with the given quota
+func newTestCloudProvider(quota map[string]interface{}) *unstructured.Unstructured {
+	cp := &unstructured.Unstructured{}
+	cp.SetGroupVersionKind(cloudProviderGVK)
+	cp.SetName(cloudProviderName)
+	if quota != nil {
+		cp.Object["status"] = map[string]interface{}{"quota": quota}
+	}
+	return cp
+}
+
+// newTestPeerPod returns a pod that requests n peer pods
+func newTestPeerPod(name, nodeName string, phase corev1.PodPhase, n string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{peerPodsExtendedResource: resource.MustParse(n)},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestRemainingQuota(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		quota     map[string]interface{}
+		expected  int64
+		expectErr bool
+	}{
+		{name: "no CloudProvider", expectErr: true},
+		{name: "no quota", quota: nil, expectErr: true},
+		{name: "unknown quota", quota: map[string]interface{}{"instances": int64(-1), "vcpus": int64(-1)}, expectErr: true},
+		{name: "instances", quota: map[string]interface{}{"instances": int64(19), "vcpus": int64(-1)}, expected: 19},
+		{name: "vcpus", quota: map[string]interface{}{"instances": int64(-1), "vcpus": int64(8)}, expected: 8},
+		{name: "minimum", quota: map[string]interface{}{"instances": int64(19), "vcpus": int64(8)}, expected: 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var objects []client.Object
+			if tc.name != "no CloudProvider" {
+				objects = append(objects, newTestCloudProvider(tc.quota))
+			}
+			r := newTestReconciler(t, objects...)
+
+			remaining, err := r.remainingQuota(context.Background())
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expect error, got %d", remaining)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			if e, a := tc.expected, remaining; e != a {
+				t.Fatalf("Expect %d, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestPeerPodsInUse(t *testing.T) {
+	requested := newTestPeerPod("requested", "worker-0", corev1.PodRunning, "1")
+	requested.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{peerPodsExtendedResource: resource.MustParse("2")},
+		Limits:   corev1.ResourceList{peerPodsExtendedResource: resource.MustParse("2")},
+	}
+	plain := newTestPeerPod("plain", "worker-0", corev1.PodRunning, "1")
+	plain.Spec.Containers[0].Resources = corev1.ResourceRequirements{}
+
+	r := newTestReconciler(t,
+		newTestPeerPod("running", "worker-0", corev1.PodRunning, "1"),
+		newTestPeerPod("pending", "worker-1", corev1.PodPending, "1"),
+		requested,
+		plain,
+		// Pods that are not scheduled or that terminated do not use an instance
+		newTestPeerPod("unscheduled", "", corev1.PodPending, "1"),
+		newTestPeerPod("succeeded", "worker-0", corev1.PodSucceeded, "1"),
+		newTestPeerPod("failed", "worker-1", corev1.PodFailed, "1"),
+	)
+
+	used, err := r.peerPodsInUse(context.Background())
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := int64(4), used; e != a {
+		t.Fatalf("Expect %d peer pods in use, got %d", e, a)
+	}
+}
+
+func TestNodeCapacities(t *testing.T) {
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Labels: map[string]string{ccv1alpha1.CapacityLabel: "5"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{ccv1alpha1.CapacityLabel: "-1"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		spec      ccv1alpha1.PeerPodConfigSpec
+		objects   []client.Object
+		expected  map[string]string
+		expectErr bool
+	}{
+		{
+			name:     "limit",
+			spec:     ccv1alpha1.PeerPodConfigSpec{Limit: "3"},
+			expected: map[string]string{"worker-0": "3", "worker-1": "3", "worker-2": "3"},
+		},
+		{
+			name:     "default limit",
+			spec:     ccv1alpha1.PeerPodConfigSpec{},
+			expected: map[string]string{"worker-0": "1", "worker-1": "1", "worker-2": "1"},
+		},
+		{
+			// An invalid label falls back to the limit
+			name:     "node label",
+			spec:     ccv1alpha1.PeerPodConfigSpec{Limit: "3", CapacitySource: ccv1alpha1.CapacityFromNodeLabel},
+			expected: map[string]string{"worker-0": "5", "worker-1": "3", "worker-2": "3"},
+		},
+		{
+			// The remaining quota of 5 and the 2 peer pods in use are spread across the nodes
+			name: "quota",
+			spec: ccv1alpha1.PeerPodConfigSpec{CapacitySource: ccv1alpha1.CapacityFromQuota},
+			objects: []client.Object{
+				newTestCloudProvider(map[string]interface{}{"instances": int64(5), "vcpus": int64(-1)}),
+				newTestPeerPod("running", "worker-0", corev1.PodRunning, "2"),
+			},
+			expected: map[string]string{"worker-0": "3", "worker-1": "2", "worker-2": "2"},
+		},
+		{
+			name: "quota capped by limit",
+			spec: ccv1alpha1.PeerPodConfigSpec{Limit: "2", CapacitySource: ccv1alpha1.CapacityFromQuota},
+			objects: []client.Object{
+				newTestCloudProvider(map[string]interface{}{"instances": int64(10), "vcpus": int64(-1)}),
+			},
+			expected: map[string]string{"worker-0": "2", "worker-1": "2", "worker-2": "2"},
+		},
+		{
+			name: "quota with invalid limit",
+			spec: ccv1alpha1.PeerPodConfigSpec{Limit: "many", CapacitySource: ccv1alpha1.CapacityFromQuota},
+			objects: []client.Object{
+				newTestCloudProvider(map[string]interface{}{"instances": int64(10), "vcpus": int64(-1)}),
+			},
+			expectErr: true,
+		},
+		{
+			name:      "quota not reported",
+			spec:      ccv1alpha1.PeerPodConfigSpec{CapacitySource: ccv1alpha1.CapacityFromQuota},
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestReconciler(t, tc.objects...)
+			r.peerPodConfig = &ccv1alpha1.PeerPodConfig{Spec: tc.spec}
+
+			capacities, err := r.nodeCapacities(context.Background(), nodes)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expect error, got %v", capacities)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, capacities) {
+				t.Fatalf("Expect %v, got %v", tc.expected, capacities)
+			}
+		})
+	}
+}
@@ -18,27 +18,33 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
-	k8sclient "k8s.io/client-go/kubernetes"
-	restclient "k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	ccv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpodconfig-ctrl/api/v1alpha1"
 )
@@ -51,6 +57,12 @@ const (
 	defaultPeerPodsLimitPerNode = "1"
 	// cloud-api-adaptor (CAA) daemonset name
 	caaDsName = "peerpodconfig-ctrl-caa-daemon"
+	// Annotation of the CAA daemonset with the hash of its desired spec
+	caaSpecHashAnnotation = "confidentialcontainers.org/spec-hash"
+	// Extended resource that limits the number of peer pods per node
+	peerPodsExtendedResource = "kata.peerpods.io/vm"
+	// Finalizer that removes the extended resource from the nodes when PeerPodConfig is deleted
+	peerPodConfigFinalizer = "confidentialcontainers.org/peerpodconfig-finalizer"
 )
 
 // PeerPodConfigReconciler reconciles a PeerPodConfig object
@@ -65,19 +77,20 @@ type PeerPodConfigReconciler struct {
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpodconfigs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpodconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=peerpodconfigs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=cloudproviders,verbs=get
 //+kubebuilder:rbac:groups="",resources=nodes/status,verbs=patch
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=create;get;update;list;watch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=create;get;update;list;watch
 //+kubebuilder:rbac:groups="";machineconfiguration.openshift.io,resources=nodes;machineconfigs;machineconfigpools;containerruntimeconfigs;pods;services;services/finalizers;endpoints;persistentvolumeclaims;events;configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=apps,resources=daemonsets,resourceNames=peerpodconfig-ctrl-caa-daemon,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=daemonsets/finalizers,verbs=update
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the PeerPodConfig object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// It creates or updates the cloud-api-adaptor DaemonSet and the extended resources of the nodes
+// according to the PeerPodConfig, and reports the state of each of them in the status conditions.
+// When the PeerPodConfig is deleted, the extended resources are removed from the nodes, and the DaemonSet
+// is garbage collected.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
@@ -88,7 +101,7 @@ func (r *PeerPodConfigReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 
 	// Fetch the PeerPodConfig instance
 	r.peerPodConfig = &ccv1alpha1.PeerPodConfig{}
-	err := r.Client.Get(context.TODO(), req.NamespacedName, r.peerPodConfig)
+	err := r.Client.Get(ctx, req.NamespacedName, r.peerPodConfig)
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
@@ -100,30 +113,169 @@ func (r *PeerPodConfigReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
-	if err := r.advertiseExtendedResources(); err != nil {
-		return ctrl.Result{}, err
+	if r.peerPodConfig.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(r.peerPodConfig, peerPodConfigFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		r.Log.Info("Removing extended resources from nodes", "nodes", r.peerPodConfig.Status.AdvertisedNodes)
+		if _, err := r.reconcileExtendedResources(ctx, nil, r.peerPodConfig.Status.AdvertisedNodes); err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(r.peerPodConfig, peerPodConfigFinalizer)
+		if err := r.Client.Update(ctx, r.peerPodConfig); err != nil && !k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(r.peerPodConfig, peerPodConfigFinalizer) {
+		controllerutil.AddFinalizer(r.peerPodConfig, peerPodConfigFinalizer)
+		if err := r.Client.Update(ctx, r.peerPodConfig); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := r.peerPodConfig.Status.DeepCopy()
+	status.ObservedGeneration = r.peerPodConfig.Generation
+
+	advertisedNodes, resourcesErr := r.advertiseExtendedResources(ctx)
+	status.AdvertisedNodes = advertisedNodes
+	if resourcesErr != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ccv1alpha1.ExtendedResourcesAdvertised,
+			Status:  metav1.ConditionFalse,
+			Reason:  "AdvertiseFailed",
+			Message: resourcesErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ccv1alpha1.ExtendedResourcesAdvertised,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Advertised",
+			Message: fmt.Sprintf("Extended resource %s is advertised on the selected nodes", peerPodsExtendedResource),
+		})
+	}
+
+	ds, dsErr := r.reconcileCaaDaemonset(ctx)
+	if dsErr != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    ccv1alpha1.CloudAPIAdaptorReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "DaemonSetFailed",
+			Message: dsErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, caaDaemonsetCondition(ds))
+	}
+
+	status.SetupCompleted = resourcesErr == nil && dsErr == nil
+
+	if !equality.Semantic.DeepEqual(status, &r.peerPodConfig.Status) {
+		r.peerPodConfig.Status = *status
+		if err := r.Client.Status().Update(ctx, r.peerPodConfig); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if resourcesErr != nil {
+		return ctrl.Result{}, resourcesErr
+	}
+	if dsErr != nil {
+		return ctrl.Result{}, dsErr
 	}
 
-	// Create the cloud-api-adapter DaemonSet
+	r.Log.Info("Reconciling PeerPodConfig")
+
+	if r.peerPodConfig.Spec.CapacitySource == ccv1alpha1.CapacityFromQuota {
+		// Follow the changes of the cloud quota and of the peer pods
+		return ctrl.Result{RequeueAfter: quotaResyncInterval}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileCaaDaemonset creates the cloud-api-adaptor DaemonSet, or updates it when its desired spec changed
+func (r *PeerPodConfigReconciler) reconcileCaaDaemonset(ctx context.Context) (*appsv1.DaemonSet, error) {
 	ds := r.createCaaDaemonset()
 	if err := controllerutil.SetControllerReference(r.peerPodConfig, ds, r.Scheme); err != nil {
 		r.Log.Error(err, "Failed setting ControllerReference for cloud-api-adaptor DS")
-		return ctrl.Result{}, err
+		return nil, err
+	}
+
+	hash, err := specHash(ds.Spec)
+	if err != nil {
+		return nil, err
 	}
+	ds.Annotations = map[string]string{caaSpecHashAnnotation: hash}
+
 	foundDs := &appsv1.DaemonSet{}
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace}, foundDs)
-	if err != nil && k8serrors.IsNotFound(err) {
+	err = r.Client.Get(ctx, types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace}, foundDs)
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, err
+		}
 		r.Log.Info("Creating cloud-api-adapter daemonset", "ds.Namespace", ds.Namespace, "ds.Name", ds.Name)
-		err = r.Client.Create(context.TODO(), ds)
-		if err != nil {
+		if err := r.Client.Create(ctx, ds); err != nil {
 			r.Log.Error(err, "failed to create cloud-api-adaptor")
-			return ctrl.Result{}, err
+			return nil, err
 		}
+		return ds, nil
 	}
 
-	r.Log.Info("Reconciling PeerPodConfig")
+	if foundDs.Annotations[caaSpecHashAnnotation] == hash {
+		return foundDs, nil
+	}
 
-	return ctrl.Result{}, nil
+	r.Log.Info("Updating cloud-api-adapter daemonset", "ds.Namespace", ds.Namespace, "ds.Name", ds.Name)
+	if foundDs.Annotations == nil {
+		foundDs.Annotations = map[string]string{}
+	}
+	foundDs.Annotations[caaSpecHashAnnotation] = hash
+	foundDs.Spec = ds.Spec
+	foundDs.OwnerReferences = ds.OwnerReferences
+	if err := r.Client.Update(ctx, foundDs); err != nil {
+		r.Log.Error(err, "failed to update cloud-api-adaptor")
+		return nil, err
+	}
+	return foundDs, nil
+}
+
+// caaDaemonsetCondition returns the CloudAPIAdaptorReady condition for the state of ds
+func caaDaemonsetCondition(ds *appsv1.DaemonSet) metav1.Condition {
+	status := ds.Status
+	if status.ObservedGeneration >= ds.Generation && status.DesiredNumberScheduled == 0 {
+		return metav1.Condition{
+			Type:    ccv1alpha1.CloudAPIAdaptorReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoNodesScheduled",
+			Message: "No nodes match the node selector of the cloud-api-adaptor DaemonSet",
+		}
+	}
+	if status.ObservedGeneration < ds.Generation || status.UpdatedNumberScheduled < status.DesiredNumberScheduled ||
+		status.NumberReady < status.DesiredNumberScheduled {
+		return metav1.Condition{
+			Type:    ccv1alpha1.CloudAPIAdaptorReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "DaemonSetProgressing",
+			Message: fmt.Sprintf("%d of %d cloud-api-adaptor pods are updated and ready", status.NumberReady, status.DesiredNumberScheduled),
+		}
+	}
+	return metav1.Condition{
+		Type:    ccv1alpha1.CloudAPIAdaptorReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "DaemonSetReady",
+		Message: fmt.Sprintf("%d cloud-api-adaptor pods are ready", status.NumberReady),
+	}
+}
+
+// specHash returns a digest of the desired spec of an object
+func specHash(spec interface{}) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
 }
 
 func MountProgagationRef(mode corev1.MountPropagationMode) *corev1.MountPropagationMode {
@@ -154,6 +306,12 @@ func (r *PeerPodConfigReconciler) createCaaDaemonset() *appsv1.DaemonSet {
 	}
 	r.Log.Info("cloud-api-adaptor container image was set", "CAA image", imageString)
 
+	// The instance type of the PeerPodConfig overrides the one in the ConfigMap
+	var env []corev1.EnvVar
+	if r.peerPodConfig.Spec.InstanceType != "" {
+		env = append(env, corev1.EnvVar{Name: "PODVM_INSTANCE_TYPE", Value: r.peerPodConfig.Spec.InstanceType})
+	}
+
 	return &appsv1.DaemonSet{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "apps/v1",
@@ -195,6 +353,7 @@ func (r *PeerPodConfigReconciler) createCaaDaemonset() *appsv1.DaemonSet {
 								RunAsUser:  &runAsUser,
 							},
 							Command: []string{"/usr/local/bin/entrypoint.sh"},
+							Env:     env,
 							EnvFrom: []corev1.EnvFromSource{
 								{
 									SecretRef: &corev1.SecretEnvSource{
@@ -279,25 +438,31 @@ func (r *PeerPodConfigReconciler) createCaaDaemonset() *appsv1.DaemonSet {
 // SetupWithManager sets up the controller with the Manager.
 func (r *PeerPodConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&ccv1alpha1.PeerPodConfig{}).
+		// Ignore status updates. The deletion timestamp increments the generation.
+		For(&ccv1alpha1.PeerPodConfig{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&appsv1.DaemonSet{}).
+		// Recompute the extended resources when nodes join or leave, or their labels change
+		Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(r.peerPodConfigsForNode),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
 		Complete(r)
 }
 
-func (r *PeerPodConfigReconciler) getNodesWithLabels(nodeLabels map[string]string) (*corev1.NodeList, error) {
-	nodes := &corev1.NodeList{}
-	labelSelector := labels.SelectorFromSet(nodeLabels)
-	listOpts := []client.ListOption{
-		client.MatchingLabelsSelector{Selector: labelSelector},
+// peerPodConfigsForNode returns requests of all PeerPodConfigs, which may select the node
+func (r *PeerPodConfigReconciler) peerPodConfigsForNode(node client.Object) []ctrl.Request {
+	list := &ccv1alpha1.PeerPodConfigList{}
+	if err := r.Client.List(context.TODO(), list); err != nil {
+		ctrl.Log.Error(err, "Failed to list PeerPodConfigs for node", "node name", node.GetName())
+		return nil
 	}
 
-	if err := r.Client.List(context.TODO(), nodes, listOpts...); err != nil {
-		r.Log.Error(err, "Getting list of nodes having specified labels failed")
-		return &corev1.NodeList{}, err
+	var requests []ctrl.Request
+	for _, ppc := range list.Items {
+		requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Name: ppc.Name, Namespace: ppc.Namespace}})
 	}
-	return nodes, nil
+	return requests
 }
 
-func (r *PeerPodConfigReconciler) advertiseExtendedResources() error {
+func (r *PeerPodConfigReconciler) advertiseExtendedResources(ctx context.Context) ([]string, error) {
 
 	nodeSelector := map[string]string{
 		defaultNodeSelectorLabel: "",
@@ -307,49 +472,82 @@ func (r *PeerPodConfigReconciler) advertiseExtendedResources() error {
 		nodeSelector = r.peerPodConfig.Spec.NodeSelector
 	}
 
-	r.Log.Info("set up extended resources")
-	nodesList, err := r.getNodesWithLabels(nodeSelector)
-	if err != nil {
+	r.Log.Info("set up extended resources", "nodeSelector", nodeSelector, "capacitySource", r.peerPodConfig.Spec.CapacitySource)
+
+	nodesList := &corev1.NodeList{}
+	if err := r.Client.List(ctx, nodesList, client.MatchingLabels(nodeSelector)); err != nil {
 		r.Log.Info("getting node list failed when trying to update nodes with extended resources")
-		return err
+		return r.peerPodConfig.Status.AdvertisedNodes, err
 	}
 
-	// Do nothing for empty nodes list
-	if len(nodesList.Items) == 0 {
-		r.Log.Info("Not advertising extended resources for empty nodelist for nodeSelector", "nodeSelector", nodeSelector)
-		return nil
+	capacities, err := r.nodeCapacities(ctx, nodesList.Items)
+	if err != nil {
+		return r.peerPodConfig.Status.AdvertisedNodes, err
 	}
 
-	// Parse limit from PeerPodConfig.Spec.Limit.
-	// If not set or in case of error, use defaultPeerPodsLimitPerNode
-	limitPerNode := defaultPeerPodsLimitPerNode
-	if r.peerPodConfig.Spec.Limit != "" {
-		limitPerNode = r.peerPodConfig.Spec.Limit
-	}
+	return r.reconcileExtendedResources(ctx, capacities, r.peerPodConfig.Status.AdvertisedNodes)
+}
 
-	patch := append([]JsonPatch{}, NewJsonPatch("add", "/status/capacity", "kata.peerpods.io~1vm", limitPerNode))
+// reconcileExtendedResources advertises the extended resource on the nodes in capacities, and removes it from
+// the nodes in advertisedNodes that are not in capacities, e.g. after NodeSelector changed. Other nodes are left
+// alone, since their extended resource is not advertised by this PeerPodConfig. It returns the nodes that the
+// extended resource is advertised on afterwards, including those that it failed to be removed from.
+func (r *PeerPodConfigReconciler) reconcileExtendedResources(ctx context.Context, capacities map[string]string, advertisedNodes []string) ([]string, error) {
+	nodesList := &corev1.NodeList{}
+	if err := r.Client.List(ctx, nodesList); err != nil {
+		r.Log.Info("getting node list failed when trying to update nodes with extended resources")
+		return advertisedNodes, err
+	}
 
-	cli, err := r.GetClient()
-	if err != nil {
-		return fmt.Errorf("failed to get k8s client: %v", err)
+	previous := make(map[string]bool, len(advertisedNodes))
+	for _, name := range advertisedNodes {
+		previous[name] = true
 	}
 
+	var nodes, failed []string
 	for _, node := range nodesList.Items {
-		err = r.PatchNodeStatus(cli, node.Name, patch)
-		if err != nil {
-			r.Log.Info("Failed to set extended resource for node", "node name", node.Name)
+		current, advertised := node.Status.Capacity[peerPodsExtendedResource]
+
+		var patch []JsonPatch
+		switch capacity, selected := capacities[node.Name]; {
+		case selected && (!advertised || current.String() != capacity):
+			patch = append(patch, NewJsonPatch("add", "/status/capacity", peerPodsExtendedResource, capacity))
+		case selected:
+			nodes = append(nodes, node.Name)
+			continue
+		case previous[node.Name] && advertised:
+			patch = append(patch, NewJsonPatch("remove", "/status/capacity", peerPodsExtendedResource, ""))
+		default:
 			continue
 		}
-		r.Log.Info("Successfully set extended resource for node", "node name", node.Name)
+
+		if err := r.PatchNodeStatus(ctx, &node, patch); err != nil {
+			r.Log.Info("Failed to set extended resource for node", "node name", node.Name, "error", err)
+			failed = append(failed, node.Name)
+			if previous[node.Name] {
+				nodes = append(nodes, node.Name)
+			}
+			continue
+		}
+		r.Log.Info("Successfully set extended resource for node", "node name", node.Name, "op", patch[0].Op, "capacity", capacities[node.Name])
+		if patch[0].Op == "add" {
+			nodes = append(nodes, node.Name)
+		}
 	}
-	return nil
+
+	sort.Strings(nodes)
+
+	if len(failed) > 0 {
+		return nodes, fmt.Errorf("failed to update extended resource %s of nodes %s", peerPodsExtendedResource, strings.Join(failed, ", "))
+	}
+	return nodes, nil
 }
 
-func (r *PeerPodConfigReconciler) PatchNodeStatus(c *k8sclient.Clientset, nodeName string, patches []JsonPatch) error {
+func (r *PeerPodConfigReconciler) PatchNodeStatus(ctx context.Context, node *corev1.Node, patches []JsonPatch) error {
 	if len(patches) > 0 {
 		data, err := json.Marshal(patches)
 		if err == nil {
-			_, err = c.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.JSONPatchType, data, metav1.PatchOptions{}, "status")
+			err = r.Client.Status().Patch(ctx, node, client.RawPatch(types.JSONPatchType, data))
 		}
 		return err
 	}
@@ -368,16 +566,3 @@ type JsonPatch struct {
 func NewJsonPatch(verb string, jsonpath string, key string, value string) JsonPatch {
 	return JsonPatch{verb, path.Join(jsonpath, strings.ReplaceAll(key, "/", "~1")), value}
 }
-
-// GetClient creates and returns a new clientset from given config
-func (r *PeerPodConfigReconciler) GetClient() (*k8sclient.Clientset, error) {
-	Kubeconfig, err := restclient.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-	clientset, err := k8sclient.NewForConfig(Kubeconfig)
-	if err != nil {
-		return nil, err
-	}
-	return clientset, nil
-}
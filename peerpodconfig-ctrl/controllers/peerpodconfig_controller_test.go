@@ -0,0 +1,294 @@
+/*
+Copyright Confidential Containers Contributors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	ccv1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpodconfig-ctrl/api/v1alpha1"
+)
+
+var _ = Describe("PeerPodConfig controller", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 250 * time.Millisecond
+	)
+
+	ctx := context.Background()
+	dsKey := types.NamespacedName{Name: caaDsName, Namespace: testNamespace}
+	ppcKey := types.NamespacedName{Name: "peerpodconfig", Namespace: testNamespace}
+
+	createNode := func(name string, nodeLabels map[string]string) {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels}}
+		Expect(k8sClient.Create(ctx, node)).To(Succeed())
+		node.Status.Capacity = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}
+		Expect(k8sClient.Status().Update(ctx, node)).To(Succeed())
+	}
+
+	extendedResource := func(name string) func() string {
+		return func() string {
+			var node corev1.Node
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: name}, &node); err != nil {
+				return err.Error()
+			}
+			limit, ok := node.Status.Capacity[peerPodsExtendedResource]
+			if !ok {
+				return ""
+			}
+			return limit.String()
+		}
+	}
+
+	It("reconciles the cloud-api-adaptor DaemonSet and the extended resources", func() {
+		createNode("worker-0", map[string]string{defaultNodeSelectorLabel: ""})
+		createNode("worker-1", map[string]string{"peerpods": "true"})
+
+		By("creating a PeerPodConfig")
+		ppc := &ccv1alpha1.PeerPodConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: ppcKey.Name, Namespace: ppcKey.Namespace},
+			Spec: ccv1alpha1.PeerPodConfigSpec{
+				Limit:           "10",
+				CloudSecretName: "peer-pods-secret",
+				ConfigMapName:   "peer-pods-cm",
+			},
+		}
+		Expect(k8sClient.Create(ctx, ppc)).To(Succeed())
+
+		ds := &appsv1.DaemonSet{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, dsKey, ds)
+		}, timeout, interval).Should(Succeed())
+		Expect(ds.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{defaultNodeSelectorLabel: ""}))
+		Expect(ds.Annotations).To(HaveKey(caaSpecHashAnnotation))
+		Expect(metav1.IsControlledBy(ds, ppc)).To(BeTrue())
+
+		Eventually(extendedResource("worker-0"), timeout, interval).Should(Equal("10"))
+		Expect(extendedResource("worker-1")()).To(BeEmpty())
+
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, ppcKey, ppc)).To(Succeed())
+			g.Expect(ppc.Finalizers).To(ContainElement(peerPodConfigFinalizer))
+			g.Expect(ppc.Status.SetupCompleted).To(BeTrue())
+			g.Expect(ppc.Status.ObservedGeneration).To(Equal(ppc.Generation))
+			g.Expect(meta.IsStatusConditionTrue(ppc.Status.Conditions, ccv1alpha1.ExtendedResourcesAdvertised)).To(BeTrue())
+			// The DaemonSet controller does not run in envtest
+			g.Expect(meta.FindStatusCondition(ppc.Status.Conditions, ccv1alpha1.CloudAPIAdaptorReady)).NotTo(BeNil())
+		}, timeout, interval).Should(Succeed())
+
+		By("updating the spec of the PeerPodConfig")
+		hash := ds.Annotations[caaSpecHashAnnotation]
+		ppc.Spec.NodeSelector = map[string]string{"peerpods": "true"}
+		ppc.Spec.InstanceType = "t3.large"
+		Expect(k8sClient.Update(ctx, ppc)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(k8sClient.Get(ctx, dsKey, ds)).To(Succeed())
+			g.Expect(ds.Annotations[caaSpecHashAnnotation]).NotTo(Equal(hash))
+			g.Expect(ds.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"peerpods": "true"}))
+			g.Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "PODVM_INSTANCE_TYPE", Value: "t3.large"}))
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(extendedResource("worker-1"), timeout, interval).Should(Equal("10"))
+		Eventually(extendedResource("worker-0"), timeout, interval).Should(BeEmpty())
+
+		By("deleting the PeerPodConfig")
+		Expect(k8sClient.Delete(ctx, ppc)).To(Succeed())
+
+		Eventually(func() bool {
+			return k8serrors.IsNotFound(k8sClient.Get(ctx, ppcKey, ppc))
+		}, timeout, interval).Should(BeTrue())
+		Expect(extendedResource("worker-1")()).To(BeEmpty())
+	})
+})
+
+// newTestReconciler returns a reconciler with a fake client, for tests that run without envtest
+func newTestReconciler(t *testing.T, objects ...client.Object) *PeerPodConfigReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := ccv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return &PeerPodConfigReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Scheme: scheme,
+		Log:    logr.Discard(),
+	}
+}
+
+func newTestNode(name string, nodeLabels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+		Status:     corev1.NodeStatus{Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+	}
+}
+
+func nodeExtendedResource(t *testing.T, c client.Client, name string) string {
+	var node corev1.Node
+	if err := c.Get(context.Background(), types.NamespacedName{Name: name}, &node); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	limit, ok := node.Status.Capacity[peerPodsExtendedResource]
+	if !ok {
+		return ""
+	}
+	return limit.String()
+}
+
+// TestReconcile covers the reconciliation of the envtest suite with a fake client, so that it also runs
+// without KUBEBUILDER_ASSETS
+func TestReconcile(t *testing.T) {
+	t.Setenv("PEERPODS_NAMESPACE", testNamespace)
+
+	ctx := context.Background()
+	ppcKey := types.NamespacedName{Name: "peerpodconfig", Namespace: testNamespace}
+	dsKey := types.NamespacedName{Name: caaDsName, Namespace: testNamespace}
+	req := ctrl.Request{NamespacedName: ppcKey}
+
+	ppc := &ccv1alpha1.PeerPodConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: ppcKey.Name, Namespace: ppcKey.Namespace},
+		Spec: ccv1alpha1.PeerPodConfigSpec{
+			Limit:           "10",
+			CloudSecretName: "peer-pods-secret",
+			ConfigMapName:   "peer-pods-cm",
+		},
+	}
+	// The extended resource of worker-2 is not advertised by this PeerPodConfig
+	other := newTestNode("worker-2", nil)
+	other.Status.Capacity[peerPodsExtendedResource] = resource.MustParse("5")
+	r := newTestReconciler(t, ppc,
+		newTestNode("worker-0", map[string]string{defaultNodeSelectorLabel: ""}),
+		newTestNode("worker-1", map[string]string{"peerpods": "true"}),
+		other)
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	ds := &appsv1.DaemonSet{}
+	if err := r.Client.Get(ctx, dsKey, ds); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := defaultNodeSelectorLabel, ds.Spec.Template.Spec.NodeSelector; len(a) != 1 || a[e] != "" {
+		t.Fatalf("Expect node selector %s, got %v", e, a)
+	}
+	if !metav1.IsControlledBy(ds, ppc) {
+		t.Fatalf("Expect DaemonSet controlled by PeerPodConfig, got %v", ds.OwnerReferences)
+	}
+	hash := ds.Annotations[caaSpecHashAnnotation]
+	if hash == "" {
+		t.Fatalf("Expect annotation %s, got %v", caaSpecHashAnnotation, ds.Annotations)
+	}
+	if e, a := "10", nodeExtendedResource(t, r.Client, "worker-0"); e != a {
+		t.Fatalf("Expect extended resource %q on worker-0, got %q", e, a)
+	}
+	if a := nodeExtendedResource(t, r.Client, "worker-1"); a != "" {
+		t.Fatalf("Expect no extended resource on worker-1, got %q", a)
+	}
+
+	if err := r.Client.Get(ctx, ppcKey, ppc); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(ppc, peerPodConfigFinalizer) {
+		t.Fatalf("Expect finalizer %s, got %v", peerPodConfigFinalizer, ppc.Finalizers)
+	}
+	if !ppc.Status.SetupCompleted {
+		t.Fatal("Expect setup to be completed")
+	}
+	if e, a := []string{"worker-0"}, ppc.Status.AdvertisedNodes; !reflect.DeepEqual(e, a) {
+		t.Fatalf("Expect advertised nodes %v, got %v", e, a)
+	}
+	if !meta.IsStatusConditionTrue(ppc.Status.Conditions, ccv1alpha1.ExtendedResourcesAdvertised) {
+		t.Fatalf("Expect condition %s=True, got %v", ccv1alpha1.ExtendedResourcesAdvertised, ppc.Status.Conditions)
+	}
+	// No DaemonSet controller runs with the fake client
+	if c := meta.FindStatusCondition(ppc.Status.Conditions, ccv1alpha1.CloudAPIAdaptorReady); c == nil || c.Status != metav1.ConditionFalse {
+		t.Fatalf("Expect condition %s=False, got %v", ccv1alpha1.CloudAPIAdaptorReady, c)
+	}
+
+	// A changed spec updates the DaemonSet and moves the extended resource
+	ppc.Spec.NodeSelector = map[string]string{"peerpods": "true"}
+	ppc.Spec.InstanceType = "t3.large"
+	if err := r.Client.Update(ctx, ppc); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if err := r.Client.Get(ctx, dsKey, ds); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if ds.Annotations[caaSpecHashAnnotation] == hash {
+		t.Fatal("Expect spec hash to change")
+	}
+	if e, a := "true", ds.Spec.Template.Spec.NodeSelector["peerpods"]; e != a {
+		t.Fatalf("Expect node selector peerpods=%s, got %v", e, ds.Spec.Template.Spec.NodeSelector)
+	}
+	if e, a := (corev1.EnvVar{Name: "PODVM_INSTANCE_TYPE", Value: "t3.large"}), ds.Spec.Template.Spec.Containers[0].Env; len(a) != 1 || a[0] != e {
+		t.Fatalf("Expect env %v, got %v", e, a)
+	}
+	if e, a := "10", nodeExtendedResource(t, r.Client, "worker-1"); e != a {
+		t.Fatalf("Expect extended resource %q on worker-1, got %q", e, a)
+	}
+	if a := nodeExtendedResource(t, r.Client, "worker-0"); a != "" {
+		t.Fatalf("Expect no extended resource on worker-0, got %q", a)
+	}
+	if e, a := "5", nodeExtendedResource(t, r.Client, "worker-2"); e != a {
+		t.Fatalf("Expect extended resource %q on worker-2, got %q", e, a)
+	}
+
+	// A deleted PeerPodConfig removes the extended resource from the nodes it advertised it on
+	if err := r.Client.Delete(ctx, ppc); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if a := nodeExtendedResource(t, r.Client, "worker-1"); a != "" {
+		t.Fatalf("Expect no extended resource on worker-1, got %q", a)
+	}
+	if e, a := "5", nodeExtendedResource(t, r.Client, "worker-2"); e != a {
+		t.Fatalf("Expect extended resource %q on worker-2, got %q", e, a)
+	}
+	if err := r.Client.Get(ctx, ppcKey, ppc); err == nil && controllerutil.ContainsFinalizer(ppc, peerPodConfigFinalizer) {
+		t.Fatalf("Expect finalizer to be removed, got %v", ppc.Finalizers)
+	} else if err != nil && !k8serrors.IsNotFound(err) {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+}
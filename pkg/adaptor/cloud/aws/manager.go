@@ -5,6 +5,7 @@ package aws
 
 import (
 	"flag"
+	"os"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 )
@@ -43,9 +44,20 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (_ *Manager) LoadEnv() {
-	cloud.DefaultToEnv(&awscfg.AccessKeyId, "AWS_ACCESS_KEY_ID", "")
-	cloud.DefaultToEnv(&awscfg.SecretKey, "AWS_SECRET_ACCESS_KEY", "")
+	loadConfig(&awscfg, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (_ *Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := awscfg
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
 
+func loadConfig(cfg *Config, lookup func(key string) string) {
+	cloud.DefaultTo(&cfg.AccessKeyId, lookup, "AWS_ACCESS_KEY_ID", "")
+	cloud.DefaultTo(&cfg.SecretKey, lookup, "AWS_SECRET_ACCESS_KEY", "")
+	cloud.DefaultTo(&cfg.Region, lookup, "AWS_REGION", "")
 }
 
 func (_ *Manager) NewProvider() (cloud.Provider, error) {
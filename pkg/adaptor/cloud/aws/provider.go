@@ -10,12 +10,14 @@ import (
 	"fmt"
 	"log"
 	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util"
@@ -51,6 +53,10 @@ type ec2Client interface {
 	DescribeImages(ctx context.Context,
 		params *ec2.DescribeImagesInput,
 		optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	// Add DescribeAccountAttributes method
+	DescribeAccountAttributes(ctx context.Context,
+		params *ec2.DescribeAccountAttributesInput,
+		optFns ...func(*ec2.Options)) (*ec2.DescribeAccountAttributesOutput, error)
 }
 
 // Make instanceRunningWaiter as an interface
@@ -304,9 +310,18 @@ func (p *awsProvider) CreateInstance(ctx context.Context, podName, sandboxID str
 	}
 
 	instance := &cloud.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:    instanceID,
+		Name:  instanceName,
+		IPs:   ips,
+		Type:  string(result.Instances[0].InstanceType),
+		Image: aws.ToString(result.Instances[0].ImageId),
+	}
+
+	if placement := result.Instances[0].Placement; placement != nil {
+		instance.Zone = aws.ToString(placement.AvailabilityZone)
+	}
+	if launchTime := result.Instances[0].LaunchTime; launchTime != nil {
+		instance.LaunchTime = *launchTime
 	}
 
 	return instance, nil
@@ -324,6 +339,9 @@ func (p *awsProvider) DeleteInstance(ctx context.Context, instanceID string) err
 	resp, err := p.ec2Client.TerminateInstances(ctx, terminateInput)
 
 	if err != nil {
+		if isInstanceNotFound(err) {
+			return fmt.Errorf("failed to delete an instance %s: %w: %v", instanceID, cloud.ErrInstanceNotFound, err)
+		}
 		logger.Printf("failed to delete an instance: %v and the response is %v", err, resp)
 		return err
 	}
@@ -332,6 +350,138 @@ func (p *awsProvider) DeleteInstance(ctx context.Context, instanceID string) err
 
 }
 
+func (p *awsProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+
+	output, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		if isInstanceNotFound(err) {
+			return cloud.InstanceStateUnknown, fmt.Errorf("failed to describe an instance %s: %w: %v", instanceID, cloud.ErrInstanceNotFound, err)
+		}
+		return cloud.InstanceStateUnknown, fmt.Errorf("failed to describe an instance %s: %w", instanceID, err)
+	}
+
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return cloud.InstanceStateUnknown, fmt.Errorf("instance %s: %w", instanceID, cloud.ErrInstanceNotFound)
+	}
+
+	instance := output.Reservations[0].Instances[0]
+	if instance.State == nil {
+		return cloud.InstanceStateUnknown, nil
+	}
+
+	switch instance.State.Name {
+	case types.InstanceStateNamePending:
+		return cloud.InstanceStatePending, nil
+	case types.InstanceStateNameRunning:
+		return cloud.InstanceStateRunning, nil
+	case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated, types.InstanceStateNameStopping, types.InstanceStateNameStopped:
+		return cloud.InstanceStateStopped, nil
+	}
+
+	return cloud.InstanceStateUnknown, nil
+}
+
+// GetInstanceIPs returns the current IP addresses of an instance in the same order as CreateInstance does
+func (p *awsProvider) GetInstanceIPs(ctx context.Context, instanceID string) ([]netip.Addr, error) {
+
+	output, err := p.ec2Client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	if err != nil {
+		if isInstanceNotFound(err) {
+			return nil, fmt.Errorf("failed to describe an instance %s: %w: %v", instanceID, cloud.ErrInstanceNotFound, err)
+		}
+		return nil, fmt.Errorf("failed to describe an instance %s: %w", instanceID, err)
+	}
+
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s: %w", instanceID, cloud.ErrInstanceNotFound)
+	}
+
+	instance := output.Reservations[0].Instances[0]
+
+	ips, err := getIPs(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.serviceConfig.UsePublicIP && len(instance.NetworkInterfaces) > 0 {
+		association := instance.NetworkInterfaces[0].Association
+		if association == nil || aws.ToString(association.PublicIp) == "" {
+			return nil, fmt.Errorf("instance %s has no public IP address", instanceID)
+		}
+		publicIP, err := netip.ParseAddr(*association.PublicIp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public IP %q: %w", *association.PublicIp, err)
+		}
+		ips[0] = publicIP
+	}
+
+	return ips, nil
+}
+
+// maxInstancesAttribute is the account attribute of the maximum number of On-Demand instances in a region,
+// which is not defined in types.AccountAttributeName
+const maxInstancesAttribute types.AccountAttributeName = "max-instances"
+
+// GetQuota returns the number of On-Demand instances that can still be launched in the region.
+// It relies on the legacy max-instances account attribute, since the Service Quotas API is not used by this provider.
+// EC2 limits On-Demand instances by vCPUs, and max-instances does not reflect the vCPU limit of accounts that
+// moved to it, so the number of instances is only an approximation, and the remaining vCPUs are not known.
+func (p *awsProvider) GetQuota(ctx context.Context) (*cloud.Quota, error) {
+
+	attrs, err := p.ec2Client.DescribeAccountAttributes(ctx, &ec2.DescribeAccountAttributesInput{
+		AttributeNames: []types.AccountAttributeName{maxInstancesAttribute},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe account attributes: %w", err)
+	}
+
+	var maxInstances int64 = -1
+	for _, attr := range attrs.AccountAttributes {
+		if attr.AttributeName == nil || *attr.AttributeName != string(maxInstancesAttribute) || len(attr.AttributeValues) == 0 {
+			continue
+		}
+		if maxInstances, err = strconv.ParseInt(aws.ToString(attr.AttributeValues[0].AttributeValue), 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", maxInstancesAttribute, err)
+		}
+	}
+	if maxInstances < 0 {
+		return &cloud.Quota{Instances: -1, VCPUs: -1}, nil
+	}
+
+	var running int64
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	}
+	for {
+		output, err := p.ec2Client.DescribeInstances(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe instances: %w", err)
+		}
+		for _, reservation := range output.Reservations {
+			running += int64(len(reservation.Instances))
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	remaining := maxInstances - running
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &cloud.Quota{Instances: remaining, VCPUs: -1}, nil
+}
+
+// isInstanceNotFound returns true if err is an EC2 API error of a nonexistent instance
+func isInstanceNotFound(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceID.NotFound"
+}
+
 func (p *awsProvider) Teardown() error {
 	return nil
 }
@@ -106,6 +106,7 @@ func (m mockEC2Client) DescribeInstances(ctx context.Context,
 				Instances: []types.Instance{
 					{
 						InstanceId: &mockInstanceID,
+						State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
 						// Add private IP address to mock instance
 						PrivateIpAddress: aws.String("10.0.0.2"),
 						// Add private IP address to network interface
@@ -126,6 +127,24 @@ func (m mockEC2Client) DescribeInstances(ctx context.Context,
 	}, nil
 }
 
+// Create a mock EC2 DescribeAccountAttributes method
+func (m mockEC2Client) DescribeAccountAttributes(ctx context.Context,
+	params *ec2.DescribeAccountAttributesInput,
+	optFns ...func(*ec2.Options)) (*ec2.DescribeAccountAttributesOutput, error) {
+
+	// Return a mock DescribeAccountAttributesOutput with the max-instances attribute
+	return &ec2.DescribeAccountAttributesOutput{
+		AccountAttributes: []types.AccountAttribute{
+			{
+				AttributeName: aws.String("max-instances"),
+				AttributeValues: []types.AccountAttributeValue{
+					{AttributeValue: aws.String("20")},
+				},
+			},
+		},
+	}, nil
+}
+
 // Mock instanceRunningWaiter
 type MockAWSInstanceWaiter struct{}
 
@@ -481,6 +500,64 @@ func TestDeleteInstance(t *testing.T) {
 	}
 }
 
+func TestGetInstanceState(t *testing.T) {
+	p := &awsProvider{
+		ec2Client:     newMockEC2Client(),
+		serviceConfig: serviceConfig,
+	}
+
+	state, err := p.GetInstanceState(context.Background(), "i-1234567890abcdef0")
+	if err != nil {
+		t.Fatalf("awsProvider.GetInstanceState() error = %v", err)
+	}
+	if state != cloud.InstanceStateRunning {
+		t.Errorf("awsProvider.GetInstanceState() = %v, want %v", state, cloud.InstanceStateRunning)
+	}
+}
+
+func TestGetInstanceIPs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config *Config
+		want   []netip.Addr
+	}{
+		{"private IP", serviceConfig, []netip.Addr{netip.MustParseAddr("10.0.0.2")}},
+		{"public IP", serviceConfigPublicIP, []netip.Addr{netip.MustParseAddr("192.168.100.1")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &awsProvider{
+				ec2Client:     newMockEC2Client(),
+				serviceConfig: tc.config,
+			}
+
+			ips, err := p.GetInstanceIPs(context.Background(), "i-1234567890abcdef0")
+			if err != nil {
+				t.Fatalf("awsProvider.GetInstanceIPs() error = %v", err)
+			}
+			if !reflect.DeepEqual(ips, tc.want) {
+				t.Errorf("awsProvider.GetInstanceIPs() = %v, want %v", ips, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetQuota(t *testing.T) {
+	p := &awsProvider{
+		ec2Client:     newMockEC2Client(),
+		serviceConfig: serviceConfig,
+	}
+
+	quota, err := p.GetQuota(context.Background())
+	if err != nil {
+		t.Fatalf("awsProvider.GetQuota() error = %v", err)
+	}
+	// max-instances of the mock is 20, and one instance is running
+	want := &cloud.Quota{Instances: 19, VCPUs: -1}
+	if !reflect.DeepEqual(quota, want) {
+		t.Errorf("awsProvider.GetQuota() = %v, want %v", quota, want)
+	}
+}
+
 func TestGetInstanceTypeInformation(t *testing.T) {
 	type fields struct {
 		ec2Client     ec2Client
@@ -5,6 +5,7 @@ package azure
 
 import (
 	"flag"
+	"os"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 )
@@ -38,12 +39,23 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (_ *Manager) LoadEnv() {
-	cloud.DefaultToEnv(&azurecfg.ClientId, "AZURE_CLIENT_ID", "")
-	cloud.DefaultToEnv(&azurecfg.ClientSecret, "AZURE_CLIENT_SECRET", "")
-	cloud.DefaultToEnv(&azurecfg.TenantId, "AZURE_TENANT_ID", "")
-	cloud.DefaultToEnv(&azurecfg.SubscriptionId, "AZURE_SUBSCRIPTION_ID", "")
-	cloud.DefaultToEnv(&azurecfg.Region, "AZURE_REGION", "")
-	cloud.DefaultToEnv(&azurecfg.ResourceGroupName, "AZURE_RESOURCE_GROUP", "")
+	loadConfig(&azurecfg, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (_ *Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := azurecfg
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
+
+func loadConfig(cfg *Config, lookup func(key string) string) {
+	cloud.DefaultTo(&cfg.ClientId, lookup, "AZURE_CLIENT_ID", "")
+	cloud.DefaultTo(&cfg.ClientSecret, lookup, "AZURE_CLIENT_SECRET", "")
+	cloud.DefaultTo(&cfg.TenantId, lookup, "AZURE_TENANT_ID", "")
+	cloud.DefaultTo(&cfg.SubscriptionId, lookup, "AZURE_SUBSCRIPTION_ID", "")
+	cloud.DefaultTo(&cfg.Region, lookup, "AZURE_REGION", "")
+	cloud.DefaultTo(&cfg.ResourceGroupName, lookup, "AZURE_RESOURCE_GROUP", "")
 }
 
 func (_ *Manager) NewProvider() (cloud.Provider, error) {
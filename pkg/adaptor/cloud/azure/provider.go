@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/netip"
 	"os"
 	"regexp"
@@ -250,9 +251,15 @@ func (p *azureProvider) CreateInstance(ctx context.Context, podName, sandboxID s
 	}
 
 	instance := &cloud.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:    instanceID,
+		Name:  instanceName,
+		IPs:   ips,
+		Type:  instanceSize,
+		Zone:  p.serviceConfig.Zone,
+		Image: p.serviceConfig.ImageId,
+	}
+	if result.Properties != nil && result.Properties.TimeCreated != nil {
+		instance.LaunchTime = *result.Properties.TimeCreated
 	}
 
 	return instance, nil
@@ -264,18 +271,17 @@ func (p *azureProvider) DeleteInstance(ctx context.Context, instanceID string) e
 		return fmt.Errorf("creating VM client: %w", err)
 	}
 
-	// instanceID in the form of /subscriptions/<subID>/resourceGroups/<resource_name>/providers/Microsoft.Compute/virtualMachines/<VM_Name>.
-	re := regexp.MustCompile(`^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/virtualMachines/(.*)$`)
-	match := re.FindStringSubmatch(instanceID)
-	if len(match) < 1 {
-		logger.Print("finding VM name using regexp:", match)
-		return errNotFound
+	vmName, err := vmNameFromID(instanceID)
+	if err != nil {
+		return err
 	}
 
-	vmName := match[1]
-
 	pollerResponse, err := vmClient.BeginDelete(ctx, p.serviceConfig.ResourceGroupName, vmName, nil)
 	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("beginning VM deletion: %w: %v", cloud.ErrInstanceNotFound, err)
+		}
 		return fmt.Errorf("beginning VM deletion: %w", err)
 	}
 
@@ -287,6 +293,92 @@ func (p *azureProvider) DeleteInstance(ctx context.Context, instanceID string) e
 	return nil
 }
 
+func (p *azureProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+	vmClient, err := armcompute.NewVirtualMachinesClient(p.serviceConfig.SubscriptionId, p.azureClient, nil)
+	if err != nil {
+		return cloud.InstanceStateUnknown, fmt.Errorf("creating VM client: %w", err)
+	}
+
+	vmName, err := vmNameFromID(instanceID)
+	if err != nil {
+		return cloud.InstanceStateUnknown, err
+	}
+
+	view, err := vmClient.InstanceView(ctx, p.serviceConfig.ResourceGroupName, vmName, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return cloud.InstanceStateUnknown, fmt.Errorf("getting VM instance view: %w: %v", cloud.ErrInstanceNotFound, err)
+		}
+		return cloud.InstanceStateUnknown, fmt.Errorf("getting VM instance view: %w", err)
+	}
+
+	for _, status := range view.Statuses {
+		if status.Code == nil || !strings.HasPrefix(*status.Code, "PowerState/") {
+			continue
+		}
+		switch strings.TrimPrefix(*status.Code, "PowerState/") {
+		case "starting":
+			return cloud.InstanceStatePending, nil
+		case "running":
+			return cloud.InstanceStateRunning, nil
+		case "stopping", "stopped", "deallocating", "deallocated":
+			return cloud.InstanceStateStopped, nil
+		}
+	}
+
+	return cloud.InstanceStateUnknown, nil
+}
+
+// GetQuota returns the number of VMs and vCPUs that can still be allocated in the region, according to the
+// compute usage of the subscription
+func (p *azureProvider) GetQuota(ctx context.Context) (*cloud.Quota, error) {
+	usageClient, err := armcompute.NewUsageClient(p.serviceConfig.SubscriptionId, p.azureClient, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating usage client: %w", err)
+	}
+
+	quota := &cloud.Quota{Instances: -1, VCPUs: -1}
+
+	pager := usageClient.NewListPager(p.serviceConfig.Region, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing compute usages: %w", err)
+		}
+		for _, usage := range page.Value {
+			if usage.Name == nil || usage.Name.Value == nil || usage.Limit == nil || usage.CurrentValue == nil {
+				continue
+			}
+			remaining := *usage.Limit - int64(*usage.CurrentValue)
+			if remaining < 0 {
+				remaining = 0
+			}
+			switch *usage.Name.Value {
+			case "virtualMachines":
+				quota.Instances = remaining
+			case "cores":
+				quota.VCPUs = remaining
+			}
+		}
+	}
+
+	return quota, nil
+}
+
+// vmNameFromID returns the VM name of instanceID in the form of
+// /subscriptions/<subID>/resourceGroups/<resource_name>/providers/Microsoft.Compute/virtualMachines/<VM_Name>
+func vmNameFromID(instanceID string) (string, error) {
+	re := regexp.MustCompile(`^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/virtualMachines/(.*)$`)
+	match := re.FindStringSubmatch(instanceID)
+	if len(match) < 1 {
+		logger.Print("finding VM name using regexp:", match)
+		return "", errNotFound
+	}
+
+	return match[1], nil
+}
+
 func (p *azureProvider) deleteDisk(ctx context.Context, diskName string) error {
 	diskClient, err := armcompute.NewDisksClient(p.serviceConfig.SubscriptionId, p.azureClient, nil)
 	if err != nil {
@@ -0,0 +1,95 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+const certRenewalRetryInterval = time.Minute
+
+// startCertRenewal renews the server certificate of the forwarder in a pod VM before it expires.
+// A renewed certificate is sent over the agent connection, and the forwarder uses it for new TLS connections.
+func (s *cloudService) startCertRenewal(sandbox *sandbox) {
+
+	if sandbox.agentProxy.CAService() == nil || sandbox.serverCertPEM == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	s.mutex.Lock()
+	sandbox.stopCertRenewal = cancel
+	sandbox.certRenewalDone = done
+	s.mutex.Unlock()
+
+	go func() {
+		defer close(done)
+
+		certPEM := sandbox.serverCertPEM
+
+		for {
+			renewAt, err := tlsutil.RenewalTime(certPEM)
+			if err != nil {
+				logger.Printf("failed to get the renewal time of the server certificate of sandbox %s: %v", sandbox.id, err)
+				return
+			}
+
+			timer := time.NewTimer(time.Until(renewAt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			newCertPEM, err := s.renewCert(ctx, sandbox)
+			for err != nil {
+				logger.Printf("failed to renew the server certificate of sandbox %s: %v", sandbox.id, err)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(certRenewalRetryInterval):
+				}
+				newCertPEM, err = s.renewCert(ctx, sandbox)
+			}
+
+			logger.Printf("renewed the server certificate of sandbox %s", sandbox.id)
+			certPEM = newCertPEM
+		}
+	}()
+}
+
+func (s *cloudService) renewCert(ctx context.Context, sandbox *sandbox) ([]byte, error) {
+
+	certPEM, keyPEM, err := sandbox.agentProxy.IssueServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sandbox.agentProxy.UpdateServerCertificate(ctx, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+
+	return certPEM, nil
+}
+
+// stopCertRenewal stops the certificate renewal of a sandbox, and waits until an ongoing renewal finishes
+func (s *cloudService) stopCertRenewal(sandbox *sandbox) {
+
+	s.mutex.Lock()
+	stop, done := sandbox.stopCertRenewal, sandbox.certRenewalDone
+	sandbox.stopCertRenewal, sandbox.certRenewalDone = nil, nil
+	s.mutex.Unlock()
+
+	if stop != nil {
+		stop()
+		<-done
+	}
+}
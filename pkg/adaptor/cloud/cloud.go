@@ -14,16 +14,22 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd/pkg/cri/annotations"
 	pb "github.com/kata-containers/kata-containers/src/runtime/protocols/hypervisor"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
 )
 
 const (
@@ -71,7 +77,7 @@ func (s *cloudService) removeSandbox(id sandboxID) error {
 }
 
 func NewService(provider Provider, proxyFactory proxy.Factory, workerNode podnetwork.WorkerNode,
-	podsDir, daemonPort, aaKBCParams string) Service {
+	podsDir, daemonPort, aaKBCParams string, tunnelCheckInterval time.Duration, userDataSealer *userdata.Sealer, tunableAgentSettings []string) Service {
 	var err error
 
 	s := &cloudService{
@@ -82,9 +88,14 @@ func NewService(provider Provider, proxyFactory proxy.Factory, workerNode podnet
 		daemonPort:   daemonPort,
 		workerNode:   workerNode,
 		aaKBCParams:  aaKBCParams,
+		stopCh:       make(chan struct{}),
+
+		tunnelCheckInterval:  tunnelCheckInterval,
+		userDataSealer:       userDataSealer,
+		tunableAgentSettings: tunableAgentSettings,
 	}
 	s.cond = sync.NewCond(&s.mutex)
-	s.ppService, err = k8sops.NewPeerPodService()
+	s.ppService, err = k8sops.NewPeerPodService(s.stopCh)
 	if err != nil {
 		logger.Printf("failed to create PeerPodService, runtime failure may result in dangling resources %s", err)
 	}
@@ -93,6 +104,9 @@ func NewService(provider Provider, proxyFactory proxy.Factory, workerNode podnet
 }
 
 func (s *cloudService) Teardown() error {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
 	return s.provider.Teardown()
 }
 
@@ -201,24 +215,52 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 	}
 	socketPath := filepath.Join(podDir, proxy.SocketName)
 
-	agentProxy := s.proxyFactory.New(serverName, socketPath)
+	// The agent policy is enforced both by the agent proxy on the worker node and by the forwarder in the pod VM
+	var agentPolicy agentproto.Policy
+	policyDoc := req.Annotations[agentproto.PolicyAnnotation]
+	if policyDoc != "" {
+		agentPolicy, err = agentproto.ParsePolicy(policyDoc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", agentproto.PolicyAnnotation, err)
+		}
+	}
+
+	// Kata agent settings are merged into the agent config file by process-user-data in the pod VM
+	var agentConfig map[string]interface{}
+	if agentConfigDoc := req.Annotations[forwarder.AgentConfigAnnotation]; agentConfigDoc != "" {
+		if err := json.Unmarshal([]byte(agentConfigDoc), &agentConfig); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", forwarder.AgentConfigAnnotation, err)
+		}
+		if err := forwarder.ValidateAgentConfig(agentConfig, s.tunableAgentSettings); err != nil {
+			return nil, fmt.Errorf("invalid annotation %s: %w", forwarder.AgentConfigAnnotation, err)
+		}
+	}
+
+	agentProxy := s.proxyFactory.New(serverName, socketPath, agentproto.Sandbox{ID: string(sid), PodName: pod, PodNamespace: namespace}, agentPolicy)
 
 	daemonConfig := forwarder.Config{
 		PodNamespace: namespace,
 		PodName:      pod,
+		SandboxID:    string(sid),
 		PodNetwork:   podNetworkConfig,
 		TLSClientCA:  string(agentProxy.ClientCA()),
+		AgentPolicy:  policyDoc,
+		AgentConfig:  agentConfig,
+		Attestation:  agentProxy.AttestationEnabled(),
 	}
 
-	if caService := agentProxy.CAService(); caService != nil {
+	var serverCertPEM []byte
 
-		certPEM, keyPEM, err := caService.Issue(serverName)
+	if agentProxy.CAService() != nil {
+
+		certPEM, keyPEM, err := agentProxy.IssueServerCertificate()
 		if err != nil {
 			return nil, fmt.Errorf("creating TLS certificate for communication between worker node and peer pod VM")
 		}
 
 		daemonConfig.TLSServerCert = string(certPEM)
 		daemonConfig.TLSServerKey = string(keyPEM)
+		serverCertPEM = certPEM
 	}
 
 	if s.aaKBCParams != "" {
@@ -244,11 +286,22 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 	}
 	logger.Printf("stored %s", daemonJSONPath)
 
+	// Sign the daemon config, which is verified by process-user-data in the pod VM. A sealed daemon config
+	// is written to a separate file, so that process-user-data can verify it again when the pod VM restarts.
+	userData, userDataPath := daemonJSON, forwarder.DefaultConfigPath
+	if s.userDataSealer != nil {
+		userData, err = s.userDataSealer.Seal(daemonJSON)
+		if err != nil {
+			return nil, fmt.Errorf("sealing %s: %w", forwarder.DefaultConfigPath, err)
+		}
+		userDataPath = forwarder.DefaultSealedConfigPath
+	}
+
 	cloudConfig := &cloudinit.CloudConfig{
 		WriteFiles: []cloudinit.WriteFile{
 			{
-				Path:    forwarder.DefaultConfigPath,
-				Content: string(daemonJSON),
+				Path:    userDataPath,
+				Content: string(userData),
 			},
 		},
 	}
@@ -262,6 +315,8 @@ func (s *cloudService) CreateVM(ctx context.Context, req *pb.CreateVMRequest) (r
 		podNetwork:   podNetworkConfig,
 		cloudConfig:  cloudConfig,
 		spec:         vmSpec,
+
+		serverCertPEM: serverCertPEM,
 	}
 
 	if err := s.addSandbox(sid, sandbox); err != nil {
@@ -288,15 +343,25 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 		return nil, fmt.Errorf("getting sandbox: %w", err)
 	}
 
+	ownPeerPod := false
+	defer func() {
+		if err != nil {
+			s.reportStartFailure(sandbox, ownPeerPod, err)
+		}
+	}()
+
 	instance, err := s.provider.CreateInstance(ctx, sandbox.podName, string(sid), sandbox.cloudConfig, sandbox.spec)
 	if err != nil {
 		return nil, fmt.Errorf("creating an instance : %w", err)
 	}
 
 	if s.ppService != nil {
-		if err := s.ppService.OwnPeerPod(sandbox.podName, sandbox.podNamespace, instance.ID); err != nil {
+		if err := s.ppService.OwnPeerPod(ctx, sandbox.podName, sandbox.podNamespace, instance.ID, peerPodInstanceStatus(instance)); err != nil {
 			logger.Printf("failed to create PeerPod: %s", err.Error())
+		} else {
+			ownPeerPod = true
 		}
+		s.ppService.RecordPodEvent(ctx, sandbox.podName, sandbox.podNamespace, v1.EventTypeNormal, "PodVMCreated", "Created pod VM instance %s (%s)", instance.Name, instance.ID)
 	}
 
 	if err := s.setInstance(sid, instance.ID, instance.Name); err != nil {
@@ -309,6 +374,31 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 		return nil, fmt.Errorf("setting up pod network tunnel on netns %s: %w", sandbox.netNSPath, err)
 	}
 
+	s.setPeerPodCondition(ctx, sandbox, metav1.Condition{
+		Type:    peerPodV1alpha1.TunnelReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "TunnelSetUp",
+		Message: "pod network tunnel is set up",
+	})
+
+	// Port forwarding is not essential to run a pod, so a failure is only logged
+	if sandbox.podNetwork != nil && sandbox.podNetwork.PodIP.IsValid() {
+		if portForward, err := podnetwork.StartPortForwarder(sandbox.netNSPath, sandbox.podNetwork.PodIP.Addr()); err != nil {
+			logger.Printf("failed to start port forwarder on netns %s: %v", sandbox.netNSPath, err)
+		} else {
+			sandbox.portForward = portForward
+
+			defer func() {
+				if err != nil {
+					if e := portForward.Close(); e != nil {
+						logger.Printf("stopping port forwarder on netns %s: %v", sandbox.netNSPath, e)
+					}
+					sandbox.portForward = nil
+				}
+			}()
+		}
+	}
+
 	serverURL := &url.URL{
 		Scheme: "http",
 		Host:   net.JoinHostPort(instance.IPs[0].String(), s.daemonPort),
@@ -335,6 +425,18 @@ func (s *cloudService) StartVM(ctx context.Context, req *pb.StartVMRequest) (res
 	}
 
 	logger.Printf("agent proxy is ready")
+
+	s.setPeerPodCondition(ctx, sandbox, metav1.Condition{
+		Type:    peerPodV1alpha1.AgentConnected,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AgentProxyReady",
+		Message: fmt.Sprintf("connected to kata agent via %s", serverURL.Host),
+	})
+	s.setPeerPodPhase(ctx, sandbox, peerPodV1alpha1.PeerPodRunning)
+
+	s.startTunnelMonitor(sandbox, instance.IPs, serverURL.Host)
+	s.startCertRenewal(sandbox)
+
 	return &pb.StartVMResponse{}, nil
 }
 
@@ -349,14 +451,37 @@ func (s *cloudService) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.S
 		return nil, err
 	}
 
+	s.stopTunnelMonitor(sandbox)
+	s.stopCertRenewal(sandbox)
+
+	if sandbox.portForward != nil {
+		if err := sandbox.portForward.Close(); err != nil {
+			logger.Printf("stopping port forwarder on netns %s: %v", sandbox.netNSPath, err)
+		}
+	}
+
 	if err := sandbox.agentProxy.Shutdown(); err != nil {
 		logger.Printf("stopping agent proxy: %v", err)
 	}
 
-	if err := s.provider.DeleteInstance(ctx, sandbox.instanceID); err != nil {
+	s.setPeerPodCondition(ctx, sandbox, metav1.Condition{
+		Type:    peerPodV1alpha1.AgentConnected,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AgentProxyStopped",
+		Message: "agent proxy is stopped",
+	})
+	s.setPeerPodPhase(ctx, sandbox, peerPodV1alpha1.PeerPodDeleting)
+
+	if err := s.provider.DeleteInstance(ctx, sandbox.instanceID); err != nil && !errors.Is(err, ErrInstanceNotFound) {
 		logger.Printf("Error deleting an instance %s: %v", sandbox.instanceID, err)
+		if s.ppService != nil {
+			// The finalizer of the PeerPod is kept, so that peerpod-ctrl retries the deletion
+			s.setPeerPodPhase(ctx, sandbox, peerPodV1alpha1.PeerPodFailed)
+			s.ppService.RecordPodEvent(ctx, sandbox.podName, sandbox.podNamespace, v1.EventTypeWarning, "PodVMDeletionFailed", "Failed to delete pod VM instance %s (%s): %v", sandbox.instanceName, sandbox.instanceID, err)
+		}
 	} else if s.ppService != nil {
-		if err := s.ppService.ReleasePeerPod(sandbox.podName, sandbox.podNamespace, sandbox.instanceID); err != nil {
+		s.ppService.RecordPodEvent(ctx, sandbox.podName, sandbox.podNamespace, v1.EventTypeNormal, "PodVMDeleted", "Deleted pod VM instance %s (%s)", sandbox.instanceName, sandbox.instanceID)
+		if err := s.ppService.ReleasePeerPod(ctx, sandbox.podName, sandbox.podNamespace, sandbox.instanceID); err != nil {
 			logger.Printf("failed to release PeerPod %v", err)
 		}
 	}
@@ -371,3 +496,59 @@ func (s *cloudService) StopVM(ctx context.Context, req *pb.StopVMRequest) (*pb.S
 
 	return &pb.StopVMResponse{}, nil
 }
+
+func peerPodInstanceStatus(instance *Instance) *peerPodV1alpha1.PeerPodStatus {
+
+	status := &peerPodV1alpha1.PeerPodStatus{
+		Phase:                     peerPodV1alpha1.PeerPodCreating,
+		InstanceName:              instance.Name,
+		InstanceType:              instance.Type,
+		Zone:                      instance.Zone,
+		Image:                     instance.Image,
+		InstanceCreationTimestamp: &metav1.Time{Time: instance.LaunchTime},
+	}
+	// Not all providers report the launch time, which is close to the time when CreateInstance returns
+	if instance.LaunchTime.IsZero() {
+		status.InstanceCreationTimestamp.Time = time.Now()
+	}
+	for _, ip := range instance.IPs {
+		status.IPs = append(status.IPs, ip.String())
+	}
+
+	return status
+}
+
+func (s *cloudService) setPeerPodPhase(ctx context.Context, sandbox *sandbox, phase peerPodV1alpha1.PeerPodPhase) {
+
+	if s.ppService == nil {
+		return
+	}
+	if err := s.ppService.SetPeerPodPhase(ctx, sandbox.podName, sandbox.podNamespace, phase); err != nil {
+		logger.Printf("failed to set PeerPod phase %s: %v", phase, err)
+	}
+}
+
+func (s *cloudService) setPeerPodCondition(ctx context.Context, sandbox *sandbox, condition metav1.Condition) {
+
+	if s.ppService == nil {
+		return
+	}
+	if err := s.ppService.SetPeerPodCondition(ctx, sandbox.podName, sandbox.podNamespace, condition); err != nil {
+		logger.Printf("failed to set PeerPod condition %s: %v", condition.Type, err)
+	}
+}
+
+// reportStartFailure emits an event on the pod, and marks its PeerPod as failed if it exists
+func (s *cloudService) reportStartFailure(sandbox *sandbox, ownPeerPod bool, err error) {
+
+	if s.ppService == nil {
+		return
+	}
+	// The context of StartVM may be already canceled
+	ctx := context.Background()
+
+	if ownPeerPod {
+		s.setPeerPodPhase(ctx, sandbox, peerPodV1alpha1.PeerPodFailed)
+	}
+	s.ppService.RecordPodEvent(ctx, sandbox.podName, sandbox.podNamespace, v1.EventTypeWarning, "PodVMCreationFailed", "Failed to start pod VM: %v", err)
+}
@@ -5,6 +5,7 @@ package cloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/netip"
 	"net/url"
@@ -15,23 +16,41 @@ import (
 	cri "github.com/containerd/containerd/pkg/cri/annotations"
 	pb "github.com/kata-containers/kata-containers/src/runtime/protocols/hypervisor"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	ppfake "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/pkg/generated/peerpod/clientset/versioned/fake"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/k8sops"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
-type mockProvider struct{}
+type mockProvider struct {
+	launchTime time.Time
+	createErr  error
+}
 
 func (p *mockProvider) CreateInstance(ctx context.Context, podName, sandboxID string, cloudConfig cloudinit.CloudConfigGenerator, spec InstanceTypeSpec) (*Instance, error) {
+	if p.createErr != nil {
+		return nil, p.createErr
+	}
 	return &Instance{
 		Name: "abc",
 		ID:   fmt.Sprintf("%s-%.8s", podName, sandboxID),
 		IPs: []netip.Addr{
 			netip.MustParseAddr("192.0.2.1"),
 		},
+		LaunchTime: p.launchTime,
 	}, nil
 }
 
@@ -55,6 +74,7 @@ type mockProxy struct {
 	readyCh    chan struct{}
 	stopCh     chan struct{}
 	socketPath string
+	serverAddr string
 }
 
 func (p *mockProxy) Start(ctx context.Context, serverURL *url.URL) error {
@@ -80,11 +100,31 @@ func (p *mockProxy) CAService() tlsutil.CAService {
 	return nil
 }
 
+func (p *mockProxy) AttestationEnabled() bool {
+	return false
+}
+
+func (p *mockProxy) IssueServerCertificate() (certPEM, keyPEM []byte, err error) {
+	return nil, nil, nil
+}
+
+func (p *mockProxy) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+	return nil
+}
+
+func (p *mockProxy) ConnectionState() agentproto.ConnectionState {
+	return agentproto.Ready
+}
+
+func (p *mockProxy) SetServerAddress(address string) {
+	p.serverAddr = address
+}
+
 type mockProxyFactory struct {
 	podsDir string
 }
 
-func (f *mockProxyFactory) New(serverName, socketPath string) proxy.AgentProxy {
+func (f *mockProxyFactory) New(serverName, socketPath string, sandbox agentproto.Sandbox, policy agentproto.Policy) proxy.AgentProxy {
 	return &mockProxy{
 		socketPath: socketPath,
 		readyCh:    make(chan struct{}),
@@ -92,20 +132,26 @@ func (f *mockProxyFactory) New(serverName, socketPath string) proxy.AgentProxy {
 	}
 }
 
-type mockWorkerNode struct{}
+type mockWorkerNode struct {
+	setupErr error
+}
 
 func (n mockWorkerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 	return nil, nil
 }
 
 func (n *mockWorkerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
-	return nil
+	return n.setupErr
 }
 
 func (n *mockWorkerNode) Teardown(nsPath string, config *tunneler.Config) error {
 	return nil
 }
 
+func (n *mockWorkerNode) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	return nil
+}
+
 func TestCloudService(t *testing.T) {
 
 	ctx := context.Background()
@@ -115,7 +161,7 @@ func TestCloudService(t *testing.T) {
 		podsDir: dir,
 	}
 
-	s := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, dir, forwarder.DefaultListenPort, "")
+	s := NewService(&mockProvider{}, proxyFactory, &mockWorkerNode{}, dir, forwarder.DefaultListenPort, "", 0, nil, forwarder.DefaultTunableAgentSettings)
 
 	assert.NotNil(t, s)
 
@@ -148,6 +194,195 @@ func TestCloudService(t *testing.T) {
 	assert.NotNil(t, res3)
 }
 
+func newTestPeerPodService(t *testing.T, podName, podNamespace string) (*k8sops.PeerPodService, *ppfake.Clientset, *record.FakeRecorder) {
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: podNamespace, UID: types.UID("uid-" + podName)}}
+	client := k8sfake.NewSimpleClientset(pod)
+	ppClient := ppfake.NewSimpleClientset()
+	recorder := record.NewFakeRecorder(10)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	return k8sops.NewPeerPodServiceWithClients(client, ppClient, recorder, "mock", stopCh), ppClient, recorder
+}
+
+func getTestPeerPod(t *testing.T, ppClient *ppfake.Clientset, namespace string) *peerPodV1alpha1.PeerPod {
+
+	list, err := ppClient.ConfidentialcontainersV1alpha1().PeerPods(namespace).List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	if !assert.Len(t, list.Items, 1) {
+		t.FailNow()
+	}
+	return &list.Items[0]
+}
+
+func receiveEvents(recorder *record.FakeRecorder) (events []string) {
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPeerPodStatus(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	launchTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewService(&mockProvider{launchTime: launchTime}, &mockProxyFactory{podsDir: dir}, &mockWorkerNode{}, dir, forwarder.DefaultListenPort, "", 0, nil, forwarder.DefaultTunableAgentSettings)
+	ppService, ppClient, recorder := newTestPeerPodService(t, "mypod", "default")
+	s.(*cloudService).ppService = ppService
+
+	req := &pb.CreateVMRequest{
+		Id: "123",
+		Annotations: map[string]string{
+			cri.SandboxNamespace: "default",
+			cri.SandboxName:      "mypod",
+		},
+	}
+	_, err := s.CreateVM(ctx, req)
+	assert.NoError(t, err)
+
+	_, err = s.StartVM(ctx, &pb.StartVMRequest{Id: "123"})
+	assert.NoError(t, err)
+
+	pp := getTestPeerPod(t, ppClient, "default")
+	assert.Equal(t, peerPodV1alpha1.PeerPodRunning, pp.Status.Phase)
+	assert.Equal(t, []string{"192.0.2.1"}, pp.Status.IPs)
+	if assert.NotNil(t, pp.Status.InstanceCreationTimestamp) {
+		assert.True(t, launchTime.Equal(pp.Status.InstanceCreationTimestamp.Time), "instance creation timestamp %v", pp.Status.InstanceCreationTimestamp)
+	}
+	assert.True(t, meta.IsStatusConditionTrue(pp.Status.Conditions, peerPodV1alpha1.TunnelReady))
+	assert.True(t, meta.IsStatusConditionTrue(pp.Status.Conditions, peerPodV1alpha1.AgentConnected))
+	assert.Equal(t, []string{"Normal PodVMCreated Created pod VM instance abc (mypod-123)"}, receiveEvents(recorder))
+
+	_, err = s.StopVM(ctx, &pb.StopVMRequest{Id: "123"})
+	assert.NoError(t, err)
+
+	pp = getTestPeerPod(t, ppClient, "default")
+	assert.Equal(t, peerPodV1alpha1.PeerPodDeleting, pp.Status.Phase)
+	assert.True(t, meta.IsStatusConditionFalse(pp.Status.Conditions, peerPodV1alpha1.AgentConnected))
+	assert.Empty(t, pp.Finalizers)
+	assert.Equal(t, []string{"Normal PodVMDeleted Deleted pod VM instance abc (mypod-123)"}, receiveEvents(recorder))
+}
+
+func TestReportStartFailure(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name       string
+		provider   *mockProvider
+		workerNode *mockWorkerNode
+		peerPod    bool
+	}{
+		{
+			name:       "instance creation failure",
+			provider:   &mockProvider{createErr: errors.New("quota exceeded")},
+			workerNode: &mockWorkerNode{},
+		},
+		{
+			name:       "tunnel setup failure",
+			provider:   &mockProvider{},
+			workerNode: &mockWorkerNode{setupErr: errors.New("no route")},
+			peerPod:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewService(tc.provider, &mockProxyFactory{podsDir: dir}, tc.workerNode, dir, forwarder.DefaultListenPort, "", 0, nil, forwarder.DefaultTunableAgentSettings)
+			ppService, ppClient, recorder := newTestPeerPodService(t, "mypod", "default")
+			s.(*cloudService).ppService = ppService
+
+			req := &pb.CreateVMRequest{
+				Id: "123",
+				Annotations: map[string]string{
+					cri.SandboxNamespace: "default",
+					cri.SandboxName:      "mypod",
+				},
+			}
+			_, err := s.CreateVM(ctx, req)
+			assert.NoError(t, err)
+
+			_, err = s.StartVM(ctx, &pb.StartVMRequest{Id: "123"})
+			assert.Error(t, err)
+
+			events := receiveEvents(recorder)
+			if assert.NotEmpty(t, events) {
+				assert.Contains(t, events[len(events)-1], "Warning PodVMCreationFailed Failed to start pod VM: ")
+			}
+
+			list, err := ppClient.ConfidentialcontainersV1alpha1().PeerPods("default").List(ctx, metav1.ListOptions{})
+			assert.NoError(t, err)
+			if !tc.peerPod {
+				assert.Empty(t, list.Items)
+				return
+			}
+			pp := getTestPeerPod(t, ppClient, "default")
+			assert.Equal(t, peerPodV1alpha1.PeerPodFailed, pp.Status.Phase)
+		})
+	}
+}
+
+func TestCreateVMAgentConfig(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := NewService(&mockProvider{}, &mockProxyFactory{podsDir: dir}, &mockWorkerNode{}, dir, forwarder.DefaultListenPort, "", 0, nil, forwarder.DefaultTunableAgentSettings)
+
+	for i, tc := range []struct {
+		agentConfig string
+		valid       bool
+	}{
+		{agentConfig: `{"log_level": "debug"}`, valid: true},
+		{agentConfig: `{"guest_components_procs": "none", "image_policy_file": "kbs:///default/security-policy/test"}`, valid: true},
+		{agentConfig: `{"enable_signature_verification": false}`, valid: false},
+		{agentConfig: `{"server_addr": "vsock://-1:1024"}`, valid: false},
+		{agentConfig: `{"endpoints": {"allowed": ["ExecProcessRequest"]}}`, valid: false},
+	} {
+		req := &pb.CreateVMRequest{
+			Id: fmt.Sprintf("sandbox-%d", i),
+			Annotations: map[string]string{
+				cri.SandboxNamespace:            "default",
+				cri.SandboxName:                 "mypod",
+				forwarder.AgentConfigAnnotation: tc.agentConfig,
+			},
+		}
+
+		_, err := s.CreateVM(ctx, req)
+		if tc.valid {
+			assert.NoError(t, err, tc.agentConfig)
+		} else {
+			assert.Error(t, err, tc.agentConfig)
+		}
+	}
+}
+
+func TestDefaultTo(t *testing.T) {
+	t.Setenv("TEST_REGION", "us-east-1")
+	t.Setenv("TEST_KEY", "env-key")
+
+	lookup := ConfigLookup(map[string]string{"TEST_KEY": "config-key"})
+
+	var region, key, zone, flag string
+	flag = "from-flag"
+	DefaultTo(&region, lookup, "TEST_REGION", "")
+	DefaultTo(&key, lookup, "TEST_KEY", "")
+	DefaultTo(&zone, lookup, "TEST_ZONE", "zone-1")
+	DefaultTo(&flag, lookup, "TEST_KEY", "")
+
+	assert.Equal(t, "us-east-1", region)
+	assert.Equal(t, "config-key", key)
+	assert.Equal(t, "zone-1", zone)
+	assert.Equal(t, "from-flag", flag)
+}
+
 func TestVerifyCloudInstanceType(t *testing.T) {
 	type args struct {
 		instanceType        string
@@ -376,6 +611,38 @@ func TestSortInstanceTypesOnMemory(t *testing.T) {
 	}
 }
 
+func TestSelectInstanceTypeToUse(t *testing.T) {
+	specList := []InstanceTypeSpec{
+		{InstanceType: "t2.small", VCPUs: 1, Memory: 2048},
+		{InstanceType: "t2.medium", VCPUs: 2, Memory: 4096},
+		{InstanceType: "t2.xlarge", VCPUs: 4, Memory: 16384},
+	}
+
+	for name, tc := range map[string]struct {
+		spec    InstanceTypeSpec
+		want    string
+		wantErr bool
+	}{
+		"default":             {spec: InstanceTypeSpec{}, want: "t2.small"},
+		"instance type":       {spec: InstanceTypeSpec{InstanceType: "t2.medium"}, want: "t2.medium"},
+		"vCPUs and memory":    {spec: InstanceTypeSpec{VCPUs: 2, Memory: 8192}, want: "t2.xlarge"},
+		"vCPUs only":          {spec: InstanceTypeSpec{VCPUs: 2}, want: "t2.medium"},
+		"memory only":         {spec: InstanceTypeSpec{Memory: 3000}, want: "t2.medium"},
+		"size over instance":  {spec: InstanceTypeSpec{InstanceType: "t2.small", Memory: 3000}, want: "t2.medium"},
+		"no fitting instance": {spec: InstanceTypeSpec{VCPUs: 8}, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := SelectInstanceTypeToUse(tc.spec, specList, []string{"t2.small", "t2.medium", "t2.xlarge"}, "t2.small")
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
 func TestGetBestFitInstanceType(t *testing.T) {
 	type args struct {
 		sortedInstanceTypeSpecList []InstanceTypeSpec
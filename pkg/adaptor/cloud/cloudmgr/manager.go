@@ -13,6 +13,9 @@ type Cloud interface {
 	ParseCmd(flags *flag.FlagSet)
 	LoadEnv()
 	NewProvider() (cloud.Provider, error)
+	// NewProviderWithConfig returns a new provider that is configured with config, e.g. data of a ConfigMap and
+	// a Secret, instead of environment variables. It does not change the configuration used by NewProvider.
+	NewProviderWithConfig(config map[string]string) (cloud.Provider, error)
 }
 
 var cloudTable map[string]Cloud = make(map[string]Cloud)
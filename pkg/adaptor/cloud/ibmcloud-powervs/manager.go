@@ -5,6 +5,7 @@ package ibmcloud_powervs
 
 import (
 	"flag"
+	"os"
 	"strconv"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
@@ -31,26 +32,37 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (_ *Manager) LoadEnv() {
+	loadConfig(&ibmcloudPowerVSConfig, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (_ *Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := ibmcloudPowerVSConfig
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
+
+func loadConfig(cfg *Config, lookup func(key string) string) {
 	// overwrite config set by cmd parameters in oci image with env might come from orchastration platform
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.ApiKey, "IBMCLOUD_API_KEY", "")
+	cloud.DefaultTo(&cfg.ApiKey, lookup, "IBMCLOUD_API_KEY", "")
 
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.Zone, "POWERVS_ZONE", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.ServiceInstanceID, "POWERVS_SERVICE_INSTANCE_ID", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.NetworkID, "POWERVS_NETWORK_ID", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.ImageID, "POWERVS_IMAGE_ID", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.SSHKey, "POWERVS_SSH_KEY_NAME", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.ProcessorType, "POWERVS_PROCESSOR_TYPE", "")
-	cloud.DefaultToEnv(&ibmcloudPowerVSConfig.SystemType, "POWERVS_SYSTEM_TYPE", "")
+	cloud.DefaultTo(&cfg.Zone, lookup, "POWERVS_ZONE", "")
+	cloud.DefaultTo(&cfg.ServiceInstanceID, lookup, "POWERVS_SERVICE_INSTANCE_ID", "")
+	cloud.DefaultTo(&cfg.NetworkID, lookup, "POWERVS_NETWORK_ID", "")
+	cloud.DefaultTo(&cfg.ImageID, lookup, "POWERVS_IMAGE_ID", "")
+	cloud.DefaultTo(&cfg.SSHKey, lookup, "POWERVS_SSH_KEY_NAME", "")
+	cloud.DefaultTo(&cfg.ProcessorType, lookup, "POWERVS_PROCESSOR_TYPE", "")
+	cloud.DefaultTo(&cfg.SystemType, lookup, "POWERVS_SYSTEM_TYPE", "")
 
 	var memoryStr, processorsStr string
-	cloud.DefaultToEnv(&memoryStr, "POWERVS_MEMORY", "")
+	cloud.DefaultTo(&memoryStr, lookup, "POWERVS_MEMORY", "")
 	if memoryStr != "" {
-		ibmcloudPowerVSConfig.Memory, _ = strconv.ParseFloat(memoryStr, 64)
+		cfg.Memory, _ = strconv.ParseFloat(memoryStr, 64)
 	}
 
-	cloud.DefaultToEnv(&processorsStr, "POWERVS_MEMORY", "")
+	cloud.DefaultTo(&processorsStr, lookup, "POWERVS_MEMORY", "")
 	if processorsStr != "" {
-		ibmcloudPowerVSConfig.Processors, _ = strconv.ParseFloat(processorsStr, 64)
+		cfg.Processors, _ = strconv.ParseFloat(processorsStr, 64)
 	}
 }
 
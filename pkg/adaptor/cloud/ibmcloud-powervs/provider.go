@@ -6,11 +6,13 @@ package ibmcloud_powervs
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/netip"
 	"time"
 
+	"github.com/IBM-Cloud/power-go-client/power/client/p_cloud_p_vm_instances"
 	"github.com/IBM-Cloud/power-go-client/power/models"
 	"github.com/IBM/go-sdk-core/v5/core"
 	"github.com/avast/retry-go/v4"
@@ -120,9 +122,11 @@ func (p *ibmcloudPowerVSProvider) CreateInstance(ctx context.Context, podName, s
 	}
 
 	return &cloud.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:    instanceID,
+		Name:  instanceName,
+		IPs:   ips,
+		Zone:  p.serviceConfig.Zone,
+		Image: p.serviceConfig.ImageID,
 	}, nil
 }
 
@@ -130,6 +134,10 @@ func (p *ibmcloudPowerVSProvider) DeleteInstance(ctx context.Context, instanceID
 
 	err := p.powervsService.instanceClient(ctx).Delete(instanceID)
 	if err != nil {
+		var notFound *p_cloud_p_vm_instances.PcloudPvminstancesDeleteNotFound
+		if errors.As(err, &notFound) {
+			return fmt.Errorf("%w: %v", cloud.ErrInstanceNotFound, err)
+		}
 		logger.Printf("failed to delete an instance: %v", err)
 		return err
 	}
@@ -138,6 +146,35 @@ func (p *ibmcloudPowerVSProvider) DeleteInstance(ctx context.Context, instanceID
 	return nil
 }
 
+func (p *ibmcloudPowerVSProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+
+	instance, err := p.powervsService.instanceClient(ctx).Get(instanceID)
+	if err != nil {
+		var notFound *p_cloud_p_vm_instances.PcloudPvminstancesGetNotFound
+		if errors.As(err, &notFound) {
+			return cloud.InstanceStateUnknown, fmt.Errorf("%w: %v", cloud.ErrInstanceNotFound, err)
+		}
+		return cloud.InstanceStateUnknown, err
+	}
+
+	if instance.Status == nil {
+		return cloud.InstanceStateUnknown, nil
+	}
+
+	switch *instance.Status {
+	case "BUILD":
+		return cloud.InstanceStatePending, nil
+	case "ACTIVE":
+		return cloud.InstanceStateRunning, nil
+	case "SHUTOFF":
+		return cloud.InstanceStateStopped, nil
+	case "ERROR":
+		return cloud.InstanceStateFailed, nil
+	}
+
+	return cloud.InstanceStateUnknown, nil
+}
+
 func (p *ibmcloudPowerVSProvider) Teardown() error {
 	return nil
 }
@@ -5,6 +5,7 @@ package ibmcloud
 
 import (
 	"flag"
+	"os"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 )
@@ -35,30 +36,43 @@ func (*Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (*Manager) LoadEnv() {
+	loadConfig(&ibmcloudVPCConfig, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (*Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := ibmcloudVPCConfig
+	cfg.InstanceProfiles = append(instanceProfiles(nil), ibmcloudVPCConfig.InstanceProfiles...)
+	cfg.Images = append(Images(nil), ibmcloudVPCConfig.Images...)
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
+
+func loadConfig(cfg *Config, lookup func(key string) string) {
 	// overwrite config set by cmd parameters in oci image with env might come from orchastration platform
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.ApiKey, "IBMCLOUD_API_KEY", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.IAMProfileID, "IBMCLOUD_IAM_PROFILE_ID", "")
+	cloud.DefaultTo(&cfg.ApiKey, lookup, "IBMCLOUD_API_KEY", "")
+	cloud.DefaultTo(&cfg.IAMProfileID, lookup, "IBMCLOUD_IAM_PROFILE_ID", "")
 
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.IamServiceURL, "IBMCLOUD_IAM_ENDPOINT", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.VpcServiceURL, "IBMCLOUD_VPC_ENDPOINT", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.ResourceGroupID, "IBMCLOUD_RESOURCE_GROUP_ID", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.ProfileName, "IBMCLOUD_PODVM_INSTANCE_PROFILE_NAME", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.ZoneName, "IBMCLOUD_ZONE", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.PrimarySubnetID, "IBMCLOUD_VPC_SUBNET_ID", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.PrimarySecurityGroupID, "IBMCLOUD_VPC_SG_ID", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.KeyID, "IBMCLOUD_SSH_KEY_ID", "")
-	cloud.DefaultToEnv(&ibmcloudVPCConfig.VpcID, "IBMCLOUD_VPC_ID", "")
+	cloud.DefaultTo(&cfg.IamServiceURL, lookup, "IBMCLOUD_IAM_ENDPOINT", "")
+	cloud.DefaultTo(&cfg.VpcServiceURL, lookup, "IBMCLOUD_VPC_ENDPOINT", "")
+	cloud.DefaultTo(&cfg.ResourceGroupID, lookup, "IBMCLOUD_RESOURCE_GROUP_ID", "")
+	cloud.DefaultTo(&cfg.ProfileName, lookup, "IBMCLOUD_PODVM_INSTANCE_PROFILE_NAME", "")
+	cloud.DefaultTo(&cfg.ZoneName, lookup, "IBMCLOUD_ZONE", "")
+	cloud.DefaultTo(&cfg.PrimarySubnetID, lookup, "IBMCLOUD_VPC_SUBNET_ID", "")
+	cloud.DefaultTo(&cfg.PrimarySecurityGroupID, lookup, "IBMCLOUD_VPC_SG_ID", "")
+	cloud.DefaultTo(&cfg.KeyID, lookup, "IBMCLOUD_SSH_KEY_ID", "")
+	cloud.DefaultTo(&cfg.VpcID, lookup, "IBMCLOUD_VPC_ID", "")
 
 	var instanceProfilesStr string
-	cloud.DefaultToEnv(&instanceProfilesStr, "IBMCLOUD_PODVM_INSTANCE_PROFILE_LIST", "")
+	cloud.DefaultTo(&instanceProfilesStr, lookup, "IBMCLOUD_PODVM_INSTANCE_PROFILE_LIST", "")
 	if instanceProfilesStr != "" {
-		_ = ibmcloudVPCConfig.InstanceProfiles.Set(instanceProfilesStr)
+		_ = cfg.InstanceProfiles.Set(instanceProfilesStr)
 	}
 
 	var imageIDsStr string
-	cloud.DefaultToEnv(&imageIDsStr, "IBMCLOUD_PODVM_IMAGE_ID", "")
+	cloud.DefaultTo(&imageIDsStr, lookup, "IBMCLOUD_PODVM_IMAGE_ID", "")
 	if imageIDsStr != "" {
-		_ = ibmcloudVPCConfig.Images.Set(imageIDsStr)
+		_ = cfg.Images.Set(imageIDsStr)
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/netip"
 	"os"
 	"time"
@@ -291,9 +292,15 @@ func (p *ibmcloudVPCProvider) CreateInstance(ctx context.Context, podName, sandb
 	}
 
 	instance := &cloud.Instance{
-		ID:   instanceID,
-		Name: instanceName,
-		IPs:  ips,
+		ID:    instanceID,
+		Name:  instanceName,
+		IPs:   ips,
+		Type:  instanceProfile,
+		Zone:  p.serviceConfig.ZoneName,
+		Image: imageID,
+	}
+	if vpcInstance.CreatedAt != nil {
+		instance.LaunchTime = time.Time(*vpcInstance.CreatedAt)
 	}
 
 	return instance, nil
@@ -403,6 +410,9 @@ func (p *ibmcloudVPCProvider) DeleteInstance(ctx context.Context, instanceID str
 	options.SetID(instanceID)
 	resp, err := p.vpc.DeleteInstanceWithContext(ctx, options)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("failed to delete an instance %s: %w: %v", instanceID, cloud.ErrInstanceNotFound, err)
+		}
 		logger.Printf("failed to delete an instance: %v and the response is %v", err, resp)
 		return err
 	}
@@ -411,6 +421,34 @@ func (p *ibmcloudVPCProvider) DeleteInstance(ctx context.Context, instanceID str
 	return nil
 }
 
+func (p *ibmcloudVPCProvider) GetInstanceState(ctx context.Context, instanceID string) (cloud.InstanceState, error) {
+
+	instance, resp, err := p.vpc.GetInstanceWithContext(ctx, &vpcv1.GetInstanceOptions{ID: &instanceID})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return cloud.InstanceStateUnknown, fmt.Errorf("failed to get an instance %s: %w: %v", instanceID, cloud.ErrInstanceNotFound, err)
+		}
+		return cloud.InstanceStateUnknown, fmt.Errorf("failed to get an instance %s: %w", instanceID, err)
+	}
+
+	if instance.Status == nil {
+		return cloud.InstanceStateUnknown, nil
+	}
+
+	switch *instance.Status {
+	case vpcv1.InstanceStatusPendingConst, vpcv1.InstanceStatusStartingConst, vpcv1.InstanceStatusRestartingConst:
+		return cloud.InstanceStatePending, nil
+	case vpcv1.InstanceStatusRunningConst:
+		return cloud.InstanceStateRunning, nil
+	case vpcv1.InstanceStatusStoppingConst, vpcv1.InstanceStatusStoppedConst, vpcv1.InstanceStatusDeletingConst:
+		return cloud.InstanceStateStopped, nil
+	case vpcv1.InstanceStatusFailedConst:
+		return cloud.InstanceStateFailed, nil
+	}
+
+	return cloud.InstanceStateUnknown, nil
+}
+
 func (p *ibmcloudVPCProvider) Teardown() error {
 	return nil
 }
@@ -47,7 +47,8 @@ func (v *mockVPC) CreateInstanceWithContext(ctx context.Context, opt *vpcv1.Crea
 func (v *mockVPC) GetInstanceWithContext(ctx context.Context, opt *vpcv1.GetInstanceOptions) (*vpcv1.Instance, *core.DetailedResponse, error) {
 
 	instance := &vpcv1.Instance{
-		ID: ptr("123"),
+		ID:     ptr("123"),
+		Status: ptr(vpcv1.InstanceStatusRunningConst),
 		PrimaryNetworkInterface: &vpcv1.NetworkInterfaceInstanceContextReference{
 			ID: ptr("111"),
 			PrimaryIP: &vpcv1.ReservedIPReference{
@@ -173,6 +174,18 @@ func TestDeleteInstance(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGetInstanceState(t *testing.T) {
+
+	provider := &ibmcloudVPCProvider{
+		vpc:           &mockVPC{},
+		serviceConfig: &Config{},
+	}
+
+	state, err := provider.GetInstanceState(context.Background(), "123")
+	assert.NoError(t, err)
+	assert.Equal(t, cloud.InstanceStateRunning, state)
+}
+
 func TestGetInstanceTypeInformation(t *testing.T) {
 	type args struct {
 		instanceType string
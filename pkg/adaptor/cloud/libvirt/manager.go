@@ -7,6 +7,7 @@ package libvirt
 
 import (
 	"flag"
+	"os"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 )
@@ -38,12 +39,23 @@ func (*Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (*Manager) LoadEnv() {
-	cloud.DefaultToEnv(&libvirtcfg.URI, "LIBVIRT_URI", defaultURI)
-	cloud.DefaultToEnv(&libvirtcfg.PoolName, "LIBVIRT_POOL", defaultPoolName)
-	cloud.DefaultToEnv(&libvirtcfg.NetworkName, "LIBVIRT_NET", defaultNetworkName)
-	cloud.DefaultToEnv(&libvirtcfg.VolName, "LIBVIRT_VOL_NAME", defaultVolName)
-	cloud.DefaultToEnv(&libvirtcfg.LaunchSecurity, "LIBVIRT_LAUNCH_SECURITY", defaultLaunchSecurity)
-	cloud.DefaultToEnv(&libvirtcfg.Firmware, "LIBVIRT_FIRMWARE", defaultFirmware)
+	loadConfig(&libvirtcfg, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (*Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := libvirtcfg
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
+
+func loadConfig(cfg *Config, lookup func(key string) string) {
+	cloud.DefaultTo(&cfg.URI, lookup, "LIBVIRT_URI", defaultURI)
+	cloud.DefaultTo(&cfg.PoolName, lookup, "LIBVIRT_POOL", defaultPoolName)
+	cloud.DefaultTo(&cfg.NetworkName, lookup, "LIBVIRT_NET", defaultNetworkName)
+	cloud.DefaultTo(&cfg.VolName, lookup, "LIBVIRT_VOL_NAME", defaultVolName)
+	cloud.DefaultTo(&cfg.LaunchSecurity, lookup, "LIBVIRT_LAUNCH_SECURITY", defaultLaunchSecurity)
+	cloud.DefaultTo(&cfg.Firmware, lookup, "LIBVIRT_FIRMWARE", defaultFirmware)
 }
 
 func (*Manager) NewProvider() (cloud.Provider, error) {
@@ -0,0 +1,43 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "cloud_api_adaptor"
+
+var (
+	tunnelChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tunnel_checks_total",
+			Help:      "Number of pod network tunnel health checks, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	tunnelRepairsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tunnel_repairs_total",
+			Help:      "Number of attempts to repair a pod network tunnel, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	tunnelHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tunnel_healthy",
+			Help:      "Whether the pod network tunnel of a peer pod is healthy (1) or not (0).",
+		},
+		[]string{"namespace", "pod"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tunnelChecksTotal, tunnelRepairsTotal, tunnelHealthy)
+}
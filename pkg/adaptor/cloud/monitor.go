@@ -0,0 +1,269 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const forwarderDialTimeout = 5 * time.Second
+
+// tunnelMonitor periodically validates the pod network tunnel of a sandbox, and sets it up again when drift is detected.
+// It also reports changes of the agent connection, since the connection is lost when the tunnel or the pod VM fails.
+type tunnelMonitor struct {
+	service        *cloudService
+	sandbox        *sandbox
+	podNodeIPs     []netip.Addr
+	forwarderAddr  string
+	interval       time.Duration
+	healthy        *bool
+	agentConnected bool
+}
+
+func (s *cloudService) startTunnelMonitor(sandbox *sandbox, podNodeIPs []netip.Addr, forwarderAddr string) {
+
+	if s.tunnelCheckInterval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	s.mutex.Lock()
+	sandbox.stopMonitor = cancel
+	sandbox.monitorDone = done
+	s.mutex.Unlock()
+
+	m := &tunnelMonitor{
+		service:       s,
+		sandbox:       sandbox,
+		podNodeIPs:    podNodeIPs,
+		forwarderAddr: forwarderAddr,
+		interval:      s.tunnelCheckInterval,
+
+		// The monitor starts after the agent proxy is connected
+		agentConnected: true,
+	}
+
+	go func() {
+		defer close(done)
+		m.run(ctx)
+	}()
+}
+
+// stopTunnelMonitor stops the tunnel monitor of a sandbox, and waits until an ongoing check or repair finishes,
+// so that the monitor does not set up the tunnel again while it is being torn down
+func (s *cloudService) stopTunnelMonitor(sandbox *sandbox) {
+
+	s.mutex.Lock()
+	stop, done := sandbox.stopMonitor, sandbox.monitorDone
+	sandbox.stopMonitor, sandbox.monitorDone = nil, nil
+	s.mutex.Unlock()
+
+	if stop != nil {
+		stop()
+		<-done
+	}
+	tunnelHealthy.DeleteLabelValues(sandbox.podNamespace, sandbox.podName)
+}
+
+func (m *tunnelMonitor) run(ctx context.Context) {
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := m.check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		m.report(err)
+		m.reportAgentConnection()
+	}
+}
+
+// check validates the tunnel, and repairs it when devices or tc filters are missing
+func (m *tunnelMonitor) check(ctx context.Context) error {
+
+	workerNode := m.service.workerNode
+	sandbox := m.sandbox
+
+	m.updatePodNodeIPs(ctx)
+
+	if err := workerNode.Check(sandbox.netNSPath, m.podNodeIPs, sandbox.podNetwork); err != nil {
+		tunnelChecksTotal.WithLabelValues("unhealthy").Inc()
+		logger.Printf("tunnel of sandbox %s is unhealthy: %v", sandbox.id, err)
+
+		if err := m.repair(); err != nil {
+			tunnelRepairsTotal.WithLabelValues("failure").Inc()
+			return fmt.Errorf("repairing tunnel: %w", err)
+		}
+		tunnelRepairsTotal.WithLabelValues("success").Inc()
+		logger.Printf("tunnel of sandbox %s is repaired", sandbox.id)
+	}
+
+	dialer := &net.Dialer{Timeout: forwarderDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", m.forwarderAddr)
+	if err != nil {
+		tunnelChecksTotal.WithLabelValues("unreachable").Inc()
+		return fmt.Errorf("connecting to agent protocol forwarder at %s: %w", m.forwarderAddr, err)
+	}
+	conn.Close()
+
+	tunnelChecksTotal.WithLabelValues("healthy").Inc()
+	return nil
+}
+
+// updatePodNodeIPs looks up the current IP addresses of the pod VM, when the provider supports it. A new address,
+// for example after the pod VM is restarted, is used for the tunnel, the reachability check, and the next dial of
+// the agent proxy. Check then detects that the tunnel still points to the previous address.
+func (m *tunnelMonitor) updatePodNodeIPs(ctx context.Context) {
+
+	getter, ok := m.service.provider.(InstanceIPsGetter)
+	if !ok || m.sandbox.instanceID == "" {
+		return
+	}
+
+	ips, err := getter.GetInstanceIPs(ctx, m.sandbox.instanceID)
+	if err != nil {
+		logger.Printf("failed to get IP addresses of instance %s of sandbox %s: %v", m.sandbox.instanceID, m.sandbox.id, err)
+		return
+	}
+	if len(ips) == 0 || equalAddrs(ips, m.podNodeIPs) {
+		return
+	}
+
+	logger.Printf("IP addresses of instance %s of sandbox %s changed from %v to %v", m.sandbox.instanceID, m.sandbox.id, m.podNodeIPs, ips)
+
+	if _, port, err := net.SplitHostPort(m.forwarderAddr); err == nil {
+		m.forwarderAddr = net.JoinHostPort(ips[0].String(), port)
+		m.sandbox.agentProxy.SetServerAddress(m.forwarderAddr)
+	}
+	m.podNodeIPs = ips
+}
+
+func equalAddrs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *tunnelMonitor) repair() error {
+
+	workerNode := m.service.workerNode
+	sandbox := m.sandbox
+
+	// Teardown fails if a part of the tunnel is already missing, so its error is ignored
+	if err := workerNode.Teardown(sandbox.netNSPath, sandbox.podNetwork); err != nil {
+		logger.Printf("tearing down tunnel of sandbox %s before repair: %v", sandbox.id, err)
+	}
+
+	if err := workerNode.Setup(sandbox.netNSPath, m.podNodeIPs, sandbox.podNetwork); err != nil {
+		return fmt.Errorf("setting up pod network tunnel on netns %s: %w", sandbox.netNSPath, err)
+	}
+
+	return workerNode.Check(sandbox.netNSPath, m.podNodeIPs, sandbox.podNetwork)
+}
+
+// report updates metrics and the PeerPod status condition when the tunnel health changes
+func (m *tunnelMonitor) report(err error) {
+
+	sandbox := m.sandbox
+	healthy := err == nil
+
+	if healthy {
+		tunnelHealthy.WithLabelValues(sandbox.podNamespace, sandbox.podName).Set(1)
+	} else {
+		tunnelHealthy.WithLabelValues(sandbox.podNamespace, sandbox.podName).Set(0)
+		logger.Printf("tunnel health check of sandbox %s failed: %v", sandbox.id, err)
+	}
+
+	if m.healthy != nil && *m.healthy == healthy {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:    peerPodV1alpha1.TunnelReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "HealthCheckSucceeded",
+		Message: "pod network tunnel is healthy",
+	}
+	if !healthy {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HealthCheckFailed"
+		condition.Message = err.Error()
+	}
+
+	if ppService := m.service.ppService; ppService != nil {
+		if err := ppService.SetPeerPodCondition(context.Background(), sandbox.podName, sandbox.podNamespace, condition); err != nil {
+			logger.Printf("failed to set PeerPod condition %s: %v", condition.Type, err)
+			return
+		}
+	}
+
+	m.healthy = &healthy
+}
+
+// reportAgentConnection updates the AgentConnected condition of the PeerPod when the agent connection is lost or
+// re-established. The agent proxy redials on the next call from the kata shim after the connection is lost.
+func (m *tunnelMonitor) reportAgentConnection() {
+
+	sandbox := m.sandbox
+
+	var connected bool
+	switch sandbox.agentProxy.ConnectionState() {
+	case agentproto.Ready:
+		connected = true
+	case agentproto.TransientFailure:
+		connected = false
+	default:
+		return
+	}
+
+	if connected == m.agentConnected {
+		return
+	}
+
+	condition := metav1.Condition{
+		Type:    peerPodV1alpha1.AgentConnected,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AgentConnectionReestablished",
+		Message: "connection to kata agent is re-established",
+	}
+	if !connected {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "AgentConnectionLost"
+		condition.Message = "connection to kata agent is lost"
+		logger.Printf("agent connection of sandbox %s is lost", sandbox.id)
+	}
+
+	if ppService := m.service.ppService; ppService != nil {
+		if err := ppService.SetPeerPodCondition(context.Background(), sandbox.podName, sandbox.podNamespace, condition); err != nil {
+			logger.Printf("failed to set PeerPod condition %s: %v", condition.Type, err)
+			return
+		}
+	}
+
+	m.agentConnected = connected
+}
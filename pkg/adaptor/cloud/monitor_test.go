@@ -0,0 +1,269 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+)
+
+type driftingWorkerNode struct {
+	mockWorkerNode
+	broken     bool
+	setups     int
+	podNodeIPs []netip.Addr
+}
+
+func (n *driftingWorkerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	n.setups++
+	n.broken = false
+	n.podNodeIPs = podNodeIPs
+	return nil
+}
+
+func (n *driftingWorkerNode) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	if n.broken {
+		return errors.New("tc redirect filter is missing")
+	}
+	if n.podNodeIPs != nil && !equalAddrs(n.podNodeIPs, podNodeIPs) {
+		return errors.New("vxlan remote address is changed")
+	}
+	return nil
+}
+
+type movingProvider struct {
+	mockProvider
+	ips []netip.Addr
+}
+
+func (p *movingProvider) GetInstanceIPs(ctx context.Context, instanceID string) ([]netip.Addr, error) {
+	return p.ips, nil
+}
+
+type blockingWorkerNode struct {
+	mockWorkerNode
+	setupStarted chan struct{}
+	setupDone    chan struct{}
+}
+
+func (n *blockingWorkerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	close(n.setupStarted)
+	<-n.setupDone
+	return nil
+}
+
+func (n *blockingWorkerNode) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	select {
+	case <-n.setupStarted:
+		return nil
+	default:
+		return errors.New("tc redirect filter is missing")
+	}
+}
+
+func TestTunnelMonitor(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	defer listener.Close()
+
+	workerNode := &driftingWorkerNode{broken: true}
+
+	s := &cloudService{
+		workerNode:          workerNode,
+		tunnelCheckInterval: time.Minute,
+	}
+
+	sandbox := &sandbox{
+		id:           "123",
+		podName:      "mypod",
+		podNamespace: "default",
+		podNetwork:   &tunneler.Config{},
+	}
+
+	m := &tunnelMonitor{
+		service:       s,
+		sandbox:       sandbox,
+		podNodeIPs:    []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+		forwarderAddr: listener.Addr().String(),
+		interval:      s.tunnelCheckInterval,
+	}
+
+	ctx := context.Background()
+
+	// A broken tunnel is set up again
+	assert.NoError(t, m.check(ctx))
+	assert.Equal(t, 1, workerNode.setups)
+
+	m.report(nil)
+	assert.Equal(t, 1.0, testutil.ToFloat64(tunnelHealthy.WithLabelValues("default", "mypod")))
+
+	// A healthy tunnel is left as it is
+	assert.NoError(t, m.check(ctx))
+	assert.Equal(t, 1, workerNode.setups)
+
+	// An unreachable forwarder is reported
+	listener.Close()
+	err = m.check(ctx)
+	assert.Error(t, err)
+
+	m.report(err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(tunnelHealthy.WithLabelValues("default", "mypod")))
+
+	s.stopTunnelMonitor(sandbox)
+	assert.Equal(t, 0, testutil.CollectAndCount(tunnelHealthy))
+}
+
+type stateProxy struct {
+	mockProxy
+	state agentproto.ConnectionState
+}
+
+func (p *stateProxy) ConnectionState() agentproto.ConnectionState {
+	return p.state
+}
+
+func TestTunnelMonitorAgentConnection(t *testing.T) {
+
+	ppService, ppClient, _ := newTestPeerPodService(t, "mypod", "default")
+	err := ppService.OwnPeerPod(context.Background(), "mypod", "default", "i-1234", &peerPodV1alpha1.PeerPodStatus{})
+	assert.NoError(t, err)
+
+	agentProxy := &stateProxy{state: agentproto.Ready}
+	sandbox := &sandbox{
+		id:           "123",
+		podName:      "mypod",
+		podNamespace: "default",
+		agentProxy:   agentProxy,
+	}
+	m := &tunnelMonitor{
+		service:        &cloudService{ppService: ppService},
+		sandbox:        sandbox,
+		agentConnected: true,
+	}
+
+	for _, tc := range []struct {
+		state     agentproto.ConnectionState
+		connected bool
+	}{
+		{state: agentproto.TransientFailure, connected: false},
+		// A connection being redialed does not change the condition
+		{state: agentproto.Connecting, connected: false},
+		{state: agentproto.Ready, connected: true},
+	} {
+		agentProxy.state = tc.state
+		m.reportAgentConnection()
+
+		pp := getTestPeerPod(t, ppClient, "default")
+		assert.Equal(t, tc.connected, meta.IsStatusConditionTrue(pp.Status.Conditions, peerPodV1alpha1.AgentConnected), tc.state.String())
+		assert.Equal(t, tc.connected, m.agentConnected, tc.state.String())
+	}
+}
+
+func TestTunnelMonitorIPChange(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	oldIPs := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	newIPs := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+
+	workerNode := &driftingWorkerNode{podNodeIPs: oldIPs}
+	provider := &movingProvider{ips: oldIPs}
+
+	s := &cloudService{
+		provider:            provider,
+		workerNode:          workerNode,
+		tunnelCheckInterval: time.Minute,
+	}
+
+	agentProxy := &mockProxy{}
+	sandbox := &sandbox{
+		id:           "123",
+		podName:      "mypod",
+		podNamespace: "default",
+		instanceID:   "i-123",
+		podNetwork:   &tunneler.Config{},
+		agentProxy:   agentProxy,
+	}
+
+	m := &tunnelMonitor{
+		service:       s,
+		sandbox:       sandbox,
+		podNodeIPs:    oldIPs,
+		forwarderAddr: net.JoinHostPort("192.0.2.1", port),
+		interval:      s.tunnelCheckInterval,
+	}
+
+	// A new IP address of the pod VM is used for the tunnel, the forwarder and the agent proxy
+	provider.ips = newIPs
+	assert.NoError(t, m.check(context.Background()))
+	assert.Equal(t, 1, workerNode.setups)
+	assert.Equal(t, newIPs, workerNode.podNodeIPs)
+	assert.Equal(t, listener.Addr().String(), m.forwarderAddr)
+	assert.Equal(t, listener.Addr().String(), agentProxy.serverAddr)
+
+	s.stopTunnelMonitor(sandbox)
+}
+
+func TestStopTunnelMonitor(t *testing.T) {
+
+	workerNode := &blockingWorkerNode{
+		setupStarted: make(chan struct{}),
+		setupDone:    make(chan struct{}),
+	}
+
+	s := &cloudService{
+		provider:            &mockProvider{},
+		workerNode:          workerNode,
+		tunnelCheckInterval: time.Millisecond,
+	}
+
+	sandbox := &sandbox{
+		id:           "123",
+		podName:      "mypod",
+		podNamespace: "default",
+		podNetwork:   &tunneler.Config{},
+	}
+
+	s.startTunnelMonitor(sandbox, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, "192.0.2.1:15150")
+	<-workerNode.setupStarted
+
+	stopped := make(chan struct{})
+	go func() {
+		s.stopTunnelMonitor(sandbox)
+		close(stopped)
+	}()
+
+	// A repair in progress finishes before stopTunnelMonitor returns
+	select {
+	case <-stopped:
+		t.Fatal("Expect stopTunnelMonitor to wait for the repair, got returned")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(workerNode.setupDone)
+	<-stopped
+
+	assert.Nil(t, sandbox.stopMonitor)
+}
@@ -10,12 +10,14 @@ import (
 	"net/netip"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/k8sops"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/cloudinit"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
 	pb "github.com/kata-containers/kata-containers/src/runtime/protocols/hypervisor"
 )
 
@@ -26,10 +28,61 @@ type Provider interface {
 	ConfigVerifier() error
 }
 
+// ErrInstanceNotFound is returned, possibly wrapped, by DeleteInstance when the instance does not exist
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// InstanceState is the state of an instance reported by a cloud provider
+type InstanceState string
+
+const (
+	// InstanceStatePending means that the instance is being created or started
+	InstanceStatePending InstanceState = "Pending"
+	// InstanceStateRunning means that the instance is running
+	InstanceStateRunning InstanceState = "Running"
+	// InstanceStateStopped means that the instance is stopped, or is being stopped or terminated
+	InstanceStateStopped InstanceState = "Stopped"
+	// InstanceStateFailed means that the instance is in an error state
+	InstanceStateFailed InstanceState = "Failed"
+	// InstanceStateUnknown means that the state of the instance is not known
+	InstanceStateUnknown InstanceState = "Unknown"
+)
+
+// InstanceChecker is optionally implemented by a Provider that can check the state of an instance
+type InstanceChecker interface {
+	// GetInstanceState returns the state of an instance. It returns ErrInstanceNotFound, possibly wrapped,
+	// when the instance does not exist.
+	GetInstanceState(ctx context.Context, instanceID string) (InstanceState, error)
+}
+
+// InstanceIPsGetter is optionally implemented by a Provider that can look up the current IP addresses of an instance
+type InstanceIPsGetter interface {
+	// GetInstanceIPs returns the IP addresses of an instance in the same order as Instance.IPs of CreateInstance
+	GetInstanceIPs(ctx context.Context, instanceID string) ([]netip.Addr, error)
+}
+
+// Quota is the remaining capacity of a cloud account for new instances. A negative value means that it is not known.
+type Quota struct {
+	// Instances is the number of instances that can still be created
+	Instances int64
+	// VCPUs is the number of vCPUs that can still be allocated
+	VCPUs int64
+}
+
+// QuotaChecker is optionally implemented by a Provider that can check the remaining quota of the cloud account
+type QuotaChecker interface {
+	GetQuota(ctx context.Context) (*Quota, error)
+}
+
 type Instance struct {
 	ID   string
 	Name string
 	IPs  []netip.Addr
+
+	// Optional details of the instance, which are shown in the PeerPod status
+	Type       string
+	Zone       string
+	Image      string
+	LaunchTime time.Time
 }
 
 type Service interface {
@@ -50,6 +103,12 @@ type cloudService struct {
 	mutex        sync.Mutex
 	ppService    *k8sops.PeerPodService
 	aaKBCParams  string
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+
+	tunnelCheckInterval  time.Duration
+	userDataSealer       *userdata.Sealer
+	tunableAgentSettings []string
 }
 
 type InstanceTypeSpec struct {
@@ -73,6 +132,13 @@ type sandbox struct {
 	instanceID   string
 	netNSPath    string
 	spec         InstanceTypeSpec
+	stopMonitor  context.CancelFunc
+	monitorDone  chan struct{}
+	portForward  podnetwork.PortForwarder
+
+	serverCertPEM   []byte
+	stopCertRenewal context.CancelFunc
+	certRenewalDone chan struct{}
 }
 
 // keyValueFlag represents a flag of key-value pairs
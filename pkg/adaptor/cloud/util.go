@@ -12,12 +12,17 @@ import (
 )
 
 func DefaultToEnv(field *string, env, fallback string) {
+	DefaultTo(field, os.Getenv, env, fallback)
+}
+
+// DefaultTo sets field to the value of key returned by lookup, or fallback, if field is empty
+func DefaultTo(field *string, lookup func(key string) string, key, fallback string) {
 
 	if *field != "" {
 		return
 	}
 
-	val := os.Getenv(env)
+	val := lookup(key)
 	if val == "" {
 		val = fallback
 	}
@@ -25,6 +30,17 @@ func DefaultToEnv(field *string, env, fallback string) {
 	*field = val
 }
 
+// ConfigLookup returns a lookup function of configuration values in config, e.g. data of a ConfigMap and a Secret.
+// A value that is not in config defaults to the environment variable of the same name.
+func ConfigLookup(config map[string]string) func(key string) string {
+	return func(key string) string {
+		if val, ok := config[key]; ok {
+			return val
+		}
+		return os.Getenv(key)
+	}
+}
+
 // Method to verify the correct instanceType to be used for Pod VM
 func VerifyCloudInstanceType(instanceType string, validInstanceTypes []string, defaultInstanceType string) (string, error) {
 	// If instanceType is empty, set instanceType to default.
@@ -66,10 +82,10 @@ func SelectInstanceTypeToUse(spec InstanceTypeSpec, specList []InstanceTypeSpec,
 	var instanceType string
 	var err error
 
-	// If vCPU and memory are set in annotations then find the best fit instance type
-	// from the cloud provider
+	// If vCPU or memory are set in annotations then find the best fit instance type
+	// from the cloud provider. A pod may request only one of them, and then any value of the other one fits.
 	// vCPU and Memory gets higher priority than instance type from annotation
-	if spec.VCPUs != 0 && spec.Memory != 0 {
+	if spec.VCPUs != 0 || spec.Memory != 0 {
 		instanceType, err = GetBestFitInstanceType(specList, spec.VCPUs, spec.Memory)
 		if err != nil {
 			return "", fmt.Errorf("failed to get instance type based on vCPU and memory annotations: %w", err)
@@ -5,6 +5,7 @@ package vsphere
 
 import (
 	"flag"
+	"os"
 
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/cloud"
 )
@@ -29,9 +30,22 @@ func (_ *Manager) ParseCmd(flags *flag.FlagSet) {
 }
 
 func (_ *Manager) LoadEnv() {
-	cloud.DefaultToEnv(&vspherecfg.UserName, "GOVC_USERNAME", "")
-	cloud.DefaultToEnv(&vspherecfg.Password, "GOVC_PASSWORD", "")
-	cloud.DefaultToEnv(&vspherecfg.Thumbprint, "GOVC_THUMBPRINT", "")
+	loadConfig(&vspherecfg, os.Getenv)
+}
+
+// NewProviderWithConfig returns a provider that is configured with config instead of environment variables
+func (_ *Manager) NewProviderWithConfig(config map[string]string) (cloud.Provider, error) {
+	cfg := vspherecfg
+	loadConfig(&cfg, cloud.ConfigLookup(config))
+	return NewProvider(&cfg)
+}
+
+func loadConfig(cfg *Config, lookup func(key string) string) {
+	cloud.DefaultTo(&cfg.UserName, lookup, "GOVC_USERNAME", "")
+	cloud.DefaultTo(&cfg.Password, lookup, "GOVC_PASSWORD", "")
+	cloud.DefaultTo(&cfg.Thumbprint, lookup, "GOVC_THUMBPRINT", "")
+	cloud.DefaultTo(&cfg.VcenterURL, lookup, "GOVC_URL", "")
+	cloud.DefaultTo(&cfg.Datacenter, lookup, "GOVC_DATACENTER", "")
 }
 
 func (_ *Manager) NewProvider() (cloud.Provider, error) {
@@ -303,9 +303,10 @@ func (p *vsphereProvider) CreateInstance(ctx context.Context, podName, sandboxID
 	}
 
 	instance := &cloud.Instance{
-		ID:   clone.UUID(ctx),
-		Name: vmname,
-		IPs:  ips,
+		ID:    clone.UUID(ctx),
+		Name:  vmname,
+		IPs:   ips,
+		Image: p.serviceConfig.Template,
 	}
 
 	logger.Printf("CreateInstance VM name %s UUID %s done", vmname, clone.UUID(ctx))
@@ -371,6 +372,9 @@ func (p *vsphereProvider) DeleteInstance(ctx context.Context, instanceID string)
 		logger.Printf("Delete VM can't find VM UUID %s to delete it", instanceID)
 		return err
 	}
+	if vmref == nil {
+		return fmt.Errorf("VM UUID %s: %w", instanceID, cloud.ErrInstanceNotFound)
+	}
 
 	vm := object.NewVirtualMachine(dc.Client(), vmref.Reference())
 
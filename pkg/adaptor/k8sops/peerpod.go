@@ -9,30 +9,54 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	"github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/pkg/generated/peerpod/clientset/versioned"
+	"github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/pkg/generated/peerpod/informers/externalversions"
+	peerPodListers "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/pkg/generated/peerpod/listers/peerpod/v1alpha1"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 )
 
 var logger = log.New(log.Writer(), "[util/k8sops] ", log.LstdFlags|log.Lmsgprefix)
 var ppFinalizer string = "peer.pod/finalizer"
 
+// Timeout of each operation of PeerPodService, which may involve multiple API calls
+const apiTimeout = 30 * time.Second
+
 type PeerPodService struct {
-	client        *kubernetes.Clientset
-	uclient       *rest.RESTClient // use generated client instaed
+	client        kubernetes.Interface
+	ppClient      versioned.Interface
+	lister        peerPodListers.PeerPodLister
+	recorder      record.EventRecorder
 	cloudProvider string
-	podToPP       map[string]string // map Pod UID to owned PeerPod Name
+
+	mutex   sync.Mutex
+	podToPP map[types.UID]peerPodRef // cache of Pod UID to owned PeerPod
 }
 
-func NewPeerPodService() (*PeerPodService, error) {
+type peerPodRef struct {
+	name       string
+	instanceID string
+}
+
+// NewPeerPodService returns a PeerPodService for the cluster where cloud-api-adaptor runs.
+// The informer of PeerPods and the event broadcaster run until stopCh is closed.
+func NewPeerPodService(stopCh <-chan struct{}) (*PeerPodService, error) {
 	cloudProvider := os.Getenv("CLOUD_PROVIDER") // TODO: don't get from env var directly
 	if cloudProvider == "" {
 		return nil, errors.New("NewPeerPodService: failed to get cloudProvider")
@@ -47,23 +71,49 @@ func NewPeerPodService() (*PeerPodService, error) {
 	if err != nil {
 		return nil, fmt.Errorf("NewPeerPodService: failed to create clientset: %w", err)
 	}
-	config.ContentConfig.GroupVersion = &schema.GroupVersion{Group: peerPodV1alpha1.GroupVersion.Group, Version: peerPodV1alpha1.GroupVersion.Version}
-	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
-	config.APIPath = "/apis"
-	restClient, err := rest.UnversionedRESTClientFor(config)
+
+	ppClientset, err := versioned.NewForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("NewPeerPodService: failed to create UnversionedRESTClient: %s", err)
+		return nil, fmt.Errorf("NewPeerPodService: failed to create PeerPod clientset: %w", err)
 	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "cloud-api-adaptor"})
+
+	s := NewPeerPodServiceWithClients(clientset, ppClientset, recorder, cloudProvider, stopCh)
+
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+
 	logger.Printf("initialized PeerPodService")
-	return &PeerPodService{client: clientset, uclient: restClient, cloudProvider: cloudProvider, podToPP: make(map[string]string)}, nil
+	return s, nil
+}
+
+// NewPeerPodServiceWithClients returns a PeerPodService that uses the given clients and event recorder.
+// The informer of PeerPods runs until stopCh is closed.
+func NewPeerPodServiceWithClients(client kubernetes.Interface, ppClient versioned.Interface, recorder record.EventRecorder, cloudProvider string, stopCh <-chan struct{}) *PeerPodService {
+
+	// The lister serves lookups of PeerPods owned by pods, which are needed after a restart of cloud-api-adaptor.
+	// The informer cache is not waited for, since a cache miss falls back to the API server.
+	factory := externalversions.NewSharedInformerFactory(ppClient, 0)
+	lister := factory.Confidentialcontainers().V1alpha1().PeerPods().Lister()
+	factory.Start(stopCh)
+
+	return &PeerPodService{
+		client:        client,
+		ppClient:      ppClient,
+		lister:        lister,
+		recorder:      recorder,
+		cloudProvider: cloudProvider,
+		podToPP:       make(map[types.UID]peerPodRef),
+	}
 }
 
 func (s *PeerPodService) newPeerPod(pod *v1.Pod, instanceId string) *peerPodV1alpha1.PeerPod {
 	pp := peerPodV1alpha1.PeerPod{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: peerPodV1alpha1.GroupVersion.Group + "/" + peerPodV1alpha1.GroupVersion.Version,
-			Kind:       "PeerPod",
-		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:       pod.Name + "-resource-" + rand.String(5),
 			Namespace:  pod.Namespace,
@@ -81,49 +131,221 @@ func (s *PeerPodService) newPeerPod(pod *v1.Pod, instanceId string) *peerPodV1al
 	return &pp
 }
 
-func (s *PeerPodService) getPod(podname string, podns string) (*v1.Pod, error) {
-	pod, err := s.client.CoreV1().Pods(podns).Get(context.TODO(), podname, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
+func (s *PeerPodService) getPod(ctx context.Context, podname string, podns string) (*v1.Pod, error) {
+	return s.client.CoreV1().Pods(podns).Get(ctx, podname, metav1.GetOptions{})
+}
+
+// get the name of the PeerPod owned by a pod that tracks instanceID, or the latest one if instanceID is empty.
+// The mapping is recovered from owner references when it is not cached, e.g. after a restart of cloud-api-adaptor.
+func (s *PeerPodService) ownedPeerPodName(ctx context.Context, pod *v1.Pod, instanceID string) (string, error) {
+	s.mutex.Lock()
+	ref, ok := s.podToPP[pod.UID]
+	s.mutex.Unlock()
+	if ok && (instanceID == "" || ref.instanceID == instanceID) {
+		return ref.name, nil
+	}
+
+	var items []*peerPodV1alpha1.PeerPod
+	if cached, err := s.lister.PeerPods(pod.Namespace).List(labels.Everything()); err == nil {
+		items = cached
+	}
+
+	pp := findOwnedPeerPod(items, pod.UID, instanceID)
+	if pp == nil {
+		list, err := s.ppClient.ConfidentialcontainersV1alpha1().PeerPods(pod.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to list PeerPods in namespace %s: %w", pod.Namespace, err)
+		}
+		items = nil
+		for i := range list.Items {
+			items = append(items, &list.Items[i])
+		}
+		pp = findOwnedPeerPod(items, pod.UID, instanceID)
+	}
+	if pp == nil {
+		return "", fmt.Errorf("PeerPod owned by pod %s is not found", pod.Name)
+	}
+
+	s.mutex.Lock()
+	s.podToPP[pod.UID] = peerPodRef{name: pp.Name, instanceID: pp.Spec.InstanceID}
+	s.mutex.Unlock()
+
+	return pp.Name, nil
+}
+
+// find the latest PeerPod controlled by a pod that tracks instanceID, or any instance if instanceID is empty
+func findOwnedPeerPod(items []*peerPodV1alpha1.PeerPod, podUID types.UID, instanceID string) *peerPodV1alpha1.PeerPod {
+	var owned *peerPodV1alpha1.PeerPod
+	for _, pp := range items {
+		if ref := metav1.GetControllerOf(pp); ref == nil || ref.UID != podUID {
+			continue
+		}
+		if instanceID != "" && pp.Spec.InstanceID != instanceID {
+			continue
+		}
+		if owned == nil || owned.CreationTimestamp.Before(&pp.CreationTimestamp) {
+			owned = pp
+		}
 	}
-	return pod, nil
+	return owned
 }
 
-// make the pod an owner of a PeerPod
-func (s *PeerPodService) OwnPeerPod(podname string, podns string, instanceID string) error {
-	pod, err := s.getPod(podname, podns)
+// make the pod an owner of a PeerPod, and set the PeerPod status with the instance details
+func (s *PeerPodService) OwnPeerPod(ctx context.Context, podname string, podns string, instanceID string, status *peerPodV1alpha1.PeerPodStatus) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	pod, err := s.getPod(ctx, podname, podns)
 	if err != nil {
 		return err
 	}
-	pp := s.newPeerPod(pod, instanceID)
-	result := peerPodV1alpha1.PeerPod{}
-	err = s.uclient.Post().Namespace(pod.Namespace).Resource("peerPods").Body(pp).Do(context.TODO()).Into(&result)
+
+	pp, err := s.ppClient.ConfidentialcontainersV1alpha1().PeerPods(pod.Namespace).Create(ctx, s.newPeerPod(pod, instanceID), metav1.CreateOptions{})
 	if err != nil {
 		return err
 	}
-	s.podToPP[string(pod.UID)] = string(pp.Name)
+
+	s.mutex.Lock()
+	s.podToPP[pod.UID] = peerPodRef{name: pp.Name, instanceID: instanceID}
+	s.mutex.Unlock()
+
 	logger.Printf("%s is now owning a PeerPod object", podname)
-	return nil
+
+	if status == nil {
+		return nil
+	}
+
+	// The status of a new object is ignored by the API server, so it is set via the status subresource
+	pp.Status = *status
+	if pp.Status.Phase == "" {
+		pp.Status.Phase = peerPodV1alpha1.PeerPodCreating
+	}
+	_, err = s.ppClient.ConfidentialcontainersV1alpha1().PeerPods(pod.Namespace).UpdateStatus(ctx, pp, metav1.UpdateOptions{})
+	return err
 }
 
 // remove finalizer from PeerPod
-func (s *PeerPodService) ReleasePeerPod(podname string, podns string, instanceID string) error {
-	pod, err := s.getPod(podname, podns)
+func (s *PeerPodService) ReleasePeerPod(ctx context.Context, podname string, podns string, instanceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	pod, err := s.getPod(ctx, podname, podns)
 	if err != nil {
 		return err
 	}
 
-	ownedPPName, ok := s.podToPP[string(pod.UID)]
-	if !ok {
-		return errors.New("pod to PeerPod mapping not found")
-	}
-	result := peerPodV1alpha1.PeerPod{}
-	patch := []byte(`[{"op": "remove", "path": "/metadata/finalizers"}]`)
-	err = s.uclient.Patch(types.JSONPatchType).Name(ownedPPName).Namespace(podns).Resource("peerPods").Body(patch).Do(context.TODO()).Into(&result)
+	ownedPPName, err := s.ownedPeerPodName(ctx, pod, instanceID)
 	if err != nil {
 		return err
 	}
-	delete(s.podToPP, string(pod.UID))
+
+	peerPods := s.ppClient.ConfidentialcontainersV1alpha1().PeerPods(podns)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pp, err := peerPods.Get(ctx, ownedPPName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		var finalizers []string
+		for _, f := range pp.Finalizers {
+			if f != ppFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		if len(finalizers) == len(pp.Finalizers) {
+			return nil
+		}
+		pp.Finalizers = finalizers
+
+		_, err = peerPods.Update(ctx, pp, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.podToPP, pod.UID)
+	s.mutex.Unlock()
+
 	logger.Printf("%s's owned PeerPod object can now be deleted", podname)
 	return nil
 }
+
+// update the status of the PeerPod owned by a pod
+func (s *PeerPodService) updatePeerPodStatus(ctx context.Context, podname string, podns string, update func(status *peerPodV1alpha1.PeerPodStatus) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	pod, err := s.getPod(ctx, podname, podns)
+	if err != nil {
+		return err
+	}
+
+	ownedPPName, err := s.ownedPeerPodName(ctx, pod, "")
+	if err != nil {
+		return err
+	}
+
+	peerPods := s.ppClient.ConfidentialcontainersV1alpha1().PeerPods(podns)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pp, err := peerPods.Get(ctx, ownedPPName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if !update(&pp.Status) {
+			return nil
+		}
+		_, err = peerPods.UpdateStatus(ctx, pp, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// set the phase of the PeerPod owned by a pod
+func (s *PeerPodService) SetPeerPodPhase(ctx context.Context, podname string, podns string, phase peerPodV1alpha1.PeerPodPhase) error {
+	err := s.updatePeerPodStatus(ctx, podname, podns, func(status *peerPodV1alpha1.PeerPodStatus) bool {
+		if status.Phase == phase {
+			return false
+		}
+		status.Phase = phase
+		if phase == peerPodV1alpha1.PeerPodRunning {
+			now := metav1.Now()
+			status.RunningTimestamp = &now
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("%s's owned PeerPod object is in phase %s", podname, phase)
+	return nil
+}
+
+// set a condition in the status of the PeerPod owned by a pod
+func (s *PeerPodService) SetPeerPodCondition(ctx context.Context, podname string, podns string, condition metav1.Condition) error {
+	err := s.updatePeerPodStatus(ctx, podname, podns, func(status *peerPodV1alpha1.PeerPodStatus) bool {
+		if c := meta.FindStatusCondition(status.Conditions, condition.Type); c != nil && c.Status == condition.Status && c.Reason == condition.Reason && c.Message == condition.Message {
+			return false
+		}
+		meta.SetStatusCondition(&status.Conditions, condition)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("%s's owned PeerPod object has condition %s=%s", podname, condition.Type, condition.Status)
+	return nil
+}
+
+// record an event on a pod, e.g. a failure to create or delete its pod VM
+func (s *PeerPodService) RecordPodEvent(ctx context.Context, podname string, podns string, eventtype string, reason string, messageFmt string, args ...interface{}) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout)
+	defer cancel()
+
+	pod, err := s.getPod(ctx, podname, podns)
+	if err != nil {
+		logger.Printf("failed to record event %s on pod %s: %v", reason, podname, err)
+		return
+	}
+	s.recorder.Eventf(pod, eventtype, reason, messageFmt, args...)
+}
@@ -0,0 +1,233 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package k8sops
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	peerPodV1alpha1 "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/api/v1alpha1"
+	ppfake "github.com/confidential-containers/cloud-api-adaptor/peerpod-ctrl/pkg/generated/peerpod/clientset/versioned/fake"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestPod(name, namespace string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID("uid-" + name),
+		},
+	}
+}
+
+func newTestPeerPodService(t *testing.T, objects ...*v1.Pod) (*PeerPodService, *ppfake.Clientset, *fake.Clientset) {
+
+	client := fake.NewSimpleClientset()
+	for _, pod := range objects {
+		if _, err := client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+	ppClient := ppfake.NewSimpleClientset()
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	return NewPeerPodServiceWithClients(client, ppClient, record.NewFakeRecorder(10), "aws", stopCh), ppClient, client
+}
+
+func TestOwnPeerPod(t *testing.T) {
+
+	ctx := context.Background()
+	pod := newTestPod("nginx", "default")
+	s, ppClient, _ := newTestPeerPodService(t, pod)
+
+	created := metav1.NewTime(time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC))
+	status := &peerPodV1alpha1.PeerPodStatus{InstanceName: "podvm-nginx-12345678", InstanceType: "t3.small", IPs: []string{"10.0.0.2"}, InstanceCreationTimestamp: &created}
+	if err := s.OwnPeerPod(ctx, "nginx", "default", "i-1234", status); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	list, err := ppClient.ConfidentialcontainersV1alpha1().PeerPods("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := 1, len(list.Items); e != a {
+		t.Fatalf("Expect %d PeerPods, got %d", e, a)
+	}
+
+	pp := list.Items[0]
+	if e, a := "i-1234", pp.Spec.InstanceID; e != a {
+		t.Fatalf("Expect instance ID %q, got %q", e, a)
+	}
+	if e, a := "aws", pp.Spec.CloudProvider; e != a {
+		t.Fatalf("Expect cloud provider %q, got %q", e, a)
+	}
+	if ref := metav1.GetControllerOf(&pp); ref == nil || ref.UID != pod.UID {
+		t.Fatalf("Expect PeerPod controlled by pod %s, got %v", pod.UID, ref)
+	}
+	if e, a := []string{ppFinalizer}, pp.Finalizers; len(a) != 1 || a[0] != e[0] {
+		t.Fatalf("Expect finalizers %v, got %v", e, a)
+	}
+	if e, a := peerPodV1alpha1.PeerPodCreating, pp.Status.Phase; e != a {
+		t.Fatalf("Expect phase %q, got %q", e, a)
+	}
+	if e, a := "t3.small", pp.Status.InstanceType; e != a {
+		t.Fatalf("Expect instance type %q, got %q", e, a)
+	}
+	if e, a := "podvm-nginx-12345678", pp.Status.InstanceName; e != a {
+		t.Fatalf("Expect instance name %q, got %q", e, a)
+	}
+	if e, a := []string{"10.0.0.2"}, pp.Status.IPs; !reflect.DeepEqual(e, a) {
+		t.Fatalf("Expect IPs %v, got %v", e, a)
+	}
+	if a := pp.Status.InstanceCreationTimestamp; a == nil || !a.Equal(&created) {
+		t.Fatalf("Expect instance creation timestamp %v, got %v", created, a)
+	}
+
+	if err := s.OwnPeerPod(ctx, "unknown", "default", "i-5678", nil); err == nil {
+		t.Fatal("Expect error for an unknown pod, got nil")
+	}
+}
+
+func TestSetPeerPodStatus(t *testing.T) {
+
+	ctx := context.Background()
+	s, ppClient, _ := newTestPeerPodService(t, newTestPod("nginx", "default"))
+
+	if err := s.SetPeerPodPhase(ctx, "nginx", "default", peerPodV1alpha1.PeerPodRunning); err == nil {
+		t.Fatal("Expect error without a PeerPod, got nil")
+	}
+
+	if err := s.OwnPeerPod(ctx, "nginx", "default", "i-1234", &peerPodV1alpha1.PeerPodStatus{}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if err := s.SetPeerPodPhase(ctx, "nginx", "default", peerPodV1alpha1.PeerPodRunning); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	condition := metav1.Condition{Type: peerPodV1alpha1.AgentConnected, Status: metav1.ConditionTrue, Reason: "AgentProxyReady"}
+	if err := s.SetPeerPodCondition(ctx, "nginx", "default", condition); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	list, err := ppClient.ConfidentialcontainersV1alpha1().PeerPods("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	pp := list.Items[0]
+	if e, a := peerPodV1alpha1.PeerPodRunning, pp.Status.Phase; e != a {
+		t.Fatalf("Expect phase %q, got %q", e, a)
+	}
+	if pp.Status.RunningTimestamp == nil {
+		t.Fatal("Expect running timestamp, got nil")
+	}
+	if !meta.IsStatusConditionTrue(pp.Status.Conditions, peerPodV1alpha1.AgentConnected) {
+		t.Fatalf("Expect condition %s=True, got %v", peerPodV1alpha1.AgentConnected, pp.Status.Conditions)
+	}
+	running := pp.Status.RunningTimestamp
+
+	// Setting the same phase again keeps the running timestamp
+	if err := s.SetPeerPodPhase(ctx, "nginx", "default", peerPodV1alpha1.PeerPodRunning); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	condition = metav1.Condition{Type: peerPodV1alpha1.AgentConnected, Status: metav1.ConditionFalse, Reason: "AgentConnectionLost"}
+	if err := s.SetPeerPodCondition(ctx, "nginx", "default", condition); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := s.SetPeerPodPhase(ctx, "nginx", "default", peerPodV1alpha1.PeerPodFailed); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	list, err = ppClient.ConfidentialcontainersV1alpha1().PeerPods("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	pp = list.Items[0]
+	if e, a := peerPodV1alpha1.PeerPodFailed, pp.Status.Phase; e != a {
+		t.Fatalf("Expect phase %q, got %q", e, a)
+	}
+	if a := pp.Status.RunningTimestamp; a == nil || !a.Equal(running) {
+		t.Fatalf("Expect running timestamp %v, got %v", running, a)
+	}
+	if !meta.IsStatusConditionFalse(pp.Status.Conditions, peerPodV1alpha1.AgentConnected) {
+		t.Fatalf("Expect condition %s=False, got %v", peerPodV1alpha1.AgentConnected, pp.Status.Conditions)
+	}
+}
+
+func TestRecordPodEvent(t *testing.T) {
+
+	ctx := context.Background()
+	s, _, _ := newTestPeerPodService(t, newTestPod("nginx", "default"))
+	recorder := s.recorder.(*record.FakeRecorder)
+
+	s.RecordPodEvent(ctx, "nginx", "default", v1.EventTypeWarning, "PodVMCreationFailed", "failed to create pod VM: %v", "quota exceeded")
+	select {
+	case event := <-recorder.Events:
+		if e, a := "Warning PodVMCreationFailed failed to create pod VM: quota exceeded", event; e != a {
+			t.Fatalf("Expect event %q, got %q", e, a)
+		}
+	default:
+		t.Fatal("Expect an event, got none")
+	}
+
+	// An event on an unknown pod is dropped
+	s.RecordPodEvent(ctx, "unknown", "default", v1.EventTypeNormal, "PodVMCreated", "created pod VM")
+	select {
+	case event := <-recorder.Events:
+		t.Fatalf("Expect no event, got %q", event)
+	default:
+	}
+}
+
+func TestReleasePeerPodAfterRestart(t *testing.T) {
+
+	ctx := context.Background()
+	s, ppClient, client := newTestPeerPodService(t, newTestPod("nginx", "default"), newTestPod("httpd", "default"))
+
+	if err := s.OwnPeerPod(ctx, "nginx", "default", "i-1234", nil); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := s.OwnPeerPod(ctx, "httpd", "default", "i-5678", nil); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	// A new service has no cached mapping, as in the case of a restart of cloud-api-adaptor
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	restarted := NewPeerPodServiceWithClients(client, ppClient, record.NewFakeRecorder(10), "aws", stopCh)
+
+	if err := restarted.ReleasePeerPod(ctx, "nginx", "default", "i-9999"); err == nil {
+		t.Fatal("Expect error for an unknown instance, got nil")
+	}
+	if err := restarted.ReleasePeerPod(ctx, "nginx", "default", "i-1234"); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	list, err := ppClient.ConfidentialcontainersV1alpha1().PeerPods("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	for _, pp := range list.Items {
+		switch pp.Spec.InstanceID {
+		case "i-1234":
+			if len(pp.Finalizers) != 0 {
+				t.Fatalf("Expect no finalizers on released PeerPod, got %v", pp.Finalizers)
+			}
+		case "i-5678":
+			if len(pp.Finalizers) != 1 {
+				t.Fatalf("Expect finalizer on PeerPod of another pod, got %v", pp.Finalizers)
+			}
+		}
+	}
+}
@@ -4,57 +4,100 @@
 package proxy
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
 type Factory interface {
-	New(serverName, socketPath string) AgentProxy
+	New(serverName, socketPath string, sandbox agentproto.Sandbox, policy agentproto.Policy) AgentProxy
+}
+
+// Config is the configuration of agent proxies
+type Config struct {
+	PauseImage    string
+	CriSocketPath string
+	ProxyTimeout  time.Duration
+	AuditSink     agentproto.AuditSink
+
+	// TLSConfig enables TLS. When it has no CA, a CA service is set up to issue server certificates valid for
+	// ServerCertLifetime, and the CA is persisted in CACertDir if CACertDir is not empty.
+	TLSConfig          *tlsutil.TLSConfig
+	CACertDir          string
+	ServerCertLifetime time.Duration
+
+	// ServerIdentity specifies how agent proxies verify server certificates of pod VMs
+	ServerIdentity ServerIdentity
+
+	// Attestation makes agent proxies verify TEE evidence of pod VMs before use, when it is not nil
+	Attestation *attestation.Config
 }
 
 type factory struct {
-	pauseImage    string
-	criSocketPath string
-	tlsConfig     *tlsutil.TLSConfig
-	caService     tlsutil.CAService
-	proxyTimeout  time.Duration
+	config    Config
+	caService tlsutil.CAService
 }
 
-func NewFactory(pauseImage, criSocketPath string, tlsConfig *tlsutil.TLSConfig, proxyTimeout time.Duration) Factory {
+// NewFactory returns an agent proxy factory for config
+func NewFactory(config Config) (Factory, error) {
 
-	if tlsConfig != nil && !tlsConfig.HasCertAuth() {
+	tlsConfig := config.TLSConfig
 
-		certPEM, keyPEM, err := tlsutil.NewClientCertificate("cloud-api-adaptor")
-		if err != nil {
-			panic(err)
-		}
-		tlsConfig.CertData = certPEM
-		tlsConfig.KeyData = keyPEM
+	if config.Attestation != nil && tlsConfig == nil {
+		return nil, fmt.Errorf("attestation requires TLS")
 	}
 
 	var caService tlsutil.CAService
 
 	if tlsConfig != nil && !tlsConfig.HasCA() {
 
-		s, err := tlsutil.NewCAService("agent-protocol-forwarder")
+		var s tlsutil.CAService
+		var err error
+		if config.CACertDir != "" {
+			s, err = tlsutil.LoadOrCreateCAService("agent-protocol-forwarder", config.CACertDir, config.ServerCertLifetime)
+		} else {
+			s, err = tlsutil.NewCAService("agent-protocol-forwarder", config.ServerCertLifetime)
+		}
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("failed to set up a CA service: %w", err)
 		}
 		caService = s
 		tlsConfig.CAData = caService.RootCertificate()
 	}
 
-	return &factory{
-		pauseImage:    pauseImage,
-		criSocketPath: criSocketPath,
-		tlsConfig:     tlsConfig,
-		caService:     caService,
-		proxyTimeout:  proxyTimeout,
+	if tlsConfig != nil && !tlsConfig.HasCertAuth() {
+
+		var certPEM, keyPEM []byte
+		var err error
+		if caService != nil && config.CACertDir != "" {
+			// A client certificate issued by the persisted CA is trusted by existing pod VMs after restart
+			certPEM, keyPEM, err = caService.IssueClient("cloud-api-adaptor")
+		} else {
+			certPEM, keyPEM, err = tlsutil.NewClientCertificate("cloud-api-adaptor")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a client certificate: %w", err)
+		}
+		tlsConfig.CertData = certPEM
+		tlsConfig.KeyData = keyPEM
+	}
+
+	if tlsConfig != nil {
+		if err := config.ServerIdentity.Validate(caService != nil); err != nil {
+			return nil, err
+		}
 	}
+
+	return &factory{
+		config:    config,
+		caService: caService,
+	}, nil
 }
 
-func (f *factory) New(serverName, socketPath string) AgentProxy {
+func (f *factory) New(serverName, socketPath string, sandbox agentproto.Sandbox, policy agentproto.Policy) AgentProxy {
 
-	return NewAgentProxy(serverName, socketPath, f.criSocketPath, f.pauseImage, f.tlsConfig, f.caService, f.proxyTimeout)
+	return NewAgentProxy(serverName, socketPath, sandbox, policy, f.config, f.caService)
 }
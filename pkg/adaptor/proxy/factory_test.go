@@ -0,0 +1,27 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+func TestNewFactory(t *testing.T) {
+
+	if _, err := NewFactory(Config{TLSConfig: &tlsutil.TLSConfig{}, ProxyTimeout: time.Second}); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	if _, err := NewFactory(Config{ProxyTimeout: time.Second, Attestation: &attestation.Config{}}); err == nil {
+		t.Fatal("expect error for attestation without TLS, got nil")
+	}
+
+	if _, err := NewFactory(Config{TLSConfig: &tlsutil.TLSConfig{}, ProxyTimeout: time.Second, ServerIdentity: ServerIdentity{Mode: ServerIdentityIP}}); err == nil {
+		t.Fatal("expect error for an invalid server identity, got nil")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+// Modes of server identity verification. When no mode is specified, the identity is bound to each pod VM:
+// ServerIdentitySPIFFE is used when the CA service issues server certificates, and ServerIdentityIP is used
+// with a user-provided CA.
+const (
+	// ServerIdentityName verifies a DNS SAN. When the CA service is enabled, the name is the instance name
+	// of each pod VM. Otherwise, it is ServerIdentity.Name shared by all pod VMs, so this mode is only used
+	// when it is explicitly specified.
+	ServerIdentityName = "name"
+	// ServerIdentityIP verifies that an IP SAN matches the IP address of the pod VM
+	ServerIdentityIP = "ip"
+	// ServerIdentitySPIFFE verifies that a URI SAN matches the SPIFFE ID of the sandbox,
+	// spiffe://<trust domain>/sandbox/<sandbox ID>
+	ServerIdentitySPIFFE = "spiffe"
+
+	DefaultSPIFFETrustDomain = "confidentialcontainers.org"
+)
+
+// ServerIdentity specifies how the agent proxy verifies the identity of agent-protocol-forwarder in a pod VM
+type ServerIdentity struct {
+	Mode        string
+	Name        string
+	TrustDomain string
+}
+
+// mode returns the mode of server identity verification, or the default mode if Mode is empty.
+// caEnabled indicates whether the CA service issues server certificates.
+func (i *ServerIdentity) mode(caEnabled bool) string {
+
+	switch {
+	case i.Mode != "":
+		return i.Mode
+	case caEnabled:
+		return ServerIdentitySPIFFE
+	default:
+		return ServerIdentityIP
+	}
+}
+
+// Validate checks the server identity configuration, and sets Mode to the default mode if it is empty.
+// caEnabled indicates whether the CA service issues server certificates.
+func (i *ServerIdentity) Validate(caEnabled bool) error {
+
+	i.Mode = i.mode(caEnabled)
+
+	switch i.Mode {
+	case ServerIdentityName:
+		if !caEnabled && i.Name == "" {
+			return fmt.Errorf("server name must be specified to verify server certificates signed by a user-provided CA")
+		}
+	case ServerIdentityIP:
+		if caEnabled {
+			// Server certificates are issued before the IP address of a pod VM is known
+			return fmt.Errorf("server identity mode %q is not supported with automatically issued server certificates", i.Mode)
+		}
+	case ServerIdentitySPIFFE:
+	default:
+		return fmt.Errorf("unknown server identity mode: %q", i.Mode)
+	}
+
+	return nil
+}
+
+func (i *ServerIdentity) spiffeID(sandboxID string) *url.URL {
+
+	trustDomain := i.TrustDomain
+	if trustDomain == "" {
+		trustDomain = DefaultSPIFFETrustDomain
+	}
+
+	return &url.URL{Scheme: "spiffe", Host: trustDomain, Path: path.Join("/sandbox", sandboxID)}
+}
+
+// verifyServerIdentity configures config to verify the server identity of the pod VM at address
+func (p *agentProxy) verifyServerIdentity(config *tls.Config, address string) error {
+
+	switch p.serverIdentity.mode(p.caService != nil) {
+	case ServerIdentityIP:
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("failed to get the IP address of %s: %w", address, err)
+		}
+		// crypto/tls verifies an IP SAN when ServerName is an IP address
+		config.ServerName = host
+
+	case ServerIdentitySPIFFE:
+		tlsutil.VerifyPeerURI(config, p.serverIdentity.spiffeID(p.sandbox.ID).String())
+
+	case ServerIdentityName:
+		if p.caService != nil {
+			// A server certificate is automatically issued for the instance name of each pod VM
+			config.ServerName = p.serverName
+		} else {
+			config.ServerName = p.serverIdentity.Name
+		}
+
+	default:
+		return fmt.Errorf("unknown server identity mode: %q", p.serverIdentity.Mode)
+	}
+
+	return nil
+}
+
+// IssueServerCertificate issues a server certificate for the pod VM using the CA service
+func (p *agentProxy) IssueServerCertificate() (certPEM, keyPEM []byte, err error) {
+
+	if p.caService == nil {
+		return nil, nil, fmt.Errorf("CA service is not enabled")
+	}
+
+	var uris []*url.URL
+	if p.serverIdentity.mode(true) == ServerIdentitySPIFFE {
+		uris = append(uris, p.serverIdentity.spiffeID(p.sandbox.ID))
+	}
+
+	return p.caService.Issue(p.serverName, uris...)
+}
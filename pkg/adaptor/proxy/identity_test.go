@@ -0,0 +1,166 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+func startTLSServer(t *testing.T, certPEM, keyPEM, clientCAPEM []byte) string {
+
+	config, err := tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{CAData: clientCAPEM, CertData: certPEM, KeyData: keyPEM})
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestServerIdentity(t *testing.T) {
+
+	caService, err := tlsutil.NewCAService("agent-protocol-forwarder", time.Hour)
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+
+	clientCertPEM, clientKeyPEM, err := tlsutil.NewClientCertificate("cloud-api-adaptor")
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+
+	// When proxyCAService is nil, the CA is used as a user-provided CA
+	newProxy := func(serverName, sandboxID string, proxyCAService tlsutil.CAService, identity ServerIdentity) *agentProxy {
+		tlsConfig := &tlsutil.TLSConfig{CAData: caService.RootCertificate(), CertData: clientCertPEM, KeyData: clientKeyPEM}
+		return NewAgentProxy(serverName, "", agentproto.Sandbox{ID: sandboxID}, nil, Config{TLSConfig: tlsConfig, ServerIdentity: identity, ProxyTimeout: 500 * time.Millisecond}, proxyCAService).(*agentProxy)
+	}
+
+	// A server certificate signed by a user-provided CA for a shared name
+	sharedCertPEM, sharedKeyPEM, err := caService.Issue("podvm-server")
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+	sharedAddr := startTLSServer(t, sharedCertPEM, sharedKeyPEM, clientCertPEM)
+
+	// A server certificate issued by the CA service for sandbox "abc"
+	spiffeProxy := newProxy("podvm-abc", "abc", caService, ServerIdentity{Mode: ServerIdentitySPIFFE})
+	spiffeCertPEM, spiffeKeyPEM, err := spiffeProxy.IssueServerCertificate()
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+	spiffeAddr := startTLSServer(t, spiffeCertPEM, spiffeKeyPEM, clientCertPEM)
+
+	for _, tc := range []struct {
+		name    string
+		proxy   *agentProxy
+		addr    string
+		success bool
+	}{
+		{
+			name:    "shared name with user-provided CA",
+			proxy:   newProxy("podvm-abc", "abc", nil, ServerIdentity{Mode: ServerIdentityName, Name: "podvm-server"}),
+			addr:    sharedAddr,
+			success: true,
+		},
+		{
+			name:  "shared name is not verified by default",
+			proxy: newProxy("podvm-abc", "abc", nil, ServerIdentity{Name: "podvm-server"}),
+			addr:  sharedAddr,
+		},
+		{
+			name:  "IP without IP SAN",
+			proxy: newProxy("podvm-abc", "abc", nil, ServerIdentity{Mode: ServerIdentityIP}),
+			addr:  sharedAddr,
+		},
+		{
+			name:    "SPIFFE ID of the sandbox",
+			proxy:   spiffeProxy,
+			addr:    spiffeAddr,
+			success: true,
+		},
+		{
+			name:  "SPIFFE ID of another sandbox",
+			proxy: newProxy("podvm-xyz", "xyz", caService, ServerIdentity{Mode: ServerIdentitySPIFFE}),
+			addr:  spiffeAddr,
+		},
+		{
+			name:    "SPIFFE ID of the sandbox by default",
+			proxy:   newProxy("podvm-abc", "abc", caService, ServerIdentity{}),
+			addr:    spiffeAddr,
+			success: true,
+		},
+		{
+			name:  "instance name of another pod VM",
+			proxy: newProxy("podvm-xyz", "xyz", caService, ServerIdentity{Mode: ServerIdentityName}),
+			addr:  spiffeAddr,
+		},
+	} {
+		conn, err := tc.proxy.dial(context.Background(), tc.addr)
+		if tc.success {
+			if err != nil {
+				t.Fatalf("%s: expect no error, got %q", tc.name, err)
+			}
+			conn.Close()
+		} else if err == nil {
+			conn.Close()
+			t.Fatalf("%s: expect error, got nil", tc.name)
+		}
+	}
+}
+
+func TestServerIdentityValidate(t *testing.T) {
+
+	for _, tc := range []struct {
+		identity  ServerIdentity
+		caEnabled bool
+		valid     bool
+		mode      string
+	}{
+		{identity: ServerIdentity{}, caEnabled: true, valid: true, mode: ServerIdentitySPIFFE},
+		{identity: ServerIdentity{}, caEnabled: false, valid: true, mode: ServerIdentityIP},
+		{identity: ServerIdentity{Mode: ServerIdentityName}, caEnabled: true, valid: true},
+		{identity: ServerIdentity{Mode: ServerIdentityName}, caEnabled: false, valid: false},
+		{identity: ServerIdentity{Mode: ServerIdentityName, Name: "podvm-server"}, caEnabled: false, valid: true},
+		{identity: ServerIdentity{Mode: ServerIdentityIP}, caEnabled: false, valid: true},
+		{identity: ServerIdentity{Mode: ServerIdentityIP}, caEnabled: true, valid: false},
+		{identity: ServerIdentity{Mode: ServerIdentitySPIFFE}, caEnabled: true, valid: true},
+		{identity: ServerIdentity{Mode: "instance"}, caEnabled: true, valid: false},
+	} {
+		err := tc.identity.Validate(tc.caEnabled)
+		if tc.valid && err != nil {
+			t.Fatalf("expect no error for %#v, got %q", tc.identity, err)
+		}
+		if !tc.valid && err == nil {
+			t.Fatalf("expect error for %#v, got nil", tc.identity)
+		}
+		if tc.mode != "" && tc.identity.Mode != tc.mode {
+			t.Fatalf("expect mode %q, got %q", tc.mode, tc.identity.Mode)
+		}
+	}
+}
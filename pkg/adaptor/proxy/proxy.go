@@ -17,6 +17,8 @@ import (
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
 	"github.com/containerd/ttrpc"
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
@@ -29,10 +31,6 @@ const (
 	SocketName          = "agent.ttrpc"
 	defaultCriTimeout   = 1 * time.Second
 	DefaultProxyTimeout = 5 * time.Minute
-
-	// The server TLS certificate must have this as SAN
-	// TODO: Avoid hard coding of server name
-	podvmServername = "podvm-server"
 )
 
 var logger = log.New(log.Writer(), "[adaptor/proxy] ", log.LstdFlags|log.Lmsgprefix)
@@ -46,36 +44,58 @@ type AgentProxy interface {
 	Ready() chan struct{}
 	Shutdown() error
 	CAService() tlsutil.CAService
+	IssueServerCertificate() (certPEM, keyPEM []byte, err error)
 	ClientCA() (certPEM []byte)
+	AttestationEnabled() bool
+	UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error
+	ConnectionState() agentproto.ConnectionState
+	SetServerAddress(address string)
 }
 
 type agentProxy struct {
-	tlsConfig     *tlsutil.TLSConfig
-	caService     tlsutil.CAService
-	readyCh       chan struct{}
-	stopCh        chan struct{}
-	serverName    string
-	socketPath    string
-	criSocketPath string
-	pauseImage    string
-	proxyTimeout  time.Duration
-	criTimeout    time.Duration
-	stopOnce      sync.Once
+	tlsConfig      *tlsutil.TLSConfig
+	caService      tlsutil.CAService
+	serverIdentity ServerIdentity
+	readyCh        chan struct{}
+	stopCh         chan struct{}
+	serverName     string
+	socketPath     string
+	criSocketPath  string
+	pauseImage     string
+	proxyTimeout   time.Duration
+	criTimeout     time.Duration
+	sandbox        agentproto.Sandbox
+	policy         agentproto.Policy
+	auditSink      agentproto.AuditSink
+	attestation    *attestation.Config
+	stopOnce       sync.Once
+
+	// mutex protects service and serverAddr
+	mutex      sync.Mutex
+	service    *proxyService
+	serverAddr string
 }
 
-func NewAgentProxy(serverName, socketPath, criSocketPath string, pauseImage string, tlsConfig *tlsutil.TLSConfig, caService tlsutil.CAService, proxyTimeout time.Duration) AgentProxy {
+// NewAgentProxy returns an agent proxy of a sandbox, which listens on socketPath and connects to the pod VM serverName.
+// caService issues server certificates of the pod VM, when config.TLSConfig has no CA.
+func NewAgentProxy(serverName, socketPath string, sandbox agentproto.Sandbox, policy agentproto.Policy, config Config, caService tlsutil.CAService) AgentProxy {
 
 	return &agentProxy{
-		serverName:    serverName,
-		socketPath:    socketPath,
-		criSocketPath: criSocketPath,
-		readyCh:       make(chan struct{}),
-		stopCh:        make(chan struct{}),
-		proxyTimeout:  proxyTimeout,
-		criTimeout:    defaultCriTimeout,
-		pauseImage:    pauseImage,
-		tlsConfig:     tlsConfig,
-		caService:     caService,
+		serverName:     serverName,
+		socketPath:     socketPath,
+		criSocketPath:  config.CriSocketPath,
+		readyCh:        make(chan struct{}),
+		stopCh:         make(chan struct{}),
+		proxyTimeout:   config.ProxyTimeout,
+		criTimeout:     defaultCriTimeout,
+		pauseImage:     config.PauseImage,
+		tlsConfig:      config.TLSConfig,
+		caService:      caService,
+		serverIdentity: config.ServerIdentity,
+		sandbox:        sandbox,
+		policy:         policy,
+		auditSink:      config.AuditSink,
+		attestation:    config.Attestation,
 	}
 }
 
@@ -94,17 +114,10 @@ func (p *agentProxy) dial(ctx context.Context, address string) (net.Conn, error)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create tls config: %v", err)
 		}
-		// This is important otherwise you'll hit the following error
-		// cannot validate certificate for <IP> because it doesn't contain any IP SAN
-		// Since it's not possible to know the IP address of the pod VM apriori,
-		// we are using a well-defined hostname here. Other option is to create
-		// certificates with IP SAN having all the IPs in the network range
-		// When CA service is enabled, a server certificate is automatically generated for
-		// the instance VM name.
-		if p.caService != nil {
-			config.ServerName = p.serverName
-		} else {
-			config.ServerName = podvmServername
+		// The server certificate is verified against the identity of this pod VM, so that
+		// a certificate of another pod VM is not accepted
+		if err := p.verifyServerIdentity(config, address); err != nil {
+			return nil, err
 		}
 
 		dialer = &tls.Dialer{
@@ -135,6 +148,17 @@ func (p *agentProxy) dial(ctx context.Context, address string) (net.Conn, error)
 		return nil, err
 	}
 
+	// The pod VM is not trusted until its TEE evidence is verified
+	if p.attestation != nil {
+		if err := attestation.ClientHandshake(ctx, conn, p.attestation); err != nil {
+			conn.Close()
+			err = fmt.Errorf("failed to attest pod VM at %s: %w", address, err)
+			logger.Print(err)
+			return nil, err
+		}
+		logger.Printf("verified attestation evidence of pod VM at %s", address)
+	}
+
 	logger.Printf("established agent proxy connection to %s", address)
 	return conn, nil
 }
@@ -181,8 +205,14 @@ func (p *agentProxy) Start(ctx context.Context, serverURL *url.URL) error {
 		return fmt.Errorf("failed to listen on %s: %w", p.socketPath, err)
 	}
 
+	p.SetServerAddress(serverURL.Host)
+
 	dialer := func(ctx context.Context) (net.Conn, error) {
-		return p.dial(ctx, serverURL.Host)
+		p.mutex.Lock()
+		address := p.serverAddr
+		p.mutex.Unlock()
+
+		return p.dial(ctx, address)
 	}
 
 	criClient, err := p.initCriClient(ctx)
@@ -202,7 +232,11 @@ func (p *agentProxy) Start(ctx context.Context, serverURL *url.URL) error {
 		return fmt.Errorf("error connecting to agent: %v", err)
 	}
 
-	ttrpcServer, err := ttrpc.NewServer()
+	p.mutex.Lock()
+	p.service = proxyService
+	p.mutex.Unlock()
+
+	ttrpcServer, err := ttrpc.NewServer(agentproto.ServerOpts(p.policy, p.auditSink, "proxy", p.sandbox)...)
 	if err != nil {
 		return fmt.Errorf("failed to create TTRPC server: %w", err)
 	}
@@ -259,6 +293,53 @@ func (p *agentProxy) CAService() tlsutil.CAService {
 	return p.caService
 }
 
+// ConnectionState returns the state of the connection to agent-protocol-forwarder
+func (p *agentProxy) ConnectionState() agentproto.ConnectionState {
+
+	p.mutex.Lock()
+	service := p.service
+	p.mutex.Unlock()
+
+	if service == nil {
+		return agentproto.Idle
+	}
+	return service.State()
+}
+
+// SetServerAddress changes the address of agent-protocol-forwarder, for example when the IP address of the pod VM
+// changes. The address is used when the agent connection is dialed next time.
+func (p *agentProxy) SetServerAddress(address string) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.serverAddr != "" && p.serverAddr != address {
+		logger.Printf("agent-protocol-forwarder address of %s changed from %s to %s", p.serverName, p.serverAddr, address)
+	}
+	p.serverAddr = address
+}
+
+// UpdateServerCertificate sends a renewed server certificate to agent-protocol-forwarder over the agent connection
+func (p *agentProxy) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+
+	p.mutex.Lock()
+	service := p.service
+	p.mutex.Unlock()
+
+	if service == nil {
+		return errors.New("agent proxy is not connected")
+	}
+
+	if err := service.UpdateServerCertificate(ctx, certPEM, keyPEM); err != nil {
+		return fmt.Errorf("failed to update the server certificate of %s: %w", p.serverName, err)
+	}
+	return nil
+}
+
+func (p *agentProxy) AttestationEnabled() bool {
+	return p.attestation != nil
+}
+
 func (p *agentProxy) ClientCA() (certPEM []byte) {
 
 	if p.tlsConfig == nil {
@@ -25,6 +25,7 @@ type proxyService struct {
 	agentproto.Redirector
 	criClient  *criClient
 	pauseImage string
+	streams    *streamMux
 }
 
 const (
@@ -43,9 +44,15 @@ func newProxyService(dialer func(context.Context) (net.Conn, error), criClient *
 		Redirector: redirector,
 		criClient:  criClient,
 		pauseImage: pauseImage,
+		streams:    newStreamMux(),
 	}
 }
 
+func (s *proxyService) Close() error {
+	s.streams.close()
+	return s.Redirector.Close()
+}
+
 func (s *proxyService) getImageFromDigest(ctx context.Context, digest string) (string, error) {
 	if s.criClient == nil {
 		return "", fmt.Errorf("getImageFromDigest: criClient is nil.")
@@ -223,6 +230,8 @@ func (s *proxyService) RemoveContainer(ctx context.Context, req *pb.RemoveContai
 
 	logger.Printf("RemoveContainer: containerID:%s", req.ContainerId)
 
+	s.streams.removeContainer(req.ContainerId)
+
 	res, err := s.Redirector.RemoveContainer(ctx, req)
 
 	if err != nil {
@@ -277,3 +286,27 @@ func (s *proxyService) PullImage(ctx context.Context, req *pb.PullImageRequest)
 
 	return res, err
 }
+
+func (s *proxyService) ReadStdout(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+
+	key := streamKey{containerID: req.ContainerId, execID: req.ExecId, kind: stdoutStream}
+
+	data, err := s.streams.read(ctx, key, req.Len, s.Redirector.ReadStdout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ReadStreamResponse{Data: data}, nil
+}
+
+func (s *proxyService) ReadStderr(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+
+	key := streamKey{containerID: req.ContainerId, execID: req.ExecId, kind: stderrStream}
+
+	data, err := s.streams.read(ctx, key, req.Len, s.Redirector.ReadStderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ReadStreamResponse{Data: data}, nil
+}
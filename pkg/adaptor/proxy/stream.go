@@ -0,0 +1,231 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+)
+
+const (
+	// streamChunkSize is the read size requested from the agent for each ReadStdout/ReadStderr call
+	streamChunkSize = 64 * 1024
+	// streamBufferChunks is the number of chunks buffered per stream before the proxy stops polling the agent
+	streamBufferChunks = 16
+	// streamMinBackoff and streamMaxBackoff bound the delay before polling the agent again after it returns no data
+	streamMinBackoff = time.Millisecond
+	streamMaxBackoff = 100 * time.Millisecond
+)
+
+type streamKind int
+
+const (
+	stdoutStream streamKind = iota
+	stderrStream
+)
+
+type streamKey struct {
+	containerID string
+	execID      string
+	kind        streamKind
+}
+
+type streamReadFunc func(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error)
+
+// streamReader prefetches output of a process from the agent, so that the
+// latency of a round trip to the pod VM is not paid for every read issued by
+// the shim. Chunks read from the agent are queued in a bounded channel. When
+// the queue is full, the reader stops polling the agent until the shim
+// consumes data, which propagates backpressure to the process in the pod VM.
+type streamReader struct {
+	chunks  chan []byte
+	pending []byte
+	err     error
+	cancel  context.CancelFunc
+	mutex   sync.Mutex
+}
+
+func newStreamReader(ctx context.Context, key streamKey, read streamReadFunc) *streamReader {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	r := &streamReader{
+		chunks: make(chan []byte, streamBufferChunks),
+		cancel: cancel,
+	}
+
+	go r.run(ctx, key, read)
+
+	return r
+}
+
+func (r *streamReader) run(ctx context.Context, key streamKey, read streamReadFunc) {
+	defer close(r.chunks)
+
+	req := &pb.ReadStreamRequest{
+		ContainerId: key.containerID,
+		ExecId:      key.execID,
+		Len:         streamChunkSize,
+	}
+
+	backoff := streamMinBackoff
+
+	for {
+		res, err := read(ctx, req)
+		if err != nil {
+			// The error is returned to the shim after all buffered data is consumed.
+			// The agent returns an error when the stream reaches EOF.
+			r.err = err
+			return
+		}
+
+		if len(res.Data) == 0 {
+			// The agent normally blocks until data is available, so an empty response is retried
+			// with an exponential backoff instead of polling the agent in a busy loop
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				r.err = ctx.Err()
+				return
+			}
+			if backoff *= 2; backoff > streamMaxBackoff {
+				backoff = streamMaxBackoff
+			}
+			continue
+		}
+		backoff = streamMinBackoff
+
+		select {
+		case r.chunks <- res.Data:
+		case <-ctx.Done():
+			r.err = ctx.Err()
+			return
+		}
+	}
+}
+
+// read returns up to length bytes of buffered data. It blocks until at least
+// one chunk is available, and then batches all chunks that are already
+// buffered into a single response.
+func (r *streamReader) read(ctx context.Context, length uint32) ([]byte, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if length == 0 {
+		length = streamChunkSize
+	}
+
+	if len(r.pending) == 0 {
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				// r.err is set before r.chunks is closed
+				return nil, r.err
+			}
+			r.pending = chunk
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	data := make([]byte, 0, length)
+
+	for {
+		n := copy(data[len(data):cap(data)], r.pending)
+		data = data[:len(data)+n]
+		r.pending = r.pending[n:]
+
+		if len(r.pending) > 0 || len(data) == cap(data) {
+			break
+		}
+
+		var chunk []byte
+		var ok bool
+		select {
+		case chunk, ok = <-r.chunks:
+		default:
+		}
+		if !ok {
+			break
+		}
+		r.pending = chunk
+	}
+
+	return data, nil
+}
+
+// streamMux maintains stream readers of processes running in a pod VM
+type streamMux struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	readers map[streamKey]*streamReader
+	mutex   sync.Mutex
+}
+
+func newStreamMux() *streamMux {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &streamMux{
+		ctx:     ctx,
+		cancel:  cancel,
+		readers: make(map[streamKey]*streamReader),
+	}
+}
+
+func (m *streamMux) read(ctx context.Context, key streamKey, length uint32, read streamReadFunc) ([]byte, error) {
+
+	m.mutex.Lock()
+	r, ok := m.readers[key]
+	if !ok {
+		r = newStreamReader(m.ctx, key, read)
+		m.readers[key] = r
+	}
+	m.mutex.Unlock()
+
+	data, err := r.read(ctx, length)
+	if err != nil && ctx.Err() == nil {
+		m.remove(key, r)
+	}
+
+	return data, err
+}
+
+func (m *streamMux) remove(key streamKey, r *streamReader) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.readers[key] == r {
+		delete(m.readers, key)
+	}
+	r.cancel()
+}
+
+// removeContainer stops all readers of the specified container
+func (m *streamMux) removeContainer(containerID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, r := range m.readers {
+		if key.containerID == containerID {
+			delete(m.readers, key)
+			r.cancel()
+		}
+	}
+}
+
+func (m *streamMux) close() {
+	m.cancel()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.readers = make(map[streamKey]*streamReader)
+}
@@ -0,0 +1,265 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+)
+
+// chunkSource returns chunks in order, followed by io.EOF
+func chunkSource(chunks ...string) streamReadFunc {
+	var mutex sync.Mutex
+	return func(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		data := []byte(chunks[0])
+		chunks = chunks[1:]
+		return &pb.ReadStreamResponse{Data: data}, nil
+	}
+}
+
+func TestStreamMux(t *testing.T) {
+
+	mux := newStreamMux()
+	defer mux.close()
+
+	key := streamKey{containerID: "123", execID: "456", kind: stdoutStream}
+	read := chunkSource("abc", "defgh", "", "ijklmnop")
+
+	var out bytes.Buffer
+	for {
+		data, err := mux.read(context.Background(), key, 4, read)
+		if err != nil {
+			if e, a := io.EOF, err; !errors.Is(a, e) {
+				t.Fatalf("expect %q, got %q", e, a)
+			}
+			break
+		}
+		if len(data) == 0 || len(data) > 4 {
+			t.Fatalf("expect 1 to 4 bytes, got %d bytes", len(data))
+		}
+		out.Write(data)
+	}
+
+	if e, a := "abcdefghijklmnop", out.String(); e != a {
+		t.Fatalf("expect %q, got %q", e, a)
+	}
+
+	if e, a := 0, len(mux.readers); e != a {
+		t.Fatalf("expect %d readers, got %d", e, a)
+	}
+}
+
+func TestStreamReaderEmptyData(t *testing.T) {
+
+	var calls atomic.Int32
+	read := func(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+		calls.Add(1)
+		return &pb.ReadStreamResponse{}, nil
+	}
+
+	r := newStreamReader(context.Background(), streamKey{containerID: "123"}, read)
+
+	time.Sleep(200 * time.Millisecond)
+	r.cancel()
+
+	// Empty responses are retried with a backoff of 1ms doubled up to 100ms, which allows about 10 reads
+	if n := calls.Load(); n > 20 {
+		t.Fatalf("expect the agent to be polled with a backoff, got %d reads in 200ms", n)
+	}
+
+	if _, err := r.read(context.Background(), 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect %q, got %q", context.Canceled, err)
+	}
+}
+
+func TestStreamMuxBatching(t *testing.T) {
+
+	mux := newStreamMux()
+	defer mux.close()
+
+	key := streamKey{containerID: "123", execID: "456", kind: stderrStream}
+
+	var chunks []string
+	for i := 0; i < streamBufferChunks; i++ {
+		chunks = append(chunks, fmt.Sprintf("%02d", i))
+	}
+	read := chunkSource(chunks...)
+
+	// Wait until all chunks are prefetched
+	mux.mutex.Lock()
+	r := newStreamReader(mux.ctx, key, read)
+	mux.readers[key] = r
+	mux.mutex.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.chunks) < streamBufferChunks-1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for prefetch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	data, err := mux.read(context.Background(), key, 1024, read)
+	if err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+	if len(data) < 2*(streamBufferChunks-1) {
+		t.Fatalf("expect buffered chunks to be batched, got %q", data)
+	}
+}
+
+func TestStreamMuxBackpressure(t *testing.T) {
+
+	mux := newStreamMux()
+	defer mux.close()
+
+	var calls int64
+	read := func(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+		atomic.AddInt64(&calls, 1)
+		return &pb.ReadStreamResponse{Data: []byte("x")}, nil
+	}
+
+	key := streamKey{containerID: "123", execID: "456", kind: stdoutStream}
+
+	if _, err := mux.read(context.Background(), key, 1, read); err != nil {
+		t.Fatalf("expect no error, got %q", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	// One chunk is consumed, streamBufferChunks chunks are buffered, and one read is blocked on the full buffer
+	if e, a := int64(streamBufferChunks+2), atomic.LoadInt64(&calls); a > e {
+		t.Fatalf("expect at most %d reads from agent, got %d", e, a)
+	}
+
+	mux.removeContainer("123")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	data, err := mux.read(ctx, key, 1, func(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err == nil {
+		t.Fatalf("expect error after container removal, got %q", data)
+	}
+}
+
+const benchmarkStreamSize = 4 * 1024 * 1024
+
+type streamAgentMock struct {
+	agentMock
+	mutex sync.Mutex
+	sent  map[string]int
+}
+
+func (m *streamAgentMock) ReadStdout(ctx context.Context, req *pb.ReadStreamRequest) (*pb.ReadStreamResponse, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sent := m.sent[req.ExecId]
+	if sent >= benchmarkStreamSize {
+		return nil, io.EOF
+	}
+
+	n := int(req.Len)
+	if n > benchmarkStreamSize-sent {
+		n = benchmarkStreamSize - sent
+	}
+	m.sent[req.ExecId] = sent + n
+
+	return &pb.ReadStreamResponse{Data: make([]byte, n)}, nil
+}
+
+func BenchmarkExecStream(b *testing.B) {
+
+	socketPath := filepath.Join(b.TempDir(), "test.sock")
+
+	agent := &streamAgentMock{sent: make(map[string]int)}
+
+	agentServer, err := ttrpc.NewServer()
+	if err != nil {
+		b.Fatalf("expect no error, got %q", err)
+	}
+	pb.RegisterAgentServiceService(agentServer, agent)
+	pb.RegisterImageService(agentServer, agent)
+	pb.RegisterHealthService(agentServer, agent)
+
+	agentListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("expect no error, got %q", err)
+	}
+	defer agentListener.Close()
+
+	go agentServer.Serve(context.Background(), agentListener) //nolint:errcheck
+	defer agentServer.Shutdown(context.Background())          //nolint:errcheck
+
+	serverURL := &url.URL{
+		Scheme: "grpc",
+		Host:   agentListener.Addr().String(),
+	}
+
+	proxy := NewAgentProxy("podvm", socketPath, agentproto.Sandbox{}, nil, Config{ProxyTimeout: 5 * time.Second}, nil)
+
+	proxyErrCh := make(chan error, 1)
+	go func() {
+		proxyErrCh <- proxy.Start(context.Background(), serverURL)
+	}()
+	defer proxy.Shutdown() //nolint:errcheck
+
+	select {
+	case err := <-proxyErrCh:
+		b.Fatalf("expect no error, got %q", err)
+	case <-proxy.Ready():
+	}
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		b.Fatalf("expect no error, got %q", err)
+	}
+	ttrpcClient := ttrpc.NewClient(conn)
+	defer ttrpcClient.Close()
+
+	client := pb.NewAgentServiceClient(ttrpcClient)
+
+	b.SetBytes(benchmarkStreamSize)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req := &pb.ReadStreamRequest{ContainerId: "123", ExecId: fmt.Sprintf("exec-%d", i), Len: 32 * 1024}
+
+		var total int
+		for {
+			res, err := client.ReadStdout(context.Background(), req)
+			if err != nil {
+				break
+			}
+			total += len(res.Data)
+		}
+		if total != benchmarkStreamSize {
+			b.Fatalf("expect %d bytes, got %d", benchmarkStreamSize, total)
+		}
+	}
+}
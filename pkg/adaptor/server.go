@@ -19,7 +19,8 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/vminfo"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
-	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/userdata"
 	pbPodVMInfo "github.com/confidential-containers/cloud-api-adaptor/proto/podvminfo"
 )
 
@@ -28,18 +29,20 @@ var logger = log.New(log.Writer(), "[adaptor] ", log.LstdFlags|log.Lmsgprefix)
 const (
 	DefaultSocketPath = "/run/peerpod/hypervisor.sock"
 	DefaultPodsDir    = "/run/peerpod/pods"
+
+	DefaultTunnelCheckInterval = 30 * time.Second
 )
 
 type ServerConfig struct {
-	TLSConfig               *tlsutil.TLSConfig
+	ProxyConfig             proxy.Config
 	SocketPath              string
-	CriSocketPath           string
-	PauseImage              string
 	PodsDir                 string
 	ForwarderPort           string
-	ProxyTimeout            time.Duration
 	AAKBCParams             string
 	EnableCloudConfigVerify bool
+	TunnelCheckInterval     time.Duration
+	UserDataSealer          *userdata.Sealer
+	TunableAgentSettings    []string
 }
 
 type Server interface {
@@ -52,6 +55,7 @@ type server struct {
 	cloudService            cloud.Service
 	vmInfoService           pbPodVMInfo.PodVMInfoService
 	workerNode              podnetwork.WorkerNode
+	auditSink               agentproto.AuditSink
 	ttRpc                   *ttrpc.Server
 	readyCh                 chan struct{}
 	stopCh                  chan struct{}
@@ -60,12 +64,15 @@ type server struct {
 	enableCloudConfigVerify bool
 }
 
-func NewServer(provider cloud.Provider, cfg *ServerConfig, workerNode podnetwork.WorkerNode) Server {
+func NewServer(provider cloud.Provider, cfg *ServerConfig, workerNode podnetwork.WorkerNode) (Server, error) {
 
 	logger.Printf("server config: %#v", cfg)
 
-	agentFactory := proxy.NewFactory(cfg.PauseImage, cfg.CriSocketPath, cfg.TLSConfig, cfg.ProxyTimeout)
-	cloudService := cloud.NewService(provider, agentFactory, workerNode, cfg.PodsDir, cfg.ForwarderPort, cfg.AAKBCParams)
+	agentFactory, err := proxy.NewFactory(cfg.ProxyConfig)
+	if err != nil {
+		return nil, err
+	}
+	cloudService := cloud.NewService(provider, agentFactory, workerNode, cfg.PodsDir, cfg.ForwarderPort, cfg.AAKBCParams, cfg.TunnelCheckInterval, cfg.UserDataSealer, cfg.TunableAgentSettings)
 	vmInfoService := vminfo.NewService(cloudService)
 
 	return &server{
@@ -73,10 +80,11 @@ func NewServer(provider cloud.Provider, cfg *ServerConfig, workerNode podnetwork
 		cloudService:            cloudService,
 		vmInfoService:           vmInfoService,
 		workerNode:              workerNode,
+		auditSink:               cfg.ProxyConfig.AuditSink,
 		readyCh:                 make(chan struct{}),
 		stopCh:                  make(chan struct{}),
 		enableCloudConfigVerify: cfg.EnableCloudConfigVerify,
-	}
+	}, nil
 }
 
 func (s *server) Start(ctx context.Context) (err error) {
@@ -141,7 +149,15 @@ func (s *server) Shutdown() error {
 		close(s.stopCh)
 	})
 
-	return s.cloudService.Teardown()
+	err := s.cloudService.Teardown()
+
+	if s.auditSink != nil {
+		if e := s.auditSink.Close(); e != nil {
+			logger.Printf("failed to close audit log: %v", e)
+		}
+	}
+
+	return err
 }
 
 func (s *server) Ready() chan struct{} {
@@ -154,10 +154,14 @@ func newServer(t *testing.T, socketPath, podsDir string) Server {
 		SocketPath:              socketPath,
 		PodsDir:                 podsDir,
 		ForwarderPort:           port,
-		ProxyTimeout:            5 * time.Second,
+		ProxyConfig:             proxy.Config{ProxyTimeout: 5 * time.Second},
 		EnableCloudConfigVerify: false,
 	}
-	return NewServer(provider, serverConfig, &mockWorkerNode{})
+	s, err := NewServer(provider, serverConfig, &mockWorkerNode{})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return s
 }
 
 func testServerShutdown(t *testing.T, s Server, socketPath, dir string, serverErrCh chan error) {
@@ -190,6 +194,10 @@ func (n *mockWorkerNode) Teardown(nsPath string, config *tunneler.Config) error
 	return nil
 }
 
+func (n *mockWorkerNode) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+	return nil
+}
+
 type mockProvider struct {
 	primaryIP   string
 	secondaryIP string
@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/adaptor/proxy"
 	daemon "github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder/interceptor"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
@@ -90,12 +91,15 @@ func TestShim(t *testing.T) {
 		SocketPath:              helperSocketPath,
 		PodsDir:                 podsDir,
 		ForwarderPort:           port,
-		ProxyTimeout:            5 * time.Second,
+		ProxyConfig:             proxy.Config{ProxyTimeout: 5 * time.Second},
 		EnableCloudConfigVerify: false,
 	}
 
 	provider := &mockProvider{primaryIP: primaryIP, secondaryIP: secondaryIP}
-	srv := NewServer(provider, serverConfig, workerNode)
+	srv, err := NewServer(provider, serverConfig, workerNode)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
 
 	serverDone := make(chan struct{})
 	go func() {
@@ -163,7 +167,7 @@ func startDaemon(t *testing.T, ctx context.Context, agentSocketPath string, port
 	nsPath := os.Getenv("AGENT_PROTOCOL_FORWARDER_NAMESPACE")
 	interceptor := interceptor.NewInterceptor(agentSocketPath, nsPath)
 
-	d := daemon.NewDaemon(config, "127.0.0.1:0", nil, interceptor, &mockPodNode{})
+	d := daemon.NewDaemon(config, "127.0.0.1:0", nil, interceptor, &mockPodNode{}, nil, nil)
 
 	daemonErr := make(chan error)
 	go func() {
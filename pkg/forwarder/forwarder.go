@@ -6,11 +6,17 @@ package forwarder
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/containerd/ttrpc"
 	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
@@ -18,6 +24,8 @@ import (
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/forwarder/interceptor"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/attestation"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
 )
 
@@ -28,16 +36,62 @@ const (
 	DefaultListenPort          = "15150"
 	DefaultListenAddr          = DefaultListenHost + ":" + DefaultListenPort
 	DefaultConfigPath          = "/peerpod/daemon.json"
+	DefaultSealedConfigPath    = "/peerpod/daemon.json.sealed"
 	DefaultPodNetworkSpecPath  = "/peerpod/podnetwork.json"
 	DefaultKataAgentSocketPath = "/run/kata-containers/agent.sock"
 	DefaultKataAgentNamespace  = ""
+	DefaultAgentPolicyPath     = "/etc/agent-protocol-forwarder/agent-policy.json"
+	DefaultRenewedCertPath     = "/peerpod/tls-server-renewed.pem"
 	AgentURLPath               = "/agent"
 )
 
+// AgentConfigAnnotation is a pod annotation that specifies kata agent settings as a JSON object.
+// The settings are delivered to the pod VM in Config.AgentConfig. Only tunable settings are accepted.
+const AgentConfigAnnotation = "io.confidentialcontainers.org.peerpod.agent-config"
+
+// DefaultTunableAgentSettings are the kata agent settings that AgentConfigAnnotation can specify, unless a deployment
+// configures other settings with the -tunable-agent-settings option of cloud-api-adaptor and process-user-data.
+// Settings that protect the pod VM, such as endpoints, enable_signature_verification and server_addr, are not
+// tunable by default.
+var DefaultTunableAgentSettings = []string{
+	"container_pipe_size",
+	"guest_components_procs",
+	"guest_components_rest_api",
+	"hotplug_timeout",
+	"image_policy_file",
+	"log_level",
+	"unified_cgroup_hierarchy",
+}
+
+// ValidateAgentConfig returns an error if agentConfig has a setting that is not in tunableSettings
+func ValidateAgentConfig(agentConfig map[string]interface{}, tunableSettings []string) error {
+
+	var rejected []string
+	for key := range agentConfig {
+		tunable := false
+		for _, setting := range tunableSettings {
+			if key == setting {
+				tunable = true
+				break
+			}
+		}
+		if !tunable {
+			rejected = append(rejected, key)
+		}
+	}
+
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return fmt.Errorf("agent settings %s are not tunable", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
 type Config struct {
 	PodNetwork   *tunneler.Config `json:"pod-network"`
 	PodNamespace string           `json:"pod-namespace"`
 	PodName      string           `json:"pod-name"`
+	SandboxID    string           `json:"sandbox-id,omitempty"`
 
 	TLSServerKey  string `json:"tls-server-key,omitempty"`
 	TLSServerCert string `json:"tls-server-cert,omitempty"`
@@ -46,6 +100,23 @@ type Config struct {
 	AAKBCParams string `json:"aa-kbc-params,omitempty"`
 
 	AuthJson string `json:"auth-json,omitempty"`
+
+	AgentPolicy string `json:"agent-policy,omitempty"`
+
+	// ImageAgentPolicy is an agent policy baked into the pod VM image. It is enforced in addition to AgentPolicy,
+	// so that a worker node cannot weaken it by omitting AgentPolicy from the user data.
+	ImageAgentPolicy string `json:"-"`
+
+	// RenewedCertPath is a file where a server certificate renewed by cloud-api-adaptor is stored, so that
+	// the pod VM keeps using it after agent-protocol-forwarder restarts instead of the one in the user data.
+	RenewedCertPath string `json:"-"`
+
+	// AgentConfig holds kata agent settings that are merged into the agent config file in the pod VM.
+	// A nested map corresponds to a TOML table.
+	AgentConfig map[string]interface{} `json:"agent-config,omitempty"`
+
+	// Attestation requires the forwarder to provide TEE evidence on each connection
+	Attestation bool `json:"attestation,omitempty"`
 }
 
 type Daemon interface {
@@ -62,14 +133,36 @@ type daemon struct {
 	readyCh     chan struct{}
 	stopCh      chan struct{}
 	listenAddr  string
+	agentPolicy string
+	imagePolicy string
+	auditSink   agentproto.AuditSink
+	attestation bool
+	attester    attestation.Attester
+	sandbox     agentproto.Sandbox
+	certPath    string
 	stopOnce    sync.Once
 }
 
-func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, interceptor interceptor.Interceptor, podNode podnetwork.PodNode) Daemon {
+func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, interceptor interceptor.Interceptor, podNode podnetwork.PodNode, auditSink agentproto.AuditSink, attester attestation.Attester) Daemon {
+
+	// A renewed certificate is persisted only when the server certificate comes from the user data
+	var certPath string
 
 	if tlsConfig != nil && !tlsConfig.HasCertAuth() {
 		tlsConfig.CertData = []byte(spec.TLSServerCert)
 		tlsConfig.KeyData = []byte(spec.TLSServerKey)
+		certPath = spec.RenewedCertPath
+
+		if certPath != "" {
+			pemData, err := loadRenewedCertificate(certPath, tlsConfig.CertData, tlsConfig.KeyData)
+			if err != nil {
+				logger.Printf("ignoring renewed server certificate %s: %v", certPath, err)
+			} else if pemData != nil {
+				logger.Printf("using renewed server certificate %s", certPath)
+				tlsConfig.CertData = pemData
+				tlsConfig.KeyData = pemData
+			}
+		}
 	}
 
 	if tlsConfig != nil && !tlsConfig.HasCA() {
@@ -81,6 +174,13 @@ func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, in
 		tlsConfig:   tlsConfig,
 		interceptor: interceptor,
 		podNode:     podNode,
+		agentPolicy: spec.AgentPolicy,
+		imagePolicy: spec.ImageAgentPolicy,
+		auditSink:   auditSink,
+		attestation: spec.Attestation,
+		attester:    attester,
+		sandbox:     agentproto.Sandbox{ID: spec.SandboxID, PodName: spec.PodName, PodNamespace: spec.PodNamespace},
+		certPath:    certPath,
 		readyCh:     make(chan struct{}),
 		stopCh:      make(chan struct{}),
 	}
@@ -90,6 +190,41 @@ func NewDaemon(spec *Config, listenAddr string, tlsConfig *tlsutil.TLSConfig, in
 
 func (d *daemon) Start(ctx context.Context) error {
 
+	// Agent policy is enforced in the pod VM, so that the worker node cannot bypass it.
+	// An invalid policy prevents the daemon from starting instead of silently allowing all calls.
+	// The policy of the pod VM image cannot be changed by the worker node, and the policy of the
+	// user data can only restrict calls further.
+	var policies []agentproto.Policy
+	for _, p := range []struct{ source, doc string }{
+		{"pod VM image", d.imagePolicy},
+		{"user data", d.agentPolicy},
+	} {
+		if p.doc == "" {
+			continue
+		}
+		policy, err := agentproto.ParsePolicy(p.doc)
+		if err != nil {
+			return fmt.Errorf("agent policy of %s: %w", p.source, err)
+		}
+		policies = append(policies, policy)
+		logger.Printf("agent policy of %s is enabled", p.source)
+	}
+	if d.sandbox.ID != "" {
+		policies = append(policies, agentproto.NewSandboxPolicy(d.sandbox.ID))
+	}
+	policy := agentproto.CombinePolicies(policies...)
+
+	// Attestation cannot be satisfied without TLS and an attester, so the daemon fails to start
+	if d.attestation {
+		if d.tlsConfig == nil {
+			return errors.New("attestation is required, but TLS is disabled")
+		}
+		if d.attester == nil {
+			return errors.New("attestation is required, but no attester is configured")
+		}
+		logger.Printf("attestation is enabled")
+	}
+
 	// Set up pod network
 
 	if err := d.podNode.Setup(); err != nil {
@@ -104,6 +239,7 @@ func (d *daemon) Start(ctx context.Context) error {
 	// Set up agent protocol interceptor
 
 	var listener net.Listener
+	peerPodService := &peerPodService{certPath: d.certPath}
 
 	logger.Printf("Starting agent-protocol-forwarder listener on address %v", d.listenAddr)
 	if d.tlsConfig != nil {
@@ -115,6 +251,15 @@ func (d *daemon) Start(ctx context.Context) error {
 			return fmt.Errorf("Failed to create tls config: %v", err)
 		}
 
+		// The server certificate is short-lived, and cloud-api-adaptor sends a renewed one before it expires.
+		// The renewed one is stored in certPath, so that it is used again after restart.
+		if len(tlsConfig.Certificates) > 0 {
+			peerPodService.certReloader, err = tlsutil.NewCertificateReloader(tlsConfig)
+			if err != nil {
+				return fmt.Errorf("failed to set up server certificate reloader: %w", err)
+			}
+		}
+
 		listener, err = tls.Listen("tcp", d.listenAddr, tlsConfig)
 		if err != nil {
 			logger.Printf("failed to create tls agent-protocol-forwarder listener: %v", err)
@@ -130,9 +275,13 @@ func (d *daemon) Start(ctx context.Context) error {
 		}
 	}
 
+	if d.attestation {
+		listener = attestation.NewListener(listener, d.attester)
+	}
+
 	d.listenAddr = listener.Addr().String()
 
-	ttrpcServer, err := ttrpc.NewServer()
+	ttrpcServer, err := ttrpc.NewServer(agentproto.ServerOpts(policy, d.auditSink, "forwarder", d.sandbox)...)
 	if err != nil {
 		return fmt.Errorf("failed to create TTRPC server: %w", err)
 	}
@@ -140,6 +289,7 @@ func (d *daemon) Start(ctx context.Context) error {
 	pb.RegisterAgentServiceService(ttrpcServer, d.interceptor)
 	pb.RegisterImageService(ttrpcServer, d.interceptor)
 	pb.RegisterHealthService(ttrpcServer, d.interceptor)
+	agentproto.RegisterPeerPodService(ttrpcServer, peerPodService)
 
 	ttrpcServerErr := make(chan error)
 	go func() {
@@ -186,3 +336,93 @@ func (d *daemon) Addr() string {
 	<-d.readyCh
 	return d.listenAddr
 }
+
+// peerPodService implements agentproto.PeerPodService
+type peerPodService struct {
+	certReloader *tlsutil.CertificateReloader
+	certPath     string
+}
+
+func (s *peerPodService) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+
+	if s.certReloader == nil {
+		return errors.New("TLS server certificate is not configured")
+	}
+
+	if err := s.certReloader.Update(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("failed to update server certificate: %w", err)
+	}
+
+	logger.Printf("server certificate is updated")
+
+	// The listener already uses the new certificate, so a failure to store it is not returned to cloud-api-adaptor.
+	// It only means that the pod VM falls back to the certificate of the user data after restart.
+	if s.certPath != "" {
+		if err := storeRenewedCertificate(s.certPath, certPEM, keyPEM); err != nil {
+			logger.Printf("failed to store renewed server certificate: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// loadRenewedCertificate returns the content of a renewed certificate file at path, which contains both
+// a certificate and its private key. It returns nil if the file does not exist, or if its certificate does not
+// expire later than certPEM, e.g. when it was left by a previous boot with older user data.
+func loadRenewedCertificate(path string, certPEM, keyPEM []byte) ([]byte, error) {
+
+	pemData, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	renewed, err := parseCertificate(pemData, pemData)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(renewed.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", renewed.NotAfter)
+	}
+
+	current, err := parseCertificate(certPEM, keyPEM)
+	if err == nil && !renewed.NotAfter.After(current.NotAfter) {
+		return nil, nil
+	}
+
+	return pemData, nil
+}
+
+// storeRenewedCertificate atomically writes a certificate and its private key to path
+func storeRenewedCertificate(path string, certPEM, keyPEM []byte) error {
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(append([]byte{}, certPEM...), keyPEM...)); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", file.Name(), err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", file.Name(), path, err)
+	}
+
+	return nil
+}
+
+func parseCertificate(certPEM, keyPEM []byte) (*x509.Certificate, error) {
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(pair.Certificate[0])
+}
@@ -4,8 +4,11 @@
 package forwarder
 
 import (
+	"bytes"
 	"context"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -33,7 +36,7 @@ func TestNew(t *testing.T) {
 	config := &Config{}
 	tlsConfig := tlsutil.TLSConfig{}
 
-	ret := NewDaemon(config, DefaultListenAddr, &tlsConfig, agentproto.NewRedirector(dummyDialer), &mockPodNode{})
+	ret := NewDaemon(config, DefaultListenAddr, &tlsConfig, agentproto.NewRedirector(dummyDialer), &mockPodNode{}, nil, nil)
 	if ret == nil {
 		t.Fatal("Expect non nil, got nil")
 	}
@@ -91,6 +94,74 @@ func TestStart(t *testing.T) {
 	}
 }
 
+func TestStartInvalidImagePolicy(t *testing.T) {
+
+	d := NewDaemon(&Config{ImageAgentPolicy: `{"default": "unknown"}`}, "127.0.0.1:0", nil, agentproto.NewRedirector(dummyDialer), &mockPodNode{}, nil, nil)
+
+	// An invalid policy of the pod VM image prevents the daemon from starting, even if the user data has no policy
+	if err := d.Start(context.Background()); err == nil {
+		t.Fatal("Expect an error, got nil")
+	}
+}
+
+func TestRenewedCertificate(t *testing.T) {
+
+	caService, err := tlsutil.NewCAService("agent-protocol-forwarder", time.Hour)
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	certPEM, keyPEM, err := caService.Issue("server1")
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+
+	certPath := filepath.Join(t.TempDir(), "tls-server-renewed.pem")
+	config := &Config{TLSServerCert: string(certPEM), TLSServerKey: string(keyPEM), RenewedCertPath: certPath}
+
+	// The certificate of the user data is used when no certificate is renewed
+	tlsConfig := &tlsutil.TLSConfig{}
+	NewDaemon(config, DefaultListenAddr, tlsConfig, agentproto.NewRedirector(dummyDialer), &mockPodNode{}, nil, nil)
+	if !bytes.Equal(tlsConfig.CertData, certPEM) {
+		t.Fatal("Expect the certificate of the user data, got another one")
+	}
+
+	// A renewed certificate is stored
+	time.Sleep(time.Second)
+	renewedCertPEM, renewedKeyPEM, err := caService.Issue("server1")
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	s := &peerPodService{certReloader: &tlsutil.CertificateReloader{}, certPath: certPath}
+	if err := s.UpdateServerCertificate(context.Background(), renewedCertPEM, renewedKeyPEM); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	info, err := os.Stat(certPath)
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expect 0600, got %o", info.Mode().Perm())
+	}
+
+	// The renewed certificate is used after restart
+	tlsConfig = &tlsutil.TLSConfig{}
+	NewDaemon(config, DefaultListenAddr, tlsConfig, agentproto.NewRedirector(dummyDialer), &mockPodNode{}, nil, nil)
+	if !bytes.HasPrefix(tlsConfig.CertData, renewedCertPEM) {
+		t.Fatal("Expect the renewed certificate, got another one")
+	}
+	if _, err := tlsutil.GetTLSConfigFor(tlsConfig); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+
+	// A renewed certificate that does not expire later than the one of the user data is ignored
+	config.TLSServerCert, config.TLSServerKey = string(renewedCertPEM), string(renewedKeyPEM)
+	tlsConfig = &tlsutil.TLSConfig{}
+	NewDaemon(config, DefaultListenAddr, tlsConfig, agentproto.NewRedirector(dummyDialer), &mockPodNode{}, nil, nil)
+	if !bytes.Equal(tlsConfig.CertData, renewedCertPEM) {
+		t.Fatal("Expect the certificate of the user data, got another one")
+	}
+}
+
 type mockPodNode struct{}
 
 func (n *mockPodNode) Setup() error {
@@ -100,3 +171,26 @@ func (n *mockPodNode) Setup() error {
 func (n *mockPodNode) Teardown() error {
 	return nil
 }
+
+func TestValidateAgentConfig(t *testing.T) {
+
+	if err := ValidateAgentConfig(map[string]interface{}{"log_level": "debug", "hotplug_timeout": 5, "guest_components_procs": "none"}, DefaultTunableAgentSettings); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	err := ValidateAgentConfig(map[string]interface{}{"log_level": "debug", "server_addr": "", "endpoints": map[string]interface{}{}}, DefaultTunableAgentSettings)
+	if err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+	if e, a := "agent settings endpoints, server_addr are not tunable", err.Error(); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	// A deployment can configure the tunable settings
+	if err := ValidateAgentConfig(map[string]interface{}{"server_addr": ""}, []string{"server_addr"}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := ValidateAgentConfig(map[string]interface{}{"log_level": "debug"}, nil); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go/v4"
@@ -25,6 +27,11 @@ const (
 	volumeTargetPathKey = "io.confidentialcontainers.org.peerpodvolumes.target_path"
 	volumeCheckInterval = 5 * time.Second
 	volumeCheckTimeout  = 3 * time.Minute
+
+	// DefaultSandboxDir is a directory to store per-sandbox files on the peer pod VM
+	DefaultSandboxDir = "/run/peerpod/sandbox"
+
+	resolvConfPath = "/etc/resolv.conf"
 )
 
 var logger = log.New(log.Writer(), "[forwarder/interceptor] ", log.LstdFlags|log.Lmsgprefix)
@@ -36,7 +43,11 @@ type Interceptor interface {
 type interceptor struct {
 	agentproto.Redirector
 
-	nsPath string
+	nsPath     string
+	sandboxDir string
+
+	mutex      sync.Mutex
+	resolvConf string
 }
 
 func dial(ctx context.Context, agentSocket string) (net.Conn, error) {
@@ -77,6 +88,7 @@ func NewInterceptor(agentSocket, nsPath string) Interceptor {
 	return &interceptor{
 		Redirector: redirector,
 		nsPath:     nsPath,
+		sandboxDir: DefaultSandboxDir,
 	}
 }
 
@@ -95,6 +107,15 @@ func (i *interceptor) CreateContainer(ctx context.Context, req *pb.CreateContain
 		logger.Printf("    %s: %q", ns.Type, ns.Path)
 	}
 
+	i.mutex.Lock()
+	resolvConf := i.resolvConf
+	i.mutex.Unlock()
+
+	if resolvConf != "" {
+		// Use the resolv.conf of the sandbox instead of the one copied from the worker node
+		replaceResolvConfMount(req.OCI.Mounts, resolvConf)
+	}
+
 	volumeTargetPath := req.OCI.Annotations[volumeTargetPathKey]
 	volumeTargetPathSlice := strings.Split(volumeTargetPath, ",")
 	if len(req.OCI.Mounts) > 0 {
@@ -202,9 +223,27 @@ func (i *interceptor) CreateSandbox(ctx context.Context, req *pb.CreateSandboxRe
 			logger.Printf("        %s", d)
 		}
 
-		logger.Print("      Eliminated the DNS setting above from CreateSandboxRequest to stop updating /etc/resolv.conf on the peer pod VM")
-		logger.Print("      See https://github.com/confidential-containers/cloud-api-adaptor/issues/98 for the details.")
-		logger.Println()
+		// The kata agent applies the DNS setting to /etc/resolv.conf of the peer pod VM, which breaks name resolution
+		// of the pod VM itself, since the cluster DNS is only reachable from the pod network namespace.
+		// See https://github.com/confidential-containers/cloud-api-adaptor/issues/98 for the details.
+		// Instead, write a resolv.conf for the sandbox, and bind mount it to containers at CreateContainer.
+		if req.SandboxId == "" || req.SandboxId != filepath.Base(req.SandboxId) || req.SandboxId == ".." {
+			err := fmt.Errorf("invalid sandbox ID: %q", req.SandboxId)
+			logger.Printf("CreateSandbox failed with error: %v", err)
+			return nil, err
+		}
+
+		path, err := writeResolvConf(filepath.Join(i.sandboxDir, req.SandboxId), req.Dns)
+		if err != nil {
+			logger.Printf("CreateSandbox failed to write resolv.conf: %v", err)
+			return nil, err
+		}
+		logger.Printf("      Wrote the DNS setting above to %s for containers instead of /etc/resolv.conf on the peer pod VM", path)
+
+		i.mutex.Lock()
+		i.resolvConf = path
+		i.mutex.Unlock()
+
 		req.Dns = nil
 	}
 
@@ -225,11 +264,86 @@ func (i *interceptor) DestroySandbox(ctx context.Context, req *pb.DestroySandbox
 
 	if err != nil {
 		logger.Printf("DestroySandbox failed with error: %v", err)
+		return res, err
+	}
+
+	i.mutex.Lock()
+	resolvConf := i.resolvConf
+	i.resolvConf = ""
+	i.mutex.Unlock()
+
+	if resolvConf != "" {
+		if err := os.RemoveAll(filepath.Dir(resolvConf)); err != nil {
+			logger.Printf("Failed to remove %s: %v", filepath.Dir(resolvConf), err)
+		}
 	}
 
 	return res, err
 }
 
+// writeResolvConf writes a resolv.conf in dir from DNS setting lines of a CreateSandboxRequest.
+// The DNS setting is generated by kubelet from dnsPolicy and dnsConfig of a pod spec.
+// Lines other than resolver directives are dropped.
+func writeResolvConf(dir string, dns []string) (string, error) {
+
+	var content strings.Builder
+
+	for _, line := range dns {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		switch strings.Fields(line)[0] {
+		case "nameserver", "search", "domain", "options", "sortlist":
+			content.WriteString(line + "\n")
+		default:
+			if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+				logger.Printf("      Ignored an unknown DNS setting: %q", line)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	path := filepath.Join(dir, "resolv.conf")
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// replaceResolvConfMount changes the source of the /etc/resolv.conf mount of a container to path.
+// A container without the /etc/resolv.conf mount, e.g. a container whose resolv.conf is managed
+// by the container image, is left unchanged.
+func replaceResolvConfMount(mounts []pb.Mount, path string) {
+
+	for j := range mounts {
+		m := &mounts[j]
+		if m.Destination != resolvConfPath {
+			continue
+		}
+
+		logger.Printf("    mount %s: %q -> %q", m.Destination, m.Source, path)
+
+		m.Source = path
+		m.Type = "bind"
+
+		hasBind := false
+		for _, o := range m.Options {
+			if o == "bind" || o == "rbind" {
+				hasBind = true
+			}
+		}
+		if !hasBind {
+			m.Options = append(m.Options, "rbind")
+		}
+	}
+}
+
 func (i *interceptor) PullImage(ctx context.Context, req *pb.PullImageRequest) (*pb.PullImageResponse, error) {
 
 	logger.Printf("PullImage: image: %q, containerID: %q", req.Image, req.ContainerId)
@@ -4,11 +4,41 @@
 package interceptor
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/gogo/protobuf/types"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/agentproto"
 )
 
+// redirectorMock records requests that an interceptor passes to the kata agent
+type redirectorMock struct {
+	agentproto.Redirector
+
+	createSandbox   *pb.CreateSandboxRequest
+	createContainer *pb.CreateContainerRequest
+}
+
+func (m *redirectorMock) CreateSandbox(ctx context.Context, req *pb.CreateSandboxRequest) (*types.Empty, error) {
+	m.createSandbox = req
+	return &types.Empty{}, nil
+}
+
+func (m *redirectorMock) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (*types.Empty, error) {
+	m.createContainer = req
+	return &types.Empty{}, nil
+}
+
+func (m *redirectorMock) DestroySandbox(ctx context.Context, req *pb.DestroySandboxRequest) (*types.Empty, error) {
+	return &types.Empty{}, nil
+}
+
 func TestNewInterceptor(t *testing.T) {
 
 	socketName := "dummy.sock"
@@ -27,3 +57,104 @@ func TestIsTargetPath(t *testing.T) {
 	assert.False(t, isTargetPath(path, "mock path"))
 	assert.True(t, isTargetPath(path, "/path/to/target"))
 }
+
+func TestWriteResolvConf(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "sandbox")
+
+	dns := []string{
+		"search default.svc.cluster.local svc.cluster.local cluster.local",
+		"nameserver 10.96.0.10",
+		"",
+		"# comment",
+		"unknown directive",
+		"options ndots:5",
+	}
+
+	path, err := writeResolvConf(dir, dns)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "resolv.conf"), path)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "search default.svc.cluster.local svc.cluster.local cluster.local\nnameserver 10.96.0.10\noptions ndots:5\n", string(content))
+}
+
+func TestReplaceResolvConfMount(t *testing.T) {
+
+	mounts := []pb.Mount{
+		{Destination: "/etc/hosts", Source: "/run/kata-containers/shared/containers/abc-hosts", Type: "bind", Options: []string{"rbind", "rw"}},
+		{Destination: "/etc/resolv.conf", Source: "/run/kata-containers/shared/containers/abc-resolv.conf", Type: "bind", Options: []string{"ro"}},
+	}
+
+	replaceResolvConfMount(mounts, "/run/peerpod/sandbox/abc/resolv.conf")
+
+	assert.Equal(t, "/run/kata-containers/shared/containers/abc-hosts", mounts[0].Source)
+	assert.Equal(t, "/run/peerpod/sandbox/abc/resolv.conf", mounts[1].Source)
+	assert.Equal(t, "bind", mounts[1].Type)
+	assert.Equal(t, []string{"ro", "rbind"}, mounts[1].Options)
+}
+
+func TestCreateSandboxInvalidID(t *testing.T) {
+
+	ctx := context.Background()
+	sandboxDir := filepath.Join(t.TempDir(), "sandbox")
+
+	for _, sandboxID := range []string{"", "..", "../abc", "abc/def", "/abc"} {
+		mock := &redirectorMock{}
+		i := &interceptor{Redirector: mock, sandboxDir: sandboxDir}
+
+		_, err := i.CreateSandbox(ctx, &pb.CreateSandboxRequest{SandboxId: sandboxID, Dns: []string{"nameserver 10.96.0.10"}})
+		assert.Error(t, err, "sandbox ID %q", sandboxID)
+		assert.Nil(t, mock.createSandbox, "sandbox ID %q", sandboxID)
+	}
+
+	// Nothing is written outside the sandbox directory
+	_, err := os.Stat(filepath.Dir(sandboxDir))
+	require.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Dir(sandboxDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCreateSandboxResolvConf(t *testing.T) {
+
+	ctx := context.Background()
+	sandboxDir := t.TempDir()
+
+	mock := &redirectorMock{}
+	i := &interceptor{Redirector: mock, sandboxDir: sandboxDir}
+
+	_, err := i.CreateSandbox(ctx, &pb.CreateSandboxRequest{SandboxId: "abc", Dns: []string{"nameserver 10.96.0.10"}})
+	require.NoError(t, err)
+
+	// The DNS setting is not applied to the pod VM
+	require.NotNil(t, mock.createSandbox)
+	assert.Empty(t, mock.createSandbox.Dns)
+
+	resolvConf := filepath.Join(sandboxDir, "abc", "resolv.conf")
+	content, err := os.ReadFile(resolvConf)
+	require.NoError(t, err)
+	assert.Equal(t, "nameserver 10.96.0.10\n", string(content))
+
+	// The resolv.conf of the sandbox is mounted to containers
+	_, err = i.CreateContainer(ctx, &pb.CreateContainerRequest{
+		ContainerId: "123",
+		OCI: &pb.Spec{
+			Linux: &pb.Linux{},
+			Mounts: []pb.Mount{
+				{Destination: "/etc/resolv.conf", Source: "/run/kata-containers/shared/containers/123-resolv.conf", Type: "bind", Options: []string{"ro"}},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, mock.createContainer)
+	assert.Equal(t, resolvConf, mock.createContainer.OCI.Mounts[0].Source)
+
+	// The resolv.conf is removed with the sandbox
+	_, err = i.DestroySandbox(ctx, &pb.DestroySandboxRequest{})
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(sandboxDir, "abc"))
+	assert.True(t, os.IsNotExist(err))
+}
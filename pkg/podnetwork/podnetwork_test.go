@@ -14,6 +14,7 @@ import (
 	testutils "github.com/confidential-containers/cloud-api-adaptor/pkg/internal/testing"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
 	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tuntest"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
 )
 
 type mockWorkerNodeTunneler struct{}
@@ -221,3 +222,64 @@ func TestPluginDetectHostInterface(t *testing.T) {
 		t.Fatalf("Expect %q, got %q", e, a)
 	}
 }
+
+func TestFindSecondaryInterfaces(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	podNS := tuntest.NewNamedNS(t, "test-pod")
+	defer tuntest.DeleteNamedNS(t, podNS)
+
+	tuntest.BridgeAdd(t, podNS, "eth0")
+	tuntest.AddrAdd(t, podNS, "eth0", "172.16.0.2/24")
+	tuntest.BridgeAdd(t, podNS, "net1")
+	tuntest.AddrAdd(t, podNS, "net1", "10.129.0.2/24")
+	tuntest.HwAddrAdd(t, podNS, "net1", "0a:58:0a:85:03:ce")
+	// An interface without an IPv4 address is ignored
+	tuntest.BridgeAdd(t, podNS, "net2")
+
+	interfaces, err := findSecondaryInterfaces(podNS, "eth0")
+	require.Nil(t, err)
+	require.Len(t, interfaces, 1)
+
+	require.Equal(t, "net1", interfaces[0].Name)
+	require.Equal(t, "10.129.0.2/24", interfaces[0].PodIP.String())
+	require.Equal(t, "0a:58:0a:85:03:ce", interfaces[0].PodHwAddr)
+	require.Equal(t, 1500, interfaces[0].MTU)
+}
+
+func TestPartitionRoutes(t *testing.T) {
+
+	net1 := &tunneler.Interface{Name: "net1"}
+
+	routes := []*netops.Route{
+		{Gateway: netip.MustParseAddr("172.16.0.1"), Device: "eth0"},
+		{Destination: netip.MustParsePrefix("10.129.0.0/24"), Device: "net1"},
+		{Destination: netip.MustParsePrefix("10.130.0.0/16"), Gateway: netip.MustParseAddr("10.129.0.1"), Device: "net1"},
+	}
+
+	primaryRoutes := partitionRoutes(routes, []*tunneler.Interface{net1})
+
+	require.Equal(t, []*tunneler.Route{
+		{GW: netip.MustParseAddr("172.16.0.1"), Dev: "eth0"},
+	}, primaryRoutes)
+	require.Equal(t, []*tunneler.Route{
+		{Dst: netip.MustParsePrefix("10.129.0.0/24"), Dev: "net1"},
+		{Dst: netip.MustParsePrefix("10.130.0.0/16"), GW: netip.MustParseAddr("10.129.0.1"), Dev: "net1"},
+	}, net1.Routes)
+}
+
+func TestSecondaryVXLANID(t *testing.T) {
+
+	index := podIndexManager.Get()
+
+	id1 := maxVXLANID - secondaryVXLANIndexManager.Get()
+	id2 := maxVXLANID - secondaryVXLANIndexManager.Get()
+
+	if id1 == id2 {
+		t.Fatalf("Expect distinct VXLAN IDs, got %d twice", id1)
+	}
+	// Secondary VXLAN IDs do not consume pod indexes
+	if e, a := index+1, podIndexManager.Get(); e != a {
+		t.Fatalf("Expect pod index %d, got %d", e, a)
+	}
+}
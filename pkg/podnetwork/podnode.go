@@ -91,8 +91,10 @@ func (n *podNode) Setup() error {
 		}
 	}()
 
-	if err := tun.Setup(n.nsPath, podNodeIPs, n.config); err != nil {
-		return fmt.Errorf("failed to set up tunnel %q: %w", n.config.TunnelType, err)
+	for _, config := range n.config.InterfaceConfigs() {
+		if err := tun.Setup(n.nsPath, podNodeIPs, config); err != nil {
+			return fmt.Errorf("failed to set up tunnel %q for %s: %w", config.TunnelType, config.InterfaceName, err)
+		}
 	}
 
 	return nil
@@ -124,8 +126,10 @@ func (n *podNode) Teardown() error {
 		hostInterface = hostPrimaryInterface
 	}
 
-	if err := tun.Teardown(n.nsPath, hostInterface, n.config); err != nil {
-		return fmt.Errorf("failed to tear down tunnel %q: %w", n.config.TunnelType, err)
+	for _, config := range n.config.InterfaceConfigs() {
+		if err := tun.Teardown(n.nsPath, hostInterface, config); err != nil {
+			return fmt.Errorf("failed to tear down tunnel %q for %s: %w", config.TunnelType, config.InterfaceName, err)
+		}
 	}
 
 	return nil
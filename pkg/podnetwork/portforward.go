@@ -0,0 +1,301 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/firewall"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+	"github.com/coreos/go-iptables/iptables"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	portForwardRuleComment = "peerpod-portforward"
+
+	portForwardNftTableFamily = "ip"
+	portForwardNftTableName   = "peerpod_portforward"
+)
+
+// PortForwarder relays TCP connections made to the loopback address of a pod
+// network namespace on the worker node to the pod IP address.
+//
+// CRI runtimes implement PortForward (used by kubectl port-forward) by
+// connecting to localhost:<port> in the pod network namespace. The pod
+// of a peer pod runs in a pod VM, so nothing listens on the loopback
+// interface of the pod network namespace on the worker node. The port
+// forwarder redirects these connections to its own listener by using an
+// iptables or nftables REDIRECT rule, and forwards them to the pod IP address
+// from the host network namespace, which is routed to the pod VM through the tunnel.
+type PortForwarder interface {
+	Close() error
+}
+
+type portForwarder struct {
+	ns       netops.Namespace
+	listener net.Listener
+	podIP    netip.Addr
+	backend  firewall.Backend
+	port     int
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// StartPortForwarder starts a port forwarder on the specified pod network namespace
+func StartPortForwarder(nsPath string, podIP netip.Addr) (PortForwarder, error) {
+	return startPortForwarder(nsPath, podIP, (&net.Dialer{}).DialContext)
+}
+
+func startPortForwarder(nsPath string, podIP netip.Addr, dial func(ctx context.Context, network, address string) (net.Conn, error)) (*portForwarder, error) {
+
+	ns, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace %q: %w", nsPath, err)
+	}
+
+	backend, err := firewall.Detect(ns)
+	if err != nil {
+		ns.Close()
+		return nil, fmt.Errorf("failed to detect firewall backend on netns %s: %w", nsPath, err)
+	}
+
+	f := &portForwarder{
+		ns:      ns,
+		podIP:   podIP,
+		backend: backend,
+		dial:    dial,
+	}
+
+	if err := ns.Run(func() error {
+
+		listener, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("failed to listen on the loopback interface: %w", err)
+		}
+		f.listener = listener
+		f.port = listener.Addr().(*net.TCPAddr).Port
+
+		return f.addRedirect()
+	}); err != nil {
+		if f.listener != nil {
+			f.listener.Close()
+		}
+		ns.Close()
+		return nil, err
+	}
+
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	f.wg.Add(1)
+	go f.serve()
+
+	logger.Printf("port forwarder started on netns %s (%s -> %s)", nsPath, f.listener.Addr(), podIP)
+
+	return f, nil
+}
+
+// iptablesRule returns the iptables rule in the OUTPUT chain of the nat table, which redirects connections to the
+// loopback address to the listener
+func (f *portForwarder) iptablesRule() []string {
+	port := strconv.Itoa(f.port)
+	return []string{"-o", "lo", "-p", "tcp", "-d", "127.0.0.1/32", "!", "--dport", port, "-m", "comment", "--comment", portForwardRuleComment, "-j", "REDIRECT", "--to-ports", port}
+}
+
+// nftRuleset returns an nft script that atomically replaces the content of the port forwarding table
+func (f *portForwarder) nftRuleset() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s\n", portForwardNftTableFamily, portForwardNftTableName)
+	fmt.Fprintf(&b, "flush table %s %s\n", portForwardNftTableFamily, portForwardNftTableName)
+	fmt.Fprintf(&b, "table %s %s {\n", portForwardNftTableFamily, portForwardNftTableName)
+	fmt.Fprintf(&b, "\tchain output {\n")
+	fmt.Fprintf(&b, "\t\ttype nat hook output priority dstnat; policy accept;\n")
+	fmt.Fprintf(&b, "\t\toifname \"lo\" ip daddr 127.0.0.1 tcp dport != %d redirect to :%d comment %q\n", f.port, f.port, portForwardRuleComment)
+	fmt.Fprintf(&b, "\t}\n")
+	fmt.Fprintf(&b, "}\n")
+
+	return b.String()
+}
+
+// addRedirect installs the rule that redirects connections to the loopback address to the listener.
+// It runs in the pod network namespace.
+func (f *portForwarder) addRedirect() error {
+
+	switch f.backend {
+	case firewall.NFTables:
+		if err := firewall.RunNft(f.nftRuleset()); err != nil {
+			return fmt.Errorf("failed to add nftables rule to redirect loopback connections to port %d: %w", f.port, err)
+		}
+		return nil
+	default:
+		ipt, err := iptables.New(iptables.IPFamily(iptables.ProtocolIPv4))
+		if err != nil {
+			return fmt.Errorf("failed to initialize iptables: %w", err)
+		}
+		if err := ipt.AppendUnique("nat", "OUTPUT", f.iptablesRule()...); err != nil {
+			return fmt.Errorf("failed to add iptables rule to redirect loopback connections to port %d: %w", f.port, err)
+		}
+		return nil
+	}
+}
+
+// deleteRedirect deletes the rule installed by addRedirect. It runs in the pod network namespace.
+func (f *portForwarder) deleteRedirect() error {
+
+	switch f.backend {
+	case firewall.NFTables:
+		if err := firewall.DeleteNftTable(portForwardNftTableFamily, portForwardNftTableName); err != nil {
+			return fmt.Errorf("failed to delete nftables table %s %s: %w", portForwardNftTableFamily, portForwardNftTableName, err)
+		}
+		return nil
+	default:
+		ipt, err := iptables.New(iptables.IPFamily(iptables.ProtocolIPv4))
+		if err != nil {
+			return fmt.Errorf("failed to initialize iptables: %w", err)
+		}
+		if err := ipt.DeleteIfExists("nat", "OUTPUT", f.iptablesRule()...); err != nil {
+			return fmt.Errorf("failed to delete iptables rule: %w", err)
+		}
+		return nil
+	}
+}
+
+func (f *portForwarder) serve() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Printf("port forwarder on netns %s failed to accept a connection: %v", f.ns.Path(), err)
+			}
+			return
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+
+			if err := f.forward(conn); err != nil {
+				logger.Printf("port forwarder on netns %s: %v", f.ns.Path(), err)
+			}
+		}()
+	}
+}
+
+func (f *portForwarder) forward(conn net.Conn) error {
+	defer conn.Close()
+
+	port, err := originalDestinationPort(conn)
+	if err != nil {
+		return err
+	}
+
+	address := netip.AddrPortFrom(f.podIP, port).String()
+
+	upstream, err := f.dial(f.ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer upstream.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(upstream, conn)
+		closeWrite(upstream)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, upstream)
+		closeWrite(conn)
+		errCh <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("failed to relay a connection to %s: %w", address, err)
+			}
+		case <-f.ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite() //nolint:errcheck
+	}
+}
+
+// originalDestinationPort returns the destination port of a connection before it was redirected
+func originalDestinationPort(conn net.Conn) (uint16, error) {
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get a raw connection: %w", err)
+	}
+
+	var port uint16
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		// SO_ORIGINAL_DST returns struct sockaddr_in, which fits in the buffer of IPv6Mreq
+		mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		port = uint16(mreq.Multiaddr[2])<<8 | uint16(mreq.Multiaddr[3])
+	}); err != nil {
+		return 0, fmt.Errorf("failed to access a raw connection: %w", err)
+	}
+	if sockErr != nil {
+		return 0, fmt.Errorf("failed to get the original destination of a connection: %w", sockErr)
+	}
+
+	return port, nil
+}
+
+func (f *portForwarder) Close() error {
+
+	f.cancel()
+
+	var errs []error
+
+	if err := f.listener.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close listener: %w", err))
+	}
+
+	if err := f.ns.Run(f.deleteRedirect); err != nil {
+		errs = append(errs, err)
+	}
+
+	f.wg.Wait()
+
+	if err := f.ns.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close network namespace: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,82 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package podnetwork
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"testing"
+
+	testutils "github.com/confidential-containers/cloud-api-adaptor/pkg/internal/testing"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tuntest"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/firewall"
+)
+
+func TestPortForwarder(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	_, iptErr := exec.LookPath(firewall.IPTablesCommand)
+	_, nftErr := exec.LookPath(firewall.NFTCommand)
+	if iptErr != nil && nftErr != nil {
+		t.Skip("neither iptables nor nft is available")
+	}
+
+	podNS := tuntest.NewNamedNS(t, "test-portforward-pod")
+	defer tuntest.DeleteNamedNS(t, podNS)
+
+	// podVMNS plays the role of the pod VM reached via the tunnel. The HTTP
+	// server listens on its loopback address, so the port forwarder dials
+	// 127.0.0.1 in podVMNS instead of a pod IP in the host network namespace.
+	podVMNS := tuntest.NewNamedNS(t, "test-portforward-podvm")
+	defer tuntest.DeleteNamedNS(t, podVMNS)
+
+	serverAddr := netip.MustParseAddrPort("127.0.0.1:8080")
+
+	httpServer := tuntest.StartHTTPServer(t, podVMNS, serverAddr)
+	defer httpServer.Shutdown(t)
+
+	dial := func(ctx context.Context, network, address string) (conn net.Conn, err error) {
+		if e := podVMNS.Run(func() error {
+			conn, err = (&net.Dialer{}).DialContext(ctx, network, address)
+			return nil
+		}); e != nil {
+			return nil, e
+		}
+		return conn, err
+	}
+
+	f, err := startPortForwarder(podNS.Path(), serverAddr.Addr(), dial)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	tuntest.ConnectToHTTPServer(t, podNS, serverAddr, netip.AddrPort{})
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+}
+
+func TestPortForwarderRules(t *testing.T) {
+
+	f := &portForwarder{port: 40000}
+
+	for _, line := range []string{
+		"table ip peerpod_portforward\n",
+		"flush table ip peerpod_portforward\n",
+		"type nat hook output priority dstnat; policy accept;\n",
+		"oifname \"lo\" ip daddr 127.0.0.1 tcp dport != 40000 redirect to :40000 comment \"peerpod-portforward\"\n",
+	} {
+		if ruleset := f.nftRuleset(); !strings.Contains(ruleset, line) {
+			t.Fatalf("Expect %q in ruleset, got %q", line, ruleset)
+		}
+	}
+
+	if e, a := "-o lo -p tcp -d 127.0.0.1/32 ! --dport 40000 -m comment --comment peerpod-portforward -j REDIRECT --to-ports 40000", strings.Join(f.iptablesRule(), " "); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
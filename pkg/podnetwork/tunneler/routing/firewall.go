@@ -0,0 +1,66 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package routing
+
+import (
+	"fmt"
+	"sync"
+
+	fwbackend "github.com/confidential-containers/cloud-api-adaptor/pkg/util/firewall"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+// firewall abstracts the packet filter rules that routing mode installs on a worker node
+type firewall interface {
+	// Name returns the name of the firewall backend
+	Name() string
+	// SetRules idempotently installs the rules that allow traffic between pod VMs and pod network namespaces
+	SetRules(ns netops.Namespace, hostInterface string) error
+	// DeleteRules idempotently removes all the rules installed by SetRules
+	DeleteRules(ns netops.Namespace) error
+}
+
+// detectBackend detects the firewall backend of the host, and can be overridden in unit tests
+var detectBackend = fwbackend.Detect
+
+var (
+	// hostMutex serializes Setup and Teardown on a worker node, since the routing rules and firewall rules
+	// on the host are shared by all pods in routing mode
+	hostMutex sync.Mutex
+
+	// hostFirewall is the firewall backend that was detected on the host by Setup, and is reused by Teardown,
+	// so that rules are deleted by the backend that installed them
+	hostFirewall firewall
+)
+
+// getHostFirewall returns the firewall backend of the host, which is detected only once.
+// The caller must hold hostMutex.
+func getHostFirewall(ns netops.Namespace) (firewall, error) {
+	if hostFirewall != nil {
+		return hostFirewall, nil
+	}
+	fw, err := detectFirewall(ns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect firewall backend on netns %s: %w", ns.Path(), err)
+	}
+	hostFirewall = fw
+	return fw, nil
+}
+
+// detectFirewall returns the firewall of the backend used on a host
+func detectFirewall(ns netops.Namespace) (firewall, error) {
+
+	backend, err := detectBackend(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case fwbackend.NFTables:
+		return &nftablesFirewall{}, nil
+	case fwbackend.IPTables:
+		return &iptablesFirewall{}, nil
+	}
+	return nil, fmt.Errorf("unsupported firewall backend %q", backend)
+}
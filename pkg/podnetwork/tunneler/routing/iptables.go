@@ -22,6 +22,21 @@ type iptablesRule struct {
 	spec  []string
 }
 
+// iptablesFirewall installs rules by using the iptables command
+type iptablesFirewall struct{}
+
+func (f *iptablesFirewall) Name() string {
+	return "iptables"
+}
+
+func (f *iptablesFirewall) SetRules(ns netops.Namespace, hostInterface string) error {
+	return setIPTablesRules(ns, hostInterface)
+}
+
+func (f *iptablesFirewall) DeleteRules(ns netops.Namespace) error {
+	return deleteIPTablesRules(ns)
+}
+
 func setIPTablesRules(ns netops.Namespace, hostInterface string) error {
 
 	var iptablesRules = []iptablesRule{
@@ -95,3 +110,48 @@ func setIPTablesRules(ns netops.Namespace, hostInterface string) error {
 		return nil
 	})
 }
+
+func deleteIPTablesRules(ns netops.Namespace) error {
+
+	var jumpRules = []iptablesRule{
+		{
+			table: "raw",
+			chain: "PREROUTING",
+			spec:  []string{"-j", chainName},
+		},
+		{
+			table: "filter",
+			chain: "FORWARD",
+			spec:  []string{"-j", chainName},
+		},
+	}
+
+	return ns.Run(func() error {
+
+		ipt, err := iptables.New(iptables.IPFamily(iptables.ProtocolIPv4))
+		if err != nil {
+			return fmt.Errorf("failed to initialize iptables: %w", err)
+		}
+
+		for _, rule := range jumpRules {
+
+			exists, err := ipt.ChainExists(rule.table, chainName)
+			if err != nil {
+				return fmt.Errorf("failed to check the existence of iptables chain %q: %w", chainName, err)
+			}
+			if !exists {
+				continue
+			}
+
+			if err := ipt.DeleteIfExists(rule.table, rule.chain, rule.spec...); err != nil {
+				return fmt.Errorf("failed to delete iptables rule \"-t %s -A %s %s\": %w", rule.table, rule.chain, strings.Join(rule.spec, " "), err)
+			}
+
+			if err := ipt.ClearAndDeleteChain(rule.table, chainName); err != nil {
+				return fmt.Errorf("failed to delete iptables chain %q in table %q: %w", chainName, rule.table, err)
+			}
+		}
+
+		return nil
+	})
+}
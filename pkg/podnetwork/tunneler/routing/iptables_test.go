@@ -95,4 +95,28 @@ func TestIPTables(t *testing.T) {
 	if err := setIPTablesRules(workerNS, hostInterface); err != nil {
 		t.Fatalf("Expect no error, got %q", err)
 	}
+
+	if err := deleteIPTablesRules(workerNS); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+
+	if err := workerNS.Run(func() error {
+
+		for _, table := range []string{"raw", "filter"} {
+			if exists, err := ipt.ChainExists(table, chainName); err != nil {
+				return err
+			} else if e, a := false, exists; e != a {
+				t.Fatalf("Expect %v, got %v", e, a)
+			}
+		}
+		return nil
+
+	}); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+
+	// Check idempotency
+	if err := deleteIPTablesRules(workerNS); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
 }
@@ -0,0 +1,70 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	fwbackend "github.com/confidential-containers/cloud-api-adaptor/pkg/util/firewall"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+const (
+	nftTableFamily = "ip"
+	nftTableName   = "peerpod"
+)
+
+// nftablesFirewall installs rules in a dedicated nftables table owned by cloud-api-adaptor.
+//
+// Note that an accept verdict in this table does not override a drop verdict in another table
+// attached to the same hook, so the FORWARD policy of other tables needs to allow pod traffic.
+type nftablesFirewall struct{}
+
+func (f *nftablesFirewall) Name() string {
+	return "nftables"
+}
+
+func (f *nftablesFirewall) SetRules(ns netops.Namespace, hostInterface string) error {
+
+	return ns.Run(func() error {
+		if err := fwbackend.RunNft(nftRuleset(hostInterface)); err != nil {
+			return fmt.Errorf("failed to set nftables table %s %s: %w", nftTableFamily, nftTableName, err)
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFirewall) DeleteRules(ns netops.Namespace) error {
+
+	return ns.Run(func() error {
+		if err := fwbackend.DeleteNftTable(nftTableFamily, nftTableName); err != nil {
+			return fmt.Errorf("failed to delete nftables table %s %s: %w", nftTableFamily, nftTableName, err)
+		}
+		return nil
+	})
+}
+
+// nftRuleset returns an nft script that atomically replaces the content of the peerpod table
+func nftRuleset(hostInterface string) string {
+
+	interfaces := fmt.Sprintf("{ %q, %q, %q }", vrf1Name, vrf2Name, hostInterface)
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "table %s %s\n", nftTableFamily, nftTableName)
+	fmt.Fprintf(&b, "flush table %s %s\n", nftTableFamily, nftTableName)
+	fmt.Fprintf(&b, "table %s %s {\n", nftTableFamily, nftTableName)
+	fmt.Fprintf(&b, "\tchain raw {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook prerouting priority raw; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tiifname %s notrack comment %q\n", interfaces, ruleComment)
+	fmt.Fprintf(&b, "\t}\n")
+	fmt.Fprintf(&b, "\tchain forward {\n")
+	fmt.Fprintf(&b, "\t\ttype filter hook forward priority filter; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tiifname %s accept comment %q\n", interfaces, ruleComment)
+	fmt.Fprintf(&b, "\t}\n")
+	fmt.Fprintf(&b, "}\n")
+
+	return b.String()
+}
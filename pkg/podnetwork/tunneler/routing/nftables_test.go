@@ -0,0 +1,136 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package routing
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	testutils "github.com/confidential-containers/cloud-api-adaptor/pkg/internal/testing"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tuntest"
+	fwbackend "github.com/confidential-containers/cloud-api-adaptor/pkg/util/firewall"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+func TestNFTables(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	if _, err := exec.LookPath(fwbackend.NFTCommand); err != nil {
+		t.Skip("nft command is not available. Skipping.")
+	}
+
+	workerNS := tuntest.NewNamedNS(t, "test-host")
+	defer tuntest.DeleteNamedNS(t, workerNS)
+
+	hostInterface := "ens4"
+
+	fw := &nftablesFirewall{}
+
+	if err := fw.SetRules(workerNS, hostInterface); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+
+	listTable := func() (string, error) {
+		var out []byte
+		err := workerNS.Run(func() error {
+			var err error
+			out, err = exec.Command(fwbackend.NFTCommand, "list", "table", nftTableFamily, nftTableName).Output()
+			return err
+		})
+		return string(out), err
+	}
+
+	ruleset, err := listTable()
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	for _, name := range []string{vrf1Name, vrf2Name, hostInterface} {
+		if !strings.Contains(ruleset, name) {
+			t.Fatalf("Expect %q in ruleset, got %q", name, ruleset)
+		}
+	}
+	if e, a := 2, strings.Count(ruleset, ruleComment+"\""); e != a {
+		t.Fatalf("Expect %d rules, got %d", e, a)
+	}
+
+	// Check idempotency
+	if err := fw.SetRules(workerNS, hostInterface); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if ruleset, err := listTable(); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	} else if e, a := 2, strings.Count(ruleset, ruleComment+"\""); e != a {
+		t.Fatalf("Expect %d rules, got %d", e, a)
+	}
+
+	if err := fw.DeleteRules(workerNS); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if _, err := listTable(); err == nil {
+		t.Fatal("Expect an error when listing a deleted table")
+	}
+
+	// Check idempotency
+	if err := fw.DeleteRules(workerNS); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+}
+
+func TestNFTRuleset(t *testing.T) {
+
+	ruleset := nftRuleset("ens4")
+
+	for _, line := range []string{
+		"table ip peerpod\n",
+		"flush table ip peerpod\n",
+		"type filter hook prerouting priority raw; policy accept;\n",
+		"iifname { \"ppvrf1\", \"ppvrf2\", \"ens4\" } notrack comment \"peerpod\"\n",
+		"type filter hook forward priority filter; policy accept;\n",
+		"iifname { \"ppvrf1\", \"ppvrf2\", \"ens4\" } accept comment \"peerpod\"\n",
+	} {
+		if !strings.Contains(ruleset, line) {
+			t.Fatalf("Expect %q in ruleset, got %q", line, ruleset)
+		}
+	}
+}
+
+func TestGetHostFirewall(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	ns, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	defer ns.Close()
+
+	origDetectBackend, origHostFirewall := detectBackend, hostFirewall
+	defer func() {
+		detectBackend, hostFirewall = origDetectBackend, origHostFirewall
+	}()
+	hostFirewall = nil
+
+	detectBackend = func(ns netops.Namespace) (fwbackend.Backend, error) {
+		return fwbackend.NFTables, nil
+	}
+	fw, err := getHostFirewall(ns)
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if e, a := "nftables", fw.Name(); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	// Teardown deletes rules with the backend that Setup used, even if detection would pick another one now
+	detectBackend = func(ns netops.Namespace) (fwbackend.Backend, error) {
+		return fwbackend.IPTables, nil
+	}
+	fw, err = getHostFirewall(ns)
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if e, a := "nftables", fw.Name(); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
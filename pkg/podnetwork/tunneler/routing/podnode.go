@@ -31,6 +31,10 @@ const (
 
 func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
 
+	if config.InterfaceIndex != 0 {
+		return fmt.Errorf("secondary pod interface %s is not supported", config.InterfaceName)
+	}
+
 	if !config.Dedicated {
 		return errors.New("shared subnet is not supported")
 	}
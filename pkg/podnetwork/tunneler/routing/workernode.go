@@ -46,6 +46,10 @@ func NewWorkerNodeTunneler() tunneler.Tunneler {
 
 func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
 
+	if config.InterfaceIndex != 0 {
+		return fmt.Errorf("secondary pod interface %s is not supported", config.InterfaceName)
+	}
+
 	if !config.Dedicated {
 		return errors.New("shared subnet is not supported")
 	}
@@ -54,6 +58,9 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 		return errors.New("secondary pod node IP is not available")
 	}
 
+	hostMutex.Lock()
+	defer hostMutex.Unlock()
+
 	podNodeIP := podNodeIPs[1]
 
 	hostNS, err := netops.OpenCurrentNamespace()
@@ -232,7 +239,14 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 		}
 	}
 
-	if err := setIPTablesRules(hostNS, hostLink.Name()); err != nil {
+	fw, err := getHostFirewall(hostNS)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Set %s rules for %s", fw.Name(), hostLink.Name())
+
+	if err := fw.SetRules(hostNS, hostLink.Name()); err != nil {
 		return err
 	}
 
@@ -241,6 +255,11 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 
 func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
 
+	// A concurrent Setup must not add a rule between the check for remaining rules and the deletion of
+	// the firewall rules below
+	hostMutex.Lock()
+	defer hostMutex.Unlock()
+
 	hostNS, err := netops.OpenCurrentNamespace()
 	if err != nil {
 		return fmt.Errorf("failed to get current network namespace: %w", err)
@@ -308,9 +327,96 @@ func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunn
 		return fmt.Errorf("failed to delete a veth interface %s at %s: %w", secondPodInterface, podNS.Path(), err)
 	}
 
+	// The firewall rules are shared by all pods, so they are deleted after the resources of this pod,
+	// which are not leaked when the firewall fails
+	rules, err = hostNS.RuleList(&netops.Rule{IifName: vrf1Name, Priority: sourceRouteTablePriority})
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		fw, err := getHostFirewall(hostNS)
+		if err != nil {
+			return err
+		}
+
+		logger.Printf("Delete %s rules since no pod uses routing mode", fw.Name())
+
+		if err := fw.DeleteRules(hostNS); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (t *workerNodeTunneler) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	if len(podNodeIPs) != 2 {
+		return errors.New("secondary pod node IP is not available")
+	}
+
+	podNodeIP := podNodeIPs[1]
+
+	hostNS, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer func() {
+		if e := hostNS.Close(); e != nil {
+			err = fmt.Errorf("failed to close the original network namespace: %w (previous error: %v)", e, err)
+		}
+	}()
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer func() {
+		if e := podNS.Close(); e != nil {
+			err = fmt.Errorf("failed to close the pod network namespace: %w (previous error: %v)", e, err)
+		}
+	}()
+
+	for _, name := range []string{vrf1Name, vrf2Name} {
+		vrf, err := hostNS.LinkFind(name)
+		if err != nil {
+			return fmt.Errorf("failed to find vrf %s on netns %s: %w", name, hostNS.Path(), err)
+		}
+		if !vrf.IsUp() {
+			return fmt.Errorf("vrf %s on netns %s is down", name, hostNS.Path())
+		}
+	}
+
+	secondPodInterfaceLink, err := podNS.LinkFind(secondPodInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %q on %s: %w", secondPodInterface, nsPath, err)
+	}
+	if !secondPodInterfaceLink.IsUp() {
+		return fmt.Errorf("interface %q on %s is down", secondPodInterface, nsPath)
+	}
+
+	for _, pair := range [][2]string{{config.InterfaceName, secondPodInterface}, {secondPodInterface, config.InterfaceName}} {
+		exists, err := podNS.RedirectExists(pair[0], pair[1])
+		if err != nil {
+			return fmt.Errorf("failed to check a tc redirect filter from %s to %s: %w", pair[0], pair[1], err)
+		}
+		if !exists {
+			return fmt.Errorf("tc redirect filter from %s to %s is missing on pod netns %s", pair[0], pair[1], nsPath)
+		}
+	}
+
+	routes, err := hostNS.RouteList(&netops.Route{Destination: mask32(config.PodIP), Table: vrf2TableID})
+	if err != nil {
+		return fmt.Errorf("failed to get routes to pod VM: %w", err)
+	}
+	for _, route := range routes {
+		if route.Gateway == podNodeIP {
+			return nil
+		}
+	}
+	return fmt.Errorf("route to pod IP %s via pod VM IP %s is missing on netns %s", config.PodIP, podNodeIP, hostNS.Path())
+}
+
 func createVethWithPrefix(vethPrefix string, hostNS, peerNS netops.Namespace, peerName string) (netops.Link, error) {
 
 	links, err := hostNS.LinkList()
@@ -13,6 +13,12 @@ type Tunneler interface {
 	Teardown(nsPath, hostInterface string, config *Config) error
 }
 
+// Checker is implemented by tunnelers that can validate a tunnel that was previously set up
+type Checker interface {
+	// Check returns an error if the tunnel set up by Setup is missing or broken
+	Check(nsPath string, podNodeIPs []netip.Addr, config *Config) error
+}
+
 type Config struct {
 	PodIP         netip.Prefix `json:"podip"`
 	PodHwAddr     string       `json:"pod-hw-addr"`
@@ -25,6 +31,21 @@ type Config struct {
 	VXLANPort     int          `json:"vxlan-port,omitempty"`
 	VXLANID       int          `json:"vxlan-id,omitempty"`
 	Dedicated     bool         `json:"dedicated"`
+
+	// InterfaceIndex is 0 for the primary pod interface, and a positive number for a secondary pod interface
+	InterfaceIndex int `json:"interface-index,omitempty"`
+	// SecondaryInterfaces are additional pod interfaces, e.g. attached by Multus
+	SecondaryInterfaces []*Interface `json:"secondary-interfaces,omitempty"`
+}
+
+// Interface describes a secondary pod interface. Each secondary interface has its own tunnel.
+type Interface struct {
+	Name      string       `json:"interface"`
+	PodIP     netip.Prefix `json:"podip"`
+	PodHwAddr string       `json:"pod-hw-addr"`
+	Routes    []*Route     `json:"routes"`
+	MTU       int          `json:"mtu"`
+	VXLANID   int          `json:"vxlan-id,omitempty"`
 }
 
 type Route struct {
@@ -33,6 +54,30 @@ type Route struct {
 	Dev string
 }
 
+// InterfaceConfigs returns a tunnel configuration for each pod interface.
+// The first configuration is for the primary pod interface, and the rest are for secondary pod interfaces.
+func (c *Config) InterfaceConfigs() []*Config {
+
+	primary := *c
+	primary.SecondaryInterfaces = nil
+
+	configs := []*Config{&primary}
+
+	for i, iface := range c.SecondaryInterfaces {
+		config := primary
+		config.InterfaceIndex = i + 1
+		config.InterfaceName = iface.Name
+		config.PodIP = iface.PodIP
+		config.PodHwAddr = iface.PodHwAddr
+		config.Routes = iface.Routes
+		config.MTU = iface.MTU
+		config.VXLANID = iface.VXLANID
+		configs = append(configs, &config)
+	}
+
+	return configs
+}
+
 type driver struct {
 	newWorkerNodeTunneler func() Tunneler
 	newPodNodeTunneler    func() Tunneler
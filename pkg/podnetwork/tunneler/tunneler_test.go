@@ -0,0 +1,95 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package tunneler
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+func TestInterfaceConfigs(t *testing.T) {
+
+	config := &Config{
+		PodIP:         netip.MustParsePrefix("10.128.0.2/24"),
+		PodHwAddr:     "0a:58:0a:84:03:ce",
+		InterfaceName: "eth0",
+		WorkerNodeIP:  netip.MustParsePrefix("192.168.0.1/24"),
+		TunnelType:    "vxlan",
+		Routes:        []*Route{{GW: netip.MustParseAddr("10.128.0.1"), Dev: "eth0"}},
+		MTU:           1500,
+		VXLANPort:     4789,
+		VXLANID:       555000,
+		SecondaryInterfaces: []*Interface{
+			{
+				Name:      "net1",
+				PodIP:     netip.MustParsePrefix("10.200.0.2/24"),
+				PodHwAddr: "0a:58:0a:c8:00:02",
+				Routes:    []*Route{{Dst: netip.MustParsePrefix("10.201.0.0/16"), GW: netip.MustParseAddr("10.200.0.1"), Dev: "net1"}},
+				MTU:       9000,
+				VXLANID:   555001,
+			},
+		},
+	}
+
+	configs := config.InterfaceConfigs()
+
+	if e, a := 2, len(configs); e != a {
+		t.Fatalf("Expect %d configs, got %d", e, a)
+	}
+
+	primary := configs[0]
+	if e, a := "eth0", primary.InterfaceName; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := 0, primary.InterfaceIndex; e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+	if primary.SecondaryInterfaces != nil {
+		t.Fatalf("Expect no secondary interfaces, got %v", primary.SecondaryInterfaces)
+	}
+
+	secondary := configs[1]
+	if e, a := "net1", secondary.InterfaceName; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := 1, secondary.InterfaceIndex; e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+	if e, a := "10.200.0.2/24", secondary.PodIP.String(); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := 555001, secondary.VXLANID; e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+	if e, a := 9000, secondary.MTU; e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+	if e, a := "net1", secondary.Routes[0].Dev; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := config.WorkerNodeIP, secondary.WorkerNodeIP; e != a {
+		t.Fatalf("Expect %v, got %v", e, a)
+	}
+	if e, a := config.VXLANPort, secondary.VXLANPort; e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+
+	// The original config is not modified
+	if e, a := 1, len(config.SecondaryInterfaces); e != a {
+		t.Fatalf("Expect %d, got %d", e, a)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	var decoded Config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	if e, a := "net1", decoded.SecondaryInterfaces[0].Name; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
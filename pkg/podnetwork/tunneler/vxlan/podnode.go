@@ -25,6 +25,8 @@ func NewPodNodeTunneler() tunneler.Tunneler {
 
 func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
 
+	podVxlanInterface := podVxlanInterfaceName(config)
+
 	nodeAddr := config.WorkerNodeIP
 
 	if !nodeAddr.IsValid() {
@@ -53,13 +55,27 @@ func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *
 		ID:    config.VXLANID,
 		Port:  config.VXLANPort,
 	}
-	vxlan, err := hostNS.LinkAdd(podVxlanInterface, vxlanDevice)
+	hostVxlanInterface := podHostVxlanInterfaceName(config)
+	vxlan, err := hostNS.LinkAdd(hostVxlanInterface, vxlanDevice)
 	if err != nil {
-		return fmt.Errorf("failed to add vxlan interface %s: %w", podVxlanInterface, err)
+		return fmt.Errorf("failed to add vxlan interface %s: %w", hostVxlanInterface, err)
 	}
 
 	if err := vxlan.SetNamespace(podNS); err != nil {
-		return fmt.Errorf("failed to move vxlan interface %s to netns %s: %w", podVxlanInterface, podNS.Path(), err)
+		return fmt.Errorf("failed to move vxlan interface %s to netns %s: %w", hostVxlanInterface, podNS.Path(), err)
+	}
+
+	if hostVxlanInterface != podVxlanInterface {
+		// The interface index may change when the interface is moved to another network namespace
+		if vxlan, err = podNS.LinkFind(hostVxlanInterface); err != nil {
+			return err
+		}
+		if err := vxlan.SetName(podVxlanInterface); err != nil {
+			return err
+		}
+		if vxlan, err = podNS.LinkFind(podVxlanInterface); err != nil {
+			return err
+		}
 	}
 
 	if err := vxlan.SetHardwareAddr(config.PodHwAddr); err != nil {
@@ -105,6 +121,26 @@ func (t *podNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *
 	return nil
 }
 
+// podVxlanInterfaceName returns the name of the VXLAN interface in the pod VM for a pod interface.
+// Secondary pod interfaces are recreated with their original names.
+func podVxlanInterfaceName(config *tunneler.Config) string {
+	if config.InterfaceIndex == 0 {
+		return podVxlanInterface
+	}
+	return config.InterfaceName
+}
+
+// podHostVxlanInterfaceName returns the name of the VXLAN interface for a pod interface, when it is created in
+// the host network namespace of the pod VM. The name of a secondary pod interface, such as net1, may be used
+// by an interface of the pod VM, so the interface is created with a name derived from its VXLAN ID, and
+// renamed in the pod network namespace.
+func podHostVxlanInterfaceName(config *tunneler.Config) string {
+	if config.InterfaceIndex == 0 {
+		return podVxlanInterface
+	}
+	return fmt.Sprintf("vxlan%d", config.VXLANID)
+}
+
 func (t *podNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
 	return nil
 }
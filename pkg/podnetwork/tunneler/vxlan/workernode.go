@@ -32,20 +32,11 @@ func NewWorkerNodeTunneler() tunneler.Tunneler {
 
 func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
 
-	var dstAddr netip.Addr
+	secondPodInterface := secondPodInterfaceName(config)
 
-	numIPs := len(podNodeIPs)
-	if numIPs == 0 {
-		return fmt.Errorf("pod node has no IPs")
-	}
-
-	if config.Dedicated {
-		if numIPs < 2 {
-			return fmt.Errorf("dedicated tunnel missing destination address")
-		}
-		dstAddr = podNodeIPs[1]
-	} else {
-		dstAddr = podNodeIPs[0]
+	dstAddr, err := destinationAddr(podNodeIPs, config)
+	if err != nil {
+		return err
 	}
 
 	hostNS, err := netops.OpenCurrentNamespace()
@@ -68,85 +59,102 @@ func (t *workerNodeTunneler) Setup(nsPath string, podNodeIPs []netip.Addr, confi
 		}
 	}()
 
-	index := 1
-
-	links, err := hostNS.LinkList()
+	podVxlanInterface, err := createVxlanInterface(hostNS, podNS, secondPodInterface, dstAddr, config)
 	if err != nil {
-		return fmt.Errorf("failed to get interfaces on host: %w", err)
+		return err
 	}
 
-	var hostVxlanInterface string
-	var hostVxlanLink netops.Link
+	if err := podVxlanInterface.SetUp(); err != nil {
+		return err
+	}
 
-	for {
-		hostVxlanInterface = fmt.Sprintf("%s%d", hostVxlanInterfacePrefix, index)
-		var found bool
-		for _, link := range links {
-			if link.Name() == hostVxlanInterface {
-				found = true
-				break
-			}
-		}
+	podInterface := config.InterfaceName
 
-		if !found {
+	logger.Printf("Add tc redirect filters between %s and %s on pod network namespace %s", podInterface, secondPodInterface, nsPath)
 
-			vxlanDevice := &netops.VXLAN{
-				Group: dstAddr,
-				ID:    config.VXLANID,
-				Port:  config.VXLANPort,
+	if err := podNS.RedirectAdd(podInterface, secondPodInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", podInterface, secondPodInterface, err)
+	}
+
+	if err := podNS.RedirectAdd(secondPodInterface, podInterface); err != nil {
+		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", secondPodInterface, podInterface, err)
+	}
+
+	return nil
+}
+
+// createVxlanInterface creates a VXLAN interface whose UDP socket is bound on the host network namespace,
+// and whose interface is placed in the pod network namespace with the specified name.
+func createVxlanInterface(hostNS, podNS netops.Namespace, name string, dstAddr netip.Addr, config *tunneler.Config) (netops.Link, error) {
+
+	vxlanDevice := &netops.VXLAN{
+		Group:     dstAddr,
+		ID:        config.VXLANID,
+		Port:      config.VXLANPort,
+		Namespace: podNS,
+	}
+
+	// Create the VXLAN interface directly in the pod network namespace, so that no interface name on the host is used
+	podVxlanLink, err := hostNS.LinkAdd(name, vxlanDevice)
+	if err == nil {
+		logger.Printf("vxlan %s (remote %s:%d, id: %d) created at %s", name, dstAddr.String(), config.VXLANPort, config.VXLANID, podNS.Path())
+		return podVxlanLink, nil
+	}
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("failed to add vxlan interface %s: %w", name, err)
+	}
+	logger.Printf("failed to create vxlan %s at %s directly: %v (retrying via host network namespace)", name, podNS.Path(), err)
+
+	// The host interface name is derived from the VXLAN ID, which is unique among pods on this worker node
+	hostVxlanInterface := hostVxlanInterfaceName(config.VXLANID)
+
+	vxlanDevice.Namespace = nil
+	hostVxlanLink, err := hostNS.LinkAdd(hostVxlanInterface, vxlanDevice)
+	if err != nil && errors.Is(err, os.ErrExist) {
+		// An interface with the same name is a leftover of a pod that failed to set up previously
+		if stale, e := hostNS.LinkFind(hostVxlanInterface); e == nil {
+			logger.Printf("delete stale vxlan %s at %s", hostVxlanInterface, hostNS.Path())
+			if e := stale.Delete(); e != nil {
+				return nil, fmt.Errorf("failed to delete stale vxlan interface %s: %w", hostVxlanInterface, e)
 			}
-			logger.Printf("vxlan %s (remote %s:%d, id: %d) created at %s", hostVxlanInterface, dstAddr.String(), config.VXLANPort, config.VXLANID, hostNS.Path())
 			hostVxlanLink, err = hostNS.LinkAdd(hostVxlanInterface, vxlanDevice)
-			if err == nil {
-				logger.Printf("vxlan %s created at %s", hostVxlanInterface, hostNS.Path())
-				break
-			}
-			logger.Printf("vxlan %s created at %s: %v", hostVxlanInterface, hostNS.Path(), err)
-			if !errors.Is(err, os.ErrExist) {
-				return fmt.Errorf("failed to add vxlan interface %s: %w", hostVxlanInterface, err)
-			}
-		}
-		index++
-		if index > 5 {
-			return fmt.Errorf("failed to create vxlan interface %s: too many", hostVxlanInterface)
 		}
 	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add vxlan interface %s: %w", hostVxlanInterface, err)
+	}
+	logger.Printf("vxlan %s (remote %s:%d, id: %d) created at %s", hostVxlanInterface, dstAddr.String(), config.VXLANPort, config.VXLANID, hostNS.Path())
 
 	if err := hostVxlanLink.SetNamespace(podNS); err != nil {
-		return fmt.Errorf("failed to move vxlan interface %s to netns %s: %w", hostVxlanInterface, podNS.Path(), err)
+		if e := hostVxlanLink.Delete(); e != nil {
+			logger.Printf("failed to delete vxlan interface %s: %v", hostVxlanInterface, e)
+		}
+		return nil, fmt.Errorf("failed to move vxlan interface %s to netns %s: %w", hostVxlanInterface, podNS.Path(), err)
 	}
 	logger.Printf("vxlan %s is moved to %s", hostVxlanInterface, podNS.Path())
 
-	podVxlanInterface, err := podNS.LinkFind(hostVxlanInterface)
+	podVxlanLink, err = podNS.LinkFind(hostVxlanInterface)
 	if err != nil {
-		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s to %s: %w", hostVxlanInterface, podNS.Path(), secondPodInterface, err)
+		return nil, fmt.Errorf("failed to find vxlan interface %q on pod netns %s: %w", hostVxlanInterface, podNS.Path(), err)
 	}
 
-	if err := podVxlanInterface.SetName(secondPodInterface); err != nil {
-		return fmt.Errorf("failed to change vxlan interface name %s on netns %s to %s: %w", hostVxlanInterface, podNS.Path(), secondPodInterface, err)
+	if err := podVxlanLink.SetName(name); err != nil {
+		return nil, fmt.Errorf("failed to change vxlan interface name %s on netns %s to %s: %w", hostVxlanInterface, podNS.Path(), name, err)
 	}
 
-	if err := podVxlanInterface.SetUp(); err != nil {
-		return err
-	}
-
-	podInterface := config.InterfaceName
-
-	logger.Printf("Add tc redirect filters between %s and %s on pod network namespace %s", podInterface, secondPodInterface, nsPath)
-
-	if err := podNS.RedirectAdd(podInterface, secondPodInterface); err != nil {
-		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", podInterface, secondPodInterface, err)
-	}
-
-	if err := podNS.RedirectAdd(secondPodInterface, podInterface); err != nil {
-		return fmt.Errorf("failed to add a tc redirect filter from %s to %s: %w", secondPodInterface, podInterface, err)
-	}
+	return podVxlanLink, nil
+}
 
-	return nil
+// hostVxlanInterfaceName returns a name of a VXLAN interface that is temporarily created on the host network namespace
+func hostVxlanInterfaceName(vxlanID int) string {
+	// A VXLAN ID is a 24-bit number, so the name fits in the 15-character limit of Linux interface names
+	return fmt.Sprintf("%s%06x", hostVxlanInterfacePrefix, vxlanID&0xffffff)
 }
 
 func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunneler.Config) error {
 
+	secondPodInterface := secondPodInterfaceName(config)
+
 	hostNS, err := netops.OpenCurrentNamespace()
 	if err != nil {
 		return fmt.Errorf("failed to get current network namespace: %w", err)
@@ -189,3 +197,80 @@ func (t *workerNodeTunneler) Teardown(nsPath, hostInterface string, config *tunn
 	}
 	return nil
 }
+
+func (t *workerNodeTunneler) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	secondPodInterface := secondPodInterfaceName(config)
+
+	podNS, err := netops.OpenNamespace(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get a network namespace: %s: %w", nsPath, err)
+	}
+	defer func() {
+		if e := podNS.Close(); e != nil {
+			err = fmt.Errorf("failed to close the pod network namespace: %w (previous error: %v)", e, err)
+		}
+	}()
+
+	podVxlanInterface, err := podNS.LinkFind(secondPodInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find vxlan interface %q on pod netns %s: %w", secondPodInterface, podNS.Path(), err)
+	}
+	if !podVxlanInterface.IsUp() {
+		return fmt.Errorf("vxlan interface %q on pod netns %s is down", secondPodInterface, podNS.Path())
+	}
+
+	// The remote address changes when the pod VM gets a new IP address
+	dstAddr, err := destinationAddr(podNodeIPs, config)
+	if err != nil {
+		return err
+	}
+	vxlan, err := podVxlanInterface.GetVXLAN()
+	if err != nil {
+		return fmt.Errorf("failed to get attributes of vxlan interface %q on pod netns %s: %w", secondPodInterface, podNS.Path(), err)
+	}
+	if vxlan.Group != dstAddr || vxlan.ID != config.VXLANID || vxlan.Port != config.VXLANPort {
+		return fmt.Errorf("vxlan interface %q on pod netns %s has remote %s:%d and id %d, expected %s:%d and id %d", secondPodInterface, podNS.Path(), vxlan.Group, vxlan.Port, vxlan.ID, dstAddr, config.VXLANPort, config.VXLANID)
+	}
+
+	return checkRedirects(podNS, config.InterfaceName, secondPodInterface)
+}
+
+// destinationAddr returns the IP address of the pod VM that is the remote end of the VXLAN tunnel
+func destinationAddr(podNodeIPs []netip.Addr, config *tunneler.Config) (netip.Addr, error) {
+
+	numIPs := len(podNodeIPs)
+	if numIPs == 0 {
+		return netip.Addr{}, fmt.Errorf("pod node has no IPs")
+	}
+
+	if config.Dedicated {
+		if numIPs < 2 {
+			return netip.Addr{}, fmt.Errorf("dedicated tunnel missing destination address")
+		}
+		return podNodeIPs[1], nil
+	}
+	return podNodeIPs[0], nil
+}
+
+// secondPodInterfaceName returns the name of the VXLAN interface in the pod network namespace for a pod interface
+func secondPodInterfaceName(config *tunneler.Config) string {
+	if config.InterfaceIndex == 0 {
+		return secondPodInterface
+	}
+	return fmt.Sprintf("%s-%d", secondPodInterface, config.InterfaceIndex)
+}
+
+func checkRedirects(podNS netops.Namespace, podInterface, secondPodInterface string) error {
+
+	for _, pair := range [][2]string{{podInterface, secondPodInterface}, {secondPodInterface, podInterface}} {
+		exists, err := podNS.RedirectExists(pair[0], pair[1])
+		if err != nil {
+			return fmt.Errorf("failed to check a tc redirect filter from %s to %s: %w", pair[0], pair[1], err)
+		}
+		if !exists {
+			return fmt.Errorf("tc redirect filter from %s to %s is missing on pod netns %s", pair[0], pair[1], podNS.Path())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package vxlan
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+
+	testutils "github.com/confidential-containers/cloud-api-adaptor/pkg/internal/testing"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tunneler"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/podnetwork/tuntest"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+func TestHostVxlanInterfaceName(t *testing.T) {
+
+	for vxlanID, expected := range map[int]string{
+		DefaultVXLANMinID:     "ppvxlan0877f8",
+		DefaultVXLANMinID + 1: "ppvxlan0877f9",
+		0xffffff:              "ppvxlanffffff",
+	} {
+		name := hostVxlanInterfaceName(vxlanID)
+		if e, a := expected, name; e != a {
+			t.Fatalf("Expect %q, got %q", e, a)
+		}
+		if len(name) > 15 {
+			t.Fatalf("Expect an interface name up to 15 characters, got %q", name)
+		}
+	}
+}
+
+func TestConcurrentSetup(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	const numPods = 32
+
+	workerNS := tuntest.NewNamedNS(t, "test-worker")
+	defer tuntest.DeleteNamedNS(t, workerNS)
+
+	tuntest.BridgeAdd(t, workerNS, "enc0")
+	tuntest.AddrAdd(t, workerNS, "enc0", "10.10.0.1/16")
+
+	podNSs := make([]netops.Namespace, numPods)
+	configs := make([]*tunneler.Config, numPods)
+
+	for i := 0; i < numPods; i++ {
+		podNSs[i] = tuntest.NewNamedNS(t, fmt.Sprintf("test-workerpod%d", i))
+		defer tuntest.DeleteNamedNS(t, podNSs[i])
+
+		tuntest.BridgeAdd(t, podNSs[i], "eth0")
+
+		configs[i] = &tunneler.Config{
+			PodIP:         netip.MustParsePrefix(fmt.Sprintf("10.128.%d.%d/16", i/256, i%256+2)),
+			InterfaceName: "eth0",
+			MTU:           1500,
+			TunnelType:    "vxlan",
+			WorkerNodeIP:  netip.MustParsePrefix("10.10.0.1/16"),
+			Index:         i,
+			VXLANPort:     DefaultVXLANPort,
+			VXLANID:       DefaultVXLANMinID + i,
+		}
+	}
+
+	run := func(fn func(i int) error) {
+		var wg sync.WaitGroup
+		errCh := make(chan error, numPods)
+
+		for i := 0; i < numPods; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := workerNS.Run(func() error { return fn(i) }); err != nil {
+					errCh <- fmt.Errorf("pod %d: %w", i, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errCh)
+
+		for err := range errCh {
+			t.Errorf("Expect no error, got %v", err)
+		}
+		if t.Failed() {
+			t.FailNow()
+		}
+	}
+
+	podNodeIPs := func(i int) []netip.Addr {
+		return []netip.Addr{netip.MustParseAddr(fmt.Sprintf("10.10.%d.%d", i/256+1, i%256+2))}
+	}
+
+	run(func(i int) error {
+		return NewWorkerNodeTunneler().Setup(podNSs[i].Path(), podNodeIPs(i), configs[i])
+	})
+
+	run(func(i int) error {
+		return (&workerNodeTunneler{}).Check(podNSs[i].Path(), podNodeIPs(i), configs[i])
+	})
+
+	// A tunnel to a previous IP address of the pod VM is detected
+	changedIPs := []netip.Addr{netip.MustParseAddr("10.10.254.254")}
+	if err := workerNS.Run(func() error {
+		return (&workerNodeTunneler{}).Check(podNSs[0].Path(), changedIPs, configs[0])
+	}); err == nil {
+		t.Fatal("Expect an error for a changed pod VM IP address, got nil")
+	}
+
+	for i, podNS := range podNSs {
+		link, err := podNS.LinkFind(secondPodInterface)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if !link.IsUp() {
+			t.Fatalf("Expect %s of pod %d to be up", secondPodInterface, i)
+		}
+	}
+
+	links, err := workerNS.LinkList()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	for _, link := range links {
+		if link.Type() == "vxlan" {
+			t.Fatalf("Expect no vxlan interface left on worker network namespace, got %s", link.Name())
+		}
+	}
+
+	run(func(i int) error {
+		return NewWorkerNodeTunneler().Teardown(podNSs[i].Path(), "enc0", configs[i])
+	})
+}
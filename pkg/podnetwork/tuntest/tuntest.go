@@ -28,6 +28,8 @@ type testPod struct {
 	podNodePrimaryAddr   string
 	podNodeSecondaryAddr string
 	hostInterface        string
+	secondaryPodAddr     string
+	secondaryPodHwAddr   string
 }
 
 func getIP(t *testing.T, addr string) netip.Addr {
@@ -49,13 +51,19 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 		gatewayAddr         = gatewayIP + "/24"
 		workerPrimaryAddr   = "10.10.0.1/16"
 		workerSecondaryAddr = "192.168.0.1/24"
+
+		// Address of a secondary pod network, which is attached like Multus does
+		secondaryGatewayAddr = "10.129.0.1/24"
 	)
 
 	pods := []*testPod{
-		{podAddr: "10.128.0.2/24", podHwAddr: "0a:58:0a:84:03:ce", podNodePrimaryAddr: "10.10.1.2/16", podNodeSecondaryAddr: "192.168.0.2/24"},
-		{podAddr: "10.128.0.3/24", podHwAddr: "0a:58:0a:84:03:cf", podNodePrimaryAddr: "10.10.1.3/16", podNodeSecondaryAddr: "192.168.0.3/24"},
+		{podAddr: "10.128.0.2/24", podHwAddr: "0a:58:0a:84:03:ce", podNodePrimaryAddr: "10.10.1.2/16", podNodeSecondaryAddr: "192.168.0.2/24", secondaryPodAddr: "10.129.0.2/24", secondaryPodHwAddr: "0a:58:0a:85:03:ce"},
+		{podAddr: "10.128.0.3/24", podHwAddr: "0a:58:0a:84:03:cf", podNodePrimaryAddr: "10.10.1.3/16", podNodeSecondaryAddr: "192.168.0.3/24", secondaryPodAddr: "10.129.0.3/24", secondaryPodHwAddr: "0a:58:0a:85:03:cf"},
 	}
 
+	// Secondary pod interfaces are only supported by the VXLAN tunnel
+	secondary := tunnelType == "vxlan"
+
 	bridgeNS := NewNamedNS(t, "test-bridge")
 	defer DeleteNamedNS(t, bridgeNS)
 
@@ -87,6 +95,11 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 	BridgeAdd(t, workerNS, "cni0")
 
 	AddrAdd(t, workerNS, "cni0", gatewayAddr)
+
+	if secondary {
+		BridgeAdd(t, workerNS, "multus0")
+		AddrAdd(t, workerNS, "multus0", secondaryGatewayAddr)
+	}
 	AddrAdd(t, workerNS, "enc0", workerPrimaryAddr)
 	AddrAdd(t, workerNS, "enc1", workerSecondaryAddr)
 
@@ -109,6 +122,15 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 		HwAddrAdd(t, pod.workerPodNS, "eth0", pod.podHwAddr)
 		RouteAdd(t, pod.workerPodNS, "", gatewayIP, "eth0")
 
+		if secondary {
+			secondaryVeth := fmt.Sprintf("mveth%d", i)
+			VethAdd(t, workerNS, secondaryVeth, pod.workerPodNS, "net1")
+			LinkSetMaster(t, workerNS, secondaryVeth, "multus0")
+
+			AddrAdd(t, pod.workerPodNS, "net1", pod.secondaryPodAddr)
+			HwAddrAdd(t, pod.workerPodNS, "net1", pod.secondaryPodHwAddr)
+		}
+
 		pod.podNodeNS = NewNamedNS(t, fmt.Sprintf("test-podvm%d", i))
 		defer DeleteNamedNS(t, pod.podNodeNS)
 
@@ -122,6 +144,11 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 		LinkSetMaster(t, bridgeNS, vmEth0, "br0")
 		LinkSetMaster(t, bridgeNS, vmEth1, "br1")
 
+		if secondary {
+			// An interface of the pod VM may have the same name as a secondary pod interface
+			BridgeAdd(t, pod.podNodeNS, "net1")
+		}
+
 		pod.podNS = NewNamedNS(t, fmt.Sprintf("test-pod%d", i))
 		defer DeleteNamedNS(t, pod.podNS)
 	}
@@ -144,6 +171,18 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 			pod.config.VXLANID = 555000 + i // vxlan.DefaultVXLANMinID + index
 		}
 
+		if secondary {
+			pod.config.SecondaryInterfaces = []*tunneler.Interface{
+				{
+					Name:      "net1",
+					PodIP:     netip.MustParsePrefix(pod.secondaryPodAddr),
+					PodHwAddr: pod.secondaryPodHwAddr,
+					MTU:       1500,
+					VXLANID:   1<<24 - 1 - i,
+				},
+			}
+		}
+
 		podNodeIPs := []netip.Addr{getIP(t, pod.podNodePrimaryAddr)}
 
 		if dedicated {
@@ -155,11 +194,22 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 			pod.config.WorkerNodeIP = netip.MustParsePrefix(workerPrimaryAddr)
 		}
 
-		if err := workerNS.Run(func() error {
-			return pod.workerNodeTunneler.Setup(pod.workerPodNS.Path(), podNodeIPs, pod.config)
+		for _, config := range pod.config.InterfaceConfigs() {
+			if err := workerNS.Run(func() error {
+				return pod.workerNodeTunneler.Setup(pod.workerPodNS.Path(), podNodeIPs, config)
+
+			}); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+
+			if checker, ok := pod.workerNodeTunneler.(tunneler.Checker); ok {
+				if err := workerNS.Run(func() error {
+					return checker.Check(pod.workerPodNS.Path(), podNodeIPs, config)
 
-		}); err != nil {
-			t.Fatalf("Expect no error, got %v", err)
+				}); err != nil {
+					t.Fatalf("Expect no error, got %v", err)
+				}
+			}
 		}
 
 		go func() {
@@ -174,40 +224,57 @@ func RunTunnelTest(t *testing.T, tunnelType string, newWorkerNodeTunneler, newPo
 			}
 		}()
 
-		if err := pod.podNodeNS.Run(func() error {
-			return pod.podNodeTunneler.Setup(pod.podNS.Path(), podNodeIPs, pod.config)
+		for _, config := range pod.config.InterfaceConfigs() {
+			if err := pod.podNodeNS.Run(func() error {
+				return pod.podNodeTunneler.Setup(pod.podNS.Path(), podNodeIPs, config)
 
-		}); err != nil {
-			t.Fatalf("Expect no error, got %v", err)
+			}); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
 		}
 	}
 
 	for _, pod := range pods {
 		httpServer := StartHTTPServer(t, pod.podNS, netip.AddrPortFrom(getIP(t, pod.podAddr), 8080))
 		defer httpServer.Shutdown(t)
+
+		if secondary {
+			secondaryHTTPServer := StartHTTPServer(t, pod.podNS, netip.AddrPortFrom(getIP(t, pod.secondaryPodAddr), 8080))
+			defer secondaryHTTPServer.Shutdown(t)
+		}
 	}
 
 	for i, pod := range pods {
 		ConnectToHTTPServer(t, workerNS, netip.AddrPortFrom(getIP(t, pod.podAddr), 8080), netip.AddrPortFrom(getIP(t, gatewayAddr), 0))
 		ConnectToHTTPServer(t, pod.podNS, netip.AddrPortFrom(getIP(t, pods[(i+1)%len(pods)].podAddr), 8080), netip.AddrPortFrom(getIP(t, pod.podAddr), 0))
+
+		if secondary {
+			// The secondary interface is recreated with its original name in the pod VM
+			if _, err := pod.podNS.LinkFind("net1"); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			ConnectToHTTPServer(t, workerNS, netip.AddrPortFrom(getIP(t, pod.secondaryPodAddr), 8080), netip.AddrPortFrom(getIP(t, secondaryGatewayAddr), 0))
+		}
 	}
 
 	for _, pod := range pods {
 
-		if err := workerNS.Run(func() error {
+		for _, config := range pod.config.InterfaceConfigs() {
+			if err := workerNS.Run(func() error {
 
-			return pod.workerNodeTunneler.Teardown(pod.workerPodNS.Path(), pod.hostInterface, pod.config)
+				return pod.workerNodeTunneler.Teardown(pod.workerPodNS.Path(), pod.hostInterface, config)
 
-		}); err != nil {
-			t.Fatalf("Expect no error, got %v", err)
-		}
+			}); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
 
-		if err := pod.podNodeNS.Run(func() error {
+			if err := pod.podNodeNS.Run(func() error {
 
-			return pod.podNodeTunneler.Teardown(pod.podNS.Path(), pod.hostInterface, pod.config)
+				return pod.podNodeTunneler.Teardown(pod.podNS.Path(), pod.hostInterface, config)
 
-		}); err != nil {
-			t.Fatalf("Expect no error, got %v", err)
+			}); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
 		}
 	}
 }
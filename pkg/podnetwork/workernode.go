@@ -18,6 +18,7 @@ type WorkerNode interface {
 	Inspect(nsPath string) (*tunneler.Config, error)
 	Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error
 	Teardown(nsPath string, config *tunneler.Config) error
+	Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error
 }
 
 type workerNode struct {
@@ -36,6 +37,13 @@ type podIndex struct {
 	mutex sync.Mutex
 }
 
+// maxVXLANID is the largest VXLAN network identifier, which is a 24-bit value
+const maxVXLANID = 1<<24 - 1
+
+// Secondary pod interfaces get VXLAN IDs counted down from maxVXLANID, so that they neither consume pod indexes
+// nor collide with the VXLAN IDs of primary pod interfaces, which are counted up from the minimum VXLAN ID
+var secondaryVXLANIndexManager podIndex
+
 func (p *podIndex) Get() int {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
@@ -162,21 +170,97 @@ func (n *workerNode) Inspect(nsPath string) (*tunneler.Config, error) {
 	}
 	config.MTU = mtu
 
+	// Secondary pod interfaces are only supported by the VXLAN tunnel
+	if n.tunnelType == "vxlan" {
+		config.SecondaryInterfaces, err = findSecondaryInterfaces(podNS, podInterface)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.Routes = partitionRoutes(routes, config.SecondaryInterfaces)
+
+	if n.tunnelType == "vxlan" {
+		config.VXLANPort = n.vxlanPort
+		config.VXLANID = n.vxlanMinID + config.Index
+
+		for _, iface := range config.SecondaryInterfaces {
+			iface.VXLANID = maxVXLANID - secondaryVXLANIndexManager.Get()
+			logger.Printf("secondary pod interface %s (IP: %s, VXLAN ID: %d)", iface.Name, iface.PodIP, iface.VXLANID)
+		}
+	}
+
+	return config, nil
+}
+
+// partitionRoutes adds the routes via a secondary pod interface to the interface, and returns the other routes
+func partitionRoutes(routes []*netops.Route, secondaryInterfaces []*tunneler.Interface) []*tunneler.Route {
+
+	interfaces := make(map[string]*tunneler.Interface)
+	for _, iface := range secondaryInterfaces {
+		interfaces[iface.Name] = iface
+	}
+
+	var primaryRoutes []*tunneler.Route
+
 	for _, route := range routes {
 		r := &tunneler.Route{
 			Dst: route.Destination,
 			Dev: route.Device,
 			GW:  route.Gateway,
 		}
-		config.Routes = append(config.Routes, r)
+		if iface, ok := interfaces[route.Device]; ok {
+			iface.Routes = append(iface.Routes, r)
+		} else {
+			primaryRoutes = append(primaryRoutes, r)
+		}
 	}
 
-	if n.tunnelType == "vxlan" {
-		config.VXLANPort = n.vxlanPort
-		config.VXLANID = n.vxlanMinID + config.Index
+	return primaryRoutes
+}
+
+// findSecondaryInterfaces identifies pod interfaces other than the primary interface, e.g. interfaces attached by Multus.
+// Interfaces without an IPv4 address are ignored.
+func findSecondaryInterfaces(podNS netops.Namespace, primaryInterface string) ([]*tunneler.Interface, error) {
+
+	links, err := podNS.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces on netns %s: %w", podNS.Path(), err)
 	}
 
-	return config, nil
+	var interfaces []*tunneler.Interface
+
+	for _, link := range links {
+		name := link.Name()
+		if name == primaryInterface || name == "lo" {
+			continue
+		}
+
+		podIP, err := getPodIP(link)
+		if err != nil {
+			logger.Printf("ignore secondary pod interface %s: %v", name, err)
+			continue
+		}
+
+		hwAddr, err := link.GetHardwareAddr()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Mac address for pod interface %s: %w", name, err)
+		}
+
+		mtu, err := link.GetMTU()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get MTU size of %s: %w", name, err)
+		}
+
+		interfaces = append(interfaces, &tunneler.Interface{
+			Name:      name,
+			PodIP:     podIP,
+			PodHwAddr: hwAddr,
+			MTU:       mtu,
+		})
+	}
+
+	return interfaces, nil
 }
 
 func (n *workerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
@@ -186,8 +270,10 @@ func (n *workerNode) Setup(nsPath string, podNodeIPs []netip.Addr, config *tunne
 		return fmt.Errorf("failed to get tunneler: %w", err)
 	}
 
-	if err := tun.Setup(nsPath, podNodeIPs, config); err != nil {
-		return fmt.Errorf("failed to set up tunnel %q: %w", config.TunnelType, err)
+	for _, c := range config.InterfaceConfigs() {
+		if err := tun.Setup(nsPath, podNodeIPs, c); err != nil {
+			return fmt.Errorf("failed to set up tunnel %q for %s: %w", c.TunnelType, c.InterfaceName, err)
+		}
 	}
 
 	return nil
@@ -219,8 +305,31 @@ func (n *workerNode) Teardown(nsPath string, config *tunneler.Config) error {
 		hostInterface = hostPrimaryInterface
 	}
 
-	if err := tun.Teardown(nsPath, hostInterface, config); err != nil {
-		return fmt.Errorf("failed to tear down tunnel %q: %w", config.TunnelType, err)
+	for _, c := range config.InterfaceConfigs() {
+		if err := tun.Teardown(nsPath, hostInterface, c); err != nil {
+			return fmt.Errorf("failed to tear down tunnel %q for %s: %w", c.TunnelType, c.InterfaceName, err)
+		}
+	}
+
+	return nil
+}
+
+func (n *workerNode) Check(nsPath string, podNodeIPs []netip.Addr, config *tunneler.Config) error {
+
+	tun, err := tunneler.WorkerNodeTunneler(n.tunnelType)
+	if err != nil {
+		return fmt.Errorf("failed to get tunneler: %w", err)
+	}
+
+	checker, ok := tun.(tunneler.Checker)
+	if !ok {
+		return nil
+	}
+
+	for _, c := range config.InterfaceConfigs() {
+		if err := checker.Check(nsPath, podNodeIPs, c); err != nil {
+			return fmt.Errorf("failed to check tunnel %q for %s: %w", c.TunnelType, c.InterfaceName, err)
+		}
 	}
 
 	return nil
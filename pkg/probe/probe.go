@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var logger = log.New(log.Writer(), "[probe/probe] ", log.LstdFlags|log.Lmsgprefix)
@@ -64,6 +66,7 @@ func Start(socketPath string) {
 		SocketPath:       socketPath,
 	}
 	http.HandleFunc("/startup", StartupHandler)
+	http.Handle("/metrics", promhttp.Handler())
 	err = http.ListenAndServe(":"+port, nil)
 
 	if err != nil {
@@ -0,0 +1,405 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeError   = "error"
+	AuditOutcomeDenied  = "denied"
+
+	redactedValue = "**********"
+
+	DefaultAuditLogMaxSize    = 100 * 1024 * 1024
+	DefaultAuditLogMaxBackups = 5
+)
+
+// Sandbox identifies the pod whose agent API calls are recorded
+type Sandbox struct {
+	ID           string
+	PodName      string
+	PodNamespace string
+}
+
+// AuditRecord is a record of an agent API call
+type AuditRecord struct {
+	Time         time.Time              `json:"time"`
+	Component    string                 `json:"component"`
+	SandboxID    string                 `json:"sandbox-id,omitempty"`
+	PodName      string                 `json:"pod-name,omitempty"`
+	PodNamespace string                 `json:"pod-namespace,omitempty"`
+	Method       string                 `json:"method"`
+	ContainerID  string                 `json:"container-id,omitempty"`
+	ExecID       string                 `json:"exec-id,omitempty"`
+	Outcome      string                 `json:"outcome"`
+	Error        string                 `json:"error,omitempty"`
+	LatencyMS    float64                `json:"latency-ms"`
+	Request      map[string]interface{} `json:"request,omitempty"`
+}
+
+// AuditSink stores audit records
+type AuditSink interface {
+	Write(record *AuditRecord) error
+	Close() error
+}
+
+// OpenAuditSink opens an audit sink specified by dest.
+// dest is "stdout", "stderr", or a file path. An empty dest disables audit logging and returns nil.
+func OpenAuditSink(dest string) (AuditSink, error) {
+	switch dest {
+	case "":
+		return nil, nil
+	case "stdout":
+		return NewAuditWriterSink(os.Stdout), nil
+	case "stderr":
+		return NewAuditWriterSink(os.Stderr), nil
+	}
+	return NewAuditFileSink(dest, DefaultAuditLogMaxSize, DefaultAuditLogMaxBackups)
+}
+
+type writerSink struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// NewAuditWriterSink returns an audit sink that writes JSON lines to w
+func NewAuditWriterSink(w io.Writer) AuditSink {
+	return &writerSink{writer: w}
+}
+
+func (s *writerSink) Write(record *AuditRecord) error {
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// NewAuditFileSink returns an audit sink that writes JSON lines to a file.
+// When the file exceeds maxSize bytes, it is rotated to path.1, path.2, ..., and at most maxBackups old files are kept.
+func NewAuditFileSink(path string, maxSize int64, maxBackups int) (AuditSink, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create a directory for audit log %s: %w", path, err)
+	}
+
+	s := &fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", s.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to get the size of audit log %s: %w", s.path, err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log %s: %w", s.path, err)
+	}
+
+	for i := s.maxBackups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", s.path, i)
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log %s: %w", src, err)
+		}
+	}
+
+	if s.maxBackups > 0 {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log %s: %w", s.path, err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("failed to remove audit log %s: %w", s.path, err)
+	}
+
+	return s.open()
+}
+
+func (s *fileSink) Write(record *AuditRecord) error {
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("audit log %s is closed", s.path)
+	}
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// NewAuditInterceptor returns a ttrpc server interceptor that records every call to sink.
+// component identifies the recording component, e.g. "proxy" or "forwarder".
+func NewAuditInterceptor(sink AuditSink, component string, sandbox Sandbox) ttrpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+
+		start := time.Now()
+
+		var req interface{}
+		res, err := method(ctx, func(v interface{}) error {
+			if err := unmarshal(v); err != nil {
+				return err
+			}
+			req = v
+			return nil
+		})
+
+		record := &AuditRecord{
+			Time:         start.UTC(),
+			Component:    component,
+			SandboxID:    sandbox.ID,
+			PodName:      sandbox.PodName,
+			PodNamespace: sandbox.PodNamespace,
+			Method:       path.Base(info.FullMethod),
+			LatencyMS:    float64(time.Since(start).Microseconds()) / 1000,
+			Outcome:      AuditOutcomeSuccess,
+		}
+
+		if err != nil {
+			record.Outcome = AuditOutcomeError
+			if status.Code(err) == codes.PermissionDenied {
+				record.Outcome = AuditOutcomeDenied
+			}
+			record.Error = err.Error()
+		}
+
+		record.ContainerID = stringField(req, "ContainerId")
+		record.ExecID = stringField(req, "ExecId")
+		record.Request = summarizeRequest(req)
+
+		if e := sink.Write(record); e != nil {
+			logger.Printf("failed to record an audit log entry of %s: %v", info.FullMethod, e)
+		}
+
+		return res, err
+	}
+}
+
+// ServerOpts returns ttrpc server options to enforce policy and record audit logs.
+// policy and sink are optional.
+func ServerOpts(policy Policy, sink AuditSink, component string, sandbox Sandbox) []ttrpc.ServerOpt {
+
+	var interceptors []ttrpc.UnaryServerInterceptor
+
+	// The audit interceptor is the outermost, so that denied calls are also recorded
+	if sink != nil {
+		interceptors = append(interceptors, NewAuditInterceptor(sink, component, sandbox))
+	}
+	if policy != nil {
+		interceptors = append(interceptors, NewPolicyInterceptor(policy))
+	}
+
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	return []ttrpc.ServerOpt{ttrpc.WithUnaryServerInterceptor(ChainUnaryServerInterceptors(interceptors...))}
+}
+
+// ChainUnaryServerInterceptors combines interceptors into one. The first interceptor is the outermost.
+func ChainUnaryServerInterceptors(interceptors ...ttrpc.UnaryServerInterceptor) ttrpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+
+		next := method
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				return interceptor(ctx, unmarshal, info, inner)
+			}
+		}
+		return next(ctx, unmarshal)
+	}
+}
+
+// stringField returns the value of a string field of a request. Kata agent requests are generated without getters.
+func stringField(req interface{}, name string) string {
+
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+
+	f := v.Elem().FieldByName(name)
+	if f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
+
+var sensitiveKey = regexp.MustCompile(`(?i)(pass|secret|token|key|credential|auth|cert)`)
+
+// redactKeyValues masks values of KEY=VALUE or --key=value strings whose key looks sensitive
+func redactKeyValues(items []string) []string {
+
+	var redacted []string
+	for _, item := range items {
+		if key, _, ok := strings.Cut(item, "="); ok && sensitiveKey.MatchString(key) {
+			item = key + "=" + redactedValue
+		}
+		redacted = append(redacted, item)
+	}
+	return redacted
+}
+
+// sensitiveShortFlags are short flags whose next argument is a password, e.g. "mysql -p secret"
+var sensitiveShortFlags = map[string]bool{"-p": true}
+
+// redactArgs masks values of command line arguments whose flag looks sensitive. In addition to KEY=VALUE
+// and --key=value, the argument that follows a sensitive flag such as "--password secret" or "-p secret" is masked.
+func redactArgs(args []string) []string {
+
+	redacted := redactKeyValues(args)
+	for i := 0; i < len(redacted)-1; i++ {
+		flag := redacted[i]
+		if !strings.HasPrefix(flag, "-") || strings.Contains(flag, "=") {
+			continue
+		}
+		if sensitiveShortFlags[flag] || sensitiveKey.MatchString(strings.TrimLeft(flag, "-")) {
+			redacted[i+1] = redactedValue
+			i++
+		}
+	}
+	return redacted
+}
+
+// summarizeRequest returns a redacted summary of a request. Payloads such as stdin data
+// and file contents are recorded only by their size.
+func summarizeRequest(req interface{}) map[string]interface{} {
+
+	switch r := req.(type) {
+	case *pb.CreateContainerRequest:
+		summary := map[string]interface{}{}
+		if r.OCI != nil {
+			if r.OCI.Process != nil {
+				summary["args"] = redactArgs(r.OCI.Process.Args)
+				summary["env"] = redactKeyValues(r.OCI.Process.Env)
+			}
+			for key, value := range r.OCI.Annotations {
+				if strings.HasSuffix(key, "image-name") || strings.HasSuffix(key, "image_name") {
+					summary["image"] = value
+				}
+			}
+		}
+		return summary
+	case *pb.ExecProcessRequest:
+		summary := map[string]interface{}{}
+		if r.Process != nil {
+			summary["args"] = redactArgs(r.Process.Args)
+			summary["env"] = redactKeyValues(r.Process.Env)
+			summary["terminal"] = r.Process.Terminal
+		}
+		return summary
+	case *pb.SignalProcessRequest:
+		return map[string]interface{}{"signal": r.Signal}
+	case *pb.WriteStreamRequest:
+		return map[string]interface{}{"size": len(r.Data)}
+	case *pb.ReadStreamRequest:
+		return map[string]interface{}{"len": r.Len}
+	case *pb.CopyFileRequest:
+		return map[string]interface{}{"path": r.Path, "size": len(r.Data)}
+	case *pb.PullImageRequest:
+		return map[string]interface{}{"image": r.Image}
+	case *pb.CreateSandboxRequest:
+		return map[string]interface{}{"hostname": r.Hostname}
+	case *pb.SetIPTablesRequest:
+		return map[string]interface{}{"ipv6": r.IsIpv6, "size": len(r.Data)}
+	}
+
+	return nil
+}
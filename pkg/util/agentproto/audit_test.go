@@ -0,0 +1,182 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+)
+
+func TestAuditInterceptor(t *testing.T) {
+
+	policy, err := ParsePolicy(`{"deny": ["ExecProcess"]}`)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	sink := NewAuditWriterSink(&buf)
+	sandbox := Sandbox{ID: "abc", PodName: "mypod", PodNamespace: "default"}
+
+	server, err := ttrpc.NewServer(ServerOpts(policy, sink, "proxy", sandbox)...)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer server.Close()
+
+	mock := &agentMock{}
+	pb.RegisterAgentServiceService(server, mock)
+	pb.RegisterHealthService(server, mock)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	go server.Serve(context.Background(), listener) //nolint:errcheck
+
+	r := NewRedirector(func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer r.Close()
+
+	createReq := &pb.CreateContainerRequest{
+		ContainerId: "123",
+		OCI: &pb.Spec{
+			Process: &pb.Process{
+				Args: []string{"server", "--api-token=abcdef"},
+				Env:  []string{"PATH=/bin", "DB_PASSWORD=hunter2"},
+			},
+		},
+	}
+	if _, err := r.CreateContainer(context.Background(), createReq); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if _, err := r.ExecProcess(context.Background(), &pb.ExecProcessRequest{ContainerId: "123", ExecId: "456"}); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+
+	var records []*AuditRecord
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		records = append(records, &record)
+	}
+
+	if e, a := 2, len(records); e != a {
+		t.Fatalf("Expect %d records, got %d", e, a)
+	}
+
+	create := records[0]
+	if e, a := "CreateContainer", create.Method; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := AuditOutcomeSuccess, create.Outcome; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := "123", create.ContainerID; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := "mypod", create.PodName; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := "proxy", create.Component; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	summary := fmt.Sprint(create.Request)
+	if strings.Contains(summary, "hunter2") || strings.Contains(summary, "abcdef") {
+		t.Fatalf("Expect secrets to be redacted, got %s", summary)
+	}
+	if !strings.Contains(summary, "PATH=/bin") {
+		t.Fatalf("Expect non-sensitive env to be recorded, got %s", summary)
+	}
+
+	exec := records[1]
+	if e, a := AuditOutcomeDenied, exec.Outcome; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := "456", exec.ExecID; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
+
+func TestAuditFileSink(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+
+	sink, err := NewAuditFileSink(path, 512, 2)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		record := &AuditRecord{
+			Time:        time.Now(),
+			Component:   "forwarder",
+			Method:      "StartContainer",
+			ContainerID: fmt.Sprintf("container-%d", i),
+			Outcome:     AuditOutcomeSuccess,
+		}
+		if err := sink.Write(record); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Expect %s to exist, got %v", p, err)
+		}
+		if info.Size() > 512 {
+			t.Fatalf("Expect %s to be rotated at 512 bytes, got %d bytes", p, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Expect %s.3 not to exist, got %v", path, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if !strings.Contains(string(data), "container-19") {
+		t.Fatalf("Expect the latest record in %s, got %s", path, data)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+
+	args := []string{"mysql", "-u", "root", "-p", "hunter2", "--password", "abcdef", "--token=xyz", "-api-key", "k", "PATH=/bin", "--verbose", "db"}
+	expected := []string{"mysql", "-u", "root", "-p", redactedValue, "--password", redactedValue, "--token=" + redactedValue, "-api-key", redactedValue, "PATH=/bin", "--verbose", "db"}
+
+	if e, a := expected, redactArgs(args); !reflect.DeepEqual(e, a) {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	// A trailing sensitive flag has no value to redact
+	if e, a := []string{"cmd", "--password"}, redactArgs([]string{"cmd", "--password"}); !reflect.DeepEqual(e, a) {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
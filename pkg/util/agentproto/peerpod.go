@@ -0,0 +1,74 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/ttrpc"
+	"github.com/gogo/protobuf/types"
+)
+
+// PeerPodServiceName is the ttrpc service name of PeerPodService
+const PeerPodServiceName = "peerpod.PeerPodService"
+
+// PeerPodService is a service provided by agent-protocol-forwarder in addition to the kata agent API.
+// It is used by cloud-api-adaptor to manage a pod VM over the established agent connection.
+type PeerPodService interface {
+	// UpdateServerCertificate replaces the TLS server certificate of agent-protocol-forwarder
+	UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error
+}
+
+// serverCertificate is a request of UpdateServerCertificate. It is encoded in JSON and
+// sent as types.BytesValue, since ttrpc requires protobuf messages.
+type serverCertificate struct {
+	Cert []byte `json:"cert"`
+	Key  []byte `json:"key"`
+}
+
+// RegisterPeerPodService registers svc to a ttrpc server
+func RegisterPeerPodService(srv *ttrpc.Server, svc PeerPodService) {
+
+	srv.Register(PeerPodServiceName, map[string]ttrpc.Method{
+		"UpdateServerCertificate": func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+
+			var req types.BytesValue
+			if err := unmarshal(&req); err != nil {
+				return nil, err
+			}
+
+			var cert serverCertificate
+			if err := json.Unmarshal(req.Value, &cert); err != nil {
+				return nil, fmt.Errorf("failed to decode a server certificate: %w", err)
+			}
+
+			if err := svc.UpdateServerCertificate(ctx, cert.Cert, cert.Key); err != nil {
+				return nil, err
+			}
+
+			return &types.Empty{}, nil
+		},
+	})
+}
+
+type peerPodClient struct {
+	client *ttrpc.Client
+}
+
+// NewPeerPodClient returns a PeerPodService client that uses a ttrpc client
+func NewPeerPodClient(client *ttrpc.Client) PeerPodService {
+	return &peerPodClient{client: client}
+}
+
+func (c *peerPodClient) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+
+	data, err := json.Marshal(&serverCertificate{Cert: certPEM, Key: keyPEM})
+	if err != nil {
+		return fmt.Errorf("failed to encode a server certificate: %w", err)
+	}
+
+	return c.client.Call(ctx, PeerPodServiceName, "UpdateServerCertificate", &types.BytesValue{Value: data}, &types.Empty{})
+}
@@ -0,0 +1,65 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+)
+
+type peerPodMock struct {
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func (m *peerPodMock) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+	if len(certPEM) == 0 {
+		return errors.New("empty certificate")
+	}
+	m.certPEM = certPEM
+	m.keyPEM = keyPEM
+	return nil
+}
+
+func TestPeerPodService(t *testing.T) {
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer server.Close()
+
+	mock := &peerPodMock{}
+	RegisterPeerPodService(server, mock)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	go server.Serve(context.Background(), listener) //nolint:errcheck
+
+	r := NewRedirector(func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer r.Close()
+
+	if err := r.UpdateServerCertificate(context.Background(), []byte("cert"), []byte("key")); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "cert", string(mock.certPEM); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := "key", string(mock.keyPEM); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	if err := r.UpdateServerCertificate(context.Background(), nil, nil); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+}
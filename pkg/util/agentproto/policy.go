@@ -0,0 +1,172 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PolicyAnnotation is a pod annotation that specifies an agent API policy document
+const PolicyAnnotation = "io.confidentialcontainers.org.peerpod.agent-policy"
+
+// Policy decides whether an agent API call is allowed.
+// method is a method name without the service name, e.g. "ExecProcess".
+// Evaluate returns a non-nil error that describes the reason when the call is denied.
+type Policy interface {
+	Evaluate(ctx context.Context, method string, req interface{}) error
+}
+
+const (
+	PolicyActionAllow = "allow"
+	PolicyActionDeny  = "deny"
+)
+
+// AllowlistPolicy is a declarative policy based on method names.
+// A method listed in Deny is denied. A method listed in Allow is allowed.
+// Other methods are handled by Default, which is "allow" if not specified.
+//
+//	{"default": "deny", "allow": ["CreateSandbox", "CreateContainer", ...]}
+//	{"deny": ["ExecProcess", "CopyFile", "ReadStdout", "ReadStderr"]}
+type AllowlistPolicy struct {
+	Default string   `json:"default,omitempty"`
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+}
+
+// ParsePolicy parses a JSON policy document
+func ParsePolicy(doc string) (Policy, error) {
+
+	var p AllowlistPolicy
+
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse agent policy: %w", err)
+	}
+
+	switch p.Default {
+	case "", PolicyActionAllow, PolicyActionDeny:
+	default:
+		return nil, fmt.Errorf("invalid default action of agent policy: %q", p.Default)
+	}
+
+	for _, method := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if method == "" || strings.ContainsAny(method, "/ ") {
+			return nil, fmt.Errorf("invalid method name in agent policy: %q", method)
+		}
+	}
+
+	return &p, nil
+}
+
+func (p *AllowlistPolicy) Evaluate(ctx context.Context, method string, req interface{}) error {
+
+	for _, m := range p.Deny {
+		if m == method {
+			return fmt.Errorf("%s is in the deny list", method)
+		}
+	}
+
+	for _, m := range p.Allow {
+		if m == method {
+			return nil
+		}
+	}
+
+	if p.Default == PolicyActionDeny {
+		return fmt.Errorf("%s is not in the allow list", method)
+	}
+
+	return nil
+}
+
+// combinedPolicy allows a call only if all its policies allow it
+type combinedPolicy []Policy
+
+// CombinePolicies returns a policy that allows a call only if all the non-nil policies allow it.
+// It returns nil when no policy is given.
+func CombinePolicies(policies ...Policy) Policy {
+
+	var combined combinedPolicy
+	for _, p := range policies {
+		if p != nil {
+			combined = append(combined, p)
+		}
+	}
+
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	}
+	return combined
+}
+
+func (c combinedPolicy) Evaluate(ctx context.Context, method string, req interface{}) error {
+
+	for _, p := range c {
+		if err := p.Evaluate(ctx, method, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandboxPolicy allows CreateSandbox only for a specific sandbox
+type sandboxPolicy string
+
+// NewSandboxPolicy returns a policy that denies CreateSandbox of a sandbox other than sandboxID.
+// It binds a pod VM to the sandbox that its user data was created for, so that user data
+// replayed to another pod VM cannot be used to run a different sandbox.
+func NewSandboxPolicy(sandboxID string) Policy {
+	return sandboxPolicy(sandboxID)
+}
+
+func (p sandboxPolicy) Evaluate(ctx context.Context, method string, req interface{}) error {
+
+	if r, ok := req.(*pb.CreateSandboxRequest); ok && r.SandboxId != string(p) {
+		return fmt.Errorf("sandbox %q does not match sandbox %q of the pod VM", r.SandboxId, string(p))
+	}
+	return nil
+}
+
+// NewPolicyInterceptor returns a ttrpc server interceptor that evaluates the policy for each call.
+// A denied call fails with codes.PermissionDenied without reaching the service.
+// Calls of PeerPodService are not evaluated, since the policy governs the kata agent API, and
+// cloud-api-adaptor needs PeerPodService to keep the pod VM reachable, e.g. to renew its server certificate.
+func NewPolicyInterceptor(policy Policy) ttrpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+
+		if path.Dir(info.FullMethod) == "/"+PeerPodServiceName {
+			return method(ctx, unmarshal)
+		}
+
+		name := path.Base(info.FullMethod)
+
+		// The policy is evaluated after the request is decoded, so that a policy can inspect the request
+		return method(ctx, func(req interface{}) error {
+			if err := unmarshal(req); err != nil {
+				return err
+			}
+
+			if err := policy.Evaluate(ctx, name, req); err != nil {
+				logger.Printf("%s is denied by agent policy: %v", info.FullMethod, err)
+				return status.Errorf(codes.PermissionDenied, "%s is denied by agent policy: %v", name, err)
+			}
+
+			return nil
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/containerd/ttrpc"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePolicy(t *testing.T) {
+
+	for _, tc := range []struct {
+		doc     string
+		allowed []string
+		denied  []string
+		invalid bool
+	}{
+		{
+			doc:     `{"deny": ["ExecProcess", "CopyFile"]}`,
+			allowed: []string{"CreateContainer", "ReadStdout"},
+			denied:  []string{"ExecProcess", "CopyFile"},
+		},
+		{
+			doc:     `{"default": "deny", "allow": ["CreateContainer", "ExecProcess"], "deny": ["ExecProcess"]}`,
+			allowed: []string{"CreateContainer"},
+			denied:  []string{"ExecProcess", "ReadStdout"},
+		},
+		{
+			doc:     `{}`,
+			allowed: []string{"ExecProcess"},
+		},
+		{
+			doc:     `{"default": "reject"}`,
+			invalid: true,
+		},
+		{
+			doc:     `{"deny": ["grpc.AgentService/ExecProcess"]}`,
+			invalid: true,
+		},
+		{
+			doc:     `{"denied": ["ExecProcess"]}`,
+			invalid: true,
+		},
+		{
+			doc:     `deny ExecProcess`,
+			invalid: true,
+		},
+	} {
+		policy, err := ParsePolicy(tc.doc)
+		if tc.invalid {
+			if err == nil {
+				t.Fatalf("Expect error for %q, got nil", tc.doc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expect no error for %q, got %v", tc.doc, err)
+		}
+		for _, method := range tc.allowed {
+			if err := policy.Evaluate(context.Background(), method, nil); err != nil {
+				t.Fatalf("Expect %s to be allowed by %q, got %v", method, tc.doc, err)
+			}
+		}
+		for _, method := range tc.denied {
+			if err := policy.Evaluate(context.Background(), method, nil); err == nil {
+				t.Fatalf("Expect %s to be denied by %q, got nil", method, tc.doc)
+			}
+		}
+	}
+}
+
+func TestPolicyInterceptor(t *testing.T) {
+
+	policy, err := ParsePolicy(`{"deny": ["CreateContainer"]}`)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	server, err := ttrpc.NewServer(ttrpc.WithUnaryServerInterceptor(NewPolicyInterceptor(policy)))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer server.Close()
+
+	mock := &agentMock{}
+	pb.RegisterAgentServiceService(server, mock)
+	pb.RegisterHealthService(server, mock)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	go server.Serve(context.Background(), listener) //nolint:errcheck
+
+	r := NewRedirector(func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer r.Close()
+
+	if _, err := r.Check(context.Background(), &pb.CheckRequest{}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	_, err = r.CreateContainer(context.Background(), &pb.CreateContainerRequest{ContainerId: "123"})
+	if e, a := codes.PermissionDenied, status.Code(err); e != a {
+		t.Fatalf("Expect %s, got %s (%v)", e, a, err)
+	}
+
+	// A denied call does not break the connection
+	if e, a := Ready, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+}
+
+func TestCombinePolicies(t *testing.T) {
+
+	if p := CombinePolicies(nil, nil); p != nil {
+		t.Fatalf("Expect nil, got %v", p)
+	}
+
+	image, err := ParsePolicy(`{"deny": ["ExecProcess"]}`)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	userData, err := ParsePolicy(`{"default": "allow", "allow": ["ExecProcess"], "deny": ["CopyFile"]}`)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	policy := CombinePolicies(image, userData)
+
+	for method, allowed := range map[string]bool{
+		"CreateContainer": true,
+		// The user data cannot allow a call that the image denies
+		"ExecProcess": false,
+		"CopyFile":    false,
+	} {
+		err := policy.Evaluate(context.Background(), method, nil)
+		if allowed && err != nil {
+			t.Fatalf("Expect %s to be allowed, got %v", method, err)
+		}
+		if !allowed && err == nil {
+			t.Fatalf("Expect %s to be denied, got nil", method)
+		}
+	}
+}
+
+func TestPolicyInterceptorPeerPodService(t *testing.T) {
+
+	policy, err := ParsePolicy(`{"default": "deny", "allow": ["CreateSandbox"]}`)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	server, err := ttrpc.NewServer(ttrpc.WithUnaryServerInterceptor(NewPolicyInterceptor(policy)))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer server.Close()
+
+	mock := &peerPodMock{}
+	RegisterPeerPodService(server, mock)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	go server.Serve(context.Background(), listener) //nolint:errcheck
+
+	r := NewRedirector(func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer r.Close()
+
+	// Certificate renewal is not subject to the agent policy
+	if err := r.UpdateServerCertificate(context.Background(), []byte("cert"), []byte("key")); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "cert", string(mock.certPEM); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+}
+
+func TestSandboxPolicy(t *testing.T) {
+
+	policy := NewSandboxPolicy("123")
+	ctx := context.Background()
+
+	if err := policy.Evaluate(ctx, "CreateSandbox", &pb.CreateSandboxRequest{SandboxId: "123"}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := policy.Evaluate(ctx, "CreateSandbox", &pb.CreateSandboxRequest{SandboxId: "456"}); err == nil {
+		t.Fatal("Expect error for another sandbox, got nil")
+	}
+	if err := policy.Evaluate(ctx, "CreateContainer", &pb.CreateContainerRequest{ContainerId: "456"}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+}
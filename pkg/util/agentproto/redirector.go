@@ -7,9 +7,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/avast/retry-go/v4"
 	"github.com/containerd/ttrpc"
 	"github.com/gogo/protobuf/types"
 	"github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols"
@@ -21,392 +27,672 @@ type Redirector interface {
 	pb.AgentServiceService
 	pb.ImageService
 	pb.HealthService
+	PeerPodService
 
 	Connect(ctx context.Context) error
 	Close() error
+	State() ConnectionState
+}
+
+// ConnectionState represents the state of the connection to the agent
+type ConnectionState int32
+
+const (
+	// Idle means that no connection has been established yet
+	Idle ConnectionState = iota
+	// Connecting means that the redirector is dialing the agent
+	Connecting
+	// Ready means that the connection is established
+	Ready
+	// TransientFailure means that the connection is broken or could not be established. The redirector redials on the next call
+	TransientFailure
+	// Shutdown means that the redirector is closed
+	Shutdown
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Idle:
+		return "IDLE"
+	case Connecting:
+		return "CONNECTING"
+	case Ready:
+		return "READY"
+	case TransientFailure:
+		return "TRANSIENT_FAILURE"
+	case Shutdown:
+		return "SHUTDOWN"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int32(s))
 }
 
+const (
+	defaultDialAttempts = 5
+	defaultDialDelay    = 100 * time.Millisecond
+	defaultDialMaxDelay = 5 * time.Second
+
+	// maxReplays is the number of times an idempotent call is replayed after the connection breaks
+	maxReplays = 1
+
+	replay   = true
+	noReplay = false
+)
+
+var ErrClosed = errors.New("agent redirector is closed")
+
+var logger = log.New(log.Writer(), "[util/agentproto] ", log.LstdFlags|log.Lmsgprefix)
+
 type redirector struct {
-	agentClient *client
+	dialer       func(context.Context) (net.Conn, error)
+	dialAttempts uint
+	dialDelay    time.Duration
+	dialMaxDelay time.Duration
+
+	// mutex protects conn, dialing and closed. The agent is dialed without holding mutex, and a dial in
+	// progress is shared by all callers that need a connection.
+	mutex   sync.Mutex
+	conn    *connection
+	dialing *dialCall
+	closed  bool
+	state   int32
+
+	// ctx is the context of dials, which is canceled when the redirector is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// dialCall is a dial in progress. done is closed when conn or err is set.
+type dialCall struct {
+	done chan struct{}
+	conn *connection
+	err  error
+}
+
+type connection struct {
+	*client
 	ttrpcClient *ttrpc.Client
-	dialer      func(context.Context) (net.Conn, error)
-	once        sync.Once
 }
 
 type client struct {
 	pb.AgentServiceService
 	pb.ImageService
 	pb.HealthService
+	PeerPodService
 }
 
 func NewRedirector(dialer func(context.Context) (net.Conn, error)) Redirector {
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &redirector{
-		dialer: dialer,
+		dialer:       dialer,
+		dialAttempts: defaultDialAttempts,
+		dialDelay:    defaultDialDelay,
+		dialMaxDelay: defaultDialMaxDelay,
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
+func (s *redirector) State() ConnectionState {
+	return ConnectionState(atomic.LoadInt32(&s.state))
+}
+
+func (s *redirector) setState(state ConnectionState) {
+	atomic.StoreInt32(&s.state, int32(state))
+}
+
+// Connect establishes a connection to the agent if there is no healthy connection
 func (s *redirector) Connect(ctx context.Context) error {
+	_, err := s.connect(ctx)
+	return err
+}
 
-	var err error
+// connect returns the connection to the agent, or waits for a dial. A dial is not canceled when ctx is done,
+// since other callers may wait for it, but only when the redirector is closed.
+func (s *redirector) connect(ctx context.Context) (*connection, error) {
 
-	s.once.Do(func() {
+	s.mutex.Lock()
 
-		conn, e := s.dialer(ctx)
-		if e != nil {
-			err = e
-			return
-		}
+	if s.closed {
+		s.mutex.Unlock()
+		return nil, ErrClosed
+	}
+
+	if s.conn != nil {
+		conn := s.conn
+		s.mutex.Unlock()
+		return conn, nil
+	}
+
+	call := s.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		s.dialing = call
+
+		reconnect := s.State() != Idle
+		s.setState(Connecting)
+
+		go s.dial(call, reconnect)
+	}
+
+	s.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.conn, call.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("agent connection is not established: %w", ctx.Err())
+	}
+}
+
+// dial establishes a connection to the agent, and passes the result to the callers waiting for call
+func (s *redirector) dial(call *dialCall, reconnect bool) {
+
+	defer close(call.done)
+
+	// The initial connection is dialed once, since the dialer retries until its own timeout.
+	// Redials after a connection failure are retried with backoff.
+	attempts := uint(1)
+	if reconnect {
+		attempts = s.dialAttempts
+	}
+
+	var netConn net.Conn
+	err := retry.Do(
+		func() error {
+			var err error
+			netConn, err = s.dialer(s.ctx)
+			return err
+		},
+		retry.Attempts(attempts),
+		retry.Delay(s.dialDelay),
+		retry.MaxDelay(s.dialMaxDelay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.Context(s.ctx),
+	)
 
-		s.ttrpcClient = ttrpc.NewClient(conn)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		s.agentClient = &client{
-			AgentServiceService: pb.NewAgentServiceClient(s.ttrpcClient),
-			ImageService:        pb.NewImageClient(s.ttrpcClient),
-			HealthService:       pb.NewHealthClient(s.ttrpcClient),
+	s.dialing = nil
+
+	if s.closed {
+		if err == nil {
+			netConn.Close()
 		}
-	})
+		call.err = ErrClosed
+		return
+	}
 
 	if err != nil {
-		return fmt.Errorf("agent connection is not established: %w", err)
+		s.setState(TransientFailure)
+		call.err = fmt.Errorf("agent connection is not established: %w", err)
+		return
 	}
 
-	if s.agentClient == nil {
-		return errors.New("agent connection is not established")
+	conn := &connection{}
+	conn.ttrpcClient = ttrpc.NewClient(netConn, ttrpc.WithOnClose(func() {
+		s.disconnect(conn)
+	}))
+	conn.client = &client{
+		AgentServiceService: pb.NewAgentServiceClient(conn.ttrpcClient),
+		ImageService:        pb.NewImageClient(conn.ttrpcClient),
+		HealthService:       pb.NewHealthClient(conn.ttrpcClient),
+		PeerPodService:      NewPeerPodClient(conn.ttrpcClient),
 	}
 
-	return nil
+	s.conn = conn
+	s.setState(Ready)
+	call.conn = conn
+
+	if reconnect {
+		logger.Printf("agent connection is re-established")
+	}
+}
+
+// disconnect discards a broken connection, so that the next call redials the agent
+func (s *redirector) disconnect(conn *connection) {
+
+	s.mutex.Lock()
+	if s.conn == conn {
+		s.conn = nil
+		if !s.closed {
+			s.setState(TransientFailure)
+			logger.Printf("agent connection is lost")
+		}
+	}
+	s.mutex.Unlock()
+
+	// Close is idempotent. The onClose hook may call this function after the connection is already closed
+	conn.ttrpcClient.Close()
 }
 
 func (s *redirector) Close() error {
-	client := s.ttrpcClient
-	if client == nil {
+
+	s.mutex.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.closed = true
+	s.setState(Shutdown)
+	s.mutex.Unlock()
+
+	// A dial in progress is aborted
+	s.cancel()
+
+	if conn == nil {
 		return nil
 	}
-	return client.Close()
+	return conn.ttrpcClient.Close()
+}
+
+// invoke calls fn with a healthy connection. When the connection is found
+// broken, the connection is discarded, and fn is replayed on a new
+// connection if the call is idempotent.
+func (s *redirector) invoke(ctx context.Context, idempotent bool, fn func(c *client) error) error {
+
+	for attempt := 0; ; attempt++ {
+
+		conn, err := s.connect(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn.client)
+		if err == nil || !isConnectionError(err) {
+			return err
+		}
+
+		s.disconnect(conn)
+
+		if !idempotent || attempt >= maxReplays || ctx.Err() != nil {
+			return err
+		}
+
+		logger.Printf("replaying an agent call after connection failure: %v", err)
+	}
+}
+
+func isConnectionError(err error) bool {
+	return errors.Is(err, ttrpc.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
 }
 
 // AgentServiceService methods
 
 func (s *redirector) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CreateContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.CreateContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) StartContainer(ctx context.Context, req *pb.StartContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.StartContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.StartContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) RemoveContainer(ctx context.Context, req *pb.RemoveContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.RemoveContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.RemoveContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ExecProcess(ctx context.Context, req *pb.ExecProcessRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ExecProcess(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ExecProcess(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) SignalProcess(ctx context.Context, req *pb.SignalProcessRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SignalProcess(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.SignalProcess(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) WaitProcess(ctx context.Context, req *pb.WaitProcessRequest) (res *pb.WaitProcessResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.WaitProcess(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.WaitProcess(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) UpdateContainer(ctx context.Context, req *pb.UpdateContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.UpdateContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) UpdateEphemeralMounts(ctx context.Context, req *pb.UpdateEphemeralMountsRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateEphemeralMounts(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.UpdateEphemeralMounts(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) StatsContainer(ctx context.Context, req *pb.StatsContainerRequest) (res *pb.StatsContainerResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.StatsContainer(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.StatsContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) PauseContainer(ctx context.Context, req *pb.PauseContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.PauseContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.PauseContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ResumeContainer(ctx context.Context, req *pb.ResumeContainerRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ResumeContainer(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ResumeContainer(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) RemoveStaleVirtiofsShareMounts(ctx context.Context, req *pb.RemoveStaleVirtiofsShareMountsRequest) (res *types.Empty, err error) {
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.RemoveStaleVirtiofsShareMounts(ctx, req)
+
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.RemoveStaleVirtiofsShareMounts(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) WriteStdin(ctx context.Context, req *pb.WriteStreamRequest) (res *pb.WriteStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.WriteStdin(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.WriteStdin(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ReadStdout(ctx context.Context, req *pb.ReadStreamRequest) (res *pb.ReadStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReadStdout(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ReadStdout(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ReadStderr(ctx context.Context, req *pb.ReadStreamRequest) (res *pb.ReadStreamResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReadStderr(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ReadStderr(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) CloseStdin(ctx context.Context, req *pb.CloseStdinRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CloseStdin(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.CloseStdin(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) TtyWinResize(ctx context.Context, req *pb.TtyWinResizeRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.TtyWinResize(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.TtyWinResize(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) UpdateInterface(ctx context.Context, req *pb.UpdateInterfaceRequest) (res *protocols.Interface, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateInterface(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.UpdateInterface(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) UpdateRoutes(ctx context.Context, req *pb.UpdateRoutesRequest) (res *pb.Routes, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.UpdateRoutes(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.UpdateRoutes(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ListInterfaces(ctx context.Context, req *pb.ListInterfacesRequest) (res *pb.Interfaces, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ListInterfaces(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.ListInterfaces(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ListRoutes(ctx context.Context, req *pb.ListRoutesRequest) (res *pb.Routes, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ListRoutes(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.ListRoutes(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) AddARPNeighbors(ctx context.Context, req *pb.AddARPNeighborsRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.AddARPNeighbors(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.AddARPNeighbors(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) GetIPTables(ctx context.Context, req *pb.GetIPTablesRequest) (res *pb.GetIPTablesResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetIPTables(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.GetIPTables(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) SetIPTables(ctx context.Context, req *pb.SetIPTablesRequest) (res *pb.SetIPTablesResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SetIPTables(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.SetIPTables(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (res *pb.Metrics, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetMetrics(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.GetMetrics(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) CreateSandbox(ctx context.Context, req *pb.CreateSandboxRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CreateSandbox(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.CreateSandbox(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) DestroySandbox(ctx context.Context, req *pb.DestroySandboxRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.DestroySandbox(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.DestroySandbox(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) OnlineCPUMem(ctx context.Context, req *pb.OnlineCPUMemRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.OnlineCPUMem(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.OnlineCPUMem(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ReseedRandomDev(ctx context.Context, req *pb.ReseedRandomDevRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ReseedRandomDev(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ReseedRandomDev(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) GetGuestDetails(ctx context.Context, req *pb.GuestDetailsRequest) (res *pb.GuestDetailsResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetGuestDetails(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.GetGuestDetails(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) MemHotplugByProbe(ctx context.Context, req *pb.MemHotplugByProbeRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.MemHotplugByProbe(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.MemHotplugByProbe(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) SetGuestDateTime(ctx context.Context, req *pb.SetGuestDateTimeRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.SetGuestDateTime(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.SetGuestDateTime(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) CopyFile(ctx context.Context, req *pb.CopyFileRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.CopyFile(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.CopyFile(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) GetOOMEvent(ctx context.Context, req *pb.GetOOMEventRequest) (res *pb.OOMEvent, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetOOMEvent(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.GetOOMEvent(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) AddSwap(ctx context.Context, req *pb.AddSwapRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.AddSwap(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.AddSwap(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) GetVolumeStats(ctx context.Context, req *pb.VolumeStatsRequest) (res *pb.VolumeStatsResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.GetVolumeStats(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.GetVolumeStats(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) ResizeVolume(ctx context.Context, req *pb.ResizeVolumeRequest) (res *types.Empty, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.ResizeVolume(ctx, req)
+	err = s.invoke(ctx, noReplay, func(c *client) (err error) {
+		res, err = c.ResizeVolume(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 // ImageService method
 
 func (s *redirector) PullImage(ctx context.Context, req *pb.PullImageRequest) (res *pb.PullImageResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.PullImage(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.PullImage(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 // HealthService methods
 
 func (s *redirector) Check(ctx context.Context, req *pb.CheckRequest) (res *pb.HealthCheckResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.Check(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.Check(ctx, req)
+		return err
+	})
+	return res, err
 }
 
 func (s *redirector) Version(ctx context.Context, req *pb.CheckRequest) (res *pb.VersionCheckResponse, err error) {
 
-	if err := s.Connect(ctx); err != nil {
-		return nil, err
-	}
-	return s.agentClient.Version(ctx, req)
+	err = s.invoke(ctx, replay, func(c *client) (err error) {
+		res, err = c.Version(ctx, req)
+		return err
+	})
+	return res, err
+}
+
+// PeerPodService methods
+
+func (s *redirector) UpdateServerCertificate(ctx context.Context, certPEM, keyPEM []byte) error {
+
+	return s.invoke(ctx, replay, func(c *client) error {
+		return c.UpdateServerCertificate(ctx, certPEM, keyPEM)
+	})
 }
@@ -0,0 +1,352 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package agentproto
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"github.com/gogo/protobuf/types"
+	pb "github.com/kata-containers/kata-containers/src/runtime/virtcontainers/pkg/agent/protocols/grpc"
+)
+
+type agentMock struct {
+	pb.AgentServiceService
+	pb.ImageService
+
+	// block is closed when the first call is received, and the call blocks until the connection is closed
+	block chan struct{}
+	once  sync.Once
+}
+
+func (m *agentMock) call(ctx context.Context) {
+	if m.block == nil {
+		return
+	}
+
+	blocked := false
+	m.once.Do(func() {
+		blocked = true
+		close(m.block)
+	})
+	if blocked {
+		<-ctx.Done()
+	}
+}
+
+func (m *agentMock) Check(ctx context.Context, req *pb.CheckRequest) (*pb.HealthCheckResponse, error) {
+	m.call(ctx)
+	return &pb.HealthCheckResponse{}, nil
+}
+
+func (m *agentMock) Version(ctx context.Context, req *pb.CheckRequest) (*pb.VersionCheckResponse, error) {
+	m.call(ctx)
+	return &pb.VersionCheckResponse{}, nil
+}
+
+func (m *agentMock) CreateContainer(ctx context.Context, req *pb.CreateContainerRequest) (*types.Empty, error) {
+	m.call(ctx)
+	return &types.Empty{}, nil
+}
+
+type testAgent struct {
+	mock     *agentMock
+	listener net.Listener
+	server   *ttrpc.Server
+
+	mutex sync.Mutex
+	conns []net.Conn
+	dials int
+}
+
+func startTestAgent(t *testing.T, mock *agentMock) *testAgent {
+	t.Helper()
+
+	server, err := ttrpc.NewServer()
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	pb.RegisterAgentServiceService(server, mock)
+	pb.RegisterHealthService(server, mock)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	go server.Serve(context.Background(), listener) //nolint:errcheck
+
+	return &testAgent{
+		mock:     mock,
+		listener: listener,
+		server:   server,
+	}
+}
+
+func (a *testAgent) shutdown() {
+	a.server.Close()
+}
+
+func (a *testAgent) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", a.listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.conns = append(a.conns, conn)
+	a.dials++
+	return conn, nil
+}
+
+// breakConnections simulates a network failure by closing the client side of all connections
+func (a *testAgent) breakConnections() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, conn := range a.conns {
+		conn.Close()
+	}
+	a.conns = nil
+}
+
+func (a *testAgent) dialCount() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.dials
+}
+
+func waitForState(t *testing.T, r Redirector, state ConnectionState) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expect state %s, got %s", state, r.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedirectorReconnect(t *testing.T) {
+
+	agent := startTestAgent(t, &agentMock{})
+	defer agent.shutdown()
+
+	r := NewRedirector(agent.dial)
+	defer r.Close()
+
+	if e, a := Idle, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+
+	if _, err := r.Check(context.Background(), &pb.CheckRequest{}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := Ready, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+
+	agent.breakConnections()
+	waitForState(t, r, TransientFailure)
+
+	if _, err := r.CreateContainer(context.Background(), &pb.CreateContainerRequest{}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := Ready, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+	if e, a := 2, agent.dialCount(); e != a {
+		t.Fatalf("Expect %d dials, got %d", e, a)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := Shutdown, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+	if _, err := r.Check(context.Background(), &pb.CheckRequest{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expect %v, got %v", ErrClosed, err)
+	}
+}
+
+func TestRedirectorReplay(t *testing.T) {
+
+	for _, tc := range []struct {
+		name       string
+		call       func(r Redirector) error
+		idempotent bool
+	}{
+		{
+			name: "idempotent",
+			call: func(r Redirector) error {
+				_, err := r.Version(context.Background(), &pb.CheckRequest{})
+				return err
+			},
+			idempotent: true,
+		},
+		{
+			name: "non-idempotent",
+			call: func(r Redirector) error {
+				_, err := r.CreateContainer(context.Background(), &pb.CreateContainerRequest{})
+				return err
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			mock := &agentMock{block: make(chan struct{})}
+			agent := startTestAgent(t, mock)
+			defer agent.shutdown()
+
+			r := NewRedirector(agent.dial)
+			defer r.Close()
+
+			go func() {
+				<-mock.block
+				agent.breakConnections()
+			}()
+
+			err := tc.call(r)
+
+			if tc.idempotent {
+				if err != nil {
+					t.Fatalf("Expect no error, got %v", err)
+				}
+				if e, a := 2, agent.dialCount(); e != a {
+					t.Fatalf("Expect %d dials, got %d", e, a)
+				}
+				if e, a := Ready, r.State(); e != a {
+					t.Fatalf("Expect %s, got %s", e, a)
+				}
+			} else {
+				if !errors.Is(err, ttrpc.ErrClosed) {
+					t.Fatalf("Expect %v, got %v", ttrpc.ErrClosed, err)
+				}
+				if e, a := 1, agent.dialCount(); e != a {
+					t.Fatalf("Expect %d dials, got %d", e, a)
+				}
+				if e, a := TransientFailure, r.State(); e != a {
+					t.Fatalf("Expect %s, got %s", e, a)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectorDialFailure(t *testing.T) {
+
+	dials := 0
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}
+
+	r := NewRedirector(dialer).(*redirector)
+	r.dialAttempts = 3
+	r.dialDelay = time.Millisecond
+	defer r.Close()
+
+	// The initial connection is dialed once
+	if err := r.Connect(context.Background()); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+	if e, a := 1, dials; e != a {
+		t.Fatalf("Expect %d dials, got %d", e, a)
+	}
+
+	// Redials are retried
+	if err := r.Connect(context.Background()); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+	if e, a := 4, dials; e != a {
+		t.Fatalf("Expect %d dials, got %d", e, a)
+	}
+	if e, a := TransientFailure, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+}
+
+func TestRedirectorSharedDial(t *testing.T) {
+
+	agent := startTestAgent(t, &agentMock{})
+	defer agent.shutdown()
+
+	release := make(chan struct{})
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		<-release
+		return agent.dial(ctx)
+	}
+
+	r := NewRedirector(dialer)
+	defer r.Close()
+
+	// Callers wait for the dial in progress, instead of dialing again
+	errs := make(chan error)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- r.Connect(context.Background())
+		}()
+	}
+	waitForState(t, r, Connecting)
+
+	// A caller gives up waiting when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expect %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+	}
+	if e, a := 1, agent.dialCount(); e != a {
+		t.Fatalf("Expect %d dials, got %d", e, a)
+	}
+	if e, a := Ready, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+}
+
+func TestRedirectorCloseDuringDial(t *testing.T) {
+
+	dialer := func(ctx context.Context) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	r := NewRedirector(dialer)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- r.Connect(context.Background())
+	}()
+	waitForState(t, r, Connecting)
+
+	// Close does not wait for the dial, and aborts it
+	if err := r.Close(); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("Expect %v, got %v", ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expect the dial to be aborted, got blocked")
+	}
+	if e, a := Shutdown, r.State(); e != a {
+		t.Fatalf("Expect %s, got %s", e, a)
+	}
+}
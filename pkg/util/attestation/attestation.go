@@ -0,0 +1,129 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package attestation implements an attested TLS connection between cloud-api-adaptor and agent-protocol-forwarder.
+//
+// After a TLS handshake, cloud-api-adaptor sends a random nonce, and agent-protocol-forwarder responds with
+// TEE evidence of the pod VM. The report data of the evidence is bound to the nonce and to keying material
+// exported from the TLS session, so that evidence cannot be replayed or relayed from another TLS session.
+// cloud-api-adaptor verifies the evidence with a Verifier, and evaluates the verified claims against
+// reference values before it uses the connection.
+package attestation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var logger = log.New(log.Writer(), "[util/attestation] ", log.LstdFlags|log.Lmsgprefix)
+
+// Evidence is TEE evidence of a pod VM
+type Evidence struct {
+	// TEE is a TEE type, e.g. "snp", "tdx", or "kbs-token"
+	TEE string `json:"tee"`
+	// Evidence is TEE specific evidence, e.g. an attestation report or a token issued by KBS
+	Evidence []byte `json:"evidence"`
+}
+
+// Claims are verified properties of a pod VM, e.g. a launch measurement
+type Claims map[string]interface{}
+
+// Attester generates evidence that includes reportData
+type Attester interface {
+	GetEvidence(ctx context.Context, reportData []byte) (*Evidence, error)
+}
+
+// Verifier verifies evidence, and returns verified claims. Verify must fail when the evidence does not include reportData.
+type Verifier interface {
+	Verify(ctx context.Context, evidence *Evidence, reportData []byte) (Claims, error)
+}
+
+// Config is a configuration of attestation on the client side
+type Config struct {
+	Verifier Verifier
+	Policy   *ReferencePolicy
+}
+
+// ReferencePolicy specifies acceptable TEE types and reference values of claims.
+// A claim name is a dot separated path of nested claims. A claim value must match one of the reference values.
+//
+//	{"tees": ["snp"], "reference-values": {"measurement": ["8a3f..."], "policy.debug": ["false"]}}
+type ReferencePolicy struct {
+	TEEs            []string            `json:"tees,omitempty"`
+	ReferenceValues map[string][]string `json:"reference-values,omitempty"`
+}
+
+// LoadReferencePolicy loads a JSON reference policy from a file
+func LoadReferencePolicy(path string) (*ReferencePolicy, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attestation policy %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var policy ReferencePolicy
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation policy %s: %w", path, err)
+	}
+
+	return &policy, nil
+}
+
+// Evaluate checks a TEE type and verified claims against the policy
+func (p *ReferencePolicy) Evaluate(tee string, claims Claims) error {
+
+	if len(p.TEEs) > 0 && !contains(p.TEEs, tee) {
+		return fmt.Errorf("TEE type %q is not allowed", tee)
+	}
+
+	for name, values := range p.ReferenceValues {
+		value, ok := lookupClaim(claims, name)
+		if !ok {
+			return fmt.Errorf("claim %q is not found", name)
+		}
+		if !contains(values, value) {
+			return fmt.Errorf("claim %q does not match reference values: %s", name, value)
+		}
+	}
+
+	return nil
+}
+
+func lookupClaim(claims Claims, name string) (string, bool) {
+
+	var current interface{} = map[string]interface{}(claims)
+
+	for _, key := range strings.Split(name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if current, ok = m[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch current.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+
+	return fmt.Sprint(current), true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
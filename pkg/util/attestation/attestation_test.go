@@ -0,0 +1,52 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package attestation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReferencePolicy(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	doc := `{"tees": ["snp"], "reference-values": {"measurement": ["abc", "def"], "policy.debug": ["false"]}}`
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	policy, err := LoadReferencePolicy(path)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		tee    string
+		claims Claims
+		valid  bool
+	}{
+		{tee: "snp", claims: Claims{"measurement": "def", "policy": map[string]interface{}{"debug": false}}, valid: true},
+		{tee: "tdx", claims: Claims{"measurement": "def", "policy": map[string]interface{}{"debug": false}}},
+		{tee: "snp", claims: Claims{"measurement": "xyz", "policy": map[string]interface{}{"debug": false}}},
+		{tee: "snp", claims: Claims{"measurement": "abc", "policy": map[string]interface{}{"debug": true}}},
+		{tee: "snp", claims: Claims{"measurement": "abc"}},
+		{tee: "snp", claims: Claims{"measurement": "abc", "policy": "debug"}},
+	} {
+		err := policy.Evaluate(tc.tee, tc.claims)
+		if tc.valid && err != nil {
+			t.Fatalf("Expect no error for %v, got %v", tc.claims, err)
+		}
+		if !tc.valid && err == nil {
+			t.Fatalf("Expect error for %s %v, got nil", tc.tee, tc.claims)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"tee": ["snp"]}`), 0600); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := LoadReferencePolicy(path); err == nil {
+		t.Fatal("Expect error for an unknown field, got nil")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// commandRequest is written to the standard input of an attester or verifier command
+type commandRequest struct {
+	ReportData []byte    `json:"report-data"`
+	Evidence   *Evidence `json:"evidence,omitempty"`
+}
+
+type commandAttester struct {
+	path string
+}
+
+// NewCommandAttester returns an attester that runs an external command, e.g. an evidence getter of guest components.
+// The command reads {"report-data": <base64>} from its standard input, and writes evidence
+// {"tee": <TEE type>, "evidence": <base64>} to its standard output.
+func NewCommandAttester(path string) Attester {
+	return &commandAttester{path: path}
+}
+
+func (a *commandAttester) GetEvidence(ctx context.Context, reportData []byte) (*Evidence, error) {
+
+	var evidence Evidence
+	if err := runCommand(ctx, a.path, &commandRequest{ReportData: reportData}, &evidence); err != nil {
+		return nil, err
+	}
+
+	return &evidence, nil
+}
+
+type commandVerifier struct {
+	path string
+}
+
+// NewCommandVerifier returns a verifier that runs an external command, e.g. a client of an attestation service.
+// The command reads {"report-data": <base64>, "evidence": {"tee": <TEE type>, "evidence": <base64>}} from its
+// standard input, and writes verified claims as a JSON object to its standard output.
+// The command must exit with a non-zero status when the evidence is invalid or does not include the report data.
+func NewCommandVerifier(path string) Verifier {
+	return &commandVerifier{path: path}
+}
+
+func (v *commandVerifier) Verify(ctx context.Context, evidence *Evidence, reportData []byte) (Claims, error) {
+
+	var claims Claims
+	if err := runCommand(ctx, v.path, &commandRequest{ReportData: reportData, Evidence: evidence}, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func runCommand(ctx context.Context, path string, req interface{}, res interface{}) error {
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode input of %s: %w", path, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+		return fmt.Errorf("failed to decode output of %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package attestation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeScript(t *testing.T, content string) string {
+
+	path := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+content), 0700); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	return path
+}
+
+func TestCommand(t *testing.T) {
+
+	// The attester returns the report data as evidence, and the verifier returns it as a claim
+	attester := NewCommandAttester(writeScript(t, `sed 's/.*"report-data":"\([^"]*\)".*/{"tee":"sample","evidence":"\1"}/'`))
+	verifier := NewCommandVerifier(writeScript(t, `sed 's/.*"evidence":"\([^"]*\)".*/{"evidence":"\1"}/'`))
+
+	reportData := []byte("0123456789abcdef")
+
+	evidence, err := attester.GetEvidence(context.Background(), reportData)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "sample", evidence.TEE; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+	if e, a := string(reportData), string(evidence.Evidence); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	claims, err := verifier.Verify(context.Background(), evidence, reportData)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "MDEyMzQ1Njc4OWFiY2RlZg==", claims["evidence"]; e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	failing := NewCommandVerifier(writeScript(t, `echo "invalid evidence" >&2; exit 1`))
+	if _, err := failing.Verify(context.Background(), evidence, reportData); err == nil {
+		t.Fatal("Expect error, got nil")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package attestation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	nonceSize      = 32
+	maxMessageSize = 1024 * 1024
+
+	exporterLabel = "EXPORTER-peerpod-attestation"
+
+	// DefaultHandshakeTimeout is the maximum duration of an attestation handshake
+	DefaultHandshakeTimeout = 30 * time.Second
+)
+
+type challenge struct {
+	Nonce []byte `json:"nonce"`
+}
+
+type response struct {
+	Evidence *Evidence `json:"evidence,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ClientHandshake requests evidence over an established TLS connection, and verifies it.
+// The connection must not be used when an error is returned.
+func ClientHandshake(ctx context.Context, conn net.Conn, config *Config) error {
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return errors.New("attestation requires a TLS connection")
+	}
+
+	reset := setDeadline(ctx, conn)
+	defer reset()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("failed to complete TLS handshake: %w", err)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate a nonce: %w", err)
+	}
+
+	reportData, err := bindReportData(tlsConn, nonce)
+	if err != nil {
+		return err
+	}
+
+	if err := writeMessage(conn, &challenge{Nonce: nonce}); err != nil {
+		return fmt.Errorf("failed to send an attestation challenge: %w", err)
+	}
+
+	var res response
+	if err := readMessage(conn, &res); err != nil {
+		return fmt.Errorf("failed to receive attestation evidence: %w", err)
+	}
+	if res.Error != "" {
+		return fmt.Errorf("pod VM failed to provide attestation evidence: %s", res.Error)
+	}
+	if res.Evidence == nil {
+		return errors.New("pod VM provided no attestation evidence")
+	}
+
+	claims, err := config.Verifier.Verify(ctx, res.Evidence, reportData)
+	if err != nil {
+		return fmt.Errorf("failed to verify attestation evidence: %w", err)
+	}
+
+	if config.Policy != nil {
+		if err := config.Policy.Evaluate(res.Evidence.TEE, claims); err != nil {
+			return fmt.Errorf("attestation evidence is rejected by policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ServerHandshake responds to an attestation challenge with evidence generated by attester
+func ServerHandshake(ctx context.Context, conn net.Conn, attester Attester) error {
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return errors.New("attestation requires a TLS connection")
+	}
+
+	reset := setDeadline(ctx, conn)
+	defer reset()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("failed to complete TLS handshake: %w", err)
+	}
+
+	var req challenge
+	if err := readMessage(conn, &req); err != nil {
+		return fmt.Errorf("failed to receive an attestation challenge: %w", err)
+	}
+	if len(req.Nonce) != nonceSize {
+		return fmt.Errorf("invalid nonce size: %d", len(req.Nonce))
+	}
+
+	reportData, err := bindReportData(tlsConn, req.Nonce)
+	if err != nil {
+		return err
+	}
+
+	var res response
+	evidence, err := attester.GetEvidence(ctx, reportData)
+	if err != nil {
+		err = fmt.Errorf("failed to get attestation evidence: %w", err)
+		res.Error = err.Error()
+	} else {
+		res.Evidence = evidence
+	}
+
+	if e := writeMessage(conn, &res); e != nil {
+		return fmt.Errorf("failed to send attestation evidence: %w", e)
+	}
+
+	return err
+}
+
+// bindReportData derives report data from a nonce and keying material of a TLS session
+func bindReportData(conn *tls.Conn, nonce []byte) ([]byte, error) {
+
+	state := conn.ConnectionState()
+
+	ekm, err := state.ExportKeyingMaterial(exporterLabel, nil, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export keying material of TLS session: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write(nonce)
+	h.Write(ekm)
+	return h.Sum(nil), nil
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) (reset func()) {
+
+	deadline := time.Now().Add(DefaultHandshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	return func() {
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+func writeMessage(w io.Writer, v interface{}) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+func readMessage(r io.Reader, v interface{}) error {
+
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxMessageSize {
+		return fmt.Errorf("message size %d exceeds the limit %d", size, maxMessageSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+type listener struct {
+	net.Listener
+	attester  Attester
+	connCh    chan net.Conn
+	errCh     chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener returns a listener that performs an attestation handshake on each accepted TLS connection.
+// Handshakes are performed concurrently, so that a slow peer does not block other connections.
+func NewListener(inner net.Listener, attester Attester) net.Listener {
+
+	l := &listener{
+		Listener: inner,
+		attester: attester,
+		connCh:   make(chan net.Conn),
+		errCh:    make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	go l.acceptLoop()
+
+	return l
+}
+
+func (l *listener) acceptLoop() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errCh <- err:
+			case <-l.done:
+				return
+			}
+			var ne interface{ Temporary() bool }
+			if errors.As(err, &ne) && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		go func() {
+			if err := ServerHandshake(ctx, conn, l.attester); err != nil {
+				logger.Printf("attestation handshake with %s failed: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+
+			select {
+			case l.connCh <- conn:
+			case <-l.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case err := <-l.errCh:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
@@ -0,0 +1,207 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/tlsutil"
+)
+
+type fakeReport struct {
+	ReportData  []byte `json:"report-data"`
+	Measurement string `json:"measurement"`
+}
+
+type fakeAttester struct {
+	measurement string
+	// relay replaces report data to simulate evidence obtained from another session
+	relay []byte
+}
+
+func (a *fakeAttester) GetEvidence(ctx context.Context, reportData []byte) (*Evidence, error) {
+
+	if a.relay != nil {
+		reportData = a.relay
+	}
+
+	data, err := json.Marshal(&fakeReport{ReportData: reportData, Measurement: a.measurement})
+	if err != nil {
+		return nil, err
+	}
+	return &Evidence{TEE: "fake", Evidence: data}, nil
+}
+
+type fakeVerifier struct{}
+
+func (v *fakeVerifier) Verify(ctx context.Context, evidence *Evidence, reportData []byte) (Claims, error) {
+
+	var report fakeReport
+	if err := json.Unmarshal(evidence.Evidence, &report); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(report.ReportData, reportData) {
+		return nil, errors.New("report data mismatch")
+	}
+	return Claims{"measurement": report.Measurement}, nil
+}
+
+func newTLSConfigs(t *testing.T) (server, client *tls.Config) {
+
+	caService, err := tlsutil.NewCAService("agent-protocol-forwarder", time.Hour)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	certPEM, keyPEM, err := caService.Issue("podvm")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	server, err = tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{CertData: certPEM, KeyData: keyPEM})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	client, err = tlsutil.GetTLSConfigFor(&tlsutil.TLSConfig{CAData: caService.RootCertificate()})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	client.ServerName = "podvm"
+
+	return server, client
+}
+
+func TestHandshake(t *testing.T) {
+
+	serverConfig, clientConfig := newTLSConfigs(t)
+
+	policy := &ReferencePolicy{
+		TEEs:            []string{"fake"},
+		ReferenceValues: map[string][]string{"measurement": {"good"}},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		attester *fakeAttester
+		success  bool
+	}{
+		{
+			name:     "trusted pod VM",
+			attester: &fakeAttester{measurement: "good"},
+			success:  true,
+		},
+		{
+			name:     "unexpected measurement",
+			attester: &fakeAttester{measurement: "bad"},
+		},
+		{
+			name:     "relayed evidence",
+			attester: &fakeAttester{measurement: "good", relay: make([]byte, 32)},
+		},
+	} {
+		inner, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		listener := NewListener(inner, tc.attester)
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					_, _ = io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+
+		err = ClientHandshake(context.Background(), conn, &Config{Verifier: &fakeVerifier{}, Policy: policy})
+		if tc.success {
+			if err != nil {
+				t.Fatalf("%s: Expect no error, got %v", tc.name, err)
+			}
+
+			// The connection is usable after the handshake
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatalf("%s: Expect no error, got %v", tc.name, err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatalf("%s: Expect no error, got %v", tc.name, err)
+			}
+			if e, a := "ping", string(buf); e != a {
+				t.Fatalf("%s: Expect %q, got %q", tc.name, e, a)
+			}
+		} else if err == nil {
+			t.Fatalf("%s: Expect error, got nil", tc.name)
+		}
+
+		conn.Close()
+		listener.Close()
+	}
+}
+
+func TestListenerSlowPeer(t *testing.T) {
+
+	serverConfig, clientConfig := newTLSConfigs(t)
+
+	inner, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	listener := NewListener(inner, &fakeAttester{measurement: "good"})
+	defer listener.Close()
+
+	// A peer that never sends a challenge does not block other connections
+	slow, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer slow.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	defer conn.Close()
+
+	if err := ClientHandshake(context.Background(), conn, &Config{Verifier: &fakeVerifier{}}); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expect an attested connection to be accepted")
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expect %v, got %v", net.ErrClosed, err)
+	}
+}
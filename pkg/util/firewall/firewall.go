@@ -0,0 +1,99 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+// Backend is a packet filter backend used on a host
+type Backend string
+
+const (
+	// IPTables manages rules by using the iptables command
+	IPTables Backend = "iptables"
+	// NFTables manages rules in dedicated nftables tables by using the nft command
+	NFTables Backend = "nftables"
+)
+
+const (
+	IPTablesCommand = "iptables"
+	NFTCommand      = "nft"
+)
+
+// These variables are used to detect the firewall backend, and can be overridden in unit tests
+var (
+	lookPath   = exec.LookPath
+	runCommand = func(name string, args ...string) (string, error) {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		return string(out), err
+	}
+)
+
+// Detect identifies the firewall backend used on the network namespace.
+//
+// The iptables backend is used when the iptables command runs in legacy mode, since mixing iptables-legacy
+// rules with nftables rules breaks CNI plugins. The nftables backend is used when the iptables command
+// is missing or uses the nf_tables kernel API, as long as the nft command is available.
+func Detect(ns netops.Namespace) (Backend, error) {
+
+	var backend Backend
+
+	err := ns.Run(func() error {
+
+		_, nftErr := lookPath(NFTCommand)
+
+		if _, err := lookPath(IPTablesCommand); err != nil {
+			if nftErr != nil {
+				return fmt.Errorf("neither %s nor %s command is available", IPTablesCommand, NFTCommand)
+			}
+			backend = NFTables
+			return nil
+		}
+
+		out, err := runCommand(IPTablesCommand, "--version")
+		if err != nil {
+			return fmt.Errorf("failed to get %s version: %w", IPTablesCommand, err)
+		}
+
+		if strings.Contains(out, "nf_tables") && nftErr == nil {
+			backend = NFTables
+		} else {
+			backend = IPTables
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return backend, nil
+}
+
+// RunNft runs an nft script in the current network namespace
+func RunNft(script string) error {
+
+	cmd := exec.Command(NFTCommand, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// DeleteNftTable deletes an nftables table in the current network namespace, if it exists
+func DeleteNftTable(family, name string) error {
+
+	// Declaring the table before deleting it makes deletion succeed even when the table does not exist
+	return RunNft(fmt.Sprintf("table %s %s\ndelete table %s %s\n", family, name, family, name))
+}
@@ -0,0 +1,73 @@
+// (C) Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package firewall
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	testutils "github.com/confidential-containers/cloud-api-adaptor/pkg/internal/testing"
+	"github.com/confidential-containers/cloud-api-adaptor/pkg/util/netops"
+)
+
+func TestDetect(t *testing.T) {
+	testutils.SkipTestIfNotRoot(t)
+
+	ns, err := netops.OpenCurrentNamespace()
+	if err != nil {
+		t.Fatalf("Expect no error, got %q", err)
+	}
+	defer ns.Close()
+
+	origLookPath, origRunCommand := lookPath, runCommand
+	defer func() {
+		lookPath, runCommand = origLookPath, origRunCommand
+	}()
+
+	for _, tc := range []struct {
+		name     string
+		commands []string
+		version  string
+		expected Backend
+	}{
+		{name: "legacy", commands: []string{IPTablesCommand, NFTCommand}, version: "iptables v1.8.7 (legacy)", expected: IPTables},
+		{name: "nf_tables", commands: []string{IPTablesCommand, NFTCommand}, version: "iptables v1.8.7 (nf_tables)", expected: NFTables},
+		{name: "nf_tables without nft", commands: []string{IPTablesCommand}, version: "iptables v1.8.7 (nf_tables)", expected: IPTables},
+		{name: "nft only", commands: []string{NFTCommand}, expected: NFTables},
+		{name: "none"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+
+			lookPath = func(file string) (string, error) {
+				for _, cmd := range tc.commands {
+					if cmd == file {
+						return "/usr/sbin/" + file, nil
+					}
+				}
+				return "", exec.ErrNotFound
+			}
+			runCommand = func(name string, args ...string) (string, error) {
+				if name != IPTablesCommand {
+					return "", errors.New("unexpected command")
+				}
+				return tc.version, nil
+			}
+
+			backend, err := Detect(ns)
+			if tc.expected == "" {
+				if err == nil {
+					t.Fatal("Expect an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expect no error, got %q", err)
+			}
+			if e, a := tc.expected, backend; e != a {
+				t.Fatalf("Expect %q, got %q", e, a)
+			}
+		})
+	}
+}
@@ -26,6 +26,7 @@ type Namespace interface {
 	Path() string
 	RedirectAdd(src, dst string) error
 	RedirectDel(src string) error
+	RedirectExists(src, dst string) (bool, error)
 	RouteAdd(route *Route) error
 	RouteDel(route *Route) error
 	RouteList(filters ...*Route) ([]*Route, error)
@@ -168,11 +169,13 @@ type Link interface {
 	SetHardwareAddr(hwAddr string) error
 	GetMTU() (int, error)
 	SetMTU(mtu int) error
+	GetVXLAN() (*VXLAN, error)
 
 	SetMaster(master Link) error
 	SetNamespace(target Namespace) error
 	SetName(name string) error
 	SetUp() error
+	IsUp() bool
 }
 
 type link struct {
@@ -232,6 +235,21 @@ func (l *link) SetMTU(mtu int) error {
 	return nil
 }
 
+// GetVXLAN returns the remote address, VNI and port of a VXLAN interface
+func (l *link) GetVXLAN() (*VXLAN, error) {
+
+	vxlan, ok := l.nlLink.(*netlink.Vxlan)
+	if !ok {
+		return nil, fmt.Errorf("interface %q is not a vxlan interface: %s", l.Name(), l.Type())
+	}
+
+	return &VXLAN{
+		Group: toAddr(vxlan.Group).Unmap(),
+		ID:    vxlan.VxlanId,
+		Port:  vxlan.Port,
+	}, nil
+}
+
 func (l *link) GetHardwareAddr() (string, error) {
 
 	hwAddr := l.nlLink.Attrs().HardwareAddr.String()
@@ -264,11 +282,16 @@ func (l *link) SetMaster(master Link) error {
 
 func (l *link) SetNamespace(target Namespace) error {
 
-	if err := l.ns.handle.LinkSetNsFd(l.nlLink, target.(*namespace).fd()); err != nil {
+	ns, ok := target.(*namespace)
+	if !ok {
+		return fmt.Errorf("unsupported network namespace to move interface %s to: %T", l.Name(), target)
+	}
+
+	if err := l.ns.handle.LinkSetNsFd(l.nlLink, ns.fd()); err != nil {
 		return fmt.Errorf("failed to change network namespace of interface %s from %s to %s: %w", l.Name(), l.ns.path, target.Path(), err)
 	}
 
-	l.ns = target.(*namespace)
+	l.ns = ns
 
 	return nil
 }
@@ -290,6 +313,10 @@ func (l *link) SetUp() error {
 	return nil
 }
 
+func (l *link) IsUp() bool {
+	return l.nlLink.Attrs().Flags&net.FlagUp != 0
+}
+
 func (l *link) Delete() error {
 
 	if err := l.ns.handle.LinkDel(l.nlLink); err != nil {
@@ -299,7 +326,7 @@ func (l *link) Delete() error {
 }
 
 type Device interface {
-	getLink() netlink.Link
+	getLink() (netlink.Link, error)
 }
 
 type VEth struct {
@@ -307,44 +334,70 @@ type VEth struct {
 	PeerNamespace Namespace
 }
 
-func (d *VEth) getLink() netlink.Link {
+func (d *VEth) getLink() (netlink.Link, error) {
+
+	peerNS, ok := d.PeerNamespace.(*namespace)
+	if !ok {
+		return nil, fmt.Errorf("unsupported peer network namespace of veth interface: %T", d.PeerNamespace)
+	}
 
 	return &netlink.Veth{
 		PeerName:      d.PeerName,
-		PeerNamespace: netlink.NsFd(d.PeerNamespace.(*namespace).nsHandle),
-	}
+		PeerNamespace: netlink.NsFd(peerNS.nsHandle),
+	}, nil
 }
 
 type Bridge struct{}
 
-func (d *Bridge) getLink() netlink.Link {
+func (d *Bridge) getLink() (netlink.Link, error) {
 
-	return &netlink.Bridge{}
+	return &netlink.Bridge{}, nil
 }
 
 type VXLAN struct {
 	Group netip.Addr
 	ID    int
 	Port  int
+
+	// Namespace is a network namespace where the VXLAN interface is created.
+	// The UDP socket of the VXLAN interface stays in the namespace where LinkAdd is called.
+	Namespace Namespace
 }
 
-func (d *VXLAN) getLink() netlink.Link {
+func (d *VXLAN) getLink() (netlink.Link, error) {
 
-	return &netlink.Vxlan{
+	vxlan := &netlink.Vxlan{
 		Group:   toIP(d.Group),
 		VxlanId: d.ID,
 		Port:    d.Port,
 	}
+	if d.Namespace != nil {
+		ns, ok := d.Namespace.(*namespace)
+		if !ok {
+			return nil, fmt.Errorf("unsupported network namespace of vxlan interface: %T", d.Namespace)
+		}
+		vxlan.Attrs().Namespace = netlink.NsFd(ns.nsHandle)
+	}
+	return vxlan, nil
+}
+
+func (d *VXLAN) targetNamespace() Namespace {
+	return d.Namespace
+}
+
+// namespacedDevice is implemented by devices that can be created in a network namespace other than the current one
+type namespacedDevice interface {
+	targetNamespace() Namespace
 }
 
 type VRF struct {
 	Table uint32
 }
 
-func (d *VRF) getLink() netlink.Link {
+func (d *VRF) getLink() (netlink.Link, error) {
 	return &netlink.Vrf{
 		Table: d.Table,
-	}
+	}, nil
 }
 
 func (ns *namespace) LinkFind(name string) (Link, error) {
@@ -390,16 +443,24 @@ func (ns *namespace) LinkList() ([]Link, error) {
 // LinkAdd creates a new link with an attribute specified by device
 func (ns *namespace) LinkAdd(name string, device Device) (Link, error) {
 
-	nlLink := device.getLink()
+	nlLink, err := device.getLink()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create interface %q: %s: %w", name, ns.Path(), err)
+	}
 	nlLink.Attrs().Name = name
 
 	if err := ns.handle.LinkAdd(nlLink); err != nil {
 		return nil, fmt.Errorf("failed to create %s interface %q: %s:  %w", nlLink.Type(), name, ns.Path(), err)
 	}
 
-	link, err := ns.LinkFind(name)
+	var target Namespace = ns
+	if d, ok := device.(namespacedDevice); ok && d.targetNamespace() != nil {
+		target = d.targetNamespace()
+	}
+
+	link, err := target.LinkFind(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find created %s interface %q on %s:  %w", nlLink.Type(), name, ns.Path(), err)
+		return nil, fmt.Errorf("failed to find created %s interface %q on %s:  %w", nlLink.Type(), name, target.Path(), err)
 	}
 
 	return link, err
@@ -759,6 +820,37 @@ func (ns *namespace) RedirectDel(src string) error {
 	return nil
 }
 
+// RedirectExists checks whether a tc redirect filter that redirects all traffic from src to dst exists
+func (ns *namespace) RedirectExists(src, dst string) (bool, error) {
+	srcLink, err := ns.handle.LinkByName(src)
+	if err != nil {
+		return false, fmt.Errorf("failed to get interface %s: %w", src, err)
+	}
+
+	dstLink, err := ns.handle.LinkByName(dst)
+	if err != nil {
+		return false, fmt.Errorf("failed to get interface %s: %w", dst, err)
+	}
+
+	filters, err := ns.handle.FilterList(srcLink, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		return false, fmt.Errorf("failed to get a list of filters on %s: %w", src, err)
+	}
+	for _, filter := range filters {
+		u32, ok := filter.(*netlink.U32)
+		if !ok {
+			continue
+		}
+		for _, action := range u32.Actions {
+			if mirred, ok := action.(*netlink.MirredAction); ok && mirred.MirredAction == netlink.TCA_EGRESS_REDIR && mirred.Ifindex == dstLink.Attrs().Index {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
 func toAddr(ip net.IP) netip.Addr {
 
 	addr, _ := netip.AddrFromSlice(ip)
@@ -54,3 +54,19 @@ func TestRouteList(t *testing.T) {
 		t.Logf("Route: dst:%s, gw:%s, dev:%s, prio: %d", route.Destination.String(), route.Gateway.String(), route.Device, route.Priority)
 	}
 }
+
+type otherNamespace struct {
+	Namespace
+}
+
+func TestUnsupportedNamespace(t *testing.T) {
+
+	for _, device := range []Device{
+		&VXLAN{ID: 555000, Port: 4789, Namespace: &otherNamespace{}},
+		&VEth{PeerName: "eth0", PeerNamespace: &otherNamespace{}},
+	} {
+		if _, err := device.getLink(); err == nil {
+			t.Fatalf("Expect an error for %T in another implementation of Namespace, got nil", device)
+		}
+	}
+}
@@ -8,12 +8,17 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"log"
 	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -30,47 +35,173 @@ import (
 // 5. cloud-api-adaptor initiates TLS connection to agent-protocol-forwarder using the client cert/key
 // 6. agent-protocol-adaptor validates incoming TLS connection using the client certificate
 // 7. cloud-api-adaptor validates the server certificate sent from agent-protocol-forwarder using the server CA certificate
+// 8. Before a server certificate expires, cloud-api-adaptor issues a new one and sends it to agent-protocol-forwarder
+//    over the established connection. agent-protocol-forwarder uses it for new TLS handshakes.
+//
+// When a CA directory is specified, the CA certificate and key are loaded from the directory, or generated and stored
+// there at the first start up. The directory uses the layout of a kubernetes.io/tls Secret (tls.crt and tls.key), so
+// a Secret, e.g. one managed by a cert-manager CA Certificate, can be mounted there. In this case, the client certificate
+// is also issued by the CA, so that pod VMs keep trusting cloud-api-adaptor after it restarts.
+
+var logger = log.New(log.Writer(), "[util/tlsutil] ", log.LstdFlags|log.Lmsgprefix)
 
 const (
 	validFor = 2 * 365 * 24 * time.Hour
+
+	// DefaultServerCertLifetime is the default lifetime of server certificates issued for pod VMs.
+	// agent-protocol-forwarder stores a renewed certificate, so a pod VM that restarts keeps using it.
+	DefaultServerCertLifetime = 24 * time.Hour
+
+	// caExpiryWarningPeriod is how long before its expiry a CA certificate is reported as expiring
+	caExpiryWarningPeriod = 90 * 24 * time.Hour
+
+	caCertFileName = "tls.crt"
+	caKeyFileName  = "tls.key"
 )
 
 type CAService interface {
 	RootCertificate() (certPEM []byte)
-	Issue(serverName string) (certPEM, keyPEM []byte, err error)
+	Issue(serverName string, uris ...*url.URL) (certPEM, keyPEM []byte, err error)
+	IssueClient(orgName string) (certPEM, keyPEM []byte, err error)
 }
 
 type caService struct {
-	orgName string
-	certPEM []byte
-	keyPEM  []byte
+	orgName            string
+	certPEM            []byte
+	keyPEM             []byte
+	notAfter           time.Time
+	serverCertLifetime time.Duration
 }
 
-func NewCAService(orgName string) (CAService, error) {
+// NewCAService generates an in-memory CA. Server certificates are valid for serverCertLifetime,
+// or DefaultServerCertLifetime if serverCertLifetime is zero.
+func NewCAService(orgName string, serverCertLifetime time.Duration) (CAService, error) {
 
-	certPEM, keyPEM, err := generateCertificate(orgName, "", nil, nil, false, true)
+	certPEM, keyPEM, err := generateCertificate(orgName, "", nil, nil, nil, false, true, validFor)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to set up a CA service for %q", orgName)
 	}
 
+	return newCAService(orgName, certPEM, keyPEM, serverCertLifetime), nil
+}
+
+// LoadOrCreateCAService loads a CA certificate and key from dir. When they do not exist, it generates
+// a new CA and stores it in dir, so that the same CA is used after restart. An expired CA is replaced with
+// a new one in the same way, since no certificate issued by it is valid anymore. A CA that expires soon is
+// reported, so that it can be rotated before pod VMs become unreachable.
+func LoadOrCreateCAService(orgName, dir string, serverCertLifetime time.Duration) (CAService, error) {
+
+	certPath := filepath.Join(dir, caCertFileName)
+	keyPath := filepath.Join(dir, caKeyFileName)
+
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+
+	switch {
+	case certErr == nil && keyErr == nil:
+		notAfter, err := validateCA(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA in %s: %w", dir, err)
+		}
+		if time.Now().Before(notAfter) {
+			return newCAService(orgName, certPEM, keyPEM, serverCertLifetime), nil
+		}
+		logger.Printf("CA certificate in %s expired at %s. Generating a new CA", dir, notAfter)
+
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		// Generate a new CA below
+
+	case certErr != nil && !errors.Is(certErr, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to read a CA certificate %s: %w", certPath, certErr)
+	case keyErr != nil && !errors.Is(keyErr, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to read a CA key %s: %w", keyPath, keyErr)
+	default:
+		return nil, fmt.Errorf("either of a CA certificate or key is missing in %s", dir)
+	}
+
+	certPEM, keyPEM, err := generateCertificate(orgName, "", nil, nil, nil, false, true, validFor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up a CA service for %q", orgName)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create a CA directory %s: %w", dir, err)
+	}
+	// The key is written first, so that a certificate never pairs with the key of an older CA
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to store a CA key %s: %w", keyPath, err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to store a CA certificate %s: %w", certPath, err)
+	}
+
+	return newCAService(orgName, certPEM, keyPEM, serverCertLifetime), nil
+}
+
+func newCAService(orgName string, certPEM, keyPEM []byte, serverCertLifetime time.Duration) *caService {
+
+	if serverCertLifetime == 0 {
+		serverCertLifetime = DefaultServerCertLifetime
+	}
+
 	s := &caService{
-		orgName: orgName,
-		certPEM: certPEM,
-		keyPEM:  keyPEM,
+		orgName:            orgName,
+		certPEM:            certPEM,
+		keyPEM:             keyPEM,
+		serverCertLifetime: serverCertLifetime,
+	}
+
+	if certDER, err := decodePEM(certPEM); err == nil {
+		if cert, err := x509.ParseCertificate(certDER); err == nil {
+			s.notAfter = cert.NotAfter
+		}
+	}
+	s.warnExpiry()
+
+	return s
+}
+
+// validateCA checks that certPEM is a CA certificate that matches keyPEM, and returns its expiry time
+func validateCA(certPEM, keyPEM []byte) (notAfter time.Time, err error) {
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	return s, nil
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse a CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return time.Time{}, errors.New("certificate is not a CA certificate")
+	}
+
+	return cert.NotAfter, nil
+}
+
+// warnExpiry logs a warning if the CA certificate expires within caExpiryWarningPeriod. Rotating the CA
+// requires recreating existing pod VMs, since they only trust a client certificate issued by it.
+func (s *caService) warnExpiry() {
+
+	if !s.notAfter.IsZero() && time.Until(s.notAfter) < caExpiryWarningPeriod {
+		logger.Printf("WARNING: CA certificate of %q expires at %s. Replace the CA and recreate peer pods before then", s.orgName, s.notAfter)
+	}
 }
 
 func (s *caService) RootCertificate() (certPEM []byte) {
 	return s.certPEM
 }
 
-// Issue generates a server certificate for serverName and its private key
-func (s *caService) Issue(serverName string) (certPEM, keyPEM []byte, err error) {
+// Issue generates a server certificate for serverName and its private key. The certificate is valid for
+// the server certificate lifetime of the CA service, but not beyond the expiry of the CA certificate.
+// uris are added to the certificate as URI SANs.
+func (s *caService) Issue(serverName string, uris ...*url.URL) (certPEM, keyPEM []byte, err error) {
 
-	serverCertPEM, serverKeyPEM, err := generateCertificate(s.orgName, serverName, s.certPEM, s.keyPEM, false, false)
+	s.warnExpiry()
+
+	serverCertPEM, serverKeyPEM, err := generateCertificate(s.orgName, serverName, uris, s.certPEM, s.keyPEM, false, false, s.serverCertLifetime)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to issue a server certificate for %q: %w", serverName, err)
 	}
@@ -78,10 +209,38 @@ func (s *caService) Issue(serverName string) (certPEM, keyPEM []byte, err error)
 	return serverCertPEM, serverKeyPEM, nil
 }
 
+// IssueClient generates a client certificate for orgName and its private key.
+// certPEM contains the client certificate followed by the CA certificate, so that a peer can use certPEM as a trusted CA bundle.
+func (s *caService) IssueClient(orgName string) (certPEM, keyPEM []byte, err error) {
+
+	clientCertPEM, clientKeyPEM, err := generateCertificate(orgName, "", nil, s.certPEM, s.keyPEM, true, false, validFor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue a client certificate for %q: %w", orgName, err)
+	}
+
+	return append(clientCertPEM, s.certPEM...), clientKeyPEM, nil
+}
+
+// RenewalTime returns the time when a certificate should be renewed, which is at two thirds of its lifetime
+func RenewalTime(certPEM []byte) (time.Time, error) {
+
+	certDER, err := decodePEM(certPEM)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode a certificate PEM: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse a certificate: %w", err)
+	}
+
+	return cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3), nil
+}
+
 // NewClientCertificate generates a self-signed client certificate for orgName and its private key
 func NewClientCertificate(orgName string) (certPEM, keyPEM []byte, err error) {
 
-	certPEM, keyPEM, err = generateCertificate(orgName, "", nil, nil, true, false)
+	certPEM, keyPEM, err = generateCertificate(orgName, "", nil, nil, nil, true, false, validFor)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate a client certificate for %q", orgName)
 	}
@@ -101,6 +260,23 @@ func decodePEM(pemBytes []byte) ([]byte, error) {
 	return firstBlock.Bytes, nil
 }
 
+// parsePrivateKey parses a private key in PKCS #8, SEC 1, or PKCS #1 form. Keys in a Secret managed
+// by cert-manager are encoded in PKCS #1 or SEC 1 form by default.
+func parsePrivateKey(der []byte) (interface{}, error) {
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unsupported private key form")
+}
+
 func encodePEM(dataType string, der []byte) ([]byte, error) {
 
 	var buf bytes.Buffer
@@ -111,7 +287,7 @@ func encodePEM(dataType string, der []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func generateCertificate(orgName, serverName string, parentCertPEM, parentKeyPEM []byte, isClient, isCA bool) (certPEM, keyPEM []byte, err error) {
+func generateCertificate(orgName, serverName string, uris []*url.URL, parentCertPEM, parentKeyPEM []byte, isClient, isCA bool, lifetime time.Duration) (certPEM, keyPEM []byte, err error) {
 
 	var (
 		signerCert, parentCert *x509.Certificate
@@ -140,7 +316,7 @@ func generateCertificate(orgName, serverName string, parentCertPEM, parentKeyPEM
 			return nil, nil, fmt.Errorf("failed to decode a parent key PEM: %w", err)
 		}
 
-		parentKey, err = x509.ParsePKCS8PrivateKey(parentKeyDER)
+		parentKey, err = parsePrivateKey(parentKeyDER)
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to parse a parent key: %w", err)
 		}
@@ -148,7 +324,7 @@ func generateCertificate(orgName, serverName string, parentCertPEM, parentKeyPEM
 	// Prepare a certificate template
 
 	notBefore := time.Now().UTC().Add(-5 * time.Minute)
-	notAfter := notBefore.Add(validFor)
+	notAfter := notBefore.Add(lifetime)
 
 	if parentCert != nil {
 		if notBefore.Before(parentCert.NotBefore) {
@@ -186,10 +362,13 @@ func generateCertificate(orgName, serverName string, parentCertPEM, parentKeyPEM
 		certTemplate.Subject.CommonName = serverName
 		certTemplate.DNSNames = []string{serverName}
 	}
+	certTemplate.URIs = uris
 
 	if isCA {
 		certTemplate.IsCA = true
 		certTemplate.KeyUsage |= x509.KeyUsageCertSign
+		// A CA issues client certificates as well as server certificates
+		certTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
 	}
 
 	// Generate a private key
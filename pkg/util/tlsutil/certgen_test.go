@@ -6,7 +6,12 @@ package tlsutil
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,7 +21,7 @@ func TestGenerateCertificate(t *testing.T) {
 
 	serverName := "server1"
 
-	caService, err := NewCAService("agent-protocol-forwarder")
+	caService, err := NewCAService("agent-protocol-forwarder", 0)
 	assert.NoError(t, err)
 
 	serverCACertPEM := caService.RootCertificate()
@@ -75,3 +80,167 @@ func TestGenerateCertificate(t *testing.T) {
 
 	assert.Equal(t, recv, msg)
 }
+
+func TestLoadOrCreateCAService(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "ca")
+
+	caService, err := LoadOrCreateCAService("agent-protocol-forwarder", dir, time.Hour)
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, caKeyFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The same CA is loaded after restart
+	reloaded, err := LoadOrCreateCAService("agent-protocol-forwarder", dir, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, caService.RootCertificate(), reloaded.RootCertificate())
+
+	serverCertPEM, _, err := reloaded.Issue("server1")
+	require.NoError(t, err)
+
+	serverCert := parseCertificate(t, serverCertPEM)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), serverCert.NotAfter, 10*time.Minute)
+
+	renewAt, err := RenewalTime(serverCertPEM)
+	require.NoError(t, err)
+	assert.True(t, renewAt.After(time.Now()))
+	assert.True(t, renewAt.Before(serverCert.NotAfter))
+
+	// A client certificate issued by the CA before restart is trusted by a peer that trusts the client certificate bundle
+	clientCertPEM, clientKeyPEM, err := caService.IssueClient("cloud-api-adaptor")
+	require.NoError(t, err)
+
+	newClientCertPEM, newClientKeyPEM, err := reloaded.IssueClient("cloud-api-adaptor")
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(clientCertPEM))
+
+	newClientCert, err := tls.X509KeyPair(newClientCertPEM, newClientKeyPEM)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(newClientCert.Certificate[0])
+	require.NoError(t, err)
+
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	_, err = tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	assert.NoError(t, err)
+
+	// A server certificate is not accepted as a client certificate
+	_, err = serverCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.Error(t, err)
+
+	// A directory with only one of a certificate and a key is rejected
+	require.NoError(t, os.Remove(filepath.Join(dir, caKeyFileName)))
+	_, err = LoadOrCreateCAService("agent-protocol-forwarder", dir, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestLoadOrCreateCAServiceExpired(t *testing.T) {
+
+	dir := t.TempDir()
+
+	expiredCertPEM, expiredKeyPEM, err := generateCertificate("agent-protocol-forwarder", "", nil, nil, nil, false, true, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, caCertFileName), expiredCertPEM, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, caKeyFileName), expiredKeyPEM, 0600))
+
+	// An expired CA is replaced with a new one
+	caService, err := LoadOrCreateCAService("agent-protocol-forwarder", dir, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, expiredCertPEM, caService.RootCertificate())
+
+	storedCertPEM, err := os.ReadFile(filepath.Join(dir, caCertFileName))
+	require.NoError(t, err)
+	assert.Equal(t, caService.RootCertificate(), storedCertPEM)
+
+	caCert := parseCertificate(t, storedCertPEM)
+	assert.True(t, caCert.NotAfter.After(time.Now().Add(caExpiryWarningPeriod)))
+}
+
+func TestCertificateReloader(t *testing.T) {
+
+	caService, err := NewCAService("agent-protocol-forwarder", time.Hour)
+	require.NoError(t, err)
+
+	clientCertPEM, clientKeyPEM, err := NewClientCertificate("cloud-api-adaptor")
+	require.NoError(t, err)
+
+	serverCertPEM, serverKeyPEM, err := caService.Issue("server1")
+	require.NoError(t, err)
+
+	serverConfig, err := GetTLSConfigFor(&TLSConfig{CAData: clientCertPEM, CertData: serverCertPEM, KeyData: serverKeyPEM})
+	require.NoError(t, err)
+
+	reloader, err := NewCertificateReloader(serverConfig)
+	require.NoError(t, err)
+
+	clientConfig, err := GetTLSConfigFor(&TLSConfig{CAData: caService.RootCertificate(), CertData: clientCertPEM, KeyData: clientKeyPEM})
+	require.NoError(t, err)
+	clientConfig.ServerName = "server1"
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	dial := func() *tls.Conn {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		require.NoError(t, err)
+		return conn
+	}
+
+	echo := func(conn *tls.Conn) {
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	}
+
+	oldConn := dial()
+	defer oldConn.Close()
+	echo(oldConn)
+
+	newCertPEM, newKeyPEM, err := caService.Issue("server1")
+	require.NoError(t, err)
+
+	require.NoError(t, reloader.Update(newCertPEM, newKeyPEM))
+
+	newConn := dial()
+	defer newConn.Close()
+	echo(newConn)
+
+	assert.Equal(t, parseCertificate(t, newCertPEM).SerialNumber, newConn.ConnectionState().PeerCertificates[0].SerialNumber)
+
+	// The connection established before the update keeps working
+	echo(oldConn)
+	assert.Equal(t, parseCertificate(t, serverCertPEM).SerialNumber, oldConn.ConnectionState().PeerCertificates[0].SerialNumber)
+
+	assert.Error(t, reloader.Update(newCertPEM, serverKeyPEM))
+}
+
+func parseCertificate(t *testing.T, certPEM []byte) *x509.Certificate {
+
+	der, err := decodePEM(certPEM)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
@@ -0,0 +1,63 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// CertificateReloader holds a certificate that can be replaced while a TLS listener is running.
+// New TLS handshakes use the latest certificate, and established connections are not affected.
+type CertificateReloader struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// NewCertificateReloader returns a reloader that initially serves the first certificate of config,
+// and sets config.GetCertificate to the reloader.
+func NewCertificateReloader(config *tls.Config) (*CertificateReloader, error) {
+
+	if len(config.Certificates) == 0 {
+		return nil, fmt.Errorf("no certificate is configured")
+	}
+
+	r := &CertificateReloader{}
+	r.cert.Store(&config.Certificates[0])
+
+	config.Certificates = nil
+	config.GetCertificate = r.GetCertificate
+
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It is used as tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Update replaces the current certificate with a new pair of certificate and private key.
+func (r *CertificateReloader) Update(certPEM, keyPEM []byte) error {
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to load a new certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse a new certificate: %w", err)
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("new certificate expired at %s", leaf.NotAfter)
+	}
+	cert.Leaf = leaf
+
+	r.cert.Store(&cert)
+
+	return nil
+}
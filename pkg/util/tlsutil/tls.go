@@ -156,3 +156,43 @@ func GetTLSConfigFor(t *TLSConfig) (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// VerifyPeerURI configures a client tls.Config to accept a server certificate only when it has uri as a URI SAN,
+// e.g. a SPIFFE ID. The certificate chain is verified with config.RootCAs, and the host name is not checked.
+// It does nothing when certificate verification is disabled.
+func VerifyPeerURI(config *tls.Config, uri string) {
+
+	if config.InsecureSkipVerify {
+		return
+	}
+
+	roots := config.RootCAs
+
+	// InsecureSkipVerify disables the default verification including the host name check,
+	// and VerifyConnection verifies the certificate chain and the URI instead
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("no server certificate is presented")
+		}
+
+		leaf := cs.PeerCertificates[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return fmt.Errorf("failed to verify server certificate: %w", err)
+		}
+
+		for _, u := range leaf.URIs {
+			if u.String() == uri {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("server certificate is not valid for %s", uri)
+	}
+}
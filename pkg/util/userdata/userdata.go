@@ -0,0 +1,376 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package userdata protects the integrity, and optionally the confidentiality, of pod VM user data.
+//
+// cloud-api-adaptor seals user data in an envelope that is signed with a private key of cloud-api-adaptor,
+// and process-user-data in a pod VM opens the envelope with the public key baked in the pod VM image.
+// The payload can also be encrypted with a symmetric key that a key broker service (KBS) releases to
+// the pod VM after attestation.
+package userdata
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// Version identifies the envelope format
+	Version = "peerpod-userdata/v1"
+
+	// EncryptionAlgorithm is AES-256 in GCM mode
+	EncryptionAlgorithm = "A256GCM"
+
+	encryptionKeySize = 32
+)
+
+// Envelope is a sealed user data, encoded in JSON
+type Envelope struct {
+	Version    string      `json:"version"`
+	Encryption *Encryption `json:"encryption,omitempty"`
+	Payload    []byte      `json:"payload"`
+	Signature  []byte      `json:"signature,omitempty"`
+}
+
+// Encryption describes how the payload of an envelope is encrypted
+type Encryption struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key-id"`
+	Nonce     []byte `json:"nonce"`
+}
+
+// signingInput returns the data covered by the signature, which is the envelope without the signature
+func (e *Envelope) signingInput() ([]byte, error) {
+	return json.Marshal(&Envelope{Version: e.Version, Encryption: e.Encryption, Payload: e.Payload})
+}
+
+// additionalData binds a ciphertext to the envelope version and the key ID
+func additionalData(keyID string) []byte {
+	return []byte(Version + "\n" + keyID)
+}
+
+// Sealer signs, and optionally encrypts, user data
+type Sealer struct {
+	signer        crypto.Signer
+	encryptionKey []byte
+	keyID         string
+}
+
+// NewSealer returns a sealer that signs user data with signer. When encryptionKey is not nil,
+// the user data is also encrypted with encryptionKey, and keyID is embedded in the envelope
+// so that a pod VM can retrieve the key from a KBS.
+func NewSealer(signer crypto.Signer, encryptionKey []byte, keyID string) (*Sealer, error) {
+
+	if signer == nil {
+		return nil, fmt.Errorf("signing key is not specified")
+	}
+
+	if encryptionKey != nil {
+		if len(encryptionKey) != encryptionKeySize {
+			return nil, fmt.Errorf("encryption key must be %d bytes, got %d bytes", encryptionKeySize, len(encryptionKey))
+		}
+		if keyID == "" {
+			return nil, fmt.Errorf("encryption key ID is not specified")
+		}
+	} else if keyID != "" {
+		return nil, fmt.Errorf("encryption key ID is specified without encryption key")
+	}
+
+	return &Sealer{signer: signer, encryptionKey: encryptionKey, keyID: keyID}, nil
+}
+
+// Seal returns an envelope of payload encoded in JSON
+func (s *Sealer) Seal(payload []byte) ([]byte, error) {
+
+	envelope := &Envelope{Version: Version, Payload: payload}
+
+	if s.encryptionKey != nil {
+
+		aead, err := newAEAD(s.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate a nonce: %w", err)
+		}
+
+		envelope.Encryption = &Encryption{Algorithm: EncryptionAlgorithm, KeyID: s.keyID, Nonce: nonce}
+		envelope.Payload = aead.Seal(nil, nonce, payload, additionalData(s.keyID))
+	}
+
+	input, err := envelope.signingInput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user data: %w", err)
+	}
+
+	envelope.Signature, err = sign(s.signer, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign user data: %w", err)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user data: %w", err)
+	}
+
+	return data, nil
+}
+
+// KeyProvider retrieves a decryption key of user data, typically from a KBS after attestation
+type KeyProvider interface {
+	GetKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+type commandKeyProvider struct {
+	path string
+}
+
+// NewCommandKeyProvider returns a key provider that runs an external command, e.g. a client of
+// guest components that retrieves a resource from a KBS. The command is invoked with the key ID
+// as its argument, and writes the raw key to its standard output.
+func NewCommandKeyProvider(path string) KeyProvider {
+	return &commandKeyProvider{path: path}
+}
+
+func (p *commandKeyProvider) GetKey(ctx context.Context, keyID string) ([]byte, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, p.path, keyID)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w: %s", p.path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// IsSealed returns true if data is an envelope
+func IsSealed(data []byte) bool {
+
+	var envelope struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return false
+	}
+
+	return envelope.Version == Version
+}
+
+// Open verifies the signature of an envelope with publicKey, and returns its payload.
+// An encrypted payload is decrypted with a key retrieved by keys.
+func Open(ctx context.Context, data []byte, publicKey crypto.PublicKey, keys KeyProvider) ([]byte, error) {
+
+	envelope, err := Verify(data, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope.Open(ctx, keys)
+}
+
+// Verify decodes an envelope and verifies its signature with publicKey
+func Verify(data []byte, publicKey crypto.PublicKey) (*Envelope, error) {
+
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode user data: %w", err)
+	}
+
+	if envelope.Version != Version {
+		return nil, fmt.Errorf("unsupported user data version: %q", envelope.Version)
+	}
+
+	input, err := envelope.signingInput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user data: %w", err)
+	}
+
+	if err := verify(publicKey, input, envelope.Signature); err != nil {
+		return nil, fmt.Errorf("failed to verify user data signature: %w", err)
+	}
+
+	return &envelope, nil
+}
+
+// Open returns the payload of a verified envelope. An encrypted payload is decrypted with a key retrieved by keys.
+func (e *Envelope) Open(ctx context.Context, keys KeyProvider) ([]byte, error) {
+
+	if e.Encryption == nil {
+		return e.Payload, nil
+	}
+
+	if e.Encryption.Algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported user data encryption algorithm: %q", e.Encryption.Algorithm)
+	}
+
+	if keys == nil {
+		return nil, fmt.Errorf("user data is encrypted with key %q, but no key provider is configured", e.Encryption.KeyID)
+	}
+
+	key, err := keys.GetKey(ctx, e.Encryption.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user data decryption key %q: %w", e.Encryption.KeyID, err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(e.Encryption.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid user data nonce size: %d", len(e.Encryption.Nonce))
+	}
+
+	payload, err := aead.Open(nil, e.Encryption.Nonce, e.Payload, additionalData(e.Encryption.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt user data: %w", err)
+	}
+
+	return payload, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d bytes", encryptionKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func sign(signer crypto.Signer, input []byte) ([]byte, error) {
+
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, input, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(input)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func verify(publicKey crypto.PublicKey, input, signature []byte) error {
+
+	if len(signature) == 0 {
+		return fmt.Errorf("user data is not signed")
+	}
+
+	digest := sha256.Sum256(input)
+
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, input, signature) {
+			return fmt.Errorf("signature mismatch")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return fmt.Errorf("signature mismatch")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("signature mismatch: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	return nil
+}
+
+// LoadSigningKey loads a PEM encoded private key (PKCS#8, EC or PKCS#1) from a file
+func LoadSigningKey(path string) (crypto.Signer, error) {
+
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("failed to parse a private key in %s", path)
+}
+
+// LoadVerificationKey loads a PEM encoded public key (PKIX) from a file
+func LoadVerificationKey(path string) (crypto.PublicKey, error) {
+
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse a public key in %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// LoadEncryptionKey loads a raw encryption key from a file
+func LoadEncryptionKey(path string) ([]byte, error) {
+
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key in %s must be %d bytes, got %d bytes", path, encryptionKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data is found in %s", path)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,254 @@
+// Copyright Confidential Containers Contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package userdata
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type keyProviderFunc func(ctx context.Context, keyID string) ([]byte, error)
+
+func (f keyProviderFunc) GetKey(ctx context.Context, keyID string) ([]byte, error) {
+	return f(ctx, keyID)
+}
+
+func TestSealAndOpen(t *testing.T) {
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	payload := []byte(`{"pod-name":"nginx","tls-server-key":"secret"}`)
+
+	for _, signer := range []crypto.Signer{ed25519Key, ecdsaKey} {
+
+		sealer, err := NewSealer(signer, nil, "")
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+
+		sealed, err := sealer.Seal(payload)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if !IsSealed(sealed) {
+			t.Fatalf("Expect sealed user data, got %s", sealed)
+		}
+		if IsSealed(payload) {
+			t.Fatalf("Expect unsealed user data, got %s", payload)
+		}
+
+		opened, err := Open(context.Background(), sealed, signer.Public(), nil)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if e, a := string(payload), string(opened); e != a {
+			t.Fatalf("Expect %q, got %q", e, a)
+		}
+
+		// Tampered payload
+		var envelope Envelope
+		if err := json.Unmarshal(sealed, &envelope); err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		envelope.Payload = bytes.Replace(envelope.Payload, []byte("nginx"), []byte("evil!"), 1)
+		tampered, err := json.Marshal(&envelope)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if _, err := Open(context.Background(), tampered, signer.Public(), nil); err == nil {
+			t.Fatal("Expect error for tampered user data, got nil")
+		}
+
+		// Missing signature
+		envelope.Signature = nil
+		unsigned, err := json.Marshal(&envelope)
+		if err != nil {
+			t.Fatalf("Expect no error, got %v", err)
+		}
+		if _, err := Open(context.Background(), unsigned, signer.Public(), nil); err == nil {
+			t.Fatal("Expect error for unsigned user data, got nil")
+		}
+	}
+
+	// Wrong verification key
+	sealer, err := NewSealer(ed25519Key, nil, "")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	sealed, err := sealer.Seal(payload)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := Open(context.Background(), sealed, otherKey, nil); err == nil {
+		t.Fatal("Expect error for a wrong verification key, got nil")
+	}
+}
+
+func TestSealAndOpenEncrypted(t *testing.T) {
+
+	_, signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	keyID := "kbs:///default/peerpod/user-data-key"
+
+	sealer, err := NewSealer(signer, key, keyID)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	payload := []byte(`{"pod-name":"nginx","tls-server-key":"secret"}`)
+
+	sealed, err := sealer.Seal(payload)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) || bytes.Contains(sealed, []byte("c2VjcmV0")) {
+		t.Fatalf("Expect encrypted user data, got %s", sealed)
+	}
+
+	keys := keyProviderFunc(func(ctx context.Context, id string) ([]byte, error) {
+		if id != keyID {
+			return nil, fmt.Errorf("unknown key %q", id)
+		}
+		return key, nil
+	})
+
+	opened, err := Open(context.Background(), sealed, signer.Public(), keys)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := string(payload), string(opened); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	if _, err := Open(context.Background(), sealed, signer.Public(), nil); err == nil {
+		t.Fatal("Expect error without a key provider, got nil")
+	}
+
+	wrongKey := keyProviderFunc(func(ctx context.Context, id string) ([]byte, error) {
+		return make([]byte, 32), nil
+	})
+	if _, err := Open(context.Background(), sealed, signer.Public(), wrongKey); err == nil {
+		t.Fatal("Expect error for a wrong decryption key, got nil")
+	}
+
+	if _, err := NewSealer(signer, key[:16], keyID); err == nil {
+		t.Fatal("Expect error for a short encryption key, got nil")
+	}
+	if _, err := NewSealer(signer, key, ""); err == nil {
+		t.Fatal("Expect error for an empty key ID, got nil")
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	privPath := filepath.Join(dir, "signing.key")
+	pubPath := filepath.Join(dir, "signing.pub")
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	signer, err := LoadSigningKey(privPath)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	publicKey, err := LoadVerificationKey(pubPath)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	sealer, err := NewSealer(signer, nil, "")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	sealed, err := sealer.Seal([]byte("{}"))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := Open(context.Background(), sealed, publicKey, nil); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	if _, err := LoadVerificationKey(privPath); err == nil {
+		t.Fatal("Expect error for a private key, got nil")
+	}
+
+	encKeyPath := filepath.Join(dir, "encryption.key")
+	if err := os.WriteFile(encKeyPath, []byte("too short"), 0600); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if _, err := LoadEncryptionKey(encKeyPath); err == nil {
+		t.Fatal("Expect error for a short encryption key, got nil")
+	}
+}
+
+func TestCommandKeyProvider(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "get-key.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n[ \"$1\" = \"kbs:///default/key\" ] || exit 1\nprintf 0123456789abcdef0123456789abcdef\n"), 0700); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	keys := NewCommandKeyProvider(path)
+
+	key, err := keys.GetKey(context.Background(), "kbs:///default/key")
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	if e, a := "0123456789abcdef0123456789abcdef", string(key); e != a {
+		t.Fatalf("Expect %q, got %q", e, a)
+	}
+
+	if _, err := keys.GetKey(context.Background(), "kbs:///default/other"); err == nil {
+		t.Fatal("Expect error for an unknown key, got nil")
+	}
+}
@@ -83,6 +83,13 @@ TLS_OPTIONS=-cert-file /etc/certificates/tls.crt -cert-key /etc/certificates/tls
 END
 fi
 
+# Provide TEE evidence when cloud-api-adaptor requires attestation, if the image includes an attester command
+if [ -x /usr/local/bin/peerpod-attester ]; then
+    cat <<END >> /etc/default/agent-protocol-forwarder
+ATTESTER_OPTIONS=-attester /usr/local/bin/peerpod-attester
+END
+fi
+
 # If DISABLE_CLOUD_CONFIG is not set or not set to true, then add cloud-init.target as a dependency for process-user-data.service
 # so that required files via cloud-config are available before kata-agent starts
 if [ -z "$DISABLE_CLOUD_CONFIG" ] || [ "$DISABLE_CLOUD_CONFIG" != "true" ]
@@ -95,7 +102,10 @@ then
 After=cloud-config.service
 
 [Service]
+# Verify the daemon config written by cloud-init, which may be signed by cloud-api-adaptor. A signed daemon
+# config is kept in daemon.json.sealed, so that it is verified again on every boot.
 ExecStartPre=
+ExecStartPre=/usr/local/bin/process-user-data provision-files --data-source file --user-data-file /peerpod/daemon.json.sealed,/peerpod/daemon.json
 END
 fi
 
@@ -17,19 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"net/http"
 	"os"
 
+	"github.com/go-logr/logr"
+
 	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/mutating_webhook"
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/validating_webhook"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -51,11 +60,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var policyConfigMap string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&policyConfigMap, "policy-configmap", "peer-pods-webhook-policy",
+		"The name of the ConfigMap in the namespace of the webhook (POD_NAMESPACE) with the policy.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -77,8 +89,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The policy defaults to the environment variables, and is loaded from the policy ConfigMap if it exists
+	policyStore := &policy.Store{}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		if err := watchPolicy(mgr, policyStore, namespace, policyConfigMap); err != nil {
+			setupLog.Error(err, "unable to watch policy ConfigMap")
+			os.Exit(1)
+		}
+	} else {
+		setupLog.Info("POD_NAMESPACE is not set, using the policy of the environment variables")
+	}
+
 	setupLog.Info("Setting up webhook server")
-	mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &mutating_webhook.PodMutator{Client: mgr.GetClient()}})
+	mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &mutating_webhook.PodMutator{Client: mgr.GetClient(), Policy: policyStore}})
+	mgr.GetWebhookServer().Register("/validate-v1-pod", &webhook.Admission{Handler: &validating_webhook.PodValidator{Policy: policyStore}})
 
 	//+kubebuilder:scaffold:builder
 
@@ -90,6 +114,12 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("policy", func(_ *http.Request) error {
+		return policyStore.Loaded()
+	}); err != nil {
+		setupLog.Error(err, "unable to set up policy ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -97,3 +127,45 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// watchPolicy loads the policy from the ConfigMap in namespace whenever it changes
+func watchPolicy(mgr ctrl.Manager, store *policy.Store, namespace, name string) error {
+	// Cache only the ConfigMaps of the namespace of the webhook
+	configMapCache, err := cache.New(mgr.GetConfig(), cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper(), Namespace: namespace})
+	if err != nil {
+		return err
+	}
+	informer, err := configMapCache.GetInformer(context.Background(), &corev1.ConfigMap{})
+	if err != nil {
+		return err
+	}
+	log := ctrl.Log.WithName("policy")
+	store.WatchConfigMap(informer, name, log)
+
+	if err := mgr.Add(configMapCache); err != nil {
+		return err
+	}
+	return mgr.Add(&policySyncer{cache: configMapCache, store: store, namespace: namespace, name: name, log: log})
+}
+
+// policySyncer loads the policy once the cache of the ConfigMaps is synced, so that the validating webhook does not
+// apply the policy of the environment variables before the policy ConfigMap is read
+type policySyncer struct {
+	cache     cache.Cache
+	store     *policy.Store
+	namespace string
+	name      string
+	log       logr.Logger
+}
+
+func (p *policySyncer) Start(ctx context.Context) error {
+	if !p.cache.WaitForCacheSync(ctx) {
+		return errors.New("policy ConfigMap cache did not sync")
+	}
+	return p.store.SyncConfigMap(ctx, p.cache, p.namespace, p.name, p.log)
+}
+
+// NeedLeaderElection returns false, since every replica of the webhook serves requests
+func (p *policySyncer) NeedLeaderElection() bool {
+	return false
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -30,7 +31,9 @@ import (
 
 // podMutator mutates Pods
 type PodMutator struct {
-	Client  client.Client
+	Client client.Client
+	// Policy selects the peer pods and their settings
+	Policy  *policy.Store
 	decoder *admission.Decoder
 }
 
@@ -43,7 +46,16 @@ func (a *PodMutator) Handle(ctx context.Context, req admission.Request) admissio
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	mutatedPod, _ := removePodResourceSpec(pod)
+	// Mutate only peer pods
+	settings, ok := a.Policy.Get().ForPod(pod, req.Namespace)
+	if !ok {
+		return admission.Allowed("not a peer pod")
+	}
+
+	mutatedPod, err := removePodResourceSpec(pod, settings)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 	marshaledPod, err := json.Marshal(mutatedPod)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
@@ -1,42 +1,56 @@
 package mutating_webhook
 
 import (
-	"os"
+	"encoding/json"
+	"fmt"
+	"strconv"
 
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
-	RUNTIME_CLASS_NAME_DEFAULT       = "kata-remote"
-	POD_VM_ANNOTATION_INSTANCE_TYPE  = "kata.peerpods.io/instance_type"
-	POD_VM_INSTANCE_TYPE_DEFAULT     = "t2.small"
-	POD_VM_EXTENDED_RESOURCE_DEFAULT = "kata.peerpods.io/vm"
+	POD_VM_ANNOTATION_INSTANCE_TYPE = "kata.peerpods.io/instance_type"
+	// Annotations of Kata, which the cloud provider uses to select the best fit instance type
+	POD_VM_ANNOTATION_VCPUS  = "io.katacontainers.config.hypervisor.default_vcpus"
+	POD_VM_ANNOTATION_MEMORY = "io.katacontainers.config.hypervisor.default_memory"
+	// Annotation with the resources of the containers before they were removed
+	POD_VM_ANNOTATION_ORIGINAL_RESOURCES = "kata.peerpods.io/original-resources"
 )
 
-// remove the POD resource spec
-func removePodResourceSpec(pod *corev1.Pod) (*corev1.Pod, error) {
-	var runtimeClassName string
+// remove the POD resource spec of a peer pod, according to the settings of the policy for the pod
+func removePodResourceSpec(pod *corev1.Pod, settings policy.Settings) (*corev1.Pod, error) {
 	mpod := pod.DeepCopy()
 
-	if runtimeClassName = os.Getenv("TARGET_RUNTIMECLASS"); runtimeClassName == "" {
-		runtimeClassName = RUNTIME_CLASS_NAME_DEFAULT
-	}
-	// Mutate only if the POD is using specific runtimeClass
-	if mpod.Spec.RuntimeClassName == nil || *mpod.Spec.RuntimeClassName != runtimeClassName {
+	// The resources of a pod that was already mutated, e.g. on update, are not the original ones anymore
+	if alreadyMutated(mpod, settings.ExtendedResource) {
 		return mpod, nil
 	}
 
-	var podVmInstanceType string
-	if podVmInstanceType = os.Getenv("POD_VM_INSTANCE_TYPE"); podVmInstanceType == "" {
-		podVmInstanceType = POD_VM_INSTANCE_TYPE_DEFAULT
-	}
-
 	if mpod.Annotations == nil {
 		mpod.Annotations = map[string]string{}
 	}
 
-	mpod.Annotations[POD_VM_ANNOTATION_INSTANCE_TYPE] = podVmInstanceType
+	// Size the pod VM from the resources of the containers, and use the default instance type
+	// only if nothing was requested
+	vcpus, memory := podVmSize(mpod, settings.Overhead)
+	if vcpus > 0 || memory > 0 {
+		setAnnotationIfMissing(mpod, POD_VM_ANNOTATION_VCPUS, vcpus)
+		setAnnotationIfMissing(mpod, POD_VM_ANNOTATION_MEMORY, memory)
+	} else {
+		mpod.Annotations[POD_VM_ANNOTATION_INSTANCE_TYPE] = settings.InstanceType
+	}
+
+	original, err := originalResources(mpod)
+	if err != nil {
+		return nil, err
+	}
+	if original != "" {
+		mpod.Annotations[POD_VM_ANNOTATION_ORIGINAL_RESOURCES] = original
+	}
 
 	// Remove all resource specs
 	for idx := range mpod.Spec.Containers {
@@ -48,21 +62,77 @@ func removePodResourceSpec(pod *corev1.Pod) (*corev1.Pod, error) {
 	}
 
 	// Add peer-pod resource to one container
-	mpod.Spec.Containers[0].Resources = defaultContainerResourceRequirements()
+	mpod.Spec.Containers[0].Resources = defaultContainerResourceRequirements(settings.ExtendedResource)
 	return mpod, nil
 }
 
-// defaultContainerResourceRequirements returns the default requirements for a container
-func defaultContainerResourceRequirements() corev1.ResourceRequirements {
+// alreadyMutated returns whether the resources of the pod are the ones set by removePodResourceSpec
+func alreadyMutated(pod *corev1.Pod, podVmExtResource string) bool {
+	for idx, c := range pod.Spec.Containers {
+		expected := corev1.ResourceRequirements{}
+		if idx == 0 {
+			expected = defaultContainerResourceRequirements(podVmExtResource)
+		}
+		if !equality.Semantic.DeepEqual(c.Resources, expected) {
+			return false
+		}
+	}
+	return len(pod.Spec.Containers) > 0
+}
+
+// podVmSize returns the number of vCPUs and the memory in MiB that the containers of the pod need, including
+// the pod overhead if the overhead policy adds it to the pod VM. Zero means that the resource is not requested.
+func podVmSize(pod *corev1.Pod, overhead policy.OverheadPolicy) (int64, int64) {
+	cpuQuantity := utils.GetEffectiveResourceQuantity(pod, corev1.ResourceCPU)
+	memoryQuantity := utils.GetEffectiveResourceQuantity(pod, corev1.ResourceMemory)
+	if overhead == policy.OverheadAddToPodVM {
+		utils.AddPodOverhead(pod, corev1.ResourceCPU, &cpuQuantity)
+		utils.AddPodOverhead(pod, corev1.ResourceMemory, &memoryQuantity)
+	}
+	milliCPU, memory := cpuQuantity.MilliValue(), memoryQuantity.Value()
+
+	// Round up to whole vCPUs and MiB
+	const mib = 1024 * 1024
+	return (milliCPU + 999) / 1000, (memory + mib - 1) / mib
+}
+
+// setAnnotationIfMissing sets a size annotation of the pod VM, unless it was set by the user
+func setAnnotationIfMissing(pod *corev1.Pod, key string, value int64) {
+	if _, ok := pod.Annotations[key]; ok || value == 0 {
+		return
+	}
+	pod.Annotations[key] = strconv.FormatInt(value, 10)
+}
+
+// originalResources returns the non-empty resources of the containers and init containers by container name,
+// encoded as JSON, or an empty string if no container specifies resources
+func originalResources(pod *corev1.Pod) (string, error) {
+	resources := map[string]corev1.ResourceRequirements{}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if len(c.Resources.Requests) > 0 || len(c.Resources.Limits) > 0 {
+				resources[c.Name] = c.Resources
+			}
+		}
+	}
+	if len(resources) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(resources)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the resources of the pod: %w", err)
+	}
+	return string(data), nil
+}
+
+// defaultContainerResourceRequirements returns the default requirements for a container, which request
+// the given extended resource of peer pods
+func defaultContainerResourceRequirements(podVmExtResource string) corev1.ResourceRequirements {
 	requirements := corev1.ResourceRequirements{}
 	requirements.Requests = corev1.ResourceList{}
 	requirements.Limits = corev1.ResourceList{}
 
-	var podVmExtResource string
-	if podVmExtResource = os.Getenv("POD_VM_EXTENDED_RESOURCE"); podVmExtResource == "" {
-		podVmExtResource = POD_VM_EXTENDED_RESOURCE_DEFAULT
-	}
-
 	requirements.Requests[corev1.ResourceName(podVmExtResource)] = resource.MustParse("1")
 	requirements.Limits[corev1.ResourceName(podVmExtResource)] = resource.MustParse("1")
 	return requirements
@@ -0,0 +1,133 @@
+package mutating_webhook
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRemovePodResourceSpec(t *testing.T) {
+	runtimeClassName := policy.RUNTIME_CLASS_NAME_DEFAULT
+	settings := policy.Settings{InstanceType: "t3.small", ExtendedResource: "kata.peerpods.io/vm"}
+	newPod := func(resources ...corev1.ResourceRequirements) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+			Spec:       corev1.PodSpec{RuntimeClassName: &runtimeClassName},
+		}
+		for _, r := range resources {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: fmt.Sprintf("c%d", len(pod.Spec.Containers)), Resources: r})
+		}
+		return pod
+	}
+	requirements := func(requests, limits corev1.ResourceList) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: requests, Limits: limits}
+	}
+
+	withInitContainer := func(pod *corev1.Pod, resources corev1.ResourceRequirements) *corev1.Pod {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: "init", Resources: resources})
+		return pod
+	}
+	withOverhead := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Spec.Overhead = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("120Mi")}
+		return pod
+	}
+
+	for name, tc := range map[string]struct {
+		pod         *corev1.Pod
+		overhead    policy.OverheadPolicy
+		annotations map[string]string
+	}{
+		"no resources": {
+			pod:         newPod(corev1.ResourceRequirements{}),
+			annotations: map[string]string{POD_VM_ANNOTATION_INSTANCE_TYPE: "t3.small"},
+		},
+		"requests of all containers": {
+			pod: newPod(
+				requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}, nil),
+				requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("100M")}, nil),
+			),
+			annotations: map[string]string{
+				POD_VM_ANNOTATION_VCPUS:              "2",
+				POD_VM_ANNOTATION_MEMORY:             "1120",
+				POD_VM_ANNOTATION_ORIGINAL_RESOURCES: `{"c0":{"requests":{"cpu":"1","memory":"1Gi"}},"c1":{"requests":{"cpu":"500m","memory":"100M"}}}`,
+			},
+		},
+		// Only the requested dimension is annotated, and cloud-api-adaptor selects an instance type that fits it
+		"memory limit only": {
+			pod: newPod(requirements(nil, corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")})),
+			annotations: map[string]string{
+				POD_VM_ANNOTATION_MEMORY:             "2048",
+				POD_VM_ANNOTATION_ORIGINAL_RESOURCES: `{"c0":{"limits":{"memory":"2Gi"}}}`,
+			},
+		},
+		"largest init container": {
+			pod: withInitContainer(
+				newPod(requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}, nil)),
+				requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")}, nil),
+			),
+			annotations: map[string]string{
+				POD_VM_ANNOTATION_VCPUS:              "3",
+				POD_VM_ANNOTATION_MEMORY:             "1024",
+				POD_VM_ANNOTATION_ORIGINAL_RESOURCES: `{"c0":{"requests":{"cpu":"1","memory":"1Gi"}},"init":{"requests":{"cpu":"3"}}}`,
+			},
+		},
+		"overhead ignored": {
+			pod: withOverhead(newPod(requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}, nil))),
+			annotations: map[string]string{
+				POD_VM_ANNOTATION_VCPUS:              "1",
+				POD_VM_ANNOTATION_MEMORY:             "1024",
+				POD_VM_ANNOTATION_ORIGINAL_RESOURCES: `{"c0":{"requests":{"cpu":"1","memory":"1Gi"}}}`,
+			},
+		},
+		"overhead added to pod VM": {
+			pod:      withOverhead(newPod(requirements(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}, nil))),
+			overhead: policy.OverheadAddToPodVM,
+			annotations: map[string]string{
+				POD_VM_ANNOTATION_VCPUS:              "2",
+				POD_VM_ANNOTATION_MEMORY:             "1144",
+				POD_VM_ANNOTATION_ORIGINAL_RESOURCES: `{"c0":{"requests":{"cpu":"1","memory":"1Gi"}}}`,
+			},
+		},
+		"overhead without resources": {
+			pod:         withOverhead(newPod(corev1.ResourceRequirements{})),
+			overhead:    policy.OverheadAddToPodVM,
+			annotations: map[string]string{POD_VM_ANNOTATION_INSTANCE_TYPE: "t3.small"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			settings := settings
+			settings.Overhead = tc.overhead
+
+			mpod, err := removePodResourceSpec(tc.pod, settings)
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			if len(mpod.Annotations) != len(tc.annotations) {
+				t.Errorf("Expect annotations %v, got %v", tc.annotations, mpod.Annotations)
+			}
+			for k, e := range tc.annotations {
+				if a := mpod.Annotations[k]; e != a {
+					t.Errorf("Expect annotation %s=%q, got %q", k, e, a)
+				}
+			}
+			for idx, c := range mpod.Spec.Containers {
+				if q, ok := c.Resources.Limits["kata.peerpods.io/vm"]; (idx == 0) != ok || (ok && q.Value() != 1) {
+					t.Errorf("Expect peer pod resource only in the first container, got %v in container %s", c.Resources, c.Name)
+				}
+			}
+
+			// Mutating the pod again keeps the annotations
+			again, err := removePodResourceSpec(mpod, settings)
+			if err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			if len(again.Annotations) != len(tc.annotations) {
+				t.Errorf("Expect annotations %v after mutating again, got %v", tc.annotations, again.Annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	RUNTIME_CLASS_NAME_DEFAULT       = "kata-remote"
+	POD_VM_INSTANCE_TYPE_DEFAULT     = "t2.small"
+	POD_VM_EXTENDED_RESOURCE_DEFAULT = "kata.peerpods.io/vm"
+
+	// Key of the policy in the policy ConfigMap
+	ConfigMapKey = "policy.yaml"
+)
+
+// OverheadPolicy selects how the pod overhead of the RuntimeClass is accounted for
+type OverheadPolicy string
+
+const (
+	// OverheadIgnore accounts the pod overhead only on the worker node, which runs the shim and cloud-api-adaptor
+	OverheadIgnore OverheadPolicy = "Ignore"
+	// OverheadAddToPodVM adds the pod overhead to the size of the pod VM as well
+	OverheadAddToPodVM OverheadPolicy = "AddToPodVM"
+)
+
+// Settings are applied to the peer pods that a rule selects
+type Settings struct {
+	// InstanceType is the pod VM instance type of pods that request no cpu and memory
+	InstanceType string `json:"instanceType,omitempty"`
+	// ExtendedResource is the extended resource that is requested for the pod VM
+	ExtendedResource string `json:"extendedResource,omitempty"`
+	// AllowedInstanceTypes restricts the instance types that pods can request in annotations, if it is not empty
+	AllowedInstanceTypes []string `json:"allowedInstanceTypes,omitempty"`
+	// AllowedImages restricts the container images to the ones starting with one of the prefixes, if it is not empty
+	AllowedImages []string `json:"allowedImages,omitempty"`
+	// AllowedAnnotations restricts the Kata annotations that pods can set, if it is not empty
+	AllowedAnnotations []string `json:"allowedAnnotations,omitempty"`
+	// MaxVCPUs limits the vCPUs of the pod VM that pods can request, if it is not zero
+	MaxVCPUs int64 `json:"maxVCPUs,omitempty"`
+	// MaxMemory limits the memory in MiB of the pod VM that pods can request, if it is not zero
+	MaxMemory int64 `json:"maxMemory,omitempty"`
+	// Overhead selects how the pod overhead is accounted for, and defaults to Ignore
+	Overhead OverheadPolicy `json:"overhead,omitempty"`
+}
+
+// RuntimeClass is a RuntimeClass of peer pods, and overrides the default settings for its pods
+type RuntimeClass struct {
+	Name string `json:"name"`
+	Settings
+}
+
+// Rule selects peer pods by namespace, labels and RuntimeClass, and overrides the default settings for them.
+// A rule selects a pod only if all its criteria match, and an empty criterion matches all pods.
+type Rule struct {
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	Selector          *metav1.LabelSelector `json:"selector,omitempty"`
+	RuntimeClassNames []string              `json:"runtimeClassNames,omitempty"`
+	Settings
+
+	selector labels.Selector
+}
+
+// Policy is the configuration of the webhooks
+type Policy struct {
+	// RuntimeClassName is the default RuntimeClass of peer pods. Pods with a RuntimeClass of RuntimeClasses
+	// or of a rule are peer pods as well.
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+	// RuntimeClasses are additional RuntimeClasses of peer pods with their own settings
+	RuntimeClasses []RuntimeClass `json:"runtimeClasses,omitempty"`
+	// Defaults apply to peer pods that no rule selects, and to the settings that a rule or RuntimeClass does not set
+	Defaults Settings `json:"defaults,omitempty"`
+	// Rules are evaluated in order, and the first one that selects a pod applies
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// FromEnv returns the policy that is configured by the TARGET_RUNTIMECLASS, POD_VM_INSTANCE_TYPE and
+// POD_VM_EXTENDED_RESOURCE environment variables. TARGET_RUNTIMECLASS may be a comma separated list of
+// RuntimeClasses, where the first one is the default.
+func FromEnv() *Policy {
+	p := &Policy{}
+	p.setDefaults()
+	return p
+}
+
+// Parse parses a policy in YAML. Values that are not set default to the environment variables.
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	for _, runtimeClass := range p.RuntimeClasses {
+		if runtimeClass.Name == "" {
+			return nil, fmt.Errorf("RuntimeClass without name")
+		}
+		if err := runtimeClass.Settings.validate(); err != nil {
+			return nil, fmt.Errorf("invalid RuntimeClass %s: %w", runtimeClass.Name, err)
+		}
+	}
+	if err := p.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("invalid defaults: %w", err)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if err := rule.Settings.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %w", i, err)
+		}
+		if rule.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(rule.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of rule %d: %w", i, err)
+		}
+		rule.selector = selector
+	}
+
+	p.setDefaults()
+	return p, nil
+}
+
+func (p *Policy) setDefaults() {
+	if p.RuntimeClassName == "" && len(p.RuntimeClasses) == 0 {
+		for _, name := range strings.Split(os.Getenv("TARGET_RUNTIMECLASS"), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if p.RuntimeClassName == "" {
+				p.RuntimeClassName = name
+			} else {
+				p.RuntimeClasses = append(p.RuntimeClasses, RuntimeClass{Name: name})
+			}
+		}
+	}
+	defaultTo(&p.RuntimeClassName, RUNTIME_CLASS_NAME_DEFAULT)
+	defaultTo(&p.Defaults.InstanceType, os.Getenv("POD_VM_INSTANCE_TYPE"), POD_VM_INSTANCE_TYPE_DEFAULT)
+	defaultTo(&p.Defaults.ExtendedResource, os.Getenv("POD_VM_EXTENDED_RESOURCE"), POD_VM_EXTENDED_RESOURCE_DEFAULT)
+	if p.Defaults.Overhead == "" {
+		p.Defaults.Overhead = OverheadIgnore
+	}
+}
+
+func (s *Settings) validate() error {
+	if s.MaxVCPUs < 0 {
+		return fmt.Errorf("negative maxVCPUs %d", s.MaxVCPUs)
+	}
+	if s.MaxMemory < 0 {
+		return fmt.Errorf("negative maxMemory %d", s.MaxMemory)
+	}
+	return s.Overhead.validate()
+}
+
+func (o OverheadPolicy) validate() error {
+	switch o {
+	case "", OverheadIgnore, OverheadAddToPodVM:
+		return nil
+	}
+	return fmt.Errorf("unknown overhead policy %q", o)
+}
+
+func defaultTo(field *string, values ...string) {
+	for _, value := range values {
+		if *field != "" {
+			return
+		}
+		*field = value
+	}
+}
+
+// IsPeerPod returns whether the pod uses a RuntimeClass of peer pods
+func (p *Policy) IsPeerPod(pod *corev1.Pod) bool {
+	if pod.Spec.RuntimeClassName == nil {
+		return false
+	}
+	runtimeClassName := *pod.Spec.RuntimeClassName
+	if runtimeClassName == p.RuntimeClassName || p.runtimeClass(runtimeClassName) != nil {
+		return true
+	}
+	for _, rule := range p.Rules {
+		if contains(rule.RuntimeClassNames, runtimeClassName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForPod returns the settings of a peer pod in the given namespace, and false if the pod is not a peer pod.
+// The namespace is passed separately, since it is not set in the pod on creation.
+func (p *Policy) ForPod(pod *corev1.Pod, namespace string) (Settings, bool) {
+	if !p.IsPeerPod(pod) {
+		return Settings{}, false
+	}
+
+	// The settings of the RuntimeClass override the defaults, and are overridden by the first matching rule
+	defaults := p.Defaults
+	if runtimeClass := p.runtimeClass(*pod.Spec.RuntimeClassName); runtimeClass != nil {
+		defaults = runtimeClass.Settings.merge(defaults)
+	}
+
+	for _, rule := range p.Rules {
+		if rule.matches(pod, namespace) {
+			return rule.Settings.merge(defaults), true
+		}
+	}
+	return defaults, true
+}
+
+// runtimeClass returns the RuntimeClass with the given name, or nil if it is not in RuntimeClasses
+func (p *Policy) runtimeClass(name string) *RuntimeClass {
+	for i := range p.RuntimeClasses {
+		if p.RuntimeClasses[i].Name == name {
+			return &p.RuntimeClasses[i]
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(pod *corev1.Pod, namespace string) bool {
+	if len(r.Namespaces) > 0 && !contains(r.Namespaces, namespace) {
+		return false
+	}
+	if r.selector != nil && !r.selector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	if len(r.RuntimeClassNames) > 0 && (pod.Spec.RuntimeClassName == nil || !contains(r.RuntimeClassNames, *pod.Spec.RuntimeClassName)) {
+		return false
+	}
+	return true
+}
+
+// merge returns the settings, where the ones that are not set are taken from defaults
+func (s Settings) merge(defaults Settings) Settings {
+	defaultTo(&s.InstanceType, defaults.InstanceType)
+	defaultTo(&s.ExtendedResource, defaults.ExtendedResource)
+	if s.AllowedInstanceTypes == nil {
+		s.AllowedInstanceTypes = defaults.AllowedInstanceTypes
+	}
+	if s.AllowedImages == nil {
+		s.AllowedImages = defaults.AllowedImages
+	}
+	if s.AllowedAnnotations == nil {
+		s.AllowedAnnotations = defaults.AllowedAnnotations
+	}
+	if s.MaxVCPUs == 0 {
+		s.MaxVCPUs = defaults.MaxVCPUs
+	}
+	if s.MaxMemory == 0 {
+		s.MaxMemory = defaults.MaxMemory
+	}
+	if s.Overhead == "" {
+		s.Overhead = defaults.Overhead
+	}
+	return s
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicyForPod(t *testing.T) {
+	t.Setenv("TARGET_RUNTIMECLASS", "")
+	t.Setenv("POD_VM_INSTANCE_TYPE", "t3.small")
+	t.Setenv("POD_VM_EXTENDED_RESOURCE", "")
+
+	p, err := Parse([]byte(`
+runtimeClasses:
+- name: kata-remote-gpu
+  instanceType: p3.2xlarge
+  extendedResource: kata.peerpods.io/gpu-vm
+  overhead: AddToPodVM
+  maxVCPUs: 8
+defaults:
+  allowedImages: ["quay.io/"]
+rules:
+- namespaces: [gpu]
+  selector:
+    matchLabels:
+      app: training
+  instanceType: p3.2xlarge
+  allowedInstanceTypes: [p3.2xlarge]
+  maxMemory: 65536
+- runtimeClassNames: [kata-remote-large]
+  instanceType: m5.xlarge
+  extendedResource: kata.peerpods.io/large-vm
+`))
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	newPod := func(runtimeClassName string, podLabels map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: podLabels}}
+		if runtimeClassName != "" {
+			pod.Spec.RuntimeClassName = &runtimeClassName
+		}
+		return pod
+	}
+
+	for name, tc := range map[string]struct {
+		pod       *corev1.Pod
+		namespace string
+		peerPod   bool
+		settings  Settings
+	}{
+		"no runtime class": {
+			pod: newPod("", nil),
+		},
+		"other runtime class": {
+			pod: newPod("kata", nil),
+		},
+		"defaults": {
+			pod:       newPod(RUNTIME_CLASS_NAME_DEFAULT, map[string]string{"app": "training"}),
+			namespace: "default",
+			peerPod:   true,
+			settings:  Settings{InstanceType: "t3.small", ExtendedResource: POD_VM_EXTENDED_RESOURCE_DEFAULT, AllowedImages: []string{"quay.io/"}, Overhead: OverheadIgnore},
+		},
+		"namespace and labels": {
+			pod:       newPod(RUNTIME_CLASS_NAME_DEFAULT, map[string]string{"app": "training"}),
+			namespace: "gpu",
+			peerPod:   true,
+			settings: Settings{
+				InstanceType:         "p3.2xlarge",
+				ExtendedResource:     POD_VM_EXTENDED_RESOURCE_DEFAULT,
+				AllowedInstanceTypes: []string{"p3.2xlarge"},
+				AllowedImages:        []string{"quay.io/"},
+				Overhead:             OverheadIgnore,
+				MaxMemory:            65536,
+			},
+		},
+		"runtime class of rule": {
+			pod:       newPod("kata-remote-large", nil),
+			namespace: "default",
+			peerPod:   true,
+			settings:  Settings{InstanceType: "m5.xlarge", ExtendedResource: "kata.peerpods.io/large-vm", AllowedImages: []string{"quay.io/"}, Overhead: OverheadIgnore},
+		},
+		"runtime class": {
+			pod:       newPod("kata-remote-gpu", nil),
+			namespace: "default",
+			peerPod:   true,
+			settings:  Settings{InstanceType: "p3.2xlarge", ExtendedResource: "kata.peerpods.io/gpu-vm", AllowedImages: []string{"quay.io/"}, Overhead: OverheadAddToPodVM, MaxVCPUs: 8},
+		},
+		"rule overrides runtime class": {
+			pod:       newPod("kata-remote-gpu", map[string]string{"app": "training"}),
+			namespace: "gpu",
+			peerPod:   true,
+			settings: Settings{
+				InstanceType:         "p3.2xlarge",
+				ExtendedResource:     "kata.peerpods.io/gpu-vm",
+				AllowedInstanceTypes: []string{"p3.2xlarge"},
+				AllowedImages:        []string{"quay.io/"},
+				Overhead:             OverheadAddToPodVM,
+				MaxVCPUs:             8,
+				MaxMemory:            65536,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			settings, peerPod := p.ForPod(tc.pod, tc.namespace)
+			if tc.peerPod != peerPod {
+				t.Fatalf("Expect peer pod %v, got %v", tc.peerPod, peerPod)
+			}
+			if !reflect.DeepEqual(tc.settings, settings) {
+				t.Errorf("Expect settings %+v, got %+v", tc.settings, settings)
+			}
+		})
+	}
+}
+
+func TestFromEnvRuntimeClasses(t *testing.T) {
+	t.Setenv("TARGET_RUNTIMECLASS", "kata-remote, kata-remote-gpu")
+
+	p := FromEnv()
+	if e, a := "kata-remote", p.RuntimeClassName; e != a {
+		t.Errorf("Expect default RuntimeClass %s, got %s", e, a)
+	}
+	if e, a := []RuntimeClass{{Name: "kata-remote-gpu"}}, p.RuntimeClasses; !reflect.DeepEqual(e, a) {
+		t.Errorf("Expect RuntimeClasses %v, got %v", e, a)
+	}
+
+	runtimeClassName := "kata-remote-gpu"
+	if _, ok := p.ForPod(&corev1.Pod{Spec: corev1.PodSpec{RuntimeClassName: &runtimeClassName}}, "default"); !ok {
+		t.Errorf("Expect pod with RuntimeClass %s to be a peer pod", runtimeClassName)
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field":             "default: {}",
+		"invalid selector":          "rules: [{selector: {matchExpressions: [{key: app, operator: Equal}]}}]",
+		"RuntimeClass without name": "runtimeClasses: [{instanceType: t3.small}]",
+		"unknown overhead policy":   "defaults: {overhead: Double}",
+		"negative maxVCPUs":         "rules: [{maxVCPUs: -1}]",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Expect error for %s", name)
+		}
+	}
+}
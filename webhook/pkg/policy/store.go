@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Store holds the current policy, and defaults to the policy of the environment variables
+type Store struct {
+	mu     sync.RWMutex
+	policy *Policy
+	// watching is set when the policy is loaded from a ConfigMap, and loaded once a valid policy was parsed
+	// or the ConfigMap was found not to exist. loadErr is the last error while the policy is not loaded.
+	watching bool
+	loaded   bool
+	loadErr  error
+}
+
+// Get returns the current policy
+func (s *Store) Get() *Policy {
+	if s == nil {
+		return FromEnv()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.policy == nil {
+		return FromEnv()
+	}
+	return s.policy
+}
+
+// Set replaces the current policy
+func (s *Store) Set(p *Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// Loaded returns nil if the policy is loaded, or why it is not loaded. While the policy ConfigMap is watched, the policy
+// is not loaded until the ConfigMap was read and its policy is valid, since the policy of the environment variables
+// may be more permissive than the one of the ConfigMap.
+func (s *Store) Loaded() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch {
+	case !s.watching || s.loaded:
+		return nil
+	case s.loadErr != nil:
+		return fmt.Errorf("policy is not loaded: %w", s.loadErr)
+	default:
+		return errors.New("policy is not loaded yet")
+	}
+}
+
+// load parses the policy of the ConfigMap, and keeps the previous policy if it is invalid
+func (s *Store) load(cm *corev1.ConfigMap, log logr.Logger) {
+	p, err := Parse([]byte(cm.Data[ConfigMapKey]))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.Error(err, "keeping the previous policy", "ConfigMap", cm.Name, "resourceVersion", cm.ResourceVersion)
+		if !s.loaded {
+			s.loadErr = fmt.Errorf("invalid policy in ConfigMap %s: %w", cm.Name, err)
+		}
+		return
+	}
+	s.policy = p
+	s.loaded = true
+	s.loadErr = nil
+	log.Info("loaded policy", "ConfigMap", cm.Name, "resourceVersion", cm.ResourceVersion)
+}
+
+//+kubebuilder:rbac:groups="",namespace=system,resources=configmaps,verbs=get;list;watch
+
+// WatchConfigMap loads the policy from the ConfigMap with the given name whenever it changes. When the ConfigMap
+// is deleted, the policy falls back to the environment variables. An invalid policy is logged, and the previous
+// policy is kept. The policy is not loaded until SyncConfigMap reads the ConfigMap, or a valid policy is parsed.
+func (s *Store) WatchConfigMap(informer cache.Informer, name string, log logr.Logger) {
+	s.mu.Lock()
+	s.watching = true
+	s.mu.Unlock()
+
+	load := func(obj interface{}) {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == name {
+			s.load(cm, log)
+		}
+	}
+
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: load,
+		UpdateFunc: func(_, obj interface{}) {
+			load(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Name == name {
+				s.Set(nil)
+				log.Info("policy ConfigMap was deleted, using the environment variables", "ConfigMap", name)
+			}
+		},
+	})
+}
+
+// SyncConfigMap loads the policy from the ConfigMap in namespace. It is called once the cache of the watched
+// ConfigMaps is synced, since the event handlers may not have seen the ConfigMap yet. If the ConfigMap does not exist,
+// the policy of the environment variables is loaded. If its policy is invalid, the policy stays not loaded until
+// the ConfigMap is fixed.
+func (s *Store) SyncConfigMap(ctx context.Context, reader client.Reader, namespace, name string, log logr.Logger) error {
+	cm := &corev1.ConfigMap{}
+	err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, cm)
+	switch {
+	case apierrors.IsNotFound(err):
+		log.Info("policy ConfigMap does not exist, using the environment variables", "ConfigMap", name)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.loaded = true
+		s.loadErr = nil
+	case err != nil:
+		return err
+	default:
+		s.load(cm, log)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+)
+
+func TestStoreSyncConfigMap(t *testing.T) {
+	t.Setenv("TARGET_RUNTIMECLASS", "")
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "peer-pods-webhook-policy", Namespace: "peer-pods-webhook-system"},
+		Data:       map[string]string{ConfigMapKey: "runtimeClassName: kata-remote-confidential"},
+	}
+
+	invalid := cm.DeepCopy()
+	invalid.Data[ConfigMapKey] = "runtimeClassName: ["
+
+	for name, tc := range map[string]struct {
+		objects          []client.Object
+		loaded           bool
+		runtimeClassName string
+	}{
+		"ConfigMap":         {objects: []client.Object{cm}, loaded: true, runtimeClassName: "kata-remote-confidential"},
+		"no ConfigMap":      {loaded: true, runtimeClassName: RUNTIME_CLASS_NAME_DEFAULT},
+		"invalid ConfigMap": {objects: []client.Object{invalid}, runtimeClassName: RUNTIME_CLASS_NAME_DEFAULT},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := &Store{}
+			if err := store.Loaded(); err != nil {
+				t.Fatalf("Expect a store without a watched ConfigMap to be loaded, got %v", err)
+			}
+
+			informer := &controllertest.FakeInformer{}
+			store.WatchConfigMap(informer, cm.Name, logr.Discard())
+			if err := store.Loaded(); err == nil {
+				t.Fatal("Expect a store with a watched ConfigMap not to be loaded before it is read")
+			}
+
+			reader := fake.NewClientBuilder().WithObjects(tc.objects...).Build()
+			if err := store.SyncConfigMap(context.Background(), reader, cm.Namespace, cm.Name, logr.Discard()); err != nil {
+				t.Fatalf("Expect no error, got %v", err)
+			}
+			if err := store.Loaded(); (err == nil) != tc.loaded {
+				t.Fatalf("Expect loaded %t, got %v", tc.loaded, err)
+			}
+			if e, a := tc.runtimeClassName, store.Get().RuntimeClassName; e != a {
+				t.Errorf("Expect RuntimeClass %s, got %s", e, a)
+			}
+
+			// A valid policy loads the store, and an invalid one keeps it
+			informer.Update(invalid, cm)
+			if err := store.Loaded(); err != nil {
+				t.Fatalf("Expect store to be loaded, got %v", err)
+			}
+			informer.Update(cm, invalid)
+			if err := store.Loaded(); err != nil {
+				t.Fatalf("Expect store to stay loaded, got %v", err)
+			}
+			if e, a := "kata-remote-confidential", store.Get().RuntimeClassName; e != a {
+				t.Errorf("Expect RuntimeClass %s, got %s", e, a)
+			}
+		})
+	}
+}
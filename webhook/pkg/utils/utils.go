@@ -11,6 +11,27 @@ import (
 
 // GetResourceRequestQuantity finds and returns the request quantity for a specific resource.
 func GetResourceRequestQuantity(pod *corev1.Pod, resourceName corev1.ResourceName) resource.Quantity {
+	return getResourceQuantity(pod, resourceName, func(r corev1.ResourceRequirements) corev1.ResourceList {
+		return r.Requests
+	})
+}
+
+// GetEffectiveResourceQuantity finds and returns the quantity for a specific resource that the pod needs.
+// The quantity of each container is its request, or its limit if it requests nothing. The regular containers
+// run at the same time and their quantities are summed, while the init containers run one after the other,
+// so that the pod needs at least the quantity of the largest init container.
+func GetEffectiveResourceQuantity(pod *corev1.Pod, resourceName corev1.ResourceName) resource.Quantity {
+	return getResourceQuantity(pod, resourceName, func(r corev1.ResourceRequirements) corev1.ResourceList {
+		if _, ok := r.Requests[resourceName]; ok {
+			return r.Requests
+		}
+		return r.Limits
+	})
+}
+
+// getResourceQuantity sums the quantities of a specific resource in the resource lists of the containers,
+// and takes the maximum of the init containers into account
+func getResourceQuantity(pod *corev1.Pod, resourceName corev1.ResourceName, resourceList func(corev1.ResourceRequirements) corev1.ResourceList) resource.Quantity {
 	requestQuantity := resource.Quantity{}
 
 	switch resourceName {
@@ -23,29 +44,34 @@ func GetResourceRequestQuantity(pod *corev1.Pod, resourceName corev1.ResourceNam
 	}
 
 	for _, container := range pod.Spec.Containers {
-		if rQuantity, ok := container.Resources.Requests[resourceName]; ok {
+		if rQuantity, ok := resourceList(container.Resources)[resourceName]; ok {
 			requestQuantity.Add(rQuantity)
 		}
 	}
 
 	for _, container := range pod.Spec.InitContainers {
-		if rQuantity, ok := container.Resources.Requests[resourceName]; ok {
+		if rQuantity, ok := resourceList(container.Resources)[resourceName]; ok {
 			if requestQuantity.Cmp(rQuantity) < 0 {
 				requestQuantity = rQuantity.DeepCopy()
 			}
 		}
 	}
 
-	// Don't add PodOverhead to the total requests
-	//if pod.Spec.Overhead != nil {
-	//	if podOverhead, ok := pod.Spec.Overhead[resourceName]; ok && !requestQuantity.IsZero() {
-	//		requestQuantity.Add(podOverhead)
-	//	}
-	//}
-
+	// Don't add PodOverhead to the total requests, see AddPodOverhead
 	return requestQuantity
 }
 
+// AddPodOverhead adds the pod overhead of a specific resource to a quantity of the pod, unless the
+// quantity is zero
+func AddPodOverhead(pod *corev1.Pod, resourceName corev1.ResourceName, quantity *resource.Quantity) {
+	if pod.Spec.Overhead == nil || quantity.IsZero() {
+		return
+	}
+	if podOverhead, ok := pod.Spec.Overhead[resourceName]; ok {
+		quantity.Add(podOverhead)
+	}
+}
+
 // GetResourceRequest finds and returns the request value for a specific resource.
 func GetResourceRequest(pod *corev1.Pod, resource corev1.ResourceName) int64 {
 
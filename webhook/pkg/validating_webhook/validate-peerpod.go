@@ -0,0 +1,118 @@
+package validating_webhook
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/mutating_webhook"
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Prefix of the Kata annotations, which configure the pod VM
+	KATA_ANNOTATION_PREFIX = "io.katacontainers."
+	// Kata annotation, which the cloud provider uses as instance type
+	KATA_ANNOTATION_MACHINE_TYPE = "io.katacontainers.config.hypervisor.machine_type"
+)
+
+// validatePeerPod returns the reasons why the peer pod is not allowed, if any
+func validatePeerPod(pod *corev1.Pod, settings policy.Settings) []string {
+	var violations []string
+
+	// Settings of the node are not available in the pod VM
+	if pod.Spec.HostNetwork {
+		violations = append(violations, "hostNetwork is not supported")
+	}
+	if pod.Spec.HostPID {
+		violations = append(violations, "hostPID is not supported")
+	}
+	if pod.Spec.HostIPC {
+		violations = append(violations, "hostIPC is not supported")
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, fmt.Sprintf("hostPath volume %s is not supported", volume.Name))
+		}
+	}
+
+	if len(settings.AllowedInstanceTypes) > 0 {
+		for _, key := range []string{mutating_webhook.POD_VM_ANNOTATION_INSTANCE_TYPE, KATA_ANNOTATION_MACHINE_TYPE} {
+			if instanceType, ok := pod.Annotations[key]; ok && !contains(settings.AllowedInstanceTypes, instanceType) {
+				violations = append(violations, fmt.Sprintf("instance type %s is not allowed", instanceType))
+			}
+		}
+	}
+
+	// The size of the pod VM is not resolved to an instance type here, so it is limited separately
+	for _, limit := range []struct {
+		key  string
+		name string
+		max  int64
+	}{
+		{key: mutating_webhook.POD_VM_ANNOTATION_VCPUS, name: "vCPUs", max: settings.MaxVCPUs},
+		{key: mutating_webhook.POD_VM_ANNOTATION_MEMORY, name: "MiB of memory", max: settings.MaxMemory},
+	} {
+		value, ok := pod.Annotations[limit.key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			violations = append(violations, fmt.Sprintf("annotation %s is not a valid size: %q", limit.key, value))
+		} else if limit.max > 0 && n > limit.max {
+			violations = append(violations, fmt.Sprintf("%d %s exceed the maximum of %d", n, limit.name, limit.max))
+		}
+	}
+
+	if len(settings.AllowedImages) > 0 {
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for _, c := range containers {
+				if !hasAnyPrefix(c.Image, settings.AllowedImages) {
+					violations = append(violations, fmt.Sprintf("image %s of container %s is not allowed", c.Image, c.Name))
+				}
+			}
+		}
+	}
+
+	if len(settings.AllowedAnnotations) > 0 {
+		keys := make([]string, 0, len(pod.Annotations))
+		for key := range pod.Annotations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			switch key {
+			// Set by the mutating webhook, and validated as size or instance type
+			case mutating_webhook.POD_VM_ANNOTATION_VCPUS, mutating_webhook.POD_VM_ANNOTATION_MEMORY, KATA_ANNOTATION_MACHINE_TYPE:
+				continue
+			}
+			if strings.HasPrefix(key, KATA_ANNOTATION_PREFIX) && !contains(settings.AllowedAnnotations, key) {
+				violations = append(violations, fmt.Sprintf("annotation %s is not allowed", key))
+			}
+		}
+	}
+
+	return violations
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
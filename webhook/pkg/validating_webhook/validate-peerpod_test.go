@@ -0,0 +1,75 @@
+package validating_webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePeerPod(t *testing.T) {
+	settings := policy.Settings{
+		AllowedInstanceTypes: []string{"t3.small"},
+		AllowedImages:        []string{"quay.io/confidential-containers/"},
+		AllowedAnnotations:   []string{"io.katacontainers.config.agent.policy"},
+		MaxVCPUs:             4,
+		MaxMemory:            8192,
+	}
+
+	for name, tc := range map[string]struct {
+		pod        corev1.Pod
+		violations []string
+	}{
+		"allowed": {
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"kata.peerpods.io/instance_type":                    "t3.small",
+					"io.katacontainers.config.hypervisor.default_vcpus": "2",
+					"io.katacontainers.config.agent.policy":             "",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "quay.io/confidential-containers/app"}}},
+			},
+		},
+		"pod VM size": {
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"io.katacontainers.config.hypervisor.default_vcpus":  "96",
+					"io.katacontainers.config.hypervisor.default_memory": "8Gi",
+				}},
+			},
+			violations: []string{
+				"96 vCPUs exceed the maximum of 4",
+				`annotation io.katacontainers.config.hypervisor.default_memory is not a valid size: "8Gi"`,
+			},
+		},
+		"host settings": {
+			pod: corev1.Pod{Spec: corev1.PodSpec{
+				HostNetwork: true,
+				Volumes:     []corev1.Volume{{Name: "root", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}}},
+			}},
+			violations: []string{"hostNetwork is not supported", "hostPath volume root is not supported"},
+		},
+		"disallowed instance type, image and annotation": {
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+					"io.katacontainers.config.hypervisor.machine_type": "m5.24xlarge",
+					"io.katacontainers.config.hypervisor.kernel":       "/vmlinuz",
+				}},
+				Spec: corev1.PodSpec{InitContainers: []corev1.Container{{Name: "init", Image: "docker.io/busybox"}}},
+			},
+			violations: []string{
+				"instance type m5.24xlarge is not allowed",
+				"image docker.io/busybox of container init is not allowed",
+				"annotation io.katacontainers.config.hypervisor.kernel is not allowed",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if a := validatePeerPod(&tc.pod, settings); !reflect.DeepEqual(tc.violations, a) {
+				t.Errorf("Expect violations %q, got %q", tc.violations, a)
+			}
+		})
+	}
+}
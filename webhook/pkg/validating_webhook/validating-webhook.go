@@ -0,0 +1,56 @@
+package validating_webhook
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:admissionReviewVersions=v1,path=/validate-v1-pod,mutating=false,failurePolicy=fail,groups="",resources=pods,verbs=create,versions=v1,name=vwebhook.peerpods.io,sideEffects=None
+
+// PodValidator validates peer pods against the policy
+type PodValidator struct {
+	// Policy selects the peer pods and their settings
+	Policy  *policy.Store
+	decoder *admission.Decoder
+}
+
+// Handle rejects peer pods that use settings, which the policy does not allow or pod VMs do not support
+func (v *PodValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+
+	err := v.decoder.Decode(req, pod)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// Deny until the policy is loaded, instead of validating against the policy of the environment variables.
+	// Pods without a RuntimeClass are not peer pods under any policy, while the others may use a RuntimeClass of
+	// peer pods that only the policy, which is not loaded yet, defines.
+	if err := v.Policy.Loaded(); err != nil {
+		if pod.Spec.RuntimeClassName == nil {
+			return admission.Allowed("not a peer pod")
+		}
+		return admission.Errored(http.StatusServiceUnavailable, err)
+	}
+
+	settings, ok := v.Policy.Get().ForPod(pod, req.Namespace)
+	if !ok {
+		return admission.Allowed("not a peer pod")
+	}
+
+	if violations := validatePeerPod(pod, settings); len(violations) > 0 {
+		return admission.Denied("peer pod is not allowed: " + strings.Join(violations, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder injects the decoder.
+func (v *PodValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
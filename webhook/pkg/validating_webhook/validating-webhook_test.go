@@ -0,0 +1,83 @@
+package validating_webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/confidential-containers/cloud-api-adaptor/webhook/pkg/policy"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestHandleUnsyncedPolicy(t *testing.T) {
+	t.Setenv("TARGET_RUNTIMECLASS", "")
+
+	decoder, err := admission.NewDecoder(clientgoscheme.Scheme)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	store := &policy.Store{}
+	store.WatchConfigMap(&controllertest.FakeInformer{}, "peer-pods-webhook-policy", logr.Discard())
+	v := &PodValidator{Policy: store}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+
+	runtimeClassName := policy.RUNTIME_CLASS_NAME_DEFAULT
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+		Spec:       corev1.PodSpec{RuntimeClassName: &runtimeClassName, HostNetwork: true},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "default", Object: runtime.RawExtension{Raw: raw}}}
+
+	// The request is denied until the policy ConfigMap is read
+	res := v.Handle(context.Background(), req)
+	if res.Allowed || res.Result.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expect request to be denied with code %d, got %+v", http.StatusServiceUnavailable, res.Result)
+	}
+
+	// A pod without a RuntimeClass is not a peer pod, and is allowed
+	raw, err = json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nginx"}, Spec: corev1.PodSpec{HostNetwork: true}})
+	if err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	res = v.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "default", Object: runtime.RawExtension{Raw: raw}}})
+	if !res.Allowed {
+		t.Fatalf("Expect request of a pod without a RuntimeClass to be allowed, got %+v", res.Result)
+	}
+
+	// An invalid policy is not loaded
+	invalid := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "peer-pods-webhook-policy", Namespace: "default"},
+		Data:       map[string]string{policy.ConfigMapKey: "runtimeClassName: ["},
+	}
+	if err := store.SyncConfigMap(context.Background(), fake.NewClientBuilder().WithObjects(invalid).Build(), "default", "peer-pods-webhook-policy", logr.Discard()); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	res = v.Handle(context.Background(), req)
+	if res.Allowed || res.Result.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expect request to be denied with code %d, got %+v", http.StatusServiceUnavailable, res.Result)
+	}
+
+	if err := store.SyncConfigMap(context.Background(), fake.NewClientBuilder().Build(), "default", "peer-pods-webhook-policy", logr.Discard()); err != nil {
+		t.Fatalf("Expect no error, got %v", err)
+	}
+	res = v.Handle(context.Background(), req)
+	if res.Allowed || res.Result.Code != http.StatusForbidden {
+		t.Fatalf("Expect request to be denied by the policy, got %+v", res.Result)
+	}
+}
@@ -55,6 +55,24 @@ teardown() {
 
 @test "$test_tags test it can mutate a pod" {
 	kubectl apply -f "$pod_file"
+	assert_pod_mutated "" 1 1
+
+	local actual_vcpus=$(kubectl get -f "$pod_file" \
+		-o jsonpath='{.metadata.annotations.io\.katacontainers\.config\.hypervisor\.default_vcpus}')
+	echo "vCPUs expected: 1, actual: $actual_vcpus"
+	[ "$actual_vcpus" == "1" ]
+
+	local actual_memory=$(kubectl get -f "$pod_file" \
+		-o jsonpath='{.metadata.annotations.io\.katacontainers\.config\.hypervisor\.default_memory}')
+	echo "Memory expected: 1024, actual: $actual_memory"
+	[ "$actual_memory" == "1024" ]
+}
+
+@test "$test_tags test it uses the default instance type without resources" {
+	echo "Create a pod without resources"
+	cat "$pod_file" | sed -e '/^\s*resources:/,/^\s*runtimeClassName:/{/runtimeClassName/!d}' | \
+		kubectl apply -f -
+
 	assert_pod_mutated "t2.small" 1 1
 }
 
@@ -91,7 +109,9 @@ teardown() {
 	kubectl set env deployment/peer-pods-webhook-controller-manager \
 		-n peer-pods-webhook-system POD_VM_INSTANCE_TYPE="$instance_type"
 
-	cat "$pod_file" | sed -e 's/^\(\s*runtimeClassName:\).*/\1 '${runtimeclass}'/' | \
+	# The default instance type is only used by pods without resources
+	cat "$pod_file" | sed -e '/^\s*resources:/,/^\s*runtimeClassName:/{/runtimeClassName/!d}' \
+		-e 's/^\(\s*runtimeClassName:\).*/\1 '${runtimeclass}'/' | \
 		kubectl apply -f -
 
 	kubectl get -f $pod_file -o json